@@ -154,14 +154,16 @@ type broker struct {
 	// write goes to, but the write is expected to be fast whereas the wait
 	// for the response is expected to be slow.
 	//
-	// Produce requests go to cxnProduce, fetch to cxnFetch, join/sync go
-	// to cxnGroup, anything with TimeoutMillis goes to cxnSlow, and
-	// everything else goes to cxnNormal.
-	cxnNormal  *brokerCxn
-	cxnProduce *brokerCxn
-	cxnFetch   *brokerCxn
-	cxnGroup   *brokerCxn
-	cxnSlow    *brokerCxn
+	// Produce requests go to cxnProduce (or cxnProduceNoAck if the
+	// request has acks of 0), fetch to cxnFetch, join/sync go to cxnGroup,
+	// anything with TimeoutMillis goes to cxnSlow, and everything else
+	// goes to cxnNormal.
+	cxnNormal       *brokerCxn
+	cxnProduce      *brokerCxn
+	cxnProduceNoAck *brokerCxn
+	cxnFetch        *brokerCxn
+	cxnGroup        *brokerCxn
+	cxnSlow         *brokerCxn
 
 	reapMu sync.Mutex // held when modifying a brokerCxn
 
@@ -169,6 +171,10 @@ type broker struct {
 	reqs ringReq
 	// dead is an atomic so a backed up reqs cannot block broker stoppage.
 	dead atomicBool
+
+	// reachable is true if the most recent connection attempt to this
+	// broker succeeded; see ProduceRequireConnection.
+	reachable atomicBool
 }
 
 // brokerVersions is loaded once (and potentially a few times concurrently if
@@ -238,6 +244,7 @@ func (b *broker) stopForever() {
 
 	b.cxnNormal.die()
 	b.cxnProduce.die()
+	b.cxnProduceNoAck.die()
 	b.cxnFetch.die()
 	b.cxnGroup.die()
 	b.cxnSlow.die()
@@ -293,6 +300,11 @@ start:
 }
 
 func (b *broker) handleReq(pr promisedReq) {
+	if tt := b.cl.cfg.testTransport; tt != nil {
+		b.handleTestReq(pr, tt)
+		return
+	}
+
 	req := pr.req
 	var cxn *brokerCxn
 	var retriedOnNewConnection bool
@@ -443,13 +455,13 @@ start:
 	if writeErr != nil {
 		pr.promise(nil, writeErr)
 		cxn.die()
-		cxn.hookWriteE2E(req.Key(), bytesWritten, writeWait, timeToWrite, writeErr)
+		cxn.hookWriteE2E(req.Key(), req.GetVersion(), bytesWritten, writeWait, timeToWrite, writeErr)
 		return
 	}
 
 	if isNoResp {
 		pr.promise(noResp, nil)
-		cxn.hookWriteE2E(req.Key(), bytesWritten, writeWait, timeToWrite, writeErr)
+		cxn.hookWriteE2E(req.Key(), req.GetVersion(), bytesWritten, writeWait, timeToWrite, writeErr)
 		return
 	}
 
@@ -469,10 +481,11 @@ start:
 	})
 }
 
-func (cxn *brokerCxn) hookWriteE2E(key int16, bytesWritten int, writeWait, timeToWrite time.Duration, writeErr error) {
+func (cxn *brokerCxn) hookWriteE2E(key, version int16, bytesWritten int, writeWait, timeToWrite time.Duration, writeErr error) {
 	cxn.cl.cfg.hooks.each(func(h Hook) {
 		if h, ok := h.(HookBrokerE2E); ok {
 			h.OnBrokerE2E(cxn.b.meta, key, BrokerE2E{
+				Version:      version,
 				BytesWritten: bytesWritten,
 				WriteWait:    writeWait,
 				TimeToWrite:  timeToWrite,
@@ -499,14 +512,23 @@ func (p bufPool) put(b []byte) { p.p.Put(&b) }
 func (b *broker) loadConnection(ctx context.Context, req kmsg.Request) (*brokerCxn, error) {
 	var (
 		pcxn         = &b.cxnNormal
-		isProduceCxn bool // see docs on brokerCxn.discard for why we do this
+		isNoAckCxn   bool // see docs on brokerCxn.discard for why we do this
 		reqKey       = req.Key()
 		_, isTimeout = req.(kmsg.TimeoutRequest)
 	)
 	switch {
 	case reqKey == 0:
+		// Acks can vary per topic (ProduceTopicAcks), so acks=0
+		// requests use their own connection: only that connection
+		// discards responses, and only it never waits for them.
+		acks := b.cl.cfg.acks.val
+		if r, ok := req.(*produceRequest); ok {
+			acks = r.acks
+		}
 		pcxn = &b.cxnProduce
-		isProduceCxn = true
+		if isNoAckCxn = acks == 0; isNoAckCxn {
+			pcxn = &b.cxnProduceNoAck
+		}
 	case reqKey == 1:
 		pcxn = &b.cxnFetch
 	case reqKey == 11 || reqKey == 14: // join || sync
@@ -521,6 +543,7 @@ func (b *broker) loadConnection(ctx context.Context, req kmsg.Request) (*brokerC
 
 	conn, err := b.connect(ctx)
 	if err != nil {
+		b.reachable.Store(false)
 		return nil, err
 	}
 
@@ -532,12 +555,14 @@ func (b *broker) loadConnection(ctx context.Context, req kmsg.Request) (*brokerC
 		conn:   conn,
 		deadCh: make(chan struct{}),
 	}
-	if err = cxn.init(isProduceCxn); err != nil {
+	if err = cxn.init(isNoAckCxn); err != nil {
 		b.cl.cfg.logger.Log(LogLevelDebug, "connection initialization failed", "addr", b.addr, "broker", logID(b.meta.NodeID), "err", err)
 		cxn.closeConn()
+		b.reachable.Store(false)
 		return nil, err
 	}
 	b.cl.cfg.logger.Log(LogLevelDebug, "connection initialized successfully", "addr", b.addr, "broker", logID(b.meta.NodeID))
+	b.reachable.Store(true)
 
 	b.reapMu.Lock()
 	defer b.reapMu.Unlock()
@@ -570,6 +595,48 @@ func (cl *Client) reapConnectionsLoop() {
 	}
 }
 
+// connected returns whether any broker is reachable.
+func (cl *Client) connected() bool {
+	cl.brokersMu.RLock()
+	defer cl.brokersMu.RUnlock()
+	for _, brokers := range [][]*broker{cl.brokers, cl.loadSeeds()} {
+		for _, broker := range brokers {
+			if broker.reachable.Load() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ensureConnected returns whether any broker is reachable, and if none is,
+// first tries to connect to a broker, bounded by the dial timeout. Only one
+// attempt runs at a time; callers that wait on an attempt that fails do not
+// retry it themselves.
+func (cl *Client) ensureConnected(ctx context.Context) bool {
+	if cl.connected() {
+		return true
+	}
+	attempt := cl.connectAttempts.Load()
+	cl.connectMu.Lock()
+	defer cl.connectMu.Unlock()
+	if cl.connected() {
+		return true
+	}
+	if cl.connectAttempts.Load() != attempt {
+		return false
+	}
+	defer cl.connectAttempts.Add(1)
+
+	ctx, cancel := context.WithTimeout(ctx, cl.cfg.dialTimeout)
+	defer cancel()
+	if err := cl.Ping(ctx); err != nil {
+		cl.cfg.logger.Log(LogLevelDebug, "unable to connect to any broker", "err", err)
+		return false
+	}
+	return cl.connected()
+}
+
 func (cl *Client) reapConnections(idleTimeout time.Duration) (total int) {
 	cl.brokersMu.Lock()
 	seeds := cl.loadSeeds()
@@ -591,6 +658,7 @@ func (b *broker) reapConnections(idleTimeout time.Duration) (total int) {
 	for _, cxn := range []*brokerCxn{
 		b.cxnNormal,
 		b.cxnProduce,
+		b.cxnProduceNoAck,
 		b.cxnFetch,
 		b.cxnGroup,
 		b.cxnSlow,
@@ -680,7 +748,7 @@ type brokerCxn struct {
 	deadCh chan struct{}
 }
 
-func (cxn *brokerCxn) init(isProduceCxn bool) error {
+func (cxn *brokerCxn) init(isNoAckCxn bool) error {
 	hasVersions := cxn.b.loadVersions() != nil
 	if !hasVersions {
 		if cxn.b.cl.cfg.maxVersions == nil || cxn.b.cl.cfg.maxVersions.HasKey(18) {
@@ -704,7 +772,7 @@ func (cxn *brokerCxn) init(isProduceCxn bool) error {
 		return err
 	}
 
-	if isProduceCxn && cxn.cl.cfg.acks.val == 0 {
+	if isNoAckCxn {
 		go cxn.discard() // see docs on discard for why we do this
 	}
 	return nil
@@ -732,7 +800,7 @@ start:
 	cxn.cl.cfg.logger.Log(LogLevelDebug, "issuing api versions request", "broker", logID(cxn.b.meta.NodeID), "version", maxVersion)
 	corrID, bytesWritten, writeWait, timeToWrite, readEnqueue, writeErr := cxn.writeRequest(nil, time.Now(), req)
 	if writeErr != nil {
-		cxn.hookWriteE2E(req.Key(), bytesWritten, writeWait, timeToWrite, writeErr)
+		cxn.hookWriteE2E(req.Key(), req.GetVersion(), bytesWritten, writeWait, timeToWrite, writeErr)
 		return writeErr
 	}
 
@@ -805,7 +873,7 @@ start:
 		cxn.cl.cfg.logger.Log(LogLevelDebug, "issuing SASLHandshakeRequest", "broker", logID(cxn.b.meta.NodeID))
 		corrID, bytesWritten, writeWait, timeToWrite, readEnqueue, writeErr := cxn.writeRequest(nil, time.Now(), req)
 		if writeErr != nil {
-			cxn.hookWriteE2E(req.Key(), bytesWritten, writeWait, timeToWrite, writeErr)
+			cxn.hookWriteE2E(req.Key(), req.GetVersion(), bytesWritten, writeWait, timeToWrite, writeErr)
 			return writeErr
 		}
 
@@ -900,7 +968,7 @@ func (cxn *brokerCxn) doSasl(authenticate bool) error {
 			// without reading a response back (kerberos). If this
 			// is the case, we need to e2e.
 			if writeErr != nil || done {
-				cxn.hookWriteE2E(req.Key(), bytesWritten, writeWait, timeToWrite, writeErr)
+				cxn.hookWriteE2E(req.Key(), req.GetVersion(), bytesWritten, writeWait, timeToWrite, writeErr)
 				if writeErr != nil {
 					return writeErr
 				}
@@ -1019,7 +1087,13 @@ func (cxn *brokerCxn) writeRequest(ctx context.Context, enqueuedForWritingAt tim
 		}
 	}
 
-	buf := cxn.cl.reqFormatter.AppendRequest(
+	formatter := cxn.cl.reqFormatter
+	if fn := cxn.cl.cfg.requestTransform; fn != nil {
+		if id := fn(req.Key(), cxn.corrID); id != "" {
+			formatter = kmsg.NewRequestFormatter(kmsg.FormatterClientID(id))
+		}
+	}
+	buf := formatter.AppendRequest(
 		cxn.cl.bufPool.get()[:0],
 		req,
 		cxn.corrID,
@@ -1225,6 +1299,7 @@ func (cxn *brokerCxn) readResponse(
 		}
 		if h, ok := h.(HookBrokerE2E); ok {
 			h.OnBrokerE2E(cxn.b.meta, key, BrokerE2E{
+				Version:      version,
 				BytesWritten: bytesWritten,
 				BytesRead:    bytesRead,
 				WriteWait:    writeWait,
@@ -1290,7 +1365,7 @@ func (cxn *brokerCxn) waitResp(pr promisedResp) {
 		go cxn.handleResps(pr)
 	} else if dead {
 		pr.promise(nil, errChosenBrokerDead)
-		cxn.hookWriteE2E(pr.resp.Key(), pr.bytesWritten, pr.writeWait, pr.timeToWrite, errChosenBrokerDead)
+		cxn.hookWriteE2E(pr.resp.Key(), pr.resp.GetVersion(), pr.bytesWritten, pr.writeWait, pr.timeToWrite, errChosenBrokerDead)
 	}
 }
 
@@ -1436,7 +1511,7 @@ func (cxn *brokerCxn) handleResps(pr promisedResp) {
 start:
 	if dead {
 		pr.promise(nil, errChosenBrokerDead)
-		cxn.hookWriteE2E(pr.resp.Key(), pr.bytesWritten, pr.writeWait, pr.timeToWrite, errChosenBrokerDead)
+		cxn.hookWriteE2E(pr.resp.Key(), pr.resp.GetVersion(), pr.bytesWritten, pr.writeWait, pr.timeToWrite, errChosenBrokerDead)
 	} else {
 		cxn.handleResp(pr)
 	}
@@ -66,6 +66,11 @@ type Client struct {
 	controllerIDMu sync.Mutex
 	controllerID   int32
 
+	// connectMu and connectAttempts ensure only one ensureConnected
+	// attempt runs at a time (ProduceRequireConnection).
+	connectMu       sync.Mutex
+	connectAttempts atomic.Uint64
+
 	// The following two ensure that we only have one fetchBrokerMetadata
 	// at once. This avoids unnecessary broker metadata requests and
 	// metadata trampling.
@@ -83,6 +88,7 @@ type Client struct {
 
 	coordinatorsMu sync.Mutex
 	coordinators   map[coordinatorKey]*coordinatorLoad
+	lastCoordNodes map[coordinatorKey]int32 // our own group and txn coordinators, surviving cache deletion, for OnCoordinatorChange
 
 	updateMetadataCh     chan string
 	updateMetadataNowCh  chan string // like above, but with high priority
@@ -90,6 +96,8 @@ type Client struct {
 	metawait             metawait
 	metadone             chan struct{}
 
+	regexDiscoverDue bool // only used in the metadata loop, for RegexDiscoveryInterval
+
 	mappedMetaMu sync.Mutex
 	mappedMeta   map[string]mappedMetadataTopic
 }
@@ -152,6 +160,9 @@ func validateCfg(opts ...Opt) (cfg, []hostport, *compressor, error) {
 	if err != nil {
 		return cfg, nil, nil, err
 	}
+	if compressor != nil {
+		compressor.minBytes = cfg.compressMinBytes
+	}
 	return cfg, seeds, compressor, nil
 }
 
@@ -229,6 +240,10 @@ func (cl *Client) OptValues(opt any) []any {
 	cfg := &cl.cfg
 
 	switch name {
+	case namefn(RequestTransform):
+		return []any{cfg.requestTransform}
+	case namefn(WithTestTransport):
+		return []any{cfg.testTransport}
 	case namefn(ClientID):
 		if cfg.id != nil {
 			return []any{*cfg.id, true}
@@ -243,12 +258,18 @@ func (cl *Client) OptValues(opt any) []any {
 		return []any{nil}
 	case namefn(RequestTimeoutOverhead):
 		return []any{cfg.requestTimeoutOverhead}
+	case namefn(RequestTimeoutFor):
+		return []any{cfg.requestTimeoutFor}
 	case namefn(ConnIdleTimeout):
 		return []any{cfg.connIdleTimeout}
 	case namefn(Dialer):
 		return []any{cfg.dialFn}
 	case namefn(DialTLSConfig):
 		return []any{cfg.dialTLS}
+	case namefn(DialTCPKeepAlive):
+		return []any{cfg.dialKeepAlive}
+	case namefn(DialControl):
+		return []any{cfg.dialControl}
 	case namefn(DialTLS):
 		return []any{cfg.dialTLS != nil}
 	case namefn(SeedBrokers):
@@ -275,31 +296,67 @@ func (cl *Client) OptValues(opt any) []any {
 		return []any{cfg.metadataMaxAge}
 	case namefn(MetadataMinAge):
 		return []any{cfg.metadataMinAge}
+	case namefn(MetadataRefreshJitter):
+		return []any{cfg.metadataMaxJitter}
+	case namefn(OnCoordinatorChange):
+		return []any{cfg.onCoordinatorChange}
+	case namefn(OnMetadataRetry):
+		return []any{cfg.onMetadataRetry}
 	case namefn(SASL):
 		return []any{cfg.sasls}
 	case namefn(WithHooks):
 		return []any{cfg.hooks}
+	case namefn(WithRecordCodec):
+		return []any{cfg.recordCodec}
 	case namefn(ConcurrentTransactionsBackoff):
 		return []any{cfg.txnBackoff}
+	case namefn(TxnCoordinatorUnavailableBehavior):
+		return []any{cfg.txnCoordUnavailable}
+	case namefn(TopicNameMapper):
+		return []any{cfg.produceTopicMapper, cfg.consumeTopicMapper}
 	case namefn(ConsiderMissingTopicDeletedAfter):
 		return []any{cfg.missingTopicDelete}
 
 	case namefn(DefaultProduceTopic):
 		return []any{cfg.defaultProduceTopic}
+	case namefn(RecordValidator):
+		return []any{cfg.recordValidator}
 	case namefn(RequiredAcks):
 		return []any{cfg.acks}
+	case namefn(ProduceTopicAcks):
+		return []any{cfg.topicAcks}
 	case namefn(DisableIdempotentWrite):
 		return []any{cfg.disableIdempotency}
+	case namefn(RequireIdempotentWriteSupport):
+		return []any{cfg.verifyIdempotency}
 	case namefn(MaxProduceRequestsInflightPerBroker):
 		return []any{cfg.maxProduceInflight}
+	case namefn(RelaxPartitionOrdering):
+		return []any{cfg.relaxOrdering}
+	case namefn(MaxInFlightProduceRequests):
+		return []any{cfg.maxInflightProduceRequests}
 	case namefn(ProducerBatchCompression):
 		return []any{cfg.compression}
+	case namefn(CompressionMinBytes):
+		return []any{cfg.compressMinBytes}
 	case namefn(ProducerBatchMaxBytes):
 		return []any{cfg.maxRecordBatchBytes}
 	case namefn(MaxBufferedRecords):
 		return []any{cfg.maxBufferedRecords}
 	case namefn(MaxBufferedBytes):
 		return []any{cfg.maxBufferedBytes}
+	case namefn(OnBufferHighWater):
+		return []any{cfg.onBufferHighWater}
+	case namefn(OnBufferLowWater):
+		return []any{cfg.onBufferLowWater}
+	case namefn(BufferWaterMarks):
+		return []any{cfg.bufferHighWater, cfg.bufferLowWater}
+	case namefn(VerifyProduceOrdering):
+		return []any{cfg.verifyProduceOrdering}
+	case namefn(OnDuplicateAcknowledged):
+		return []any{cfg.onDuplicateAcked}
+	case namefn(OnProduceBatchRetry):
+		return []any{cfg.onProduceBatchRetry}
 	case namefn(RecordPartitioner):
 		return []any{cfg.partitioner}
 	case namefn(ProduceRequestTimeout):
@@ -314,8 +371,24 @@ func (cl *Client) OptValues(opt any) []any {
 		return []any{cfg.onDataLoss}
 	case namefn(ProducerLinger):
 		return []any{cfg.linger}
+	case namefn(FlushOnRecord):
+		return []any{cfg.flushOnRecords}
+	case namefn(ProduceTopicOpts):
+		return []any{cfg.topicProduceOpts}
+	case namefn(OnProduceRetryExhausted):
+		return []any{cfg.onRetryExhausted}
+	case namefn(OnProducerIDRecovered):
+		return []any{cfg.onProducerIDRecovered}
 	case namefn(ManualFlushing):
 		return []any{cfg.manualFlushing}
+	case namefn(ProduceRequireConnection):
+		return []any{cfg.requireConnection}
+	case namefn(ProduceDiskSpill):
+		return []any{cfg.spillDir, cfg.spillMaxBytes}
+	case namefn(ProduceCoalesceWindow):
+		return []any{cfg.produceCoalesceWindow}
+	case namefn(ProduceDedupWindow):
+		return []any{cfg.dedupWindow, cfg.dedupMaxKeys}
 	case namefn(RecordDeliveryTimeout):
 		return []any{cfg.recordTimeout}
 	case namefn(TransactionalID):
@@ -325,21 +398,39 @@ func (cl *Client) OptValues(opt any) []any {
 		return []any{"", false}
 	case namefn(TransactionTimeout):
 		return []any{cfg.txnTimeout}
+	case namefn(TxnEndUncancelable):
+		return []any{cfg.txnEndUncancelable}
 
 	case namefn(ConsumePartitions):
 		return []any{cfg.partitions}
+	case namefn(ConsumeLastN):
+		return []any{cfg.partitions}
 	case namefn(ConsumePreferringLagFn):
 		return []any{cfg.preferLagFn}
 	case namefn(ConsumeRegex):
 		return []any{cfg.regex}
+	case namefn(ConsumeRegexes):
+		return []any{cfg.topics}
+	case namefn(ConsumeExcludeTopics):
+		return []any{cfg.excludeTopics}
+	case namefn(ConsumeExcludeRegexes):
+		return []any{cfg.excludeTopics}
+	case namefn(RegexExcludeInternal):
+		return []any{cfg.regexExcludeInternal}
+	case namefn(RegexDiscoveryInterval):
+		return []any{cfg.regexDiscoveryInterval}
+	case namefn(OnRegexTopicsMatched):
+		return []any{cfg.onRegexMatched}
 	case namefn(ConsumeResetOffset):
 		return []any{cfg.resetOffset}
+	case namefn(OnOffsetOutOfRange):
+		return []any{cfg.onOffsetOutOfRange}
 	case namefn(ConsumeTopics):
 		return []any{cfg.topics}
 	case namefn(DisableFetchSessions):
 		return []any{cfg.disableFetchSessions}
 	case namefn(FetchIsolationLevel):
-		return []any{cfg.isolationLevel}
+		return []any{int8(cfg.isolationLevel.load())}
 	case namefn(FetchMaxBytes):
 		return []any{int32(cfg.maxBytes)}
 	case namefn(FetchMaxPartitionBytes):
@@ -350,12 +441,38 @@ func (cl *Client) OptValues(opt any) []any {
 		return []any{cfg.minBytes}
 	case namefn(KeepControlRecords):
 		return []any{cfg.keepControl}
+	case namefn(IncludeAbortedRecords):
+		return []any{cfg.includeAborted}
+	case namefn(SkipCorruptBatches):
+		return []any{cfg.skipCorruptBatches}
+	case namefn(OnCorruptBatch):
+		return []any{cfg.onCorruptBatch}
+	case namefn(PartitionProcessor):
+		return []any{cfg.partitionProcessor}
+	case namefn(MaxPollInterval):
+		return []any{cfg.maxPollInterval}
+	case namefn(OnPollStall):
+		return []any{cfg.onPollStall}
+	case namefn(OnPartitionEOF):
+		return []any{cfg.onPartitionEOF}
+	case namefn(FairPollAcrossPartitions):
+		return []any{cfg.fairPoll}
+	case namefn(MaxConsumeRecordBytes):
+		return []any{cfg.maxConsumeRecordBytes}
+	case namefn(OnRecordTooLarge):
+		return []any{cfg.onRecordTooLarge}
 	case namefn(MaxConcurrentFetches):
 		return []any{cfg.maxConcurrentFetches}
+	case namefn(MaxConcurrentFetchPartitions):
+		return []any{cfg.maxConcurrentFetchParts}
+	case namefn(MaxBufferedFetchBytes):
+		return []any{cfg.maxBufferedFetchBytes}
 	case namefn(Rack):
 		return []any{cfg.rack}
 	case namefn(KeepRetryableFetchErrors):
 		return []any{cfg.keepRetryableFetchErrors}
+	case namefn(FetchDecompressWorkers):
+		return []any{cfg.fetchDecompressWorkers}
 
 	case namefn(AdjustFetchOffsetsFn):
 		return []any{cfg.adjustOffsetsBeforeAssign}
@@ -363,6 +480,10 @@ func (cl *Client) OptValues(opt any) []any {
 		return []any{cfg.commitCallback}
 	case namefn(AutoCommitInterval):
 		return []any{cfg.autocommitInterval}
+	case namefn(OnAutoCommit):
+		return []any{cfg.onAutoCommit}
+	case namefn(RevokeCommitTimeout):
+		return []any{cfg.revokeCommitTimeout}
 	case namefn(AutoCommitMarks):
 		return []any{cfg.autocommitMarks}
 	case namefn(Balancers):
@@ -384,10 +505,21 @@ func (cl *Client) OptValues(opt any) []any {
 			return []any{*cfg.instanceID, true}
 		}
 		return []any{"", false}
+	case namefn(ImportGroupMemberState):
+		if cfg.importedMemberState != nil {
+			return []any{*cfg.importedMemberState}
+		}
+		return []any{GroupMemberState{}}
 	case namefn(OnOffsetsFetched):
 		return []any{cfg.onFetched}
 	case namefn(OnPartitionsAssigned):
 		return []any{cfg.onAssigned}
+	case namefn(OnPartialRevoke):
+		return []any{cfg.onPartialRevoke}
+	case namefn(OnRebalance):
+		return []any{cfg.onRebalance}
+	case namefn(WithOffsetStore):
+		return []any{cfg.offsetStore}
 	case namefn(OnPartitionsLost):
 		return []any{cfg.onLost}
 	case namefn(OnPartitionsRevoked):
@@ -396,6 +528,8 @@ func (cl *Client) OptValues(opt any) []any {
 		return []any{cfg.rebalanceTimeout}
 	case namefn(RequireStableFetchOffsets):
 		return []any{cfg.requireStable}
+	case namefn(TxnCommitRebalanceRetries):
+		return []any{cfg.txnCommitRebalanceRetries}
 	case namefn(SessionTimeout):
 		return []any{cfg.sessionTimeout}
 	default:
@@ -434,7 +568,11 @@ func NewClient(opts ...Opt) (*Client, error) {
 	}
 
 	if cfg.dialFn == nil {
-		dialer := &net.Dialer{Timeout: cfg.dialTimeout}
+		dialer := &net.Dialer{
+			Timeout:   cfg.dialTimeout,
+			KeepAlive: cfg.dialKeepAlive,
+			Control:   cfg.dialControl,
+		}
 		cfg.dialFn = dialer.DialContext
 		if cfg.dialTLS != nil {
 			cfg.dialFn = func(ctx context.Context, network, host string) (net.Conn, error) {
@@ -477,7 +615,7 @@ func NewClient(opts ...Opt) (*Client, error) {
 		sinksAndSources: make(map[int32]sinkAndSource),
 
 		reqFormatter:  kmsg.NewRequestFormatter(),
-		connTimeouter: connTimeouter{def: cfg.requestTimeoutOverhead},
+		connTimeouter: connTimeouter{def: cfg.requestTimeoutOverhead, defFor: cfg.requestTimeoutFor},
 
 		bufPool: newBufPool(),
 		prsPool: newPrsPool(),
@@ -485,7 +623,8 @@ func NewClient(opts ...Opt) (*Client, error) {
 		compressor:   compressor,
 		decompressor: newDecompressor(),
 
-		coordinators: make(map[coordinatorKey]*coordinatorLoad),
+		coordinators:   make(map[coordinatorKey]*coordinatorLoad),
+		lastCoordNodes: make(map[coordinatorKey]int32),
 
 		updateMetadataCh:     make(chan string, 1),
 		updateMetadataNowCh:  make(chan string, 1),
@@ -518,9 +657,43 @@ func NewClient(opts ...Opt) (*Client, error) {
 	go cl.updateMetadataLoop()
 	go cl.reapConnectionsLoop()
 
+	if cfg.verifyIdempotency && !cfg.disableIdempotency {
+		if err := cl.verifyIdempotentWrite(); err != nil {
+			cl.Close()
+			return nil, err
+		}
+	}
+
 	return cl, nil
 }
 
+// verifyIdempotentWrite issues an ApiVersions request and returns
+// ErrIdempotenceUnsupported if the broker (or our own pinned max versions)
+// does not support InitProducerID.
+func (cl *Client) verifyIdempotentWrite() error {
+	initKey := (*kmsg.InitProducerIDRequest)(nil).Key()
+	if cl.cfg.maxVersions != nil && !cl.cfg.maxVersions.HasKey(initKey) {
+		return ErrIdempotenceUnsupported
+	}
+
+	req := kmsg.NewPtrApiVersionsRequest()
+	req.ClientSoftwareName = cl.cfg.softwareName
+	req.ClientSoftwareVersion = cl.cfg.softwareVersion
+	resp, err := req.RequestWith(cl.ctx, cl)
+	if err != nil {
+		return fmt.Errorf("unable to verify idempotent write support: %w", err)
+	}
+	if err := kerr.ErrorForCode(resp.ErrorCode); err != nil {
+		return fmt.Errorf("unable to verify idempotent write support: %w", err)
+	}
+	for _, key := range resp.ApiKeys {
+		if key.ApiKey == initKey {
+			return nil
+		}
+	}
+	return ErrIdempotenceUnsupported
+}
+
 // Opts returns the options that were used to create this client. This can be
 // as a base to generate a new client, where you can add override options to
 // the end of the original input list. If you want to know a specific option
@@ -590,26 +763,34 @@ func (cl *Client) Ping(ctx context.Context) error {
 // MetadataMinAge anyway, but the map is not cleaned up one the metadata
 // expires. This function ensures the map is purged.
 func (cl *Client) PurgeTopicsFromClient(topics ...string) {
-	if len(topics) == 0 {
+	cl.purgeTopics(cl.wireProduceTopics(topics), cl.consumer.wireTopics(topics))
+}
+
+// purgeTopics purges wire topic names from producing and consuming.
+func (cl *Client) purgeTopics(producing, consuming []string) {
+	if len(producing) == 0 && len(consuming) == 0 {
 		return
 	}
-	sort.Strings(topics)           // for logging in the functions
+	sort.Strings(producing)        // for logging in the functions
+	sort.Strings(consuming)        // same
 	cl.blockingMetadataFn(func() { // make reasoning about concurrency easier
 		var wg sync.WaitGroup
 		wg.Add(2)
 		go func() {
 			defer wg.Done()
-			cl.producer.purgeTopics(topics)
+			cl.producer.purgeTopics(producing)
 		}()
 		go func() {
 			defer wg.Done()
-			cl.consumer.purgeTopics(topics)
+			cl.consumer.purgeTopics(consuming)
 		}()
 		wg.Wait()
 	})
 	cl.mappedMetaMu.Lock()
-	for _, t := range topics {
-		delete(cl.mappedMeta, t)
+	for _, topics := range [][]string{producing, consuming} {
+		for _, t := range topics {
+			delete(cl.mappedMeta, t)
+		}
 	}
 	cl.mappedMetaMu.Unlock()
 }
@@ -622,6 +803,7 @@ func (cl *Client) PurgeTopicsFromProducing(topics ...string) {
 	if len(topics) == 0 {
 		return
 	}
+	topics = cl.wireProduceTopics(topics)
 	sort.Strings(topics)
 	cl.blockingMetadataFn(func() {
 		cl.producer.purgeTopics(topics)
@@ -636,6 +818,7 @@ func (cl *Client) PurgeTopicsFromConsuming(topics ...string) {
 	if len(topics) == 0 {
 		return
 	}
+	topics = cl.consumer.wireTopics(topics)
 	sort.Strings(topics)
 	cl.blockingMetadataFn(func() {
 		cl.consumer.purgeTopics(topics)
@@ -689,12 +872,18 @@ func parseBrokerAddr(addr string) (hostport, error) {
 
 type connTimeouter struct {
 	def                  time.Duration
+	defFor               func(int16) time.Duration // optional per-key override of def
 	joinMu               sync.Mutex
 	lastRebalanceTimeout time.Duration
 }
 
 func (c *connTimeouter) timeouts(req kmsg.Request) (r, w time.Duration) {
 	def := c.def
+	if c.defFor != nil {
+		if d := c.defFor(req.Key()); d > 0 {
+			def = d
+		}
+	}
 	millis := func(m int32) time.Duration { return time.Duration(m) * time.Millisecond }
 	switch t := req.(type) {
 	default:
@@ -1054,7 +1243,13 @@ func (cl *Client) close(ctx context.Context) (rerr error) {
 		sns.source.maybeConsume() // same
 	}
 
+	if c := cl.producer.coalesce; c != nil {
+		c.fail(ErrClientClosed, true)
+	}
 	cl.failBufferedRecords(ErrClientClosed)
+	if s := cl.producer.spill; s != nil {
+		s.close()
+	}
 
 	// We need one final poll: if any sources buffered a fetch, then the
 	// manageFetchConcurrency loop only exits when all fetches have been
@@ -1634,23 +1829,65 @@ func (cl *Client) doLoadCoordinators(ctx context.Context, typ int8, keys ...stri
 		//
 		// We range key2load, which contains only coordinators we are
 		// responsible for loading.
+		//
+		// For our own group and transactional ID, if loaded
+		// successfully, we track if the coordinator moved since we
+		// last loaded it. We do not track arbitrary keys (i.e., from
+		// admin requests), which would grow the map without bound.
+		type coordChange struct {
+			key      string
+			old, new int32
+		}
+		var changes []coordChange
 		cl.coordinatorsMu.Lock()
 		for key, c := range key2load {
+			ck := coordinatorKey{key, typ}
 			if c.err != nil {
-				ck := coordinatorKey{key, typ}
 				if loading, ok := cl.coordinators[ck]; ok && loading == c {
 					delete(cl.coordinators, ck)
 				}
+				continue
+			}
+			if !cl.isOwnCoordinatorKey(ck) {
+				continue
+			}
+			if old, ok := cl.lastCoordNodes[ck]; ok && old != c.node {
+				changes = append(changes, coordChange{key, old, c.node})
 			}
+			cl.lastCoordNodes[ck] = c.node
 		}
 		cl.coordinatorsMu.Unlock()
 
+		for _, change := range changes {
+			cl.cfg.logger.Log(LogLevelInfo, "coordinator changed",
+				"coordinator_type", typ,
+				"coordinator_key", change.key,
+				"old_coordinator", change.old,
+				"new_coordinator", change.new,
+			)
+			if fn := cl.cfg.onCoordinatorChange; fn != nil {
+				fn(change.key, change.old, change.new)
+			}
+		}
+
 		close(loadWait)
 		hasLoadedBrokers = cl.waitCoordinatorLoad(ctx, typ, load2key, !hasLoadedBrokers, toRequest, m)
 	}
 	return m
 }
 
+// isOwnCoordinatorKey returns whether the key is for the client's own group
+// or transactional ID.
+func (cl *Client) isOwnCoordinatorKey(ck coordinatorKey) bool {
+	switch ck.typ {
+	case coordinatorTypeGroup:
+		return cl.cfg.group != "" && ck.name == cl.cfg.group
+	case coordinatorTypeTxn:
+		return cl.cfg.txnID != nil && ck.name == *cl.cfg.txnID
+	}
+	return false
+}
+
 // After some prep work, we wait for coordinators to load. We update toRequest
 // values with true if the caller should bypass cache and re-load these
 // coordinators.
@@ -1937,6 +2174,33 @@ func (cl *Client) Broker(id int) *Broker {
 	}
 }
 
+// FetchMetadataFrom issues a metadata request for the given topics directly to
+// the given broker, returning the broker's response. If no topics are given,
+// metadata for all topics is requested. Retryable connection errors are
+// retried, but errors within the response are not checked.
+//
+// This is meant for debugging: the client normally sends metadata requests to
+// any broker, and querying each broker individually can help diagnose which
+// broker is returning stale metadata. The response does not update the
+// client's internal metadata.
+func (cl *Client) FetchMetadataFrom(ctx context.Context, brokerID int32, topics ...string) (*kmsg.MetadataResponse, error) {
+	req := kmsg.NewPtrMetadataRequest()
+	req.AllowAutoTopicCreation = cl.cfg.allowAutoTopicCreation
+	if len(topics) > 0 {
+		req.Topics = make([]kmsg.MetadataRequestTopic, 0, len(topics))
+		for _, topic := range topics {
+			rt := kmsg.NewMetadataRequestTopic()
+			rt.Topic = kmsg.StringPtr(topic)
+			req.Topics = append(req.Topics, rt)
+		}
+	}
+	resp, err := cl.Broker(int(brokerID)).RetriableRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*kmsg.MetadataResponse), nil
+}
+
 // DiscoveredBrokers returns all brokers that were discovered from prior
 // metadata responses. This does not actually issue a metadata request to load
 // brokers; if you wish to ensure this returns all brokers, be sure to manually
@@ -1955,6 +2219,22 @@ func (cl *Client) DiscoveredBrokers() []*Broker {
 	return bs
 }
 
+// Brokers returns the metadata (node ID, host, port, and rack) for all
+// brokers that were discovered from prior metadata responses, sorted by node
+// ID. As with DiscoveredBrokers, this does not issue a metadata request and
+// does not include seed brokers. This is the broker set the client uses for
+// routing requests.
+func (cl *Client) Brokers() []BrokerMetadata {
+	cl.brokersMu.RLock()
+	defer cl.brokersMu.RUnlock()
+
+	metas := make([]BrokerMetadata, 0, len(cl.brokers))
+	for _, broker := range cl.brokers {
+		metas = append(metas, broker.meta)
+	}
+	return metas
+}
+
 // SeedBrokers returns the all seed brokers.
 func (cl *Client) SeedBrokers() []*Broker {
 	var bs []*Broker
@@ -3605,9 +3885,10 @@ func (cl *writeTxnMarkersSharder) shard(ctx context.Context, kreq kmsg.Request,
 	}
 
 	type pidEpochCommit struct {
-		pid    int64
-		epoch  int16
-		commit bool
+		pid        int64
+		epoch      int16
+		commit     bool
+		coordEpoch int32
 	}
 
 	brokerReqs := make(map[int32]map[pidEpochCommit]map[string][]int32)
@@ -3645,6 +3926,7 @@ func (cl *writeTxnMarkersSharder) shard(ctx context.Context, kreq kmsg.Request,
 			marker.ProducerID,
 			marker.ProducerEpoch,
 			marker.Committed,
+			marker.CoordinatorEpoch,
 		}
 		for _, topic := range marker.Topics {
 			t := topic.Topic
@@ -3680,6 +3962,7 @@ func (cl *writeTxnMarkersSharder) shard(ctx context.Context, kreq kmsg.Request,
 			rm.ProducerID = pec.pid
 			rm.ProducerEpoch = pec.epoch
 			rm.Committed = pec.commit
+			rm.CoordinatorEpoch = pec.coordEpoch
 			for topic, parts := range topics {
 				rt := kmsg.NewWriteTxnMarkersRequestMarkerTopic()
 				rt.Topic = topic
@@ -3701,6 +3984,7 @@ func (cl *writeTxnMarkersSharder) shard(ctx context.Context, kreq kmsg.Request,
 			rm.ProducerID = pec.pid
 			rm.ProducerEpoch = pec.epoch
 			rm.Committed = pec.commit
+			rm.CoordinatorEpoch = pec.coordEpoch
 			for topic, parts := range topics {
 				rt := kmsg.NewWriteTxnMarkersRequestMarkerTopic()
 				rt.Topic = topic
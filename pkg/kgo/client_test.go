@@ -2,12 +2,18 @@ package kgo
 
 import (
 	"context"
+	"errors"
 	"reflect"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/twmb/franz-go/pkg/kerr"
 	"github.com/twmb/franz-go/pkg/kmsg"
+	"github.com/twmb/franz-go/pkg/kversion"
 )
 
 func TestMaxVersions(t *testing.T) {
@@ -123,6 +129,30 @@ func TestUnknownGroupOffsetFetchPinned(t *testing.T) {
 	req.RequestWith(context.Background(), cl)
 }
 
+func TestRequireIdempotentWriteSupportPinned(t *testing.T) {
+	// Pinning to 0.10.2 excludes InitProducerID, so NewClient should fail
+	// before ever needing to talk to a broker.
+	_, err := NewClient(
+		SeedBrokers("localhost:1"),
+		MaxVersions(kversion.V0_10_2()),
+		RequireIdempotentWriteSupport(),
+	)
+	if !errors.Is(err, ErrIdempotenceUnsupported) {
+		t.Errorf("got err %v != exp ErrIdempotenceUnsupported", err)
+	}
+
+	cl, err := NewClient(
+		SeedBrokers("localhost:1"),
+		MaxVersions(kversion.V0_10_2()),
+		RequireIdempotentWriteSupport(),
+		DisableIdempotentWrite(),
+	)
+	if err != nil {
+		t.Fatalf("unexpected err with idempotency disabled: %v", err)
+	}
+	cl.Close()
+}
+
 func TestProcessHooks(t *testing.T) {
 	var (
 		aHook     = Hook(&someHook{index: 10})
@@ -214,3 +244,714 @@ type intSliceHook []int
 func (*intSliceHook) OnNewClient(*Client) {
 	// ignore
 }
+
+func TestTopicNameMapper(t *testing.T) {
+	prefix := func(s string) string { return "tenant." + s }
+
+	t.Run("produce", func(t *testing.T) {
+		tt := &scriptedTransport{resp: scriptedProduce}
+		cl := newUnitClient(t,
+			WithTestTransport(tt),
+			TopicNameMapper(prefix, nil),
+			DefaultProduceTopic("bar"),
+		)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		// Re-producing the record must not map its topic twice.
+		r := StringRecord("v")
+		for i := 0; i < 2; i++ {
+			if err := cl.ProduceSync(ctx, r).FirstErr(); err != nil {
+				t.Fatal(err)
+			}
+			if r.Topic != "bar" {
+				t.Errorf("produce %d: got promised topic %q != exp bar", i, r.Topic)
+			}
+		}
+
+		tt.mu.Lock()
+		defer tt.mu.Unlock()
+		var produced int
+		for _, req := range tt.reqs {
+			if req, ok := req.(*kmsg.ProduceRequest); ok {
+				for _, rt := range req.Topics {
+					produced++
+					if rt.Topic != "tenant.bar" {
+						t.Errorf("got produced wire topic %q != exp tenant.bar", rt.Topic)
+					}
+				}
+			}
+		}
+		if produced != 2 {
+			t.Errorf("got %d produced topics != exp 2", produced)
+		}
+	})
+
+	t.Run("consume", func(t *testing.T) {
+		cl := newUnitClient(t,
+			TopicNameMapper(nil, prefix),
+			ConsumeTopics("foo"),
+		)
+		cl.cfg.autocommitMarks = true // AutoCommitMarks requires a real group
+
+		if _, ok := cl.cfg.topics["tenant.foo"]; !ok || len(cl.cfg.topics) != 1 {
+			t.Errorf("got wire consume topics %v != exp [tenant.foo]", cl.cfg.topics)
+		}
+		if got := cl.GetConsumeTopics(); !reflect.DeepEqual(got, []string{"foo"}) {
+			t.Errorf("got consume topics %v != exp [foo]", got)
+		}
+
+		g := &groupConsumer{cl: cl, cfg: &cl.cfg}
+		g.nowAssigned.store(map[string][]int32{"tenant.foo": {0}})
+		cl.consumer.g = g
+		defer func() { cl.consumer.g = nil }()
+		g.updateUncommitted(Fetches{injectRecords(cl, "tenant.foo", 0, 0, 3)})
+
+		fetches := cl.PollFetches(nil)
+		fetches.EachTopic(func(ft FetchTopic) {
+			if ft.Topic != "foo" {
+				t.Errorf("got fetched topic %q != exp foo", ft.Topic)
+			}
+		})
+		var polled int
+		fetches.EachRecord(func(r *Record) {
+			polled++
+			if r.Topic != "foo" {
+				t.Errorf("got record topic %q != exp foo", r.Topic)
+			}
+		})
+		if polled != 3 {
+			t.Errorf("got %d polled records != exp 3", polled)
+		}
+
+		exp := map[string]map[int32]EpochOffset{"foo": {0: {0, 3}}}
+		if got := cl.UncommittedOffsets(); !reflect.DeepEqual(got, exp) {
+			t.Errorf("got uncommitted %v != exp %v", got, exp)
+		}
+
+		// Marking with the logical name marks the wire partition.
+		cl.MarkCommitOffsets(map[string]map[int32]EpochOffset{"foo": {0: {0, 2}}})
+		if got := g.getUncommitted(false); got["tenant.foo"][0].Offset != 2 {
+			t.Errorf("got internal marked %v != exp tenant.foo offset 2", got)
+		}
+		exp = map[string]map[int32]EpochOffset{"foo": {0: {0, 2}}}
+		if got := cl.MarkedOffsets(); !reflect.DeepEqual(got, exp) {
+			t.Errorf("got marked %v != exp %v", got, exp)
+		}
+
+		if got := cl.PauseFetchTopics("foo"); !reflect.DeepEqual(got, []string{"foo"}) {
+			t.Errorf("got paused topics %v != exp [foo]", got)
+		}
+		if got := cl.consumer.loadPaused().pausedTopics(); !reflect.DeepEqual(got, []string{"tenant.foo"}) {
+			t.Errorf("got wire paused topics %v != exp [tenant.foo]", got)
+		}
+		cl.ResumeFetchTopics("foo")
+		if got := cl.PauseFetchTopics(); len(got) != 0 {
+			t.Errorf("got paused topics %v after resuming, exp none", got)
+		}
+	})
+
+	t.Run("callbacks", func(t *testing.T) {
+		topics := make(map[string][]string) // callback => topics it was called with
+		called := func(kind, topic string) { topics[kind] = append(topics[kind], topic) }
+		cl := newUnitClient(t,
+			WithTestTransport(&scriptedTransport{resp: scriptedProduce}),
+			TopicNameMapper(prefix, prefix),
+			DefaultProduceTopic("foo"),
+			WithRecordCodec(topicCodec(called)),
+			SkipCorruptBatches(true),
+			OnCorruptBatch(func(topic string, _ int32, _ int64, _ error) { called("corrupt", topic) }),
+			MaxConsumeRecordBytes(1),
+			OnRecordTooLarge(func(topic string, _ int32, _ int64, _ int) { called("too large", topic) }),
+			OnPartitionEOF(func(topic string, _ int32, _ int64) { called("eof", topic) }),
+		)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := cl.ProduceSync(ctx, StringRecord("v")).FirstErr(); err != nil {
+			t.Fatal(err)
+		}
+
+		// A corrupt batch at offset 0 is skipped, then offset 1 is
+		// decoded and offset 2 is too large.
+		corrupt := encodeRecordBatch(t, 0, NoCompression(), "a")
+		corrupt[17] ^= 0xff
+		in := append(corrupt, encodeRecordBatch(t, 1, NoCompression(), "a", "ab")...)
+
+		p := metadataPartition{topic: cl.consumer.wireTopic("foo")}.newPartition(cl, false)
+		o := cursorOffsetNext{from: p.cursor}
+		fp := o.processRespPartition(nil, &kmsg.FetchResponseTopicPartition{RecordBatches: in}, 0, newDecompressor(), nil)
+		if fp.Err != nil || len(fp.Records) != 1 {
+			t.Fatalf("got err %v and %d records, exp no error and 1 record", fp.Err, len(fp.Records))
+		}
+		o.maybeEOF(&cl.consumer, &FetchPartition{HighWatermark: o.offset}, 0)
+
+		exp := map[string][]string{
+			"encode":    {"foo"},
+			"corrupt":   {"foo"},
+			"decode":    {"foo"},
+			"too large": {"foo"},
+			"eof":       {"foo"},
+		}
+		if !reflect.DeepEqual(topics, exp) {
+			t.Errorf("got callback topics %v != exp %v", topics, exp)
+		}
+	})
+}
+
+// topicCodec is an identity RecordCodec that reports the topics it is called
+// with.
+type topicCodec func(kind, topic string)
+
+func (c topicCodec) Encode(topic string, v []byte) ([]byte, error) {
+	c("encode", topic)
+	return v, nil
+}
+
+func (c topicCodec) Decode(topic string, v []byte) ([]byte, error) {
+	c("decode", topic)
+	return v, nil
+}
+
+func TestTestTransport(t *testing.T) {
+	tt := &scriptedTransport{resp: scriptedProduce}
+
+	cl := newUnitClient(t,
+		WithTestTransport(tt),
+		DefaultProduceTopic("foo"),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	r, err := cl.ProduceSync(ctx, StringRecord("v")).First()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Offset != 41 || r.ProducerID != 7 {
+		t.Errorf("got offset %d, producer ID %d != exp 41, 7", r.Offset, r.ProducerID)
+	}
+
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	var produced bool
+	for _, req := range tt.reqs {
+		if req, ok := req.(*kmsg.ProduceRequest); ok {
+			produced = true
+			if req.Acks != -1 || len(req.Topics) != 1 || req.Topics[0].Topic != "foo" {
+				t.Errorf("unexpected produce request: acks %d, topics %v", req.Acks, req.Topics)
+			}
+		}
+	}
+	if !produced {
+		t.Error("test transport did not see a produce request")
+	}
+}
+
+func TestPartitionReplicas(t *testing.T) {
+	cl := newUnitClient(t,
+		WithTestTransport(&scriptedTransport{resp: scriptedProduce}),
+		DefaultProduceTopic("foo"),
+	)
+
+	if infos, err := cl.PartitionReplicas("foo"); infos != nil || err != nil {
+		t.Errorf("got %v, %v for an unloaded topic, expected nil, nil", infos, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := cl.ProduceSync(ctx, StringRecord("v")).FirstErr(); err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := cl.PartitionReplicas("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := map[int32]PartitionReplicaInfo{0: {
+		Leader:      0,
+		LeaderEpoch: -1,
+		Replicas:    []int32{0, 1, 2},
+		ISR:         []int32{0, 1},
+	}}
+	if !reflect.DeepEqual(infos, exp) {
+		t.Errorf("got %+v != exp %+v", infos, exp)
+	}
+}
+
+func TestRequestTimeoutFor(t *testing.T) {
+	c := connTimeouter{
+		def: 10 * time.Second,
+		defFor: func(key int16) time.Duration {
+			if key == int16(kmsg.OffsetCommit) {
+				return time.Minute
+			}
+			return 0
+		},
+	}
+	for _, test := range []struct {
+		req  kmsg.Request
+		r, w time.Duration
+	}{
+		{kmsg.NewPtrOffsetCommitRequest(), time.Minute, time.Minute},
+		{kmsg.NewPtrMetadataRequest(), 10 * time.Second, 10 * time.Second},
+		{&kmsg.FetchRequest{MaxWaitMillis: 500}, 10*time.Second + 500*time.Millisecond, 10 * time.Second},
+	} {
+		r, w := c.timeouts(test.req)
+		if r != test.r || w != test.w {
+			t.Errorf("key %d: got read %v, write %v != exp %v, %v", test.req.Key(), r, w, test.r, test.w)
+		}
+	}
+}
+
+type xorCodec byte
+
+func (c xorCodec) Encode(_ string, v []byte) ([]byte, error) {
+	enc := make([]byte, len(v))
+	for i := range v {
+		enc[i] = v[i] ^ byte(c)
+	}
+	return enc, nil
+}
+
+func (c xorCodec) Decode(_ string, v []byte) ([]byte, error) {
+	if len(v) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return c.Encode("", v)
+}
+
+func TestRecordCodec(t *testing.T) {
+	tt := &scriptedTransport{resp: scriptedProduce}
+	cl := newUnitClient(t,
+		WithTestTransport(tt),
+		DefaultProduceTopic("foo"),
+		WithRecordCodec(xorCodec(1)),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	r, err := cl.ProduceSync(ctx, StringRecord("ab")).First()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(r.Value) != "`c" {
+		t.Errorf("got produced value %q != exp encoded %q", r.Value, "`c")
+	}
+
+	// Producing the same record again encodes the already encoded value;
+	// this is documented on Record.Value.
+	r, err = cl.ProduceSync(ctx, r).First()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(r.Value) != "ab" {
+		t.Errorf("got re-produced value %q != exp twice encoded %q", r.Value, "ab")
+	}
+
+	// The record at offset 1 fails to decode: the record before it is
+	// returned, the decode error is the partition error, and the offset
+	// does not advance past the undecodable record.
+	in := encodeRecordBatch(t, 0, NoCompression(), "`c", "", "`c")
+
+	o := cursorOffsetNext{from: &cursor{topic: "foo", decode: cl.recordDecodeFn("foo")}}
+	fp := o.processRespPartition(nil, &kmsg.FetchResponseTopicPartition{RecordBatches: in}, 0, newDecompressor(), nil)
+	if fp.Err == nil {
+		t.Error("expected decode error")
+	}
+	if len(fp.Records) != 1 || fp.Records[0].Offset != 0 {
+		t.Fatalf("got %d kept records, exp only offset 0", len(fp.Records))
+	}
+	if string(fp.Records[0].Value) != "ab" {
+		t.Errorf("got consumed value %q != exp decoded %q", fp.Records[0].Value, "ab")
+	}
+	if o.offset != 1 {
+		t.Errorf("got offset %d != exp 1", o.offset)
+	}
+}
+
+func TestOnCoordinatorChange(t *testing.T) {
+	var node atomic.Int32
+	tt := &scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+		if req, ok := req.(*kmsg.FindCoordinatorRequest); ok {
+			resp := req.ResponseKind().(*kmsg.FindCoordinatorResponse)
+			for _, key := range req.CoordinatorKeys {
+				c := kmsg.NewFindCoordinatorResponseCoordinator()
+				c.Key, c.NodeID, c.Host, c.Port = key, node.Load(), "localhost", 1
+				resp.Coordinators = append(resp.Coordinators, c)
+			}
+			return resp, nil
+		}
+		return scriptedProduce(req)
+	}}
+
+	type change struct {
+		key      string
+		old, new int32
+	}
+	var (
+		mu      sync.Mutex
+		changes []change
+	)
+	cl := newUnitClient(t,
+		WithTestTransport(tt),
+		TransactionalID("txn"),
+		OnCoordinatorChange(func(key string, old, new int32) {
+			mu.Lock()
+			defer mu.Unlock()
+			changes = append(changes, change{key, old, new})
+		}),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	load := func() {
+		cl.loadCoordinators(ctx, coordinatorTypeTxn, "txn")
+		cl.loadCoordinators(ctx, coordinatorTypeGroup, "admin-group")
+		cl.deleteStaleCoordinator("txn", coordinatorTypeTxn)
+		cl.deleteStaleCoordinator("admin-group", coordinatorTypeGroup)
+	}
+	load()
+	node.Store(1)
+	load()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if exp := []change{{"txn", 0, 1}}; !reflect.DeepEqual(changes, exp) {
+		t.Errorf("got changes %v != exp %v", changes, exp)
+	}
+	cl.coordinatorsMu.Lock()
+	defer cl.coordinatorsMu.Unlock()
+	if len(cl.lastCoordNodes) != 1 {
+		t.Errorf("got %d tracked coordinators != exp 1 (only our own)", len(cl.lastCoordNodes))
+	}
+}
+
+func TestMetadataRefreshJitter(t *testing.T) {
+	for _, bad := range []float64{-0.1, 1} {
+		if _, err := NewClient(MetadataRefreshJitter(bad)); err == nil {
+			t.Errorf("expected error for jitter %v", bad)
+		}
+	}
+
+	const age = 10 * time.Second
+	cl := newUnitClient(t, MetadataMaxAge(age))
+	if got := cl.jitteredMetadataMaxAge(); got != age {
+		t.Errorf("got unjittered refresh %v != exp %v", got, age)
+	}
+
+	cl = newUnitClient(t, MetadataMaxAge(age), MetadataRefreshJitter(0.1))
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 100; i++ {
+		got := cl.jitteredMetadataMaxAge()
+		if got < 9*time.Second || got > 11*time.Second {
+			t.Fatalf("got jittered refresh %v outside of [9s, 11s]", got)
+		}
+		seen[got] = true
+	}
+	if len(seen) < 2 {
+		t.Error("jittered refreshes were not randomized")
+	}
+}
+
+type e2eVersionHook struct {
+	mu       sync.Mutex
+	versions map[int16]int16
+}
+
+func (h *e2eVersionHook) OnBrokerE2E(_ BrokerMetadata, key int16, e2e BrokerE2E) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.versions[key] = e2e.Version
+}
+
+func TestBrokerE2EVersion(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		maxMD  int16 // 0 leaves the client's max versions alone
+		expVer int16
+	}{
+		{"broker_max", 0, 8},
+		{"pinned", 5, 5},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFakeBroker(t)
+			h := &e2eVersionHook{versions: make(map[int16]int16)}
+			opts := []Opt{SeedBrokers(f.addr()), WithHooks(h)}
+			if test.maxMD > 0 {
+				vs := kversion.Stable()
+				vs.SetMaxKeyVersion(3, test.maxMD)
+				opts = append(opts, MaxVersions(vs))
+			}
+			cl, err := NewClient(opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cl.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if _, err := cl.Request(ctx, kmsg.NewPtrMetadataRequest()); err != nil {
+				t.Fatal(err)
+			}
+
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			if got, ok := h.versions[3]; !ok || got != test.expVer {
+				t.Errorf("got metadata e2e version %d (seen? %v) != exp %d", got, ok, test.expVer)
+			}
+			if _, ok := h.versions[18]; !ok {
+				t.Error("expected an e2e hook for the api versions request")
+			}
+		})
+	}
+}
+
+func TestRequestTransform(t *testing.T) {
+	f := newFakeBroker(t)
+
+	var (
+		mu        sync.Mutex
+		calls     []fakeHeader // the key and correlation ID of each call, and the returned client ID
+		metadatas int
+	)
+	cl := newUnitClient(t,
+		SeedBrokers(f.addr()),
+		ClientID("configured"),
+		RequestTransform(func(key int16, corrID int32) string {
+			mu.Lock()
+			defer mu.Unlock()
+			var id string
+			if key == 3 {
+				if metadatas++; metadatas == 1 {
+					id = "override" // only the first metadata request is overridden
+				}
+			}
+			calls = append(calls, fakeHeader{key: key, corrID: corrID, clientID: id})
+			return id
+		}),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		if _, err := cl.Request(ctx, kmsg.NewPtrMetadataRequest()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	received := f.received()
+	if len(received) != len(calls) {
+		t.Fatalf("got %d requests != exp one per transform call (%d)", len(received), len(calls))
+	}
+	var overridden, kept int
+	for i, h := range received {
+		call := calls[i]
+		if h.key != call.key || h.corrID != call.corrID {
+			t.Errorf("request %d: got key %d, correlation ID %d != transformed key %d, correlation ID %d", i, h.key, h.corrID, call.key, call.corrID)
+		}
+		exp := call.clientID
+		if exp == "" {
+			exp = "configured"
+			kept++
+		} else {
+			overridden++
+		}
+		if h.clientID != exp {
+			t.Errorf("request %d (key %d): got client ID %q != exp %q", i, h.key, h.clientID, exp)
+		}
+	}
+	if overridden != 1 || kept < 2 {
+		t.Errorf("got %d overridden and %d configured client IDs, exp 1 and at least 2", overridden, kept)
+	}
+}
+
+func TestFetchMetadataFrom(t *testing.T) {
+	tt := &scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+		resp := req.ResponseKind().(*kmsg.MetadataResponse)
+		for node := int32(0); node < 2; node++ {
+			b := kmsg.NewMetadataResponseBroker()
+			b.NodeID, b.Host, b.Port = node, "localhost", 1+node
+			resp.Brokers = append(resp.Brokers, b)
+		}
+		return resp, nil
+	}}
+	cl := newUnitClient(t, WithTestTransport(tt), AllowAutoTopicCreation())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := cl.Request(ctx, kmsg.NewPtrMetadataRequest()); err != nil { // discover broker 1
+		t.Fatal(err)
+	}
+
+	for _, topics := range [][]string{{"foo", "bar"}, nil} {
+		resp, err := cl.FetchMetadataFrom(ctx, 1, topics...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Brokers) != 2 {
+			t.Errorf("got %d brokers in the response != exp 2", len(resp.Brokers))
+		}
+
+		tt.mu.Lock()
+		req := tt.reqs[len(tt.reqs)-1].(*kmsg.MetadataRequest)
+		node := tt.nodes[len(tt.nodes)-1]
+		tt.mu.Unlock()
+		if node != 1 {
+			t.Errorf("got metadata request issued to broker %d != exp 1", node)
+		}
+		if !req.AllowAutoTopicCreation {
+			t.Error("expected the request to allow auto topic creation")
+		}
+		var got []string
+		for _, rt := range req.Topics {
+			got = append(got, *rt.Topic)
+		}
+		if !reflect.DeepEqual(got, topics) {
+			t.Errorf("got requested topics %v != exp %v", got, topics)
+		}
+		if topics == nil && req.Topics != nil {
+			t.Error("expected nil topics to request all topics")
+		}
+	}
+}
+
+func TestBrokers(t *testing.T) {
+	rack := "rack"
+	tt := &scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+		resp := req.ResponseKind().(*kmsg.MetadataResponse)
+		for _, node := range []int32{2, 0, 1} {
+			b := kmsg.NewMetadataResponseBroker()
+			b.NodeID, b.Host, b.Port = node, "localhost", 10+node
+			if node == 1 {
+				b.Rack = &rack
+			}
+			resp.Brokers = append(resp.Brokers, b)
+		}
+		return resp, nil
+	}}
+	cl := newUnitClient(t, WithTestTransport(tt))
+
+	if brokers := cl.Brokers(); len(brokers) != 0 {
+		t.Errorf("got %v before any metadata, exp no brokers (seeds are not included)", brokers)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := cl.Request(ctx, kmsg.NewPtrMetadataRequest()); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []BrokerMetadata{
+		{NodeID: 0, Host: "localhost", Port: 10},
+		{NodeID: 1, Host: "localhost", Port: 11, Rack: &rack},
+		{NodeID: 2, Host: "localhost", Port: 12},
+	}
+	if got := cl.Brokers(); !reflect.DeepEqual(got, exp) {
+		t.Errorf("got brokers %v != exp %v", got, exp)
+	}
+}
+
+func TestOnMetadataRetry(t *testing.T) {
+	var fooErrs atomic.Int32
+	tt := &scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+		resp, err := scriptedProduce(req)
+		if mresp, ok := resp.(*kmsg.MetadataResponse); ok {
+			for i := range mresp.Topics {
+				st := &mresp.Topics[i]
+				if *st.Topic == "foo" && fooErrs.Add(1) == 1 {
+					st.ErrorCode = kerr.LeaderNotAvailable.Code
+				}
+			}
+		}
+		return resp, err
+	}}
+	reasons := make(chan map[string]error, 1)
+	cl := newUnitClient(t,
+		WithTestTransport(tt),
+		MetadataMinAge(10*time.Millisecond),
+		OnMetadataRetry(func(why map[string]error) {
+			select {
+			case reasons <- why:
+			default:
+			}
+		}),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := cl.ProduceSync(ctx, &Record{Topic: "foo"}, &Record{Topic: "bar"}).FirstErr(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case why := <-reasons:
+		if len(why) != 1 || !errors.Is(why["foo"], kerr.LeaderNotAvailable) {
+			t.Errorf("got retry reasons %v, exp only foo with %v", why, kerr.LeaderNotAvailable)
+		}
+	default:
+		t.Error("OnMetadataRetry was not called")
+	}
+}
+
+func TestRefreshPartitionLeader(t *testing.T) {
+	var leader1 atomic.Int32 // the leader of partition 1
+	tt := &scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+		mreq, ok := req.(*kmsg.MetadataRequest)
+		if !ok {
+			return scriptedProduce(req)
+		}
+		resp := mreq.ResponseKind().(*kmsg.MetadataResponse)
+		for node := int32(0); node < 2; node++ {
+			b := kmsg.NewMetadataResponseBroker()
+			b.NodeID, b.Host, b.Port = node, "localhost", 1+node
+			resp.Brokers = append(resp.Brokers, b)
+		}
+		for _, rt := range mreq.Topics {
+			st := kmsg.NewMetadataResponseTopic()
+			st.Topic = rt.Topic
+			for p := int32(0); p < 2; p++ {
+				sp := kmsg.NewMetadataResponseTopicPartition()
+				sp.Partition = p
+				if p == 1 {
+					sp.Leader = leader1.Load()
+				}
+				st.Partitions = append(st.Partitions, sp)
+			}
+			resp.Topics = append(resp.Topics, st)
+		}
+		return resp, nil
+	}}
+	cl := newUnitClient(t,
+		WithTestTransport(tt),
+		DefaultProduceTopic("foo"),
+		RecordPartitioner(ManualPartitioner()),
+		MetadataMinAge(time.Minute), // only our refresh updates metadata
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := cl.ProduceSync(ctx, &Record{Partition: 1}).FirstErr(); err != nil {
+		t.Fatal(err)
+	}
+	if leader, _, _ := cl.PartitionLeader("foo", 1); leader != 0 {
+		t.Fatalf("got initial leader %d != exp 0", leader)
+	}
+
+	leader1.Store(1)
+	if err := cl.RefreshPartitionLeader(ctx, "foo", 1); err != nil {
+		t.Fatal(err)
+	}
+	if leader, _, _ := cl.PartitionLeader("foo", 1); leader != 1 {
+		t.Errorf("got leader %d after refreshing != exp 1", leader)
+	}
+
+	if err := cl.RefreshPartitionLeader(ctx, "foo", -1); err == nil {
+		t.Error("expected an error refreshing a negative partition")
+	}
+	if err := cl.RefreshPartitionLeader(ctx, "foo", 2); err == nil || !strings.Contains(err.Error(), "partition 2 does not exist") {
+		t.Errorf("got err %v, exp partition 2 to not exist", err)
+	}
+}
@@ -0,0 +1,39 @@
+package kgo
+
+import "time"
+
+// clock abstracts time for the spots in the client where time-dependent
+// behavior (metadata backoff, transaction marker sleeps, concurrent
+// transaction retry windows) is worth testing deterministically. The client
+// always uses the real clock; tests can inject their own with withClock.
+type clock interface {
+	Now() time.Time
+	Since(time.Time) time.Duration
+	Sleep(time.Duration)
+	NewTimer(time.Duration) clockTimer
+}
+
+// clockTimer is the subset of *time.Timer that the client uses.
+type clockTimer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                  { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration { return time.Since(t) }
+func (realClock) Sleep(d time.Duration)           { time.Sleep(d) }
+func (realClock) NewTimer(d time.Duration) clockTimer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct{ t *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.t.C }
+func (t realTimer) Stop() bool          { return t.t.Stop() }
+
+// withClock overrides the client's clock; this is only used in tests.
+func withClock(c clock) Opt {
+	return clientOpt{func(cfg *cfg) { cfg.clock = c }}
+}
@@ -0,0 +1,138 @@
+package kgo
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// fakeClock is a clock that only advances when told to. Timers fire
+// immediately, advancing the clock by the timer's duration, and all timer
+// durations and sleeps are recorded.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	waited []time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1700000000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Since(t time.Time) time.Duration { return c.Now().Sub(t) }
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.waited = append(c.waited, d)
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) clockTimer {
+	c.Sleep(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.Now()
+	return fakeTimer(ch)
+}
+
+func (c *fakeClock) Waited() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Duration(nil), c.waited...)
+}
+
+type fakeTimer chan time.Time
+
+func (t fakeTimer) C() <-chan time.Time { return t }
+func (fakeTimer) Stop() bool            { return false }
+
+func TestConcurrentTransactionsBackoffClock(t *testing.T) {
+	fc := newFakeClock()
+	cl := newUnitClient(t,
+		ConcurrentTransactionsBackoff(20*time.Millisecond),
+		withClock(fc),
+	)
+
+	var tries int
+	err := cl.doWithConcurrentTransactions(context.Background(), kmsg.EndTxn.Int16(), func() error {
+		tries++
+		switch tries {
+		case 1:
+			return kerr.ConcurrentTransactions
+		case 2:
+			// Over a second has now passed since we started;
+			// the backoff should be bumped to a minimum of 200ms.
+			fc.Advance(1500 * time.Millisecond)
+			return kerr.ConcurrentTransactions
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if tries != 3 {
+		t.Errorf("got %d tries != exp 3", tries)
+	}
+
+	exp := []time.Duration{20 * time.Millisecond, 200 * time.Millisecond}
+	got := fc.Waited()
+	if len(got) != len(exp) {
+		t.Fatalf("got waits %v != exp %v", got, exp)
+	}
+	for i := range exp {
+		if got[i] != exp[i] {
+			t.Errorf("wait %d: got %v != exp %v", i, got[i], exp[i])
+		}
+	}
+}
+
+func TestConcurrentTransactionsTimeout(t *testing.T) {
+	cl := newUnitClient(t,
+		RetryTimeout(time.Second),
+		withClock(newFakeClock()),
+	)
+
+	var tries int
+	err := cl.doWithConcurrentTransactions(context.Background(), kmsg.EndTxn.Int16(), func() error {
+		tries++
+		return kerr.ConcurrentTransactions
+	})
+	if !errors.Is(err, ErrConcurrentTransactionsTimeout) {
+		t.Errorf("got err %v, expected ErrConcurrentTransactionsTimeout", err)
+	}
+	if !errors.Is(err, kerr.ConcurrentTransactions) {
+		t.Errorf("got err %v, expected wrapped kerr.ConcurrentTransactions", err)
+	}
+	if tries < 2 {
+		t.Errorf("got %d tries, expected retries before timing out", tries)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err = cl.doWithConcurrentTransactions(ctx, kmsg.EndTxn.Int16(), func() error {
+		cancel()
+		return kerr.ConcurrentTransactions
+	})
+	if err != context.Canceled {
+		t.Errorf("got err %v != exp context.Canceled", err)
+	}
+}
@@ -66,6 +66,7 @@ func (c CompressionCodec) WithLevel(level int) CompressionCodec {
 
 type compressor struct {
 	options  []codecType
+	minBytes int // batches smaller than this are not compressed
 	gzPool   sync.Pool
 	lz4Pool  sync.Pool
 	zstdPool sync.Pool
@@ -11,6 +11,7 @@ import (
 	"regexp"
 	"runtime/debug"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/twmb/franz-go/pkg/kmsg"
@@ -75,10 +76,20 @@ type cfg struct {
 	requestTimeoutOverhead time.Duration
 	connIdleTimeout        time.Duration
 
+	dialKeepAlive time.Duration // 0 uses the net.Dialer default, <0 disables
+	dialControl   func(string, string, syscall.RawConn) error
+
 	softwareName    string // KIP-511
 	softwareVersion string // KIP-511
 
+	requestTransform func(int16, int32) string
+
+	requestTimeoutFor func(int16) time.Duration
+
+	testTransport TestTransport
+
 	logger Logger
+	clock  clock
 
 	seedBrokers []string
 	maxVersions *kversion.Versions
@@ -93,13 +104,19 @@ type cfg struct {
 
 	allowAutoTopicCreation bool
 
-	metadataMaxAge time.Duration
-	metadataMinAge time.Duration
+	metadataMaxAge    time.Duration
+	metadataMinAge    time.Duration
+	metadataMaxJitter float64
+	onMetadataRetry   func(map[string]error)
+
+	onCoordinatorChange func(string, int32, int32)
 
 	sasls []sasl.Mechanism
 
 	hooks hooks
 
+	recordCodec RecordCodec
+
 	//////////////////////
 	// PRODUCER SECTION //
 	//////////////////////
@@ -108,10 +125,18 @@ type cfg struct {
 	txnTimeout         time.Duration
 	acks               Acks
 	disableIdempotency bool
+	verifyIdempotency  bool
 	maxProduceInflight int                // if idempotency is disabled, we allow a configurable max inflight
 	compression        []CompressionCodec // order of preference
+	compressMinBytes   int                // batches smaller than this are not compressed
+	relaxOrdering      bool               // if idempotency is disabled, skip the okOnSink gate
+
+	maxInflightProduceRequests int // global limit across all brokers; 0 is unlimited
+
+	txnEndUncancelable bool
 
 	defaultProduceTopic string
+	recordValidator     func(*Record) error
 	maxRecordBatchBytes int32
 	maxBufferedRecords  int64
 	maxBufferedBytes    int64
@@ -120,10 +145,41 @@ type cfg struct {
 	maxUnknownFailures  int64
 	linger              time.Duration
 	recordTimeout       time.Duration
+	topicProduceOpts    map[string]topicProduceOpts
+	produceTopicMapper  func(string) string
+	onRetryExhausted    func(*Record, int, error)
 	manualFlushing      bool
+	requireConnection   bool
 	txnBackoff          time.Duration
 	missingTopicDelete  time.Duration
 
+	txnCoordUnavailable TxnCoordinatorBehavior
+
+	onProducerIDRecovered func(old, new ProducerIDEpoch, err error)
+
+	dedupWindow  time.Duration
+	dedupMaxKeys int
+
+	flushOnRecords int // 0 disables
+
+	topicAcks map[string]Acks
+
+	spillDir      string // empty disables
+	spillMaxBytes int64
+
+	produceCoalesceWindow time.Duration
+
+	onBufferHighWater func(int64, int64)
+	onBufferLowWater  func(int64, int64)
+	bufferHighWater   float64 // fraction of the max buffered limits
+	bufferLowWater    float64
+
+	verifyProduceOrdering bool
+
+	onDuplicateAcked func(string, int32, int64)
+
+	onProduceBatchRetry func(string, int32, int, error)
+
 	partitioner Partitioner
 
 	stopOnDataLoss bool
@@ -138,19 +194,48 @@ type cfg struct {
 	maxBytes       lazyI32
 	maxPartBytes   lazyI32
 	resetOffset    Offset
-	isolationLevel int8
+	isolationLevel lazyI32 // int8, but atomic for SetIsolationLevel
 	keepControl    bool
+	includeAborted bool
 	rack           string
 	preferLagFn    PreferLagFn
 
+	skipCorruptBatches bool
+	onCorruptBatch     func(string, int32, int64, error)
+
+	partitionProcessor func(string, int32) RecordProcessor
+
+	fairPoll bool
+
+	maxPollInterval time.Duration // 0 disables the poll watchdog
+	onPollStall     func(time.Duration)
+
+	onPartitionEOF func(string, int32, int64)
+
+	onOffsetOutOfRange func(string, int32, int64) (Offset, error)
+
+	maxConsumeRecordBytes int // 0 is unbounded
+	onRecordTooLarge      func(string, int32, int64, int)
+
 	maxConcurrentFetches     int
+	maxConcurrentFetchParts  int   // 0 is unbounded
+	maxBufferedFetchBytes    int64 // 0 is unbounded
 	disableFetchSessions     bool
 	keepRetryableFetchErrors bool
+	fetchDecompressWorkers   int
 
 	topics     map[string]*regexp.Regexp   // topics to consume; if regex is true, values are compiled regular expressions
 	partitions map[string]map[int32]Offset // partitions to directly consume from
 	regex      bool
 
+	excludeTopics map[string]*regexp.Regexp // regular expressions of topics to not consume when consuming via regex
+
+	regexExcludeInternal bool
+	consumeTopicMapper   func(string) string
+	onRegexMatched       func(added, removed []string)
+
+	regexDiscoveryInterval time.Duration // 0 discovers on every metadata update
+
 	////////////////////////////
 	// CONSUMER GROUP SECTION //
 	////////////////////////////
@@ -160,16 +245,25 @@ type cfg struct {
 	balancers  []GroupBalancer // balancers we can use
 	protocol   string          // "consumer" by default, expected to never be overridden
 
+	importedMemberState *GroupMemberState
+
 	sessionTimeout    time.Duration
 	rebalanceTimeout  time.Duration
 	heartbeatInterval time.Duration
 	requireStable     bool
 
+	txnCommitRebalanceRetries int
+
 	onAssigned func(context.Context, *Client, map[string][]int32)
 	onRevoked  func(context.Context, *Client, map[string][]int32)
 	onLost     func(context.Context, *Client, map[string][]int32)
 	onFetched  func(context.Context, *Client, *kmsg.OffsetFetchResponse) error
 
+	onPartialRevoke func(context.Context, *Client, map[string][]int32)
+	onRebalance     func(RebalanceInfo)
+
+	offsetStore OffsetStore
+
 	adjustOffsetsBeforeAssign func(ctx context.Context, offsets map[string]map[int32]Offset) (map[string]map[int32]Offset, error)
 
 	blockRebalanceOnPoll bool
@@ -184,6 +278,9 @@ type cfg struct {
 	autocommitMarks    bool
 	autocommitInterval time.Duration
 	commitCallback     func(*Client, *kmsg.OffsetCommitRequest, *kmsg.OffsetCommitResponse, error)
+
+	revokeCommitTimeout time.Duration // 0 is unbounded
+	onAutoCommit        func(map[string]map[int32]EpochOffset, error)
 }
 
 func (cfg *cfg) validate() error {
@@ -208,9 +305,17 @@ func (cfg *cfg) validate() error {
 		if cfg.acks.val != -1 {
 			return errors.New("idempotency requires acks=all")
 		}
+		for topic, acks := range cfg.topicAcks {
+			if acks.val != -1 {
+				return fmt.Errorf("idempotency requires acks=all, but topic %s uses a different ProduceTopicAcks", topic)
+			}
+		}
 		if cfg.maxProduceInflight != 1 {
 			return fmt.Errorf("invalid usage of MaxProduceRequestsInflightPerBroker with idempotency enabled")
 		}
+		if cfg.relaxOrdering {
+			return errors.New("invalid usage of RelaxPartitionOrdering with idempotency enabled")
+		}
 	}
 
 	for _, limit := range []struct {
@@ -278,6 +383,11 @@ func (cfg *cfg) validate() error {
 
 		// 0 <= allowed concurrency
 		{name: "max concurrent fetches", v: int64(cfg.maxConcurrentFetches), allowed: 0, badcmp: i64lt},
+		{name: "max concurrent fetch partitions", v: int64(cfg.maxConcurrentFetchParts), allowed: 0, badcmp: i64lt},
+		{name: "max buffered fetch bytes", v: cfg.maxBufferedFetchBytes, allowed: 0, badcmp: i64lt},
+		{name: "max consume record bytes", v: int64(cfg.maxConsumeRecordBytes), allowed: 0, badcmp: i64lt},
+		{name: "max poll interval", v: int64(cfg.maxPollInterval), allowed: 0, badcmp: i64lt, durs: true},
+		{name: "fetch decompress workers", v: int64(cfg.fetchDecompressWorkers), allowed: 1, badcmp: i64lt},
 
 		// 1s <= request timeout overhead <= 15m
 		{name: "request timeout max overhead", v: int64(cfg.requestTimeoutOverhead), allowed: int64(15 * time.Minute), badcmp: i64gt, durs: true},
@@ -294,6 +404,11 @@ func (cfg *cfg) validate() error {
 
 		// Some random producer settings.
 		{name: "max buffered records", v: cfg.maxBufferedRecords, allowed: 1, badcmp: i64lt},
+		{name: "compression min bytes", v: int64(cfg.compressMinBytes), allowed: 0, badcmp: i64lt},
+		{name: "max in flight produce requests", v: int64(cfg.maxInflightProduceRequests), allowed: 0, badcmp: i64lt},
+		{name: "produce dedup window", v: int64(cfg.dedupWindow), allowed: 0, badcmp: i64lt, durs: true},
+		{name: "txn coordinator unavailable wait", v: int64(cfg.txnCoordUnavailable.wait), allowed: 0, badcmp: i64lt, durs: true},
+		{name: "flush on record", v: int64(cfg.flushOnRecords), allowed: 0, badcmp: i64lt},
 		{name: "max buffered bytes", v: cfg.maxBufferedBytes, allowed: 0, badcmp: i64lt},
 		{name: "linger", v: int64(cfg.linger), allowed: int64(time.Minute), badcmp: i64gt, durs: true},
 		{name: "produce timeout", v: int64(cfg.produceTimeout), allowed: int64(100 * time.Millisecond), badcmp: i64lt, durs: true},
@@ -316,6 +431,8 @@ func (cfg *cfg) validate() error {
 		{name: "session timeout", v: int64(cfg.sessionTimeout), allowed: int64(100 * time.Millisecond), badcmp: i64lt, durs: true},
 		{name: "rebalance timeout", v: int64(cfg.rebalanceTimeout), allowed: int64(100 * time.Millisecond), badcmp: i64lt, durs: true},
 		{name: "autocommit interval", v: int64(cfg.autocommitInterval), allowed: int64(100 * time.Millisecond), badcmp: i64lt, durs: true},
+		{name: "revoke commit timeout", v: int64(cfg.revokeCommitTimeout), allowed: 0, badcmp: i64lt, durs: true},
+		{name: "txn commit rebalance retries", v: int64(cfg.txnCommitRebalanceRetries), allowed: 0, badcmp: i64lt},
 
 		{v: int64(cfg.heartbeatInterval), allowed: int64(cfg.rebalanceTimeout) * int64(time.Millisecond), badcmp: i64gt, durs: true, fmt: "heartbeat interval %v is erroneously larger than the session timeout %v"},
 	} {
@@ -334,10 +451,57 @@ func (cfg *cfg) validate() error {
 		}
 	}
 
+	for topic, opts := range cfg.topicProduceOpts {
+		if opts.linger < 0 || opts.linger > time.Minute {
+			return fmt.Errorf("topic %s linger %v must be at least 0 and at most %v", topic, opts.linger, time.Minute)
+		}
+		if opts.maxBatchBytes != 0 && (opts.maxBatchBytes < 512 || opts.maxBatchBytes > 256<<20) {
+			return fmt.Errorf("topic %s max batch bytes %v must be 0 (use the client default) or between 512 and %v", topic, opts.maxBatchBytes, 256<<20)
+		}
+	}
+
+	if cfg.spillDir != "" {
+		if cfg.spillMaxBytes < 1 {
+			return fmt.Errorf("produce disk spill max bytes %d must be at least 1 when spilling to disk", cfg.spillMaxBytes)
+		}
+		if cfg.manualFlushing {
+			return errors.New("cannot set both ProduceDiskSpill and ManualFlushing")
+		}
+	}
+
+	if cfg.offsetStore != nil && cfg.txnID != nil {
+		return errors.New("cannot use WithOffsetStore with TransactionalID")
+	}
+
+	if cfg.produceCoalesceWindow < 0 || cfg.produceCoalesceWindow > time.Second {
+		return fmt.Errorf("produce coalesce window %v must be at least 0 and at most %v", cfg.produceCoalesceWindow, time.Second)
+	}
+	if cfg.produceCoalesceWindow > 0 && cfg.spillDir != "" {
+		return errors.New("cannot set both ProduceCoalesceWindow and ProduceDiskSpill")
+	}
+
+	if cfg.bufferLowWater <= 0 || cfg.bufferLowWater >= cfg.bufferHighWater || cfg.bufferHighWater > 1 {
+		return fmt.Errorf("buffer water marks high %v and low %v must satisfy 0 < low < high <= 1", cfg.bufferHighWater, cfg.bufferLowWater)
+	}
+
+	if cfg.dedupWindow > 0 && cfg.dedupMaxKeys < 1 {
+		return fmt.Errorf("produce dedup max keys %d must be at least 1 when using a dedup window", cfg.dedupMaxKeys)
+	}
+
+	if cfg.metadataMaxJitter < 0 || cfg.metadataMaxJitter >= 1 {
+		return fmt.Errorf("metadata refresh jitter %v must be at least 0 and less than 1", cfg.metadataMaxJitter)
+	}
+
 	if cfg.dialFn != nil {
 		if cfg.dialTLS != nil {
 			return errors.New("cannot set both Dialer and DialTLSConfig")
 		}
+		if cfg.dialKeepAlive != 0 {
+			return errors.New("cannot set both Dialer and DialTCPKeepAlive")
+		}
+		if cfg.dialControl != nil {
+			return errors.New("cannot set both Dialer and DialControl")
+		}
 	}
 
 	if len(cfg.group) > 0 {
@@ -350,13 +514,28 @@ func (cfg *cfg) validate() error {
 		if len(cfg.partitions) != 0 {
 			return errors.New("invalid direct-partition consuming option when consuming as regex")
 		}
-		for re := range cfg.topics {
+		for re, compiled := range cfg.topics {
+			if compiled != nil { // from ConsumeRegexes
+				continue
+			}
 			compiled, err := regexp.Compile(re)
 			if err != nil {
 				return fmt.Errorf("invalid regular expression %q", re)
 			}
 			cfg.topics[re] = compiled
 		}
+		for re, compiled := range cfg.excludeTopics {
+			if compiled != nil { // from ConsumeExcludeRegexes
+				continue
+			}
+			compiled, err := regexp.Compile(re)
+			if err != nil {
+				return fmt.Errorf("invalid exclude regular expression %q", re)
+			}
+			cfg.excludeTopics[re] = compiled
+		}
+	} else if len(cfg.excludeTopics) > 0 {
+		return errors.New("invalid ConsumeExcludeTopics or ConsumeExcludeRegexes when not consuming as regex")
 	}
 
 	if cfg.topics != nil && cfg.partitions != nil {
@@ -445,6 +624,7 @@ func defaultCfg() cfg {
 		softwareVersion: softwareVersion(),
 
 		logger: new(nopLogger),
+		clock:  realClock{},
 
 		seedBrokers: []string{"127.0.0.1"},
 		maxVersions: kversion.Stable(),
@@ -497,6 +677,8 @@ func defaultCfg() cfg {
 		compression:         []CompressionCodec{SnappyCompression(), NoCompression()},
 		maxRecordBatchBytes: 1000012, // Kafka max.message.bytes default is 1000012
 		maxBufferedRecords:  10000,
+		bufferHighWater:     0.8,
+		bufferLowWater:      0.5,
 		produceTimeout:      10 * time.Second,
 		recordRetries:       math.MaxInt64, // effectively unbounded
 		maxUnknownFailures:  4,
@@ -514,7 +696,10 @@ func defaultCfg() cfg {
 		resetOffset:    NewOffset().AtStart(),
 		isolationLevel: 0,
 
-		maxConcurrentFetches: 0, // unbounded default
+		maxConcurrentFetches:   0, // unbounded default
+		fetchDecompressWorkers: 1, // process inline by default
+
+		regexExcludeInternal: true,
 
 		///////////
 		// group //
@@ -543,6 +728,34 @@ func ClientID(id string) Opt {
 	return clientOpt{func(cfg *cfg) { cfg.id = &id }}
 }
 
+// RequestTransform sets a function that is called just before every request
+// is encoded and written to a broker, with the request key and the
+// correlation ID the request is being written with. If the function returns a
+// non-empty string, that string is used as the client ID for this request
+// only, overriding ClientID.
+//
+// This allows embedding per-request metadata that is visible in broker
+// request logs, such as a tenant tag in multi-tenant proxies, as well as
+// capturing the correlation IDs of requests. The function is called serially
+// per broker connection, concurrently across connections, and must be fast.
+func RequestTransform(fn func(key int16, correlationID int32) (clientID string)) Opt {
+	return clientOpt{func(cfg *cfg) { cfg.requestTransform = fn }}
+}
+
+// WithTestTransport replaces all broker connections with the given in-memory
+// transport, which is called for every request the client issues. No
+// connections are opened, and thus no dialing, TLS, SASL, or ApiVersions
+// requests occur. This is meant for unit testing: the transport can record
+// requests for assertions and return scripted responses. See TestTransport
+// for more details.
+//
+// Broker hooks that are tied to connections (connects, writes, reads) are
+// not called when using a test transport. For integration testing against
+// something that behaves like a real cluster, see the kfake package.
+func WithTestTransport(t TestTransport) Opt {
+	return clientOpt{func(cfg *cfg) { cfg.testTransport = t }}
+}
+
 // SoftwareNameAndVersion sets the client software name and version that will
 // be sent to Kafka as part of the ApiVersions request as of Kafka 2.4,
 // overriding the default "kgo" and internal version number.
@@ -589,6 +802,24 @@ func RequestTimeoutOverhead(overhead time.Duration) Opt {
 	return clientOpt{func(cfg *cfg) { cfg.requestTimeoutOverhead = overhead }}
 }
 
+// RequestTimeoutFor sets a function that returns the request timeout overhead
+// to use for a given request key, overriding RequestTimeoutOverhead for that
+// request. If the function returns zero or a negative duration, the
+// RequestTimeoutOverhead is used.
+//
+// The returned duration is used exactly as RequestTimeoutOverhead is: it is
+// the write timeout, and it is the read timeout or is added to the request's
+// own timeout field (see RequestTimeoutOverhead). This allows, for example, a
+// generous timeout for OffsetCommit (key 8) when behind a slow coordinator
+// while keeping a tight timeout for Fetch (key 1). The function is called
+// before every request is written, and must be safe for concurrent use.
+//
+// Like RequestTimeoutOverhead, hitting the timeout kills a connection, which
+// will fail any other active writes or reads on the connection.
+func RequestTimeoutFor(fn func(key int16) time.Duration) Opt {
+	return clientOpt{func(cfg *cfg) { cfg.requestTimeoutFor = fn }}
+}
+
 // ConnIdleTimeout is a rough amount of time to allow connections to idle
 // before they are closed, overriding the default 20.
 //
@@ -633,6 +864,30 @@ func DialTimeout(timeout time.Duration) Opt {
 	return clientOpt{func(cfg *cfg) { cfg.dialTimeout = timeout }}
 }
 
+// DialTCPKeepAlive sets the TCP keep-alive period for connections opened by
+// the default dialer, overriding the net.Dialer default (currently 15s). A
+// negative duration disables keep-alives.
+//
+// Keep-alives keep long-idle connections warm through stateful firewalls and
+// other middleboxes that silently drop idle flows, and allow dead connections
+// to be detected before the next request is written on them. This option
+// cannot be used with a custom Dialer; set KeepAlive on your own net.Dialer
+// instead.
+func DialTCPKeepAlive(period time.Duration) Opt {
+	return clientOpt{func(cfg *cfg) { cfg.dialKeepAlive = period }}
+}
+
+// DialControl sets a function to be called on the raw network connection
+// after it is created but before it is connected, allowing you to set socket
+// options (SO_*) on connections opened by the default dialer. This is set as
+// the net.Dialer's Control function; see its documentation for more details.
+//
+// This option cannot be used with a custom Dialer; set Control on your own
+// net.Dialer instead.
+func DialControl(fn func(network, address string, c syscall.RawConn) error) Opt {
+	return clientOpt{func(cfg *cfg) { cfg.dialControl = fn }}
+}
+
 // DialTLSConfig opts into dialing brokers with the given TLS config with a
 // 10s dial timeout. This is a shortcut for manually specifying a tls dialer
 // using the Dialer option. You can also change the default 10s timeout with
@@ -802,6 +1057,49 @@ func MetadataMinAge(age time.Duration) Opt {
 	return clientOpt{func(cfg *cfg) { cfg.metadataMinAge = age }}
 }
 
+// MetadataRefreshJitter randomizes the periodic metadata refresh (see
+// MetadataMaxAge) by up to the given fraction, overriding the default of no
+// jitter. For example, with the default 5m max age, a jitter of 0.1 causes
+// every periodic refresh to happen anywhere between 4m30s and 5m30s after the
+// prior one.
+//
+// If many clients are started at once (i.e., a large fleet restarted during a
+// deploy), their metadata refreshes happen in lockstep and can cause load
+// spikes on brokers. Jitter spreads these refreshes out. The fraction must be
+// at least 0 and less than 1. This only affects the periodic refresh; metadata
+// updates triggered by errors are unaffected.
+func MetadataRefreshJitter(fraction float64) Opt {
+	return clientOpt{func(cfg *cfg) { cfg.metadataMaxJitter = fraction }}
+}
+
+// OnMetadataRetry sets a function to be called whenever a metadata update
+// succeeds but some topics or partitions had retryable errors, causing the
+// client to update metadata again. The function is called with a map of each
+// topic that caused the retry to the error that triggered it; if a topic had
+// multiple errors across partitions, one is chosen.
+//
+// When metadata keeps retrying, this can be used to tell whether the cause is
+// a transient LEADER_NOT_AVAILABLE on one topic or a cluster wide problem.
+// This function is called in the metadata loop and must not block.
+func OnMetadataRetry(fn func(reasons map[string]error)) Opt {
+	return clientOpt{func(cfg *cfg) { cfg.onMetadataRetry = fn }}
+}
+
+// OnCoordinatorChange sets a function to be called when the coordinator for
+// the client's group (ConsumerGroup) or transactional ID (TransactionalID) is
+// found to have moved to a different broker. The function is called with the
+// group or transactional ID and the old and new coordinator broker IDs.
+// Coordinators looked up for other keys, such as for admin requests, are not
+// tracked.
+//
+// Coordinators are rediscovered after a request to the cached coordinator
+// fails with NOT_COORDINATOR or a similar error. During a coordinator
+// failover, this callback helps correlate commit or transaction latency
+// spikes with coordinator migrations. This function must not block.
+func OnCoordinatorChange(fn func(key string, oldNode, newNode int32)) Opt {
+	return clientOpt{func(cfg *cfg) { cfg.onCoordinatorChange = fn }}
+}
+
 // SASL appends sasl authentication options to use for all connections.
 //
 // SASL is tried in order; if the broker supports the first mechanism, all
@@ -823,6 +1121,22 @@ func WithHooks(hooks ...Hook) Opt {
 	return clientOpt{func(cfg *cfg) { cfg.hooks = append(cfg.hooks, hooks...) }}
 }
 
+// WithRecordCodec sets a codec to transform record values at the client
+// boundary: values are encoded when produced and decoded when consumed. This
+// can be used for transparent envelope encryption, ensuring every application
+// using the client encrypts and decrypts values the same way.
+//
+// Values are encoded in Produce (and TryProduce) before the record is
+// buffered, after any RecordValidator, and the record's Value is replaced with
+// the encoded value. If encoding fails, the record is failed with the encode
+// error. Values are decoded after batches are decompressed, and only for
+// records that are returned to you; control records are not decoded. If
+// decoding fails, the error is returned as the partition's fetch error. See
+// RecordCodec for more details.
+func WithRecordCodec(c RecordCodec) Opt {
+	return clientOpt{func(cfg *cfg) { cfg.recordCodec = c }}
+}
+
 // ConcurrentTransactionsBackoff sets the backoff interval to use during
 // transactional requests in case we encounter CONCURRENT_TRANSACTIONS error,
 // overriding the default 20ms.
@@ -839,6 +1153,49 @@ func ConcurrentTransactionsBackoff(backoff time.Duration) Opt {
 	return clientOpt{func(cfg *cfg) { cfg.txnBackoff = backoff }}
 }
 
+// TxnCoordinatorBehavior controls how transactional requests behave when the
+// transaction coordinator is unavailable; see
+// TxnCoordinatorUnavailableBehavior.
+//
+// The default is TxnCoordinatorFailFast.
+type TxnCoordinatorBehavior struct {
+	wait time.Duration
+}
+
+// TxnCoordinatorFailFast fails a transactional request once the request's
+// normal retries are exhausted, even if the failure is because the
+// transaction coordinator is unavailable.
+func TxnCoordinatorFailFast() TxnCoordinatorBehavior { return TxnCoordinatorBehavior{} }
+
+// TxnCoordinatorWait keeps retrying a transactional request, rediscovering the
+// transaction coordinator between tries, for up to wait while the coordinator
+// is unavailable. Each try is still subject to the normal request retries.
+func TxnCoordinatorWait(wait time.Duration) TxnCoordinatorBehavior {
+	return TxnCoordinatorBehavior{wait}
+}
+
+// TxnCoordinatorUnavailableBehavior sets how transactional requests behave if
+// the transaction coordinator is unavailable, overriding the default
+// TxnCoordinatorFailFast.
+//
+// The coordinator is unavailable if requests to it fail with
+// COORDINATOR_NOT_AVAILABLE, COORDINATOR_LOAD_IN_PROGRESS, or NOT_COORDINATOR,
+// or fail to connect. This commonly happens briefly when the coordinator
+// moves, such as during a rolling broker restart. By default, these errors
+// are retried within the normal retry limits (see RequestRetries and
+// RetryTimeout) and then returned, failing the transaction. With
+// TxnCoordinatorWait, the client continues retrying for a bounded amount of
+// time, riding through the coordinator moving.
+//
+// This applies to the AddPartitionsToTxn request issued when producing to a
+// new partition in a transaction, the AddOffsetsToTxn request issued when
+// committing offsets in a transaction, and the EndTxn request issued in
+// EndTransaction. Beginning a transaction does not issue any request. Waiting
+// still respects the request context.
+func TxnCoordinatorUnavailableBehavior(b TxnCoordinatorBehavior) ProducerOpt {
+	return producerOpt{func(cfg *cfg) { cfg.txnCoordUnavailable = b }}
+}
+
 // ConsiderMissingTopicDeletedAfter sets the amount of time a topic can be
 // missing from metadata responses _after_ loading it at least once before it
 // is considered deleted, overriding the default of 15s. Note that for newer
@@ -850,6 +1207,36 @@ func ConsiderMissingTopicDeletedAfter(t time.Duration) Opt {
 	return clientOpt{func(cfg *cfg) { cfg.missingTopicDelete = t }}
 }
 
+// TopicNameMapper sets functions to map logical topic names used in your
+// application to the topic names used on the wire, e.g. to prefix every topic
+// with a tenant or environment. Either function can be nil to not map names
+// for that side of the client.
+//
+// The produce function is applied to the topic of every record passed to
+// Produce (after DefaultProduceTopic is applied), as well as to the topics
+// passed to PurgeTopicsFromProducing. While a record is buffered, its Topic
+// field is the wire name; the logical name is restored before the record's
+// promise is called, so records can be re-produced as is.
+//
+// The consume function is applied to every topic passed to the consumer API:
+// ConsumeTopics, ConsumePartitions, adding, removing, pausing and resuming
+// topics and partitions, setting, marking and committing offsets, and
+// purging. Consumed records and fetches, as well as offsets, lag, paused
+// topics and the partitions passed to group callbacks, use logical names.
+// The consume function is not applied if consuming via regex, since topics
+// are then regular expressions.
+//
+// Hooks, issued requests and their responses (including those passed to
+// commit callbacks and OnOffsetsFetched), and admin style functions that
+// take a group (DeleteGroupOffsets, FetchGroupOffsetsWithMetadata) use wire
+// names.
+func TopicNameMapper(produce, consume func(string) string) Opt {
+	return clientOpt{func(cfg *cfg) {
+		cfg.produceTopicMapper = produce
+		cfg.consumeTopicMapper = consume
+	}}
+}
+
 ////////////////////////////
 // PRODUCER CONFIGURATION //
 ////////////////////////////
@@ -863,6 +1250,23 @@ func DefaultProduceTopic(t string) ProducerOpt {
 	return producerOpt{func(cfg *cfg) { cfg.defaultProduceTopic = t }}
 }
 
+// RecordValidator sets a function to validate every record before it is
+// buffered for producing. If the function returns an error, the record is
+// not buffered and is failed immediately with that error, before any network
+// I/O. With ProduceSync, validation errors are returned in the results.
+//
+// Unlike hooks, whose purpose is to observe or modify records, the sole job
+// of a validator is to accept or reject a record. For example, a schema
+// registry plugin could reject records whose value does not match the
+// registered schema for the topic, giving producers synchronous rejection
+// rather than broker side or downstream failures. The validator is called
+// after the record's topic is defaulted (DefaultProduceTopic) and mapped to
+// its wire name (TopicNameMapper), and after OnProduceRecordBuffered hooks
+// are called. The validator is called concurrently if producing concurrently.
+func RecordValidator(fn func(*Record) error) ProducerOpt {
+	return producerOpt{func(cfg *cfg) { cfg.recordValidator = fn }}
+}
+
 // Acks represents the number of acks a broker leader must have before
 // a produce request is considered complete.
 //
@@ -893,6 +1297,34 @@ func RequiredAcks(acks Acks) ProducerOpt {
 	return producerOpt{func(cfg *cfg) { cfg.acks = acks }}
 }
 
+// ProduceTopicAcks overrides RequiredAcks for an individual topic. This option
+// can be specified multiple times to configure multiple topics; specifying the
+// same topic twice uses the last acks.
+//
+// This allows one client to produce critical data with AllISRAcks alongside
+// best effort data with LeaderAck or NoAck. Acks is a field on a produce
+// request rather than per topic, so the client issues separate produce
+// requests per ack level; producing to topics with differing acks on the same
+// broker results in more, smaller requests. As with RequiredAcks, any acks
+// other than AllISRAcks requires DisableIdempotentWrite.
+func ProduceTopicAcks(topic string, acks Acks) ProducerOpt {
+	return producerOpt{func(cfg *cfg) {
+		if cfg.topicAcks == nil {
+			cfg.topicAcks = make(map[string]Acks)
+		}
+		cfg.topicAcks[topic] = acks
+	}}
+}
+
+// acksForTopic returns the acks to use for a topic, which may be overridden
+// with ProduceTopicAcks.
+func (cfg *cfg) acksForTopic(topic string) int16 {
+	if acks, ok := cfg.topicAcks[topic]; ok {
+		return acks.val
+	}
+	return cfg.acks.val
+}
+
 // DisableIdempotentWrite disables idempotent produce requests, opting out of
 // Kafka server-side deduplication in the face of reissued requests due to
 // transient network problems. Disabling idempotent write by default
@@ -908,6 +1340,24 @@ func DisableIdempotentWrite() ProducerOpt {
 	return producerOpt{func(cfg *cfg) { cfg.disableIdempotency = true }}
 }
 
+// RequireIdempotentWriteSupport opts in to checking, in NewClient, that the
+// cluster supports idempotent production. If idempotency is enabled (the
+// default), NewClient issues an ApiVersions request to the cluster and returns
+// ErrIdempotenceUnsupported if the broker does not support InitProducerID
+// (Kafka 0.11+), or if MaxVersions pins the client to versions that exclude
+// it.
+//
+// By default, NewClient does not talk to the cluster, and running against a
+// broker that is too old only surfaces as errors when producing. With this
+// option, NewClient instead blocks until a broker replies (or the request
+// retry timeout is hit) and fails fast with a clear error. If the cluster
+// cannot be reached, NewClient returns the request error.
+//
+// This option has no effect if idempotency is disabled.
+func RequireIdempotentWriteSupport() ProducerOpt {
+	return producerOpt{func(cfg *cfg) { cfg.verifyIdempotency = true }}
+}
+
 // MaxProduceRequestsInflightPerBroker changes the number of allowed produce
 // requests in flight per broker if you disable idempotency, overriding the
 // default of 1. If using idempotency, this option has no effect: the maximum
@@ -919,6 +1369,41 @@ func MaxProduceRequestsInflightPerBroker(n int) ProducerOpt {
 	return producerOpt{func(cfg *cfg) { cfg.maxProduceInflight = n }}
 }
 
+// RelaxPartitionOrdering sets whether the client may have multiple batches
+// for the same partition in flight at once even before the partition has
+// seen a successful produce response, overriding the default of false. This
+// option requires DisableIdempotentWrite: with idempotency, Kafka enforces
+// ordering with sequence numbers, and the client must keep order.
+//
+// A produce request contains at most one batch per partition, so the number
+// of batches in flight per partition is bounded by
+// MaxProduceRequestsInflightPerBroker. By default, even with a higher in
+// flight limit, the client only sends one batch per partition until it has
+// seen a successful response for that partition (for example, after startup
+// or after a retryable error), which avoids reordering records on retry.
+// Relaxing this allows higher per partition throughput when recovering from
+// errors, at the cost of possibly reordering records on retry. This can be
+// useful for workloads that do not care about order, such as a metrics
+// firehose.
+func RelaxPartitionOrdering(relax bool) ProducerOpt {
+	return producerOpt{func(cfg *cfg) { cfg.relaxOrdering = relax }}
+}
+
+// MaxInFlightProduceRequests sets a global limit on the number of produce
+// requests in flight across all brokers, overriding the default of no global
+// limit (0). This applies on top of the per-broker limit: each broker still
+// has at most 1 (or 5, see MaxProduceRequestsInflightPerBroker) requests in
+// flight, and the total across all brokers is capped at n.
+//
+// This can be used to quickly throttle total produce concurrency without
+// needing to reason about the number of brokers. Setting a limit lower than
+// the number of brokers being produced to means some brokers wait for others
+// to receive responses before their next request is issued. The current
+// number of in flight requests can be read with InFlightProduceRequests.
+func MaxInFlightProduceRequests(n int) ProducerOpt {
+	return producerOpt{func(cfg *cfg) { cfg.maxInflightProduceRequests = n }}
+}
+
 // ProducerBatchCompression sets the compression codec to use for producing
 // records.
 //
@@ -934,6 +1419,18 @@ func ProducerBatchCompression(preference ...CompressionCodec) ProducerOpt {
 	return producerOpt{func(cfg *cfg) { cfg.compression = preference }}
 }
 
+// CompressionMinBytes sets the minimum size a record batch must be (before
+// compression) for the batch to be compressed, overriding the default of 0
+// (always compress if compression is configured). Batches smaller than this
+// are sent uncompressed.
+//
+// Small batches often do not benefit from compression; compressing them can
+// be a waste of CPU. This option is useful if you produce a mix of tiny and
+// large batches.
+func CompressionMinBytes(n int) ProducerOpt {
+	return producerOpt{func(cfg *cfg) { cfg.compressMinBytes = n }}
+}
+
 // ProducerBatchMaxBytes upper bounds the size of a record batch, overriding
 // the default 1,000,012 bytes. This mirrors Kafka's max.message.bytes.
 //
@@ -975,6 +1472,94 @@ func MaxBufferedBytes(n int) ProducerOpt {
 	return producerOpt{func(cfg *cfg) { cfg.maxBufferedBytes = int64(n) }}
 }
 
+// OnBufferHighWater sets a function to call when the amount of buffered
+// records or bytes rises to the high water mark (see BufferWaterMarks). The
+// function is called with the number of records and bytes buffered at the
+// time it is called.
+//
+// This, along with OnBufferLowWater, is edge triggered and can be used for
+// backpressure: a producer reading from an upstream source can pause reading
+// when the buffer is at its high water mark and resume once the buffer has
+// drained to its low water mark, rather than polling BufferedProduceRecords.
+// Calls alternate between the high and low water functions, starting with
+// high. If the buffer rapidly crosses both marks, a high and low pair of
+// calls may be skipped entirely; the last call always reflects the current
+// state.
+//
+// The function is called serially with the low water function in the
+// goroutine that caused the crossing (Produce, or a goroutine finishing
+// promises). It must not block and must not produce.
+func OnBufferHighWater(fn func(records, bytes int64)) ProducerOpt {
+	return producerOpt{func(cfg *cfg) { cfg.onBufferHighWater = fn }}
+}
+
+// OnBufferLowWater sets a function to call when the amount of buffered
+// records and bytes drains to the low water mark after the buffer previously
+// reached its high water mark. See OnBufferHighWater for more details.
+func OnBufferLowWater(fn func(records, bytes int64)) ProducerOpt {
+	return producerOpt{func(cfg *cfg) { cfg.onBufferLowWater = fn }}
+}
+
+// BufferWaterMarks sets the high and low water marks for OnBufferHighWater
+// and OnBufferLowWater, as fractions of MaxBufferedRecords and
+// MaxBufferedBytes, overriding the default of 0.8 and 0.5. The buffer is at
+// its high water mark when either the buffered records or buffered bytes
+// reach the high fraction of their limits, and is at its low water mark when
+// both are at or below the low fraction of their limits. Bytes are only
+// considered if MaxBufferedBytes is set.
+//
+// The fractions must satisfy 0 < low < high <= 1.
+func BufferWaterMarks(high, low float64) ProducerOpt {
+	return producerOpt{func(cfg *cfg) { cfg.bufferHighWater, cfg.bufferLowWater = high, low }}
+}
+
+// VerifyProduceOrdering, if true, verifies every batch before it is added to
+// a produce request to ensure that batches for a partition are sent in
+// sequence number order. If the invariant is ever broken, the batch is not
+// sent, an error is logged, and every record buffered for the partition is
+// failed with an error describing the violation. This only checks idempotent
+// (or transactional) production, where sequence numbers are used.
+//
+// The check walks all in flight batches for a partition every time a batch is
+// sent, and is meant as a debugging aid to catch ordering regressions in
+// tests; it should not be enabled in production.
+func VerifyProduceOrdering(verify bool) ProducerOpt {
+	return producerOpt{func(cfg *cfg) { cfg.verifyProduceOrdering = verify }}
+}
+
+// OnDuplicateAcknowledged sets a function to call when a broker replies to a
+// produce request with DUPLICATE_SEQUENCE_NUMBER for a batch. This error means
+// the broker already wrote the batch (i.e., a retry after a network blip was
+// deduplicated), so the client treats the batch as successful. The function is
+// called with the batch's topic, partition, and the base offset in the
+// response, which may be -1 if the broker does not know the original offset.
+//
+// Note that Kafka 2.0+ caches the last five batches per producer and replies to
+// duplicates of those with the original offset and no error; the function is
+// only called when a broker explicitly returns DUPLICATE_SEQUENCE_NUMBER. The
+// function is called before the batch's records are finished, in the
+// goroutine handling the produce response, and must not block.
+func OnDuplicateAcknowledged(fn func(topic string, partition int32, baseOffset int64)) ProducerOpt {
+	return producerOpt{func(cfg *cfg) { cfg.onDuplicateAcked = fn }}
+}
+
+// OnProduceBatchRetry sets a function to call whenever a produce batch is
+// going to be retried, with the batch's topic and partition, how many times
+// the batch has been tried so far, and why the batch is being retried. The
+// error is either the partition's error in the produce response (e.g.
+// NOT_LEADER_FOR_PARTITION) or the error issuing the entire request (e.g. a
+// connection error), in which case the function is called for every batch in
+// the request. The function is not called for batches that are failed rather
+// than retried (e.g., due to RecordRetries or RecordDeliveryTimeout).
+//
+// This can be used to track retry rates or to alert on pathological retry
+// loops, rather than parsing logs. The function is called in the goroutine
+// handling the produce response, which may be concurrent across brokers, and
+// must not block.
+func OnProduceBatchRetry(fn func(topic string, partition int32, tries int, err error)) ProducerOpt {
+	return producerOpt{func(cfg *cfg) { cfg.onProduceBatchRetry = fn }}
+}
+
 // RecordPartitioner uses the given partitioner to partition records, overriding
 // the default UniformBytesPartitioner(64KiB, true, true, nil).
 func RecordPartitioner(partitioner Partitioner) ProducerOpt {
@@ -1071,6 +1656,66 @@ func ProducerLinger(linger time.Duration) ProducerOpt {
 	return producerOpt{func(cfg *cfg) { cfg.linger = linger }}
 }
 
+// FlushOnRecord stops lingering for a partition as soon as its lingering batch
+// has at least n records, overriding the default of 0 (disabled). This only
+// has an effect if lingering (ProducerLinger or ProduceTopicOpts).
+//
+// This gives a predictable upper bound on how many records wait for a linger.
+// The bound applies to every topic the client produces to; it cannot be set
+// per topic. To have an individual topic (such as a low volume control plane
+// topic) never wait for a linger while other topics linger, use
+// ProduceTopicOpts with a zero linger for that topic instead.
+func FlushOnRecord(n int) ProducerOpt {
+	return producerOpt{func(cfg *cfg) { cfg.flushOnRecords = n }}
+}
+
+// ProduceTopicOpts overrides the ProducerLinger and ProducerBatchMaxBytes
+// options for an individual topic. This option can be specified multiple times
+// to configure multiple topics; specifying the same topic twice uses the last
+// options.
+//
+// This allows one client to produce to, for example, a low volume control
+// topic with no linger as well as a high volume data topic with a long linger
+// and large batches. The linger must be between 0 (no linger) and one minute.
+// If maxBatchBytes is 0, the client's ProducerBatchMaxBytes is used, otherwise
+// it must be between 512 and 256MiB. As with ProducerBatchMaxBytes, the batch
+// size is still capped by BrokerMaxWriteBytes.
+func ProduceTopicOpts(topic string, linger time.Duration, maxBatchBytes int) ProducerOpt {
+	return producerOpt{func(cfg *cfg) {
+		if cfg.topicProduceOpts == nil {
+			cfg.topicProduceOpts = make(map[string]topicProduceOpts)
+		}
+		cfg.topicProduceOpts[topic] = topicProduceOpts{linger, int32(maxBatchBytes)}
+	}}
+}
+
+type topicProduceOpts struct {
+	linger        time.Duration
+	maxBatchBytes int32
+}
+
+// lingerForTopic returns the linger to use for a topic, which may be
+// overridden with ProduceTopicOpts.
+func (cfg *cfg) lingerForTopic(topic string) time.Duration {
+	if opts, ok := cfg.topicProduceOpts[topic]; ok {
+		return opts.linger
+	}
+	return cfg.linger
+}
+
+// anyLinger returns whether any topic could be lingering.
+func (cfg *cfg) anyLinger() bool {
+	if cfg.linger > 0 {
+		return true
+	}
+	for _, opts := range cfg.topicProduceOpts {
+		if opts.linger > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 // ManualFlushing disables auto-flushing when producing. While you can still
 // set lingering, it would be useless to do so.
 //
@@ -1080,6 +1725,116 @@ func ManualFlushing() ProducerOpt {
 	return producerOpt{func(cfg *cfg) { cfg.manualFlushing = true }}
 }
 
+// ProduceDedupWindow enables deduplication in ProduceOnce, overriding the
+// default of no deduplication. Idempotency keys passed to ProduceOnce are
+// remembered for the window, and at most maxKeys are remembered: once more
+// keys are tracked, the least recently produced keys are forgotten early.
+func ProduceDedupWindow(window time.Duration, maxKeys int) ProducerOpt {
+	return producerOpt{func(cfg *cfg) { cfg.dedupWindow, cfg.dedupMaxKeys = window, maxKeys }}
+}
+
+// ProduceDiskSpill enables spilling records to disk when the client has
+// MaxBufferedRecords or MaxBufferedBytes buffered, rather than blocking in
+// Produce or failing in TryProduce. Spilled records are replayed in order as
+// space frees up in the buffer. While any records are spilled, newly produced
+// records are also spilled to preserve ordering.
+//
+// Records are spilled to a single file created in dir, which is truncated
+// whenever all spilled records have been replayed and is removed when the
+// client is closed. maxBytes bounds the size of this file. Buffering a record
+// in memory while older records are spilled would reorder it ahead of them, so
+// if the file is full (or cannot be written) while records are spilled, newly
+// produced records are failed: with ErrMaxBuffered if the file is full, or
+// with the write error. If nothing is spilled, a record that cannot be spilled
+// falls back to the default blocking behavior. Only record keys, values, and
+// headers are written to disk: each spilled record and its promise are still
+// kept in memory. Spilled records are counted in BufferedProduceRecords and
+// are waited on in Flush and ProduceBarrier, but spilled records are not
+// durable: if the process dies, spilled records are lost.
+//
+// This is meant for best effort pipelines where Kafka may be briefly
+// unavailable and dropping or blocking on records is worse than using disk.
+// This option cannot be used with ManualFlushing.
+func ProduceDiskSpill(dir string, maxBytes int64) ProducerOpt {
+	return producerOpt{func(cfg *cfg) { cfg.spillDir, cfg.spillMaxBytes = dir, maxBytes }}
+}
+
+// ProduceCoalesceWindow stages produced records for up to window before
+// buffering them all at once, overriding the default of 0 (records are
+// buffered immediately in Produce). Buffering a record takes a client-wide
+// lock to account for the max buffered limits; staging takes no lock, and the
+// client-wide lock is taken once per window. This can reduce lock contention
+// and CPU when many goroutines produce small records in a tight loop.
+//
+// Staging happens after records are validated, so records can still fail
+// immediately (e.g. ErrNotInTransaction). Staged records are counted in
+// BufferedProduceRecords. If staged records do not fit within the max
+// buffered limits, they are buffered in order in the background, and Produce
+// blocks once 1024 records are staged, rather than blocking on every record.
+// Flush and ProduceBarrier immediately buffer anything staged. This option is
+// meant for high throughput, latency insensitive pipelines; it adds up to
+// window of latency to every record, in addition to any linger. The window
+// must be at most 1s and this option cannot be used with ProduceDiskSpill.
+func ProduceCoalesceWindow(window time.Duration) ProducerOpt {
+	return producerOpt{func(cfg *cfg) { cfg.produceCoalesceWindow = window }}
+}
+
+// ProduceRequireConnection sets whether producing fails records immediately
+// with ErrNotConnected if the client is not connected to any broker,
+// overriding the default of false (records are buffered until the client can
+// connect).
+//
+// The client is considered connected if it has successfully connected to
+// any broker, and the most recent connection attempt to that broker did not
+// fail. Idle connections that are reaped (ConnIdleTimeout) do not count as
+// disconnected. If the client is not connected when a record is produced
+// (for example, on a freshly created client), the client first tries to
+// connect to any discovered or seed broker, bounded by the DialTimeout, and
+// only fails the record with ErrNotConnected if that attempt fails. Only one
+// attempt runs at a time: records produced while an attempt is in flight
+// wait for it, and are failed if it fails.
+//
+// This is useful for request path producers, such as an HTTP handler
+// emitting audit events, that would rather drop and log a record than block
+// on a cold or disconnected client.
+func ProduceRequireConnection(require bool) ProducerOpt {
+	return producerOpt{func(cfg *cfg) { cfg.requireConnection = require }}
+}
+
+// OnProduceRetryExhausted sets a function to be called for every record that
+// is failed because its batch hit the RecordRetries limit. The function is
+// called with the record, the number of times the record's batch was tried,
+// and the last error that caused the batch to be retried.
+//
+// Record promises are still called as normal; this function is called just
+// before each record's promise. This is meant as a single client-level signal
+// for alerting on systemic produce failures, rather than having to handle
+// ErrRecordRetries in every promise. Note that if a batch hits the retry
+// limit, all records buffered for the same partition are failed as well (to
+// preserve ordering), and this function is called for each of them.
+//
+// This function is called serially with promises and must not block.
+func OnProduceRetryExhausted(fn func(r *Record, tries int, lastErr error)) ProducerOpt {
+	return producerOpt{func(cfg *cfg) { cfg.onRetryExhausted = fn }}
+}
+
+// OnProducerIDRecovered sets a function to be called whenever the client
+// recovers its producer ID, such as after UNKNOWN_PRODUCER_ID or
+// INVALID_PRODUCER_EPOCH (KIP-360, KIP-588). The function is called with the
+// producer ID and epoch before and after recovery. If recovery failed, err is
+// non-nil and new is the same as old. The initial producer ID load is not a
+// recovery and does not call this function.
+//
+// Every recovery is also logged at the info level with the old and new IDs
+// and epochs. When chasing duplicates caused by recovery, the exact epoch
+// transition is useful for correlating with broker logs.
+//
+// This function is called while the client holds its producer ID lock: it
+// must not block and must not call ProducerID or produce synchronously.
+func OnProducerIDRecovered(fn func(old, new ProducerIDEpoch, err error)) ProducerOpt {
+	return producerOpt{func(cfg *cfg) { cfg.onProducerIDRecovered = fn }}
+}
+
 // RecordDeliveryTimeout sets a rough time of how long a record can sit around
 // in a batch before timing out, overriding the unlimited default.
 //
@@ -1149,6 +1904,20 @@ func TransactionTimeout(timeout time.Duration) ProducerOpt {
 	return producerOpt{func(cfg *cfg) { cfg.txnTimeout = timeout }}
 }
 
+// TxnEndUncancelable sets whether the EndTxn request issued when ending a
+// transaction ignores cancellation of the context passed to EndTransaction
+// (or GroupTransactSession.End), defaulting to false.
+//
+// Canceling the context while the EndTxn request is in flight makes it
+// impossible to know whether the transaction was committed or aborted, leaving
+// the client in an undesirable state. If you end transactions with a request
+// scoped context (for example, one canceled on an HTTP request timeout),
+// enabling this option ensures that cancellation cannot interrupt ending the
+// transaction. Values in the context are still passed through to hooks.
+func TxnEndUncancelable(uncancelable bool) ProducerOpt {
+	return producerOpt{func(cfg *cfg) { cfg.txnEndUncancelable = uncancelable }}
+}
+
 ////////////////////////////
 // CONSUMER CONFIGURATION //
 ////////////////////////////
@@ -1229,6 +1998,50 @@ func MaxConcurrentFetches(n int) ConsumerOpt {
 	return consumerOpt{func(cfg *cfg) { cfg.maxConcurrentFetches = n }}
 }
 
+// MaxConcurrentFetchPartitions sets the maximum number of partitions to
+// include in a single fetch request, overriding the unbounded default.
+//
+// By default, every fetch request to a broker includes every partition the
+// client is consuming from that broker, meaning a response can contain up to
+// FetchMaxPartitionBytes for every partition (bounded by FetchMaxBytes). If
+// a consumer is assigned very many partitions, and FetchMaxBytes is large,
+// buffered fetches can use a lot of memory. With this option, each request
+// includes at most n partitions, and the client round-robins through the
+// partitions led by the broker across requests so that all partitions make
+// progress over time.
+//
+// This limit is per fetch request, and the client issues one fetch request
+// per broker at a time, so the limit applies per broker: a client consuming
+// from three brokers can have 3n partitions in flight. Paired with
+// MaxConcurrentFetches, memory used for fetching is bounded by roughly the
+// max concurrent fetches times n times FetchMaxPartitionBytes.
+//
+// Because the partitions in each request rotate, setting this option
+// disables fetch sessions (see DisableFetchSessions): a session would
+// otherwise forget and re-add partitions on every request.
+//
+// A value of 0 implies no limit.
+func MaxConcurrentFetchPartitions(n int) ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) { cfg.maxConcurrentFetchParts = n }}
+}
+
+// MaxBufferedFetchBytes sets the maximum number of bytes the client buffers
+// from fetching before it stops issuing new fetch requests, overriding the
+// default of 0 (unbounded). Bytes are counted the same as in
+// BufferedFetchBytes. Once buffered bytes are at or above the limit, no new
+// fetch request is issued to any broker until polling drains the buffer below
+// the limit.
+//
+// This is a soft limit: fetches that are already in flight when the limit is
+// reached are still buffered, so the buffer can exceed the limit by up to
+// the size of the in flight responses (see FetchMaxBytes and
+// MaxConcurrentFetches). This option is useful in memory constrained
+// environments where consuming a fast topic faster than it is processed
+// would otherwise grow the fetch buffer without bound.
+func MaxBufferedFetchBytes(n int64) ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) { cfg.maxBufferedFetchBytes = n }}
+}
+
 // ConsumeResetOffset sets the offset to start consuming from, or if
 // OffsetOutOfRange is seen while fetching, to restart consuming from. The
 // default is NewOffset().AtStart(), i.e., the earliest offset.
@@ -1270,6 +2083,25 @@ func ConsumeResetOffset(offset Offset) ConsumerOpt {
 	return consumerOpt{func(cfg *cfg) { cfg.resetOffset = offset }}
 }
 
+// OnOffsetOutOfRange sets a function to call when fetching a partition fails
+// with OFFSET_OUT_OF_RANGE, allowing you to choose where to resume consuming
+// rather than using the ConsumeResetOffset policy. The function is called
+// with the topic, partition, and the offset that was requested, and returns
+// the offset to reset to. The returned offset is resolved exactly as the
+// ConsumeResetOffset is: an exact offset is bounded to the partition's valid
+// range, so you can, for example, return an offset committed elsewhere, or
+// return the requested offset to clamp it to the nearest valid offset.
+//
+// If the function returns an error, the partition is not reset and the error
+// is returned for the partition in the next poll, as if NoResetOffset were
+// used. When this option is set, the function takes precedence over the
+// ConsumeResetOffset policy for all out of range resets.
+//
+// This function is called in the fetch loop and must not block for long.
+func OnOffsetOutOfRange(fn func(topic string, partition int32, requested int64) (Offset, error)) ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) { cfg.onOffsetOutOfRange = fn }}
+}
+
 // Rack specifies where the client is physically located and changes fetch
 // requests to consume from the closest replica as opposed to the leader
 // replica.
@@ -1296,7 +2128,7 @@ func ReadCommitted() IsolationLevel { return IsolationLevel{1} }
 // FetchIsolationLevel sets the "isolation level" used for fetching
 // records, overriding the default ReadUncommitted.
 func FetchIsolationLevel(level IsolationLevel) ConsumerOpt {
-	return consumerOpt{func(cfg *cfg) { cfg.isolationLevel = level.level }}
+	return consumerOpt{func(cfg *cfg) { cfg.isolationLevel = lazyI32(level.level) }}
 }
 
 // KeepControlRecords sets the client to keep control messages and return
@@ -1307,6 +2139,129 @@ func KeepControlRecords() ConsumerOpt {
 	return consumerOpt{func(cfg *cfg) { cfg.keepControl = true }}
 }
 
+// IncludeAbortedRecords sets whether records that are part of aborted
+// transactions are returned when consuming with the ReadCommitted isolation
+// level, overriding the default of false. Aborted records that are returned
+// have their Aborted field set to true.
+//
+// When consuming with ReadCommitted, Kafka still returns aborted records in
+// fetch responses, and the client filters them using the aborted transaction
+// index in the response. This option can be useful in forensic tools to
+// investigate what was in a transaction that aborted. With the default
+// ReadUncommitted isolation level, the client does not know which records
+// were aborted, and this option has no effect.
+func IncludeAbortedRecords(include bool) ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) { cfg.includeAborted = include }}
+}
+
+// SkipCorruptBatches sets whether the client skips batches that fail CRC
+// validation or fail to decompress, overriding the default of false. A
+// skipped batch is logged at the warn level, passed to the OnCorruptBatch
+// function if one is set, and consuming continues after the batch.
+//
+// By default, a batch with a mismatched CRC causes the partition's fetch to
+// return an error, and consuming that partition cannot progress past the
+// batch. This option is useful for best effort consumers reading topics with
+// known corruption, where halting on one bad batch would block all subsequent
+// good data. Records in a skipped batch are lost to the consumer.
+func SkipCorruptBatches(skip bool) ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) { cfg.skipCorruptBatches = skip }}
+}
+
+// OnCorruptBatch sets a function to be called whenever a corrupt batch is
+// skipped with SkipCorruptBatches. The function is called with the topic,
+// partition, and the first offset of the skipped batch, and the corruption
+// error. This is called while processing fetch responses and must not block.
+func OnCorruptBatch(fn func(topic string, partition int32, offset int64, err error)) ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) { cfg.onCorruptBatch = fn }}
+}
+
+// MaxPollInterval enables a watchdog that detects stalled polling, overriding
+// the default of 0 (disabled). If the client is not actively polling and the
+// last poll returned more than the interval ago, the client logs a warning and
+// calls the OnPollStall function, if set. The watchdog fires once per stall:
+// it does not fire again until after the next poll. Nothing is checked until
+// the first poll returns.
+//
+// This mirrors Java's max.poll.interval.ms, but only warns: the client does
+// not leave the group. If a processing loop deadlocks, nothing in the client
+// otherwise notices until the group rebalances the member out; this option
+// gives early warning so that the application can restart itself.
+func MaxPollInterval(interval time.Duration) ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) { cfg.maxPollInterval = interval }}
+}
+
+// OnPollStall sets a function to be called when the MaxPollInterval watchdog
+// detects a stall, passing how long it has been since the last poll returned.
+// This function must not block.
+func OnPollStall(fn func(since time.Duration)) ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) { cfg.onPollStall = fn }}
+}
+
+// FairPollAcrossPartitions sets whether PollRecords splits its record budget
+// evenly across all buffered partitions, overriding the default of false.
+//
+// By default, PollRecords takes as many records as it can from the first
+// buffered partition before moving on to the next, meaning a poll can be
+// heavily skewed toward a few partitions. With this option, each buffered
+// partition contributes at most its share of maxPollRecords per poll (the
+// budget divided by the number of buffered partitions, rounded up), and
+// partitions that were partially taken from are moved to the back of the line
+// for the next poll. This keeps per-partition progress roughly even, at the
+// cost of polls potentially returning fewer than maxPollRecords records even
+// if more are buffered. This option has no effect on PollFetches.
+func FairPollAcrossPartitions(fair bool) ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) { cfg.fairPoll = fair }}
+}
+
+// MaxConsumeRecordBytes sets the maximum size of a record value that will be
+// returned from polling, overriding the default of 0 (unbounded). Records with
+// a larger value are skipped: they are logged at the warn level, passed to the
+// OnRecordTooLarge function if set, and are never returned from polling. The
+// consume offset still advances past skipped records.
+//
+// This is a defense against untrusted or buggy producers: a single enormous
+// record otherwise is returned from polling and kept in memory until the
+// application finishes processing it. Note that the fetch response itself
+// must still be read; see FetchMaxBytes and FetchMaxPartitionBytes to bound
+// fetch sizes.
+func MaxConsumeRecordBytes(n int) ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) { cfg.maxConsumeRecordBytes = n }}
+}
+
+// OnRecordTooLarge sets a function to be called when a record is skipped due
+// to MaxConsumeRecordBytes, passing the record's topic, partition, offset, and
+// value size. This function is called in the fetch path and must not block.
+func OnRecordTooLarge(fn func(topic string, partition int32, offset int64, valueBytes int)) ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) { cfg.onRecordTooLarge = fn }}
+}
+
+// OnPartitionEOF sets a function to be called when a partition is caught up:
+// a fetch for the partition returned no new records and the consume offset is
+// at the partition's high watermark (or the last stable offset, if consuming
+// with ReadCommitted). This is useful for bounded batch jobs that need to know
+// when a partition is fully drained so that it can be released.
+//
+// The function is called once each time a partition catches up: it is not
+// called again for the partition until more records have been fetched. The
+// function is called in the fetch path and must not block.
+func OnPartitionEOF(fn func(topic string, partition int32, offset int64)) ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) { cfg.onPartitionEOF = fn }}
+}
+
+// PartitionProcessor sets the function used to create a RecordProcessor for
+// each partition consumed in RunPartitionProcessors. The function is called
+// once per partition the first time records are polled for it (and again if
+// the partition is revoked and later reassigned), and the returned processor
+// is called in offset order on a goroutine dedicated to that partition.
+//
+// This is an opt-in concurrency model for users that want per-partition
+// parallelism with ordered delivery without building the fan out and offset
+// tracking themselves. See RunPartitionProcessors for more details.
+func PartitionProcessor(fn func(topic string, partition int32) RecordProcessor) ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) { cfg.partitionProcessor = fn }}
+}
+
 // ConsumeTopics adds topics to use for consuming.
 //
 // By default, consuming will start at the beginning of partitions. To change
@@ -1336,6 +2291,37 @@ func ConsumePartitions(partitions map[string]map[int32]Offset) ConsumerOpt {
 	return consumerOpt{func(cfg *cfg) { cfg.partitions = partitions }}
 }
 
+// ConsumeLastN adds a partition to consume directly, starting n records before
+// the partition's current end (high watermark). If the partition has fewer
+// than n records, consuming starts at the beginning of the partition. This
+// option can be specified multiple times to consume multiple partitions, and
+// is the same as adding NewOffset().AtEnd().Relative(-n) for the partition to
+// ConsumePartitions.
+//
+// This is useful for tailing or debugging tools that want to show, e.g., the
+// last 100 records in a partition. As with ConsumePartitions, this option is
+// not compatible with group consuming and regex consuming. ConsumePartitions
+// replaces all direct partitions, including any added with this option, so
+// this option must be specified after ConsumePartitions if both are used.
+func ConsumeLastN(topic string, partition int32, n int64) ConsumerOpt {
+	if n < 0 {
+		n = 0
+	}
+	return consumerOpt{func(cfg *cfg) {
+		partitions := make(map[string]map[int32]Offset, len(cfg.partitions)+1)
+		for t, ps := range cfg.partitions {
+			partitions[t] = ps
+		}
+		ps := make(map[int32]Offset, len(partitions[topic])+1)
+		for p, o := range partitions[topic] {
+			ps[p] = o
+		}
+		ps[partition] = NewOffset().AtEnd().Relative(-n)
+		partitions[topic] = ps
+		cfg.partitions = partitions
+	}}
+}
+
 // ConsumeRegex sets the client to parse all topics passed to ConsumeTopics as
 // regular expressions.
 //
@@ -1343,10 +2329,103 @@ func ConsumePartitions(partitions map[string]map[int32]Offset) ConsumerOpt {
 // all topics can be passed to any regular expressions. Every topic is
 // evaluated only once ever across all regular expressions; either it
 // permanently is known to match, or is permanently known to not match.
+//
+// Multiple regular expressions can be passed to ConsumeTopics; a topic is
+// consumed if it matches any of them. To exclude topics that would otherwise
+// match, see ConsumeExcludeTopics. If you already have compiled regular
+// expressions, see ConsumeRegexes.
 func ConsumeRegex() ConsumerOpt {
 	return consumerOpt{func(cfg *cfg) { cfg.regex = true }}
 }
 
+// ConsumeRegexes sets the client to consume every topic matching any of the
+// given compiled regular expressions. This is shorthand for ConsumeTopics with
+// the expressions' strings and ConsumeRegex, and as with ConsumeTopics, this
+// replaces any topics previously set with ConsumeTopics or ConsumeRegexes.
+// For example, to consume "events\..*" but not "events\.internal\..*":
+//
+//	kgo.ConsumeRegexes(regexp.MustCompile(`events\..*`)),
+//	kgo.ConsumeExcludeRegexes(regexp.MustCompile(`events\.internal\..*`)),
+func ConsumeRegexes(patterns ...*regexp.Regexp) ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) {
+		cfg.regex = true
+		cfg.topics = make(map[string]*regexp.Regexp, len(patterns))
+		for _, re := range patterns {
+			cfg.topics[re.String()] = re
+		}
+	}}
+}
+
+// ConsumeExcludeTopics sets regular expressions of topics to never consume
+// when consuming via regex. A topic that matches any exclusion is not
+// consumed, even if it matches a regular expression in ConsumeTopics. For
+// example, to consume "events\..*" but not "events\.internal\..*":
+//
+//	kgo.ConsumeTopics(`events\..*`),
+//	kgo.ConsumeExcludeTopics(`events\.internal\..*`),
+//	kgo.ConsumeRegex(),
+//
+// As with ConsumeTopics, topics are evaluated against exclusions only once
+// ever. This option is only valid with ConsumeRegex or ConsumeRegexes.
+func ConsumeExcludeTopics(topics ...string) ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) {
+		cfg.excludeTopics = make(map[string]*regexp.Regexp, len(topics))
+		for _, topic := range topics {
+			cfg.excludeTopics[topic] = nil
+		}
+	}}
+}
+
+// ConsumeExcludeRegexes is ConsumeExcludeTopics with compiled regular
+// expressions, and likewise replaces any previously set exclusions.
+func ConsumeExcludeRegexes(patterns ...*regexp.Regexp) ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) {
+		cfg.excludeTopics = make(map[string]*regexp.Regexp, len(patterns))
+		for _, re := range patterns {
+			cfg.excludeTopics[re.String()] = re
+		}
+	}}
+}
+
+// RegexExcludeInternal sets whether internal topics (__consumer_offsets,
+// __transaction_state) are excluded when consuming via regex, overriding the
+// default of true. Internal topics can always be consumed by specifying them
+// explicitly when not using regex.
+func RegexExcludeInternal(exclude bool) ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) { cfg.regexExcludeInternal = exclude }}
+}
+
+// OnRegexTopicsMatched sets a function to be called when the set of topics
+// matched while consuming via regex changes. The function is called with the
+// topics that newly matched any regular expression and the topics that were
+// previously matched but are now being purged because they were deleted.
+// Internal topics are not included if they are excluded (see
+// RegexExcludeInternal).
+//
+// This function is called serially from the client's metadata loop after
+// topics are evaluated but before any newly matched topic is consumed. It must
+// not block, and must not call client functions that wait on a metadata
+// update.
+func OnRegexTopicsMatched(onMatched func(added, removed []string)) ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) { cfg.onRegexMatched = onMatched }}
+}
+
+// RegexDiscoveryInterval sets how often topics are discovered when consuming
+// via regex, decoupling discovery from the metadata refresh of topics that are
+// already being consumed. By default, every metadata update requests all
+// topics so that new topics can be evaluated against the regular expressions.
+//
+// With this option, metadata updates outside of the discovery interval only
+// request topics that are already matched (and any topics being produced to),
+// and all topics are only requested once per interval. This interval can be
+// shorter than MetadataMaxAge, to discover new topics quickly, or longer, to
+// reduce the load of requesting all topics in a large cluster. Discovery is
+// still subject to MetadataMinAge. Deleted topics are only purged during
+// discovery.
+func RegexDiscoveryInterval(interval time.Duration) ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) { cfg.regexDiscoveryInterval = interval }}
+}
+
 // DisableFetchSessions sets the client to not use fetch sessions (Kafka 1.0+).
 //
 // A "fetch session" is is a way to reduce bandwidth for fetch requests &
@@ -1411,6 +2490,24 @@ func KeepRetryableFetchErrors() ConsumerOpt {
 	return consumerOpt{func(cfg *cfg) { cfg.keepRetryableFetchErrors = true }}
 }
 
+// FetchDecompressWorkers sets the number of goroutines used to decompress and
+// process the partitions within a single fetch response, overriding the
+// default of 1.
+//
+// By default, each fetch response is processed on one goroutine per broker:
+// every partition in the response is decompressed serially. For high volume
+// compressed (notably zstd) topics, this can be the bottleneck when
+// consuming. Using more than one worker processes different partitions of the
+// same response in parallel. Records within a partition are always processed
+// by one worker, so they are still returned in offset order.
+//
+// The workers are started per fetch response and are bounded by the number
+// of partitions in the response, so increasing this beyond the number of
+// partitions led by any single broker has no effect.
+func FetchDecompressWorkers(n int) ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) { cfg.fetchDecompressWorkers = n }}
+}
+
 //////////////////////////////////
 // CONSUMER GROUP CONFIGURATION //
 //////////////////////////////////
@@ -1507,6 +2604,27 @@ func RequireStableFetchOffsets() GroupOpt {
 	return groupOpt{func(cfg *cfg) { cfg.requireStable = true }}
 }
 
+// TxnCommitRebalanceRetries sets the number of times GroupTransactSession.End
+// retries committing offsets in a transaction if the commit fails only with
+// REBALANCE_IN_PROGRESS, overriding the default of 0. Retries use the
+// client's RetryBackoffFn and stop early if partitions are revoked or lost,
+// if the group generation changes (the rebalance completed, so the commit
+// cannot succeed), or if the End context is canceled.
+//
+// By default, REBALANCE_IN_PROGRESS while committing offsets causes End to
+// abort the transaction. If the group is only briefly rebalancing (for
+// example, coordinator side flapping), a short retry may succeed before the
+// rebalance timeout and avoid the abort. If the retries are exhausted, End
+// aborts as usual.
+//
+// After a successful commit, End still forces a heartbeat before ending the
+// transaction. If the group truly is rebalancing, the forced heartbeat fails
+// and End aborts regardless: retrying does not weaken the guarantee that the
+// transaction only commits if this member is still in the group.
+func TxnCommitRebalanceRetries(n int) GroupOpt {
+	return groupOpt{func(cfg *cfg) { cfg.txnCommitRebalanceRetries = n }}
+}
+
 // BlockRebalanceOnPoll switches the client to block rebalances whenever you
 // poll until you explicitly call AllowRebalance. This option also ensures that
 // any OnPartitions{Assigned,Revoked,Lost} callbacks are only called when you
@@ -1639,6 +2757,52 @@ func OnPartitionsLost(onLost func(context.Context, *Client, map[string][]int32))
 	return groupOpt{func(cfg *cfg) { cfg.onLost, cfg.setLost = onLost, true }}
 }
 
+// OnPartialRevoke sets a function to be called when a cooperative consumer
+// has some, but not necessarily all, of its partitions revoked in a
+// rebalance. The function is called with only the partitions that were
+// revoked; every other partition is retained. This is called just after
+// OnPartitionsRevoked (which is called for both partial and full revokes), so
+// that stateful consumers can distinguish a cooperative partial revoke, where
+// state for retained partitions should be kept, from a full revoke.
+//
+// This function is only called for cooperative group balancers (i.e.,
+// CooperativeStickyBalancer), and is not called when leaving the group, which
+// always revokes everything. The same concurrency guarantees as
+// OnPartitionsRevoked apply.
+func OnPartialRevoke(onPartialRevoke func(context.Context, *Client, map[string][]int32)) GroupOpt {
+	return groupOpt{func(cfg *cfg) { cfg.onPartialRevoke = onPartialRevoke }}
+}
+
+// OnRebalance sets a function to call whenever the group member begins
+// joining its group, with the reason it is joining. This can be used to track
+// why rebalances happen, e.g. to determine whether a rebalance storm is caused
+// by a flapping member (RebalanceReasonGroup) or by churning topic metadata
+// (RebalanceReasonMetadataChange).
+//
+// Note that a member joining the group does not always cause a rebalance: if
+// this member is not the leader and its join metadata did not change, Kafka
+// may reply with the current assignment. This function is called serially in
+// the group management goroutine before the JoinGroup request is issued, and
+// must not block.
+func OnRebalance(fn func(RebalanceInfo)) GroupOpt {
+	return groupOpt{func(cfg *cfg) { cfg.onRebalance = fn }}
+}
+
+// WithOffsetStore commits and fetches group offsets with store rather than
+// with Kafka (OffsetCommit and OffsetFetch requests). Group membership and
+// partition assignment are still managed by Kafka.
+//
+// All group commits, including autocommits and commits in the default
+// OnPartitionsRevoked, go to the store, and offsets are fetched from the store
+// whenever partitions are assigned. OnOffsetsFetched and commit callbacks are
+// called with responses built from the store results. Since transactional
+// offset commits are written through the transaction coordinator, this option
+// cannot be used with TransactionalID. Admin functions that issue requests for
+// arbitrary groups (e.g., FetchGroupOffsetsWithMetadata) still use Kafka.
+func WithOffsetStore(store OffsetStore) GroupOpt {
+	return groupOpt{func(cfg *cfg) { cfg.offsetStore = store }}
+}
+
 // OnOffsetsFetched sets a function to be called after offsets have been
 // fetched after a group has been balanced. This function is meant to allow
 // users to inspect offset commit metadata. An error can be returned to exit
@@ -1694,6 +2858,38 @@ func AutoCommitInterval(interval time.Duration) GroupOpt {
 	return groupOpt{func(cfg *cfg) { cfg.autocommitInterval = interval }}
 }
 
+// OnAutoCommit sets a function to be called after every autocommit that
+// issues a commit (autocommits with nothing to commit are skipped). The
+// function is called with the offsets that were successfully committed, and
+// the first error encountered: either the request error, or the first
+// partition's commit error. Partitions that failed to commit are not included
+// in the committed map.
+//
+// This is called after the AutoCommitCallback, if one is set, and is meant
+// for observability: for example, to graph autocommit success and failure to
+// detect commits silently failing before it causes duplicate processing after
+// a restart. This function must not block and must not call commit functions,
+// since it is called within the commit.
+func OnAutoCommit(fn func(committed map[string]map[int32]EpochOffset, err error)) GroupOpt {
+	return groupOpt{func(cfg *cfg) { cfg.onAutoCommit = fn }}
+}
+
+// RevokeCommitTimeout bounds how long the default OnPartitionsRevoked
+// function waits for its synchronous commit, overriding the default of no
+// bound (0). This only applies when autocommitting without a custom
+// OnPartitionsRevoked.
+//
+// If the group coordinator is slow, an unbounded commit while revoking can
+// exceed the rebalance timeout, causing the member to be kicked from the
+// group and potentially causing repeated rebalances. If the commit times out,
+// the commit callback receives a context.DeadlineExceeded error and the
+// partitions are revoked without their latest offsets being committed,
+// meaning those records may be consumed again by the next owner. A
+// reasonable value is somewhat less than the rebalance timeout.
+func RevokeCommitTimeout(timeout time.Duration) GroupOpt {
+	return groupOpt{func(cfg *cfg) { cfg.revokeCommitTimeout = timeout }}
+}
+
 // AutoCommitMarks switches the autocommitting behavior to only commit "marked"
 // records, which can be done with the MarkCommitRecords method.
 //
@@ -1732,6 +2928,21 @@ func AutoCommitMarks() GroupOpt {
 //
 // NOTE: Leaving a group with an instance ID is only supported in Kafka 2.4+.
 //
+// An instance ID can be handed off to a new process without a rebalance, e.g.
+// for blue/green deploys: start the new process with the same instance ID
+// before the old process's session times out. When the new member joins, Kafka
+// replaces the old member, and the old member's requests fail with
+// FENCED_INSTANCE_ID. If group management (joining, syncing, or heartbeating)
+// fails with this error, the old client calls OnPartitionsLost, injects an
+// ErrGroupSession wrapping kerr.FencedInstanceID into polling, and stops
+// managing the group: it does not rejoin, which would in turn fence the new
+// member. Offset commits and transactional offset commits that fail with this
+// error are not retried; the error is returned to the caller (or passed to the
+// commit callback when autocommitting), and the next heartbeat stops group
+// management as above. The old client should then be closed. Any offsets the
+// old member did not commit before being fenced will be consumed again by the
+// new member.
+//
 // NOTE: If you restart a consumer group leader that is using an instance ID,
 // it will not cause a rebalance even if you change which topics the leader is
 // consuming. If your cluster is 3.2+, this client internally works around this
@@ -1740,6 +2951,28 @@ func InstanceID(id string) GroupOpt {
 	return groupOpt{func(cfg *cfg) { cfg.instanceID = &id }}
 }
 
+// ImportGroupMemberState sets the assignment state of a prior run of this
+// group member, as returned from Client.ExportGroupMemberState, to present to
+// sticky balancers in the member's first join. This reduces partition churn
+// on rolling restarts: by default, a restarted member has no current
+// assignment, and the sticky balancers cannot keep its prior partitions with
+// it. This is most useful for large stateful consumers where every
+// reassigned partition triggers an expensive state reload.
+//
+// The imported state is only used in the first join and only if the member
+// has no assignment of its own. The imported partitions are never considered
+// owned: OnPartitionsAssigned is called for everything assigned in the first
+// session as usual. With a cooperative balancer, if the leader does not give
+// back all imported partitions, the member immediately rejoins so that the
+// leader can assign them elsewhere. Balancers that are not sticky ignore the
+// current assignment entirely.
+//
+// This pairs well with InstanceID, which avoids a rebalance on restart
+// entirely if the member restarts within the session timeout.
+func ImportGroupMemberState(state GroupMemberState) GroupOpt {
+	return groupOpt{func(cfg *cfg) { cfg.importedMemberState = &state }}
+}
+
 // GroupProtocol sets the group's join protocol, overriding the default value
 // "consumer". The only reason to override this is if you are implementing
 // custom join and sync group logic.
@@ -0,0 +1,95 @@
+package kgo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// AlterConfig is a single config to incrementally alter.
+type AlterConfig struct {
+	// Op is the operation to perform: set, delete, append, or subtract.
+	// Append and subtract are only valid for list configs.
+	Op kmsg.IncrementalAlterConfigOp
+
+	// Name is the name of the config to alter, e.g. "retention.ms".
+	Name string
+
+	// Value is the value to set, append, or subtract. This is unused when
+	// deleting a config.
+	Value *string
+}
+
+// AlterConfigsResource is a resource (topic or broker) and the configs to
+// alter on it, used in IncrementalAlterConfigs.
+type AlterConfigsResource struct {
+	// Type is the type of resource to alter, e.g. kmsg.ConfigResourceTypeTopic.
+	Type kmsg.ConfigResourceType
+
+	// Name is the name of the resource: a topic name for topics, or a
+	// broker ID for brokers. For brokers, an empty name alters the
+	// cluster-wide default broker config.
+	Name string
+
+	// Configs are the configs to alter.
+	Configs []AlterConfig
+}
+
+// AlterConfigsResult is the result of altering the configs for a single
+// resource in IncrementalAlterConfigs.
+type AlterConfigsResult struct {
+	Type kmsg.ConfigResourceType // Type is the resource type that was altered.
+	Name string                  // Name is the resource name that was altered.
+	Err  error                   // Err is non-nil if altering the resource failed.
+}
+
+// IncrementalAlterConfigs incrementally alters the configs for topics or
+// brokers, returning a result per resource. This issues an
+// IncrementalAlterConfigsRequest (Kafka 2.3+); requests for specific brokers
+// are issued to those brokers. If validateOnly is true, the alterations are
+// validated but not applied.
+//
+// The returned error is only non-nil if the request itself failed, in which
+// case no results are returned. Per-resource errors are returned in each
+// result. For more complete config administration, including describing
+// configs, see the kadm package.
+func (cl *Client) IncrementalAlterConfigs(ctx context.Context, resources []AlterConfigsResource, validateOnly bool) ([]AlterConfigsResult, error) {
+	if len(resources) == 0 {
+		return nil, nil
+	}
+	req := kmsg.NewPtrIncrementalAlterConfigsRequest()
+	req.ValidateOnly = validateOnly
+	for _, r := range resources {
+		rr := kmsg.NewIncrementalAlterConfigsRequestResource()
+		rr.ResourceType = r.Type
+		rr.ResourceName = r.Name
+		for _, c := range r.Configs {
+			rc := kmsg.NewIncrementalAlterConfigsRequestResourceConfig()
+			rc.Name = c.Name
+			rc.Op = c.Op
+			rc.Value = c.Value
+			rr.Configs = append(rr.Configs, rc)
+		}
+		req.Resources = append(req.Resources, rr)
+	}
+
+	resp, err := req.RequestWith(ctx, cl)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]AlterConfigsResult, 0, len(resp.Resources))
+	for _, r := range resp.Resources {
+		err := kerr.ErrorForCode(r.ErrorCode)
+		if err != nil && r.ErrorMessage != nil {
+			err = fmt.Errorf("%w: %s", err, *r.ErrorMessage)
+		}
+		results = append(results, AlterConfigsResult{
+			Type: r.ResourceType,
+			Name: r.ResourceName,
+			Err:  err,
+		})
+	}
+	return results, nil
+}
@@ -0,0 +1,103 @@
+package kgo
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+func TestIncrementalAlterConfigs(t *testing.T) {
+	tt := &scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+		switch req := req.(type) {
+		case *kmsg.MetadataRequest:
+			resp := req.ResponseKind().(*kmsg.MetadataResponse)
+			for node := int32(0); node < 2; node++ {
+				b := kmsg.NewMetadataResponseBroker()
+				b.NodeID, b.Host, b.Port = node, "localhost", 1+node
+				resp.Brokers = append(resp.Brokers, b)
+			}
+			return resp, nil
+		case *kmsg.IncrementalAlterConfigsRequest:
+			resp := req.ResponseKind().(*kmsg.IncrementalAlterConfigsResponse)
+			for _, rr := range req.Resources {
+				sr := kmsg.NewIncrementalAlterConfigsResponseResource()
+				sr.ResourceType, sr.ResourceName = rr.ResourceType, rr.ResourceName
+				if rr.ResourceName == "bad" {
+					sr.ErrorCode = kerr.InvalidConfig.Code
+					sr.ErrorMessage = kmsg.StringPtr("no such config")
+				}
+				resp.Resources = append(resp.Resources, sr)
+			}
+			return resp, nil
+		}
+		return nil, errors.New("unexpected request")
+	}}
+	cl := newUnitClient(t, WithTestTransport(tt))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if results, err := cl.IncrementalAlterConfigs(ctx, nil, false); err != nil || results != nil {
+		t.Errorf("got results %v err %v altering nothing, exp none", results, err)
+	}
+
+	results, err := cl.IncrementalAlterConfigs(ctx, []AlterConfigsResource{
+		{Type: kmsg.ConfigResourceTypeTopic, Name: "foo", Configs: []AlterConfig{{Op: kmsg.IncrementalAlterConfigOpSet, Name: "retention.ms", Value: kmsg.StringPtr("1000")}}},
+		{Type: kmsg.ConfigResourceTypeTopic, Name: "bad", Configs: []AlterConfig{{Op: kmsg.IncrementalAlterConfigOpDelete, Name: "bogus"}}},
+		{Type: kmsg.ConfigResourceTypeBroker, Name: "1", Configs: []AlterConfig{{Op: kmsg.IncrementalAlterConfigOpSet, Name: "log.cleaner.threads", Value: kmsg.StringPtr("2")}}},
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	if len(results) != 3 {
+		t.Fatalf("got %d results != exp 3", len(results))
+	}
+	for _, r := range results {
+		switch r.Name {
+		case "bad":
+			if !errors.Is(r.Err, kerr.InvalidConfig) || !strings.HasSuffix(r.Err.Error(), ": no such config") {
+				t.Errorf("got err %v for bad, exp InvalidConfig with the error message", r.Err)
+			}
+		default:
+			if r.Err != nil {
+				t.Errorf("got unexpected err for %s: %v", r.Name, r.Err)
+			}
+		}
+	}
+
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	var sawBroker bool
+	for i, req := range tt.reqs {
+		areq, ok := req.(*kmsg.IncrementalAlterConfigsRequest)
+		if !ok {
+			continue
+		}
+		if !areq.ValidateOnly {
+			t.Error("expected the request to be validate only")
+		}
+		for _, rr := range areq.Resources {
+			switch rr.ResourceName {
+			case "1":
+				sawBroker = true
+				if tt.nodes[i] != 1 || len(areq.Resources) != 1 {
+					t.Errorf("broker resource was issued to broker %d with %d resources, exp only to broker 1", tt.nodes[i], len(areq.Resources))
+				}
+			case "foo":
+				if c := rr.Configs[0]; c.Name != "retention.ms" || c.Op != kmsg.IncrementalAlterConfigOpSet || c.Value == nil || *c.Value != "1000" {
+					t.Errorf("unexpected foo config %v", c)
+				}
+			}
+		}
+	}
+	if !sawBroker {
+		t.Error("broker resource was not issued")
+	}
+}
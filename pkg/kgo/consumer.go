@@ -181,6 +181,21 @@ type consumer struct {
 	bufferedRecords atomicI64
 	bufferedBytes   atomicI64
 
+	// bufferDrainedCh is closed and replaced whenever polling drops
+	// bufferedBytes below MaxBufferedFetchBytes, waking everything
+	// waiting to resume fetching.
+	bufferDrainedMu sync.Mutex
+	bufferDrainedCh chan struct{}
+
+	// logicalNames maps wire topic names to the logical names used in
+	// the consumer API; see TopicNameMapper.
+	logicalNames sync.Map
+
+	// pollsActive and lastPollDone (unix nanos) are used by the
+	// MaxPollInterval watchdog.
+	pollsActive  atomicI32
+	lastPollDone atomicI64
+
 	cl *Client
 
 	pausedMu sync.Mutex   // grabbed when updating paused
@@ -228,6 +243,22 @@ type consumer struct {
 	pollWaitState uint64 // 0 == nothing, low 32 bits: # pollers, high 32: # waiting rebalances
 }
 
+// fairPollShare returns how many records to take from each buffered partition
+// to split n records evenly across all buffered partitions, rounding up. This
+// must be called with sourcesReadyMu held.
+func (c *consumer) fairPollShare(n int) int {
+	var partitions int
+	for _, source := range c.sourcesReadyForDraining {
+		for _, t := range source.buffered.fetch.Topics {
+			partitions += len(t.Partitions)
+		}
+	}
+	if partitions == 0 {
+		return n
+	}
+	return (n + partitions - 1) / partitions
+}
+
 func (c *consumer) loadPaused() pausedTopics   { return c.paused.Load().(pausedTopics) }
 func (c *consumer) clonePaused() pausedTopics  { return c.paused.Load().(pausedTopics).clone() }
 func (c *consumer) storePaused(p pausedTopics) { c.paused.Store(p) }
@@ -310,6 +341,67 @@ func (cl *Client) BufferedFetchBytes() int64 {
 	return cl.consumer.bufferedBytes.Load()
 }
 
+// overBufferedFetchBytes returns whether MaxBufferedFetchBytes is set and the
+// buffered bytes are at or above it.
+func (c *consumer) overBufferedFetchBytes() bool {
+	limit := c.cl.cfg.maxBufferedFetchBytes
+	return limit > 0 && c.bufferedBytes.Load() >= limit
+}
+
+// bufferDrained returns a channel that is closed the next time polling drops
+// bufferedBytes below MaxBufferedFetchBytes.
+func (c *consumer) bufferDrained() <-chan struct{} {
+	c.bufferDrainedMu.Lock()
+	defer c.bufferDrainedMu.Unlock()
+	return c.bufferDrainedCh
+}
+
+// signalBufferDrained wakes everything waiting on bufferDrained.
+func (c *consumer) signalBufferDrained() {
+	c.bufferDrainedMu.Lock()
+	defer c.bufferDrainedMu.Unlock()
+	close(c.bufferDrainedCh)
+	c.bufferDrainedCh = make(chan struct{})
+}
+
+// watchPolls is the MaxPollInterval watchdog: if no poll is active and the
+// last poll returned more than the interval ago, this calls OnPollStall once
+// until the next poll. Nothing is checked until the first poll returns.
+func (c *consumer) watchPolls() {
+	var (
+		cfg      = &c.cl.cfg
+		interval = cfg.maxPollInterval
+		check    = interval / 4
+		stalled  int64 // lastPollDone when we last called OnPollStall
+	)
+	if check < 10*time.Millisecond {
+		check = 10 * time.Millisecond
+	}
+	for {
+		timer := cfg.clock.NewTimer(check)
+		select {
+		case <-c.cl.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+
+		last := c.lastPollDone.Load()
+		if last == 0 || last == stalled || c.pollsActive.Load() > 0 {
+			continue
+		}
+		since := cfg.clock.Since(time.Unix(0, last))
+		if since < interval {
+			continue
+		}
+		stalled = last
+		cfg.logger.Log(LogLevelWarn, "no poll within the max poll interval, the consumer may be stuck", "since_last_poll", since, "max_poll_interval", interval)
+		if fn := cfg.onPollStall; fn != nil {
+			fn(since)
+		}
+	}
+}
+
 type usedCursors map[*cursor]struct{}
 
 func (u *usedCursors) use(c *cursor) {
@@ -321,10 +413,16 @@ func (u *usedCursors) use(c *cursor) {
 
 func (c *consumer) init(cl *Client) {
 	c.cl = cl
+	c.mapConfiguredTopics()
 	c.paused.Store(make(pausedTopics))
+	c.bufferDrainedCh = make(chan struct{})
 	c.sourcesReadyCond = sync.NewCond(&c.sourcesReadyMu)
 	c.pollWaitC = sync.NewCond(&c.pollWaitMu)
 
+	if cl.cfg.maxPollInterval > 0 {
+		go c.watchPolls()
+	}
+
 	if len(cl.cfg.topics) > 0 || len(cl.cfg.partitions) > 0 {
 		defer cl.triggerUpdateMetadataNow("querying metadata for consumer initialization") // we definitely want to trigger a metadata update
 	}
@@ -401,6 +499,17 @@ func (cl *Client) PollFetches(ctx context.Context) Fetches {
 	return cl.PollRecords(ctx, 0)
 }
 
+// PollPartitionFetches is PollFetches, but returns the fetched partitions
+// grouped by topic and partition. This is useful if you dispatch work per
+// partition; see Fetches.Partitions for how partitions are grouped.
+//
+// Injected errors (ErrClientClosed, a context error, and so on) are keyed
+// under an empty topic and partition -1, and must be checked just as they
+// must be with PollFetches.
+func (cl *Client) PollPartitionFetches(ctx context.Context) map[string]map[int32]FetchPartition {
+	return cl.PollRecords(ctx, 0).Partitions()
+}
+
 // PollRecords waits for records to be available, returning as soon as any
 // broker returns records in a fetch. If the context is nil, this function will
 // return immediately with any currently buffered records.
@@ -428,6 +537,12 @@ func (cl *Client) PollRecords(ctx context.Context, maxPollRecords int) Fetches {
 	}
 	c := &cl.consumer
 
+	c.pollsActive.Add(1)
+	defer func() {
+		c.lastPollDone.Store(cl.cfg.clock.Now().UnixNano())
+		c.pollsActive.Add(-1)
+	}()
+
 	c.g.undirtyUncommitted()
 
 	// If the user gave us a canceled context, we bail immediately after
@@ -474,10 +589,30 @@ func (cl *Client) PollRecords(ctx context.Context, maxPollRecords int) Fetches {
 				fetches = append(fetches, ready.takeBuffered(paused))
 			}
 			c.sourcesReadyForDraining = nil
+		} else if c.cl.cfg.fairPoll {
+			// We take an even share from every buffered partition
+			// across every source, rotating sources (and within
+			// sources, partitions) so that the next poll starts
+			// with what was not taken from.
+			share := c.fairPollShare(maxPollRecords)
+			var keep, rotate []*source
+			for _, source := range c.sourcesReadyForDraining {
+				if maxPollRecords == 0 {
+					keep = append(keep, source)
+					continue
+				}
+				fetch, taken, drained := source.takeNBuffered(paused, maxPollRecords, share)
+				maxPollRecords -= taken
+				fetches = append(fetches, fetch)
+				if !drained {
+					rotate = append(rotate, source)
+				}
+			}
+			c.sourcesReadyForDraining = append(keep, rotate...)
 		} else {
 			for len(c.sourcesReadyForDraining) > 0 && maxPollRecords > 0 {
 				source := c.sourcesReadyForDraining[0]
-				fetch, taken, drained := source.takeNBuffered(paused, maxPollRecords)
+				fetch, taken, drained := source.takeNBuffered(paused, maxPollRecords, 0)
 				if drained {
 					c.sourcesReadyForDraining = c.sourcesReadyForDraining[1:]
 				}
@@ -515,7 +650,7 @@ func (cl *Client) PollRecords(ctx context.Context, maxPollRecords int) Fetches {
 	// we guarantee that we just drain anything available and return.
 	fill()
 	if len(fetches) > 0 || ctx == nil {
-		return fetches
+		return c.logicalFetches(fetches)
 	}
 
 	done := make(chan struct{})
@@ -548,7 +683,7 @@ func (cl *Client) PollRecords(ctx context.Context, maxPollRecords int) Fetches {
 	}
 
 	fill()
-	return fetches
+	return c.logicalFetches(fetches)
 }
 
 // AllowRebalance allows a consumer group to rebalance if it was blocked by you
@@ -572,6 +707,62 @@ func (cl *Client) UpdateFetchMaxBytes(maxBytes, maxPartBytes int32) {
 	cl.cfg.maxPartBytes.store(maxPartBytes)
 }
 
+// ResetFetchSessions drops all fetch session state (KIP-227), forcing the
+// next fetch to every broker to be a full fetch that establishes a new
+// session. Fetches that are already in flight are unaffected.
+//
+// The client resets sessions on its own when a broker replies with
+// FETCH_SESSION_ID_NOT_FOUND or INVALID_FETCH_SESSION_EPOCH, but only after
+// seeing the error. If you know session state was lost, such as behind a
+// proxy that occasionally drops it, this avoids a window of repeated session
+// errors. This does nothing if fetch sessions are disabled.
+func (cl *Client) ResetFetchSessions() {
+	cl.allSinksAndSources(func(sns sinkAndSource) {
+		sns.source.resetSession.Store(true)
+	})
+}
+
+// FetchPlan returns the partitions the client is currently fetching, grouped
+// by the broker they are fetched from: each broker ID maps to the topics and
+// partitions that are included in fetch requests to that broker.
+//
+// Partitions are usually fetched from their leader, but may be fetched from a
+// follower if consuming from a follower (see Rack). Comparing the fetch plan
+// to partition leadership can help diagnose fetch latency skew and imbalance
+// across brokers for consumers with large assignments. The returned plan is a
+// snapshot and may be stale as soon as it is returned.
+func (cl *Client) FetchPlan() map[int32]map[string][]int32 {
+	plan := make(map[int32]map[string][]int32)
+	cl.allSinksAndSources(func(sns sinkAndSource) {
+		s := sns.source
+		s.cursorsMu.Lock()
+		defer s.cursorsMu.Unlock()
+		if len(s.cursors) == 0 {
+			return
+		}
+		topics := make(map[string][]int32)
+		for _, c := range s.cursors {
+			topic := cl.consumer.logicalTopic(c.topic)
+			topics[topic] = append(topics[topic], c.partition)
+		}
+		plan[s.nodeID] = topics
+	})
+	return plan
+}
+
+// SetIsolationLevel changes the isolation level used for fetching records,
+// overriding the level set with FetchIsolationLevel. This takes effect for
+// fetch requests issued after this function returns; fetches that are
+// already in flight use the prior level. This does not affect your
+// assignment, nor does it rewind: if you switch from ReadUncommitted to
+// ReadCommitted, records that were already consumed stay consumed.
+//
+// This is mostly useful for debugging, e.g. to investigate whether
+// uncommitted records are being consumed without recreating the client.
+func (cl *Client) SetIsolationLevel(level IsolationLevel) {
+	cl.cfg.isolationLevel.store(int32(level.level))
+}
+
 // PauseFetchTopics sets the client to no longer fetch the given topics and
 // returns all currently paused topics. Paused topics persist until resumed.
 // You can call this function with no topics to simply receive the list of
@@ -585,14 +776,14 @@ func (cl *Client) UpdateFetchMaxBytes(maxBytes, maxPartBytes int32) {
 func (cl *Client) PauseFetchTopics(topics ...string) []string {
 	c := &cl.consumer
 	if len(topics) == 0 {
-		return c.loadPaused().pausedTopics()
+		return c.logicalTopics(c.loadPaused().pausedTopics())
 	}
 	c.pausedMu.Lock()
 	defer c.pausedMu.Unlock()
 	paused := c.clonePaused()
-	paused.addTopics(topics...)
+	paused.addTopics(c.wireTopics(topics)...)
 	c.storePaused(paused)
-	return paused.pausedTopics()
+	return c.logicalTopics(paused.pausedTopics())
 }
 
 // PauseFetchPartitions sets the client to no longer fetch the given partitions
@@ -608,14 +799,14 @@ func (cl *Client) PauseFetchTopics(topics ...string) []string {
 func (cl *Client) PauseFetchPartitions(topicPartitions map[string][]int32) map[string][]int32 {
 	c := &cl.consumer
 	if len(topicPartitions) == 0 {
-		return c.loadPaused().pausedPartitions()
+		return mapTopicKeys(c, c.loadPaused().pausedPartitions(), c.logicalTopic)
 	}
 	c.pausedMu.Lock()
 	defer c.pausedMu.Unlock()
 	paused := c.clonePaused()
-	paused.addPartitions(topicPartitions)
+	paused.addPartitions(mapTopicKeys(c, topicPartitions, c.wireTopic))
 	c.storePaused(paused)
-	return paused.pausedPartitions()
+	return mapTopicKeys(c, paused.pausedPartitions(), c.logicalTopic)
 }
 
 // ResumeFetchTopics resumes fetching the input topics if they were previously
@@ -631,7 +822,7 @@ func (cl *Client) ResumeFetchTopics(topics ...string) {
 	defer c.pausedMu.Unlock()
 
 	paused := c.clonePaused()
-	paused.delTopics(topics...)
+	paused.delTopics(c.wireTopics(topics)...)
 	c.storePaused(paused)
 }
 
@@ -649,7 +840,7 @@ func (cl *Client) ResumeFetchPartitions(topicPartitions map[string][]int32) {
 	defer c.pausedMu.Unlock()
 
 	paused := c.clonePaused()
-	paused.delPartitions(topicPartitions)
+	paused.delPartitions(mapTopicKeys(c, topicPartitions, c.wireTopic))
 	c.storePaused(paused)
 }
 
@@ -671,7 +862,8 @@ func (cl *Client) ResumeFetchPartitions(topicPartitions map[string][]int32) {
 // call) and to not use this concurrent with committing. Any other usage is
 // prone to odd interactions.
 func (cl *Client) SetOffsets(setOffsets map[string]map[int32]EpochOffset) {
-	cl.setOffsets(setOffsets, true)
+	c := &cl.consumer
+	cl.setOffsets(mapTopicKeys(c, setOffsets, c.wireTopic), true)
 }
 
 func (cl *Client) setOffsets(setOffsets map[string]map[int32]EpochOffset, log bool) {
@@ -735,7 +927,7 @@ func (c *consumer) purgeTopics(topics []string) {
 			delete(c.g.using, topic)
 			delete(c.g.reSeen, topic)
 		}
-		c.g.rejoin("rejoin from PurgeFetchTopics")
+		c.g.rejoin(RebalanceReasonUser, "rejoin from PurgeFetchTopics")
 	} else {
 		c.assignPartitions(purgeAssignments, assignPurgeMatching, c.d.tps, fmt.Sprintf("purge of %v requested", topics))
 		for _, topic := range topics {
@@ -759,6 +951,7 @@ func (cl *Client) AddConsumeTopics(topics ...string) {
 	if len(topics) == 0 || c.g == nil && c.d == nil || cl.cfg.regex {
 		return
 	}
+	topics = c.wireTopics(topics)
 
 	// We can do this outside of the metadata loop because we are strictly
 	// adding new topics and forbid regex consuming.
@@ -794,7 +987,7 @@ func (cl *Client) GetConsumeTopics() []string {
 	}
 	topics := make([]string, 0, len(m))
 	for k := range m {
-		topics = append(topics, k)
+		topics = append(topics, c.logicalTopic(k))
 	}
 	return topics
 }
@@ -806,6 +999,7 @@ func (cl *Client) AddConsumePartitions(partitions map[string]map[int32]Offset) {
 	if c.d == nil || cl.cfg.regex {
 		return
 	}
+	partitions = mapTopicKeys(c, partitions, c.wireTopic)
 	var topics []string
 	for t, ps := range partitions {
 		if len(ps) == 0 {
@@ -849,6 +1043,7 @@ func (cl *Client) RemoveConsumePartitions(partitions map[string][]int32) {
 	if c.d == nil || cl.cfg.regex {
 		return
 	}
+	partitions = mapTopicKeys(c, partitions, c.wireTopic)
 	for t, ps := range partitions {
 		if len(ps) == 0 {
 			delete(partitions, t)
@@ -1187,8 +1382,9 @@ func (c *consumer) assignPartitions(assignments map[string]map[int32]Offset, how
 }
 
 // filterMetadataAllTopics, called BEFORE doOnMetadataUpdate, evaluates
-// all topics received against the user provided regex.
-func (c *consumer) filterMetadataAllTopics(topics []string) []string {
+// all topics received against the user provided regex. This returns the topics
+// to keep as well as any topics that newly matched.
+func (c *consumer) filterMetadataAllTopics(topics []string) (keep, newMatches []string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -1202,13 +1398,23 @@ func (c *consumer) filterMetadataAllTopics(topics []string) []string {
 		reSeen = c.g.reSeen
 	}
 
-	keep := topics[:0]
+	keep = topics[:0]
 	for _, topic := range topics {
 		want, seen := reSeen[topic]
 		if !seen {
+			var excluded bool
+			for _, re := range c.cl.cfg.excludeTopics {
+				if excluded = re.MatchString(topic); excluded {
+					break
+				}
+			}
 			for rawRe, re := range c.cl.cfg.topics {
+				if excluded {
+					break
+				}
 				if want = re.MatchString(topic); want {
 					rns.add(rawRe, topic)
+					newMatches = append(newMatches, topic)
 					break
 				}
 			}
@@ -1221,7 +1427,7 @@ func (c *consumer) filterMetadataAllTopics(topics []string) []string {
 			keep = append(keep, topic)
 		}
 	}
-	return keep
+	return keep, newMatches
 }
 
 func (c *consumer) doOnMetadataUpdate() {
@@ -1555,16 +1761,18 @@ func (s *consumerSession) manageFetchConcurrency() {
 
 		case <-doneFetch:
 			activeFetches--
+		case <-s.c.bufferDrained():
 		case <-ctxCh:
 			wantQuit = true
 			ctxCh = nil
 		}
 
-		if len(wantFetch) > 0 && (activeFetches < s.allowedFetches || s.allowedFetches == 0) { // 0 means unbounded
+		// A drained buffer can allow many waiting sources to fetch at
+		// once, so we grant every fetch we can rather than just one.
+		for len(wantFetch) > 0 && (activeFetches < s.allowedFetches || s.allowedFetches == 0) && !s.c.overBufferedFetchBytes() { // 0 means unbounded
 			wantFetch[0] <- doneFetch
 			wantFetch = wantFetch[1:]
 			activeFetches++
-			continue
 		}
 
 		if wantQuit && activeFetches == 0 {
@@ -2011,7 +2219,7 @@ func (l *loadedOffsets) addAll(as []loadedOffset) loadedOffsets {
 func (cl *Client) listOffsetsForBrokerLoad(ctx context.Context, broker *broker, load offsetLoadMap, tps *topicsPartitions, results chan<- loadedOffsets) {
 	loaded := loadedOffsets{broker: broker.meta.NodeID, loadType: loadTypeList}
 
-	req1, req2 := load.buildListReq(cl.cfg.isolationLevel)
+	req1, req2 := load.buildListReq(int8(cl.cfg.isolationLevel.load()))
 	var (
 		wg     sync.WaitGroup
 		kresp2 kmsg.Response
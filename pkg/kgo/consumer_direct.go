@@ -81,7 +81,7 @@ func (d *directConsumer) findNewAssignments() map[string]map[int32]Offset {
 		// the topic is explicitly specified.
 		if useTopic {
 			partitions := topicPartitions.load()
-			if d.cfg.regex && partitions.isInternal || len(partitions.partitions) == 0 {
+			if d.cfg.regex && d.cfg.regexExcludeInternal && partitions.isInternal || len(partitions.partitions) == 0 {
 				continue
 			}
 			toUseTopic := make(map[int32]Offset, len(partitions.partitions))
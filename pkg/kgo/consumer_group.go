@@ -38,7 +38,7 @@ type groupConsumer struct {
 	// happening.
 	syncCommitMu sync.RWMutex
 
-	rejoinCh chan string // cap 1; sent to if subscription changes (regex)
+	rejoinCh chan rebalanceWhy // cap 1; sent to if subscription changes (regex)
 
 	// For EOS, before we commit, we force a heartbeat. If the client and
 	// group member are both configured properly, then the transactional
@@ -55,6 +55,11 @@ type groupConsumer struct {
 	lastAssigned map[string][]int32
 	nowAssigned  amtps
 
+	// imported is the assignment from ImportGroupMemberState, used only
+	// in our first join if we have no assignment of our own. This is
+	// only used in the manage loop.
+	imported *GroupMemberState
+
 	// Fetching ensures we continue fetching offsets across cooperative
 	// rebalance if an offset fetch returns early due to an immediate
 	// rebalance. See the large comment on adjustCooperativeFetchOffsets
@@ -96,6 +101,13 @@ type groupConsumer struct {
 	// We store this as a pointer for address comparisons.
 	external atomic.Value // *groupExternal
 
+	// lastHeartbeat is the unix nanos of the last successful heartbeat,
+	// and rebalancing is whether we are outside of a stable group session
+	// (joining, syncing, or revoking after a heartbeat error). Both are
+	// only for GroupState.
+	lastHeartbeat atomicI64
+	rebalancing   atomicBool
+
 	// See the big comment on `commit`. If we allow committing between
 	// join&sync, we occasionally see RebalanceInProgress or
 	// IllegalGeneration errors while cooperative consuming.
@@ -124,6 +136,10 @@ type groupConsumer struct {
 	// - read when getting uncommitted or committed
 	uncommitted uncommitted
 
+	// committedCh, if non-nil, is closed and cleared whenever committed
+	// offsets in uncommitted change; see WaitCommitted. Guarded by mu.
+	committedCh chan struct{}
+
 	// memberID and generation are written to in the join and sync loop,
 	// and mostly read within that loop. This can be read during commits,
 	// which can happy any time. It is **recommended** to be done within
@@ -263,6 +279,13 @@ func (cl *Client) LeaveGroupContext(ctx context.Context) error {
 
 // GroupMetadata returns the current group member ID and generation, or an
 // empty string and -1 if not in the group.
+//
+// The generation is bumped every time the group rebalances, and it is what
+// the client uses when committing (and transactionally committing) offsets.
+// If you track external state alongside consuming (a distributed lock, work
+// claimed in a database, etc.), you can record the generation when the work
+// begins and compare it against the current generation when the work finishes
+// to detect that a rebalance happened and the work may be stale.
 func (cl *Client) GroupMetadata() (string, int32) {
 	g := cl.consumer.g
 	if g == nil {
@@ -271,6 +294,95 @@ func (cl *Client) GroupMetadata() (string, int32) {
 	return g.memberGen.load()
 }
 
+// GroupInstanceID returns the group instance ID this client was configured
+// with (see the InstanceID option), and whether the client is a static group
+// member at all.
+func (cl *Client) GroupInstanceID() (string, bool) {
+	if cl.consumer.g == nil || cl.cfg.instanceID == nil {
+		return "", false
+	}
+	return *cl.cfg.instanceID, true
+}
+
+// GroupMemberState is the assignment state of a group member, as returned
+// from ExportGroupMemberState and used in the ImportGroupMemberState option.
+// This struct can be serialized (e.g., with encoding/json) and persisted
+// across restarts.
+type GroupMemberState struct {
+	// Generation is the group generation the assignment was received in.
+	Generation int32
+	// Assigned is the member's assigned topics and partitions.
+	Assigned map[string][]int32
+}
+
+// ExportGroupMemberState returns the current assignment and generation of
+// this group member, to be persisted and passed to the
+// ImportGroupMemberState option when the member restarts. If the client is
+// not consuming in a group, this returns a zero state.
+//
+// Export the state after the final revoke, e.g. in OnPartitionsRevoked when
+// closing, to capture the final assignment.
+func (cl *Client) ExportGroupMemberState() GroupMemberState {
+	g := cl.consumer.g
+	if g == nil {
+		return GroupMemberState{}
+	}
+	return GroupMemberState{
+		Generation: g.memberGen.generation(),
+		Assigned:   g.nowAssigned.clone(),
+	}
+}
+
+// GroupState is a snapshot of the health of a group member, as returned from
+// GroupState.
+type GroupState struct {
+	// LastHeartbeat is the time of the last successful heartbeat, or the
+	// zero time if no heartbeat has yet succeeded.
+	LastHeartbeat time.Time
+
+	// CoordinatorID is the broker ID of the cached group coordinator, or
+	// -1 if the coordinator is not known (not yet loaded, or unloaded
+	// after a coordinator error).
+	CoordinatorID int32
+
+	// RebalanceInProgress is whether the member is not in a stable group
+	// session: the member is joining or syncing, revoking partitions after
+	// a heartbeat error, or backing off after a group error.
+	RebalanceInProgress bool
+}
+
+// GroupState returns a snapshot of the group member's heartbeat and
+// coordinator state. This can be used for liveness monitoring: if heartbeats
+// are still succeeding but the consumer is not making progress, the problem
+// is likely in record processing rather than in the group.
+//
+// If the client is not configured to consume in a group, this returns a zero
+// LastHeartbeat, a CoordinatorID of -1, and no rebalance in progress.
+func (cl *Client) GroupState() GroupState {
+	state := GroupState{CoordinatorID: -1}
+	g := cl.consumer.g
+	if g == nil {
+		return state
+	}
+	if last := g.lastHeartbeat.Load(); last != 0 {
+		state.LastHeartbeat = time.Unix(0, last)
+	}
+	state.RebalanceInProgress = g.rebalancing.Load()
+
+	cl.coordinatorsMu.Lock()
+	defer cl.coordinatorsMu.Unlock()
+	if c, ok := cl.coordinators[coordinatorKey{g.cfg.group, coordinatorTypeGroup}]; ok {
+		select {
+		case <-c.loadWait:
+			if c.err == nil {
+				state.CoordinatorID = c.node
+			}
+		default:
+		}
+	}
+	return state
+}
+
 func (c *consumer) initGroup() {
 	ctx, cancel := context.WithCancel(c.cl.ctx)
 	g := &groupConsumer{
@@ -285,13 +397,22 @@ func (c *consumer) initGroup() {
 
 		manageDone:       make(chan struct{}),
 		tps:              newTopicsPartitions(),
-		rejoinCh:         make(chan string, 1),
+		rejoinCh:         make(chan rebalanceWhy, 1),
 		heartbeatForceCh: make(chan func(error)),
 		using:            make(map[string]int),
 
 		left: make(chan struct{}),
 	}
 	c.g = g
+	if s := g.cfg.importedMemberState; s != nil && len(s.Assigned) > 0 {
+		g.imported = &GroupMemberState{
+			Generation: s.Generation,
+			Assigned:   make(map[string][]int32, len(s.Assigned)),
+		}
+		for t, ps := range s.Assigned {
+			g.imported.Assigned[t] = append([]int32(nil), ps...)
+		}
+	}
 	if !g.cfg.setCommitCallback {
 		g.cfg.commitCallback = g.defaultCommitCallback
 	}
@@ -337,7 +458,7 @@ func (c *consumer) initGroup() {
 			if user != nil {
 				dup := make(map[string][]int32)
 				for k, vs := range m {
-					dup[k] = append([]int32(nil), vs...)
+					dup[cl.consumer.logicalTopic(k)] = append([]int32(nil), vs...)
 				}
 				user(ctx, cl, dup)
 			}
@@ -380,12 +501,16 @@ func (g *groupConsumer) manage() {
 	}
 
 	var consecutiveErrors int
-	joinWhy := "beginning to manage the group lifecycle"
+	joinWhy := rebalanceWhy{RebalanceReasonInitialJoin, "beginning to manage the group lifecycle"}
 	for {
-		if joinWhy == "" {
-			joinWhy = "rejoining from normal rebalance"
+		if joinWhy.why == "" {
+			joinWhy = rebalanceWhy{RebalanceReasonGroup, "rejoining from normal rebalance"}
+		}
+		g.rebalancing.Store(true)
+		if fn := g.cfg.onRebalance; fn != nil {
+			fn(RebalanceInfo{Reason: joinWhy.reason, Why: joinWhy.why})
 		}
-		err := g.joinAndSync(joinWhy)
+		err := g.joinAndSync(joinWhy.why)
 		if err == nil {
 			if joinWhy, err = g.setupAssignedAndHeartbeat(); err != nil {
 				if errors.Is(err, kerr.RebalanceInProgress) {
@@ -397,7 +522,7 @@ func (g *groupConsumer) manage() {
 			consecutiveErrors = 0
 			continue
 		}
-		joinWhy = "rejoining after we previously errored and backed off"
+		joinWhy = rebalanceWhy{RebalanceReasonError, "rejoining after we previously errored and backed off"}
 
 		// If the user has BlockPollOnRebalance enabled, we have to
 		// block around the onLost and assigning.
@@ -464,6 +589,20 @@ func (g *groupConsumer) manage() {
 			return
 		}
 
+		// If a new member joined with our instance ID, we have been
+		// replaced (e.g., during a blue/green deploy). Rejoining would
+		// fence the new member in turn, so we stop managing the group.
+		// The error was surfaced above: onLost was called and the
+		// ErrGroupSession was injected into polling.
+		if errors.Is(err, kerr.FencedInstanceID) {
+			g.cfg.logger.Log(LogLevelError, "group member was fenced by a new member using the same instance ID, no longer managing the group",
+				"group", g.cfg.group,
+				"instance_id", strptr{g.cfg.instanceID},
+			)
+			g.cancel()
+			return
+		}
+
 		// Waiting for the backoff is a good time to update our
 		// metadata; maybe the error is from stale metadata.
 		consecutiveErrors++
@@ -715,6 +854,13 @@ func (g *groupConsumer) revoke(stage revokeStage, lost map[string][]int32, leavi
 		if g.cfg.onRevoked != nil {
 			g.cfg.onRevoked(g.cl.ctx, g.cl, lost)
 		}
+		if len(lost) > 0 && g.cfg.onPartialRevoke != nil {
+			dup := make(map[string][]int32, len(lost))
+			for t, ps := range lost {
+				dup[g.cl.consumer.logicalTopic(t)] = append([]int32(nil), ps...)
+			}
+			g.cfg.onPartialRevoke(g.cl.ctx, g.cl, dup)
+		}
 	}
 
 	if len(lost) == 0 { // if we lost nothing, do nothing
@@ -722,7 +868,7 @@ func (g *groupConsumer) revoke(stage revokeStage, lost map[string][]int32, leavi
 	}
 
 	if stage != revokeThisSession { // cooperative consumers rejoin after they revoking what they lost
-		defer g.rejoin("cooperative rejoin after revoking what we lost from a rebalance")
+		defer g.rejoin(RebalanceReasonCooperative, "cooperative rejoin after revoking what we lost from a rebalance")
 	}
 
 	// The block below deletes everything lost from our uncommitted map.
@@ -824,9 +970,9 @@ func (s *assignRevokeSession) revoke(g *groupConsumer, leaving bool) <-chan stru
 //   - which ensures that pre revoking is complete
 //   - fetching is complete
 //   - heartbeating is complete
-func (g *groupConsumer) setupAssignedAndHeartbeat() (string, error) {
+func (g *groupConsumer) setupAssignedAndHeartbeat() (rebalanceWhy, error) {
 	type hbquit struct {
-		rejoinWhy string
+		rejoinWhy rebalanceWhy
 		err       error
 	}
 	hbErrCh := make(chan hbquit, 1)
@@ -836,6 +982,27 @@ func (g *groupConsumer) setupAssignedAndHeartbeat() (string, error) {
 	added, lost := g.diffAssigned()
 	g.lastAssigned = g.nowAssigned.clone() // now that we are done with our last assignment, update it per the new assignment
 
+	// If we claimed an imported assignment in our join, a cooperative
+	// leader expects us to revoke anything it did not give back before
+	// it assigns those partitions elsewhere. We never owned them, so we
+	// have nothing to revoke, but we rejoin so that the leader can
+	// assign them.
+	var rejoinImported bool
+	if imported := g.imported; imported != nil {
+		g.imported = nil
+		if g.cooperative.Load() {
+			for t, ps := range imported.Assigned {
+				now := make(map[int32]bool, len(g.lastAssigned[t]))
+				for _, p := range g.lastAssigned[t] {
+					now[p] = true
+				}
+				for _, p := range ps {
+					rejoinImported = rejoinImported || !now[p]
+				}
+			}
+		}
+	}
+
 	g.cfg.logger.Log(LogLevelInfo, "new group session begun", "group", g.cfg.group, "added", mtps(added), "lost", mtps(lost))
 	s.prerevoke(g, lost) // for cooperative consumers
 
@@ -849,6 +1016,9 @@ func (g *groupConsumer) setupAssignedAndHeartbeat() (string, error) {
 		rejoinWhy, err := g.heartbeat(fetchErrCh, s)
 		hbErrCh <- hbquit{rejoinWhy, err}
 	}()
+	if rejoinImported {
+		g.rejoin(RebalanceReasonCooperative, "cooperative rejoin after claiming imported partitions that were not reassigned to us")
+	}
 
 	// We immediately begin fetching offsets. We want to wait until the
 	// fetch function returns, since it assumes within it that another
@@ -919,10 +1089,13 @@ func (g *groupConsumer) setupAssignedAndHeartbeat() (string, error) {
 //
 // If the offset fetch is successful, then we basically sit in this function
 // until a heartbeat errors or we, being the leader, decide to re-join.
-func (g *groupConsumer) heartbeat(fetchErrCh <-chan error, s *assignRevokeSession) (string, error) {
+func (g *groupConsumer) heartbeat(fetchErrCh <-chan error, s *assignRevokeSession) (rebalanceWhy, error) {
 	ticker := time.NewTicker(g.cfg.heartbeatInterval)
 	defer ticker.Stop()
 
+	g.rebalancing.Store(false)
+	defer g.rebalancing.Store(true)
+
 	// We issue one heartbeat quickly if we are cooperative because
 	// cooperative consumers rejoin the group immediately, and we want to
 	// detect that in 500ms rather than 3s.
@@ -933,7 +1106,7 @@ func (g *groupConsumer) heartbeat(fetchErrCh <-chan error, s *assignRevokeSessio
 
 	var metadone, revoked <-chan struct{}
 	var heartbeat, didMetadone, didRevoke bool
-	var rejoinWhy string
+	var rejoinWhy rebalanceWhy
 	var lastErr error
 
 	ctxCh := g.ctx.Done()
@@ -952,7 +1125,7 @@ func (g *groupConsumer) heartbeat(fetchErrCh <-chan error, s *assignRevokeSessio
 		case rejoinWhy = <-g.rejoinCh:
 			// If a metadata update changes our subscription,
 			// we just pretend we are rebalancing.
-			g.cfg.logger.Log(LogLevelInfo, "forced rejoin quitting heartbeat loop", "why", rejoinWhy)
+			g.cfg.logger.Log(LogLevelInfo, "forced rejoin quitting heartbeat loop", "why", rejoinWhy.why)
 			err = kerr.RebalanceInProgress
 		case err = <-fetchErrCh:
 			fetchErrCh = nil
@@ -984,6 +1157,9 @@ func (g *groupConsumer) heartbeat(fetchErrCh <-chan error, s *assignRevokeSessio
 				err = kerr.ErrorForCode(resp.ErrorCode)
 			}
 			g.cfg.logger.Log(LogLevelDebug, "heartbeat complete", "group", g.cfg.group, "err", err)
+			if err == nil {
+				g.lastHeartbeat.Store(g.cfg.clock.Now().UnixNano())
+			}
 			if force != nil {
 				force(err)
 			}
@@ -1001,6 +1177,7 @@ func (g *groupConsumer) heartbeat(fetchErrCh <-chan error, s *assignRevokeSessio
 			continue
 		}
 
+		g.rebalancing.Store(true)
 		if lastErr == nil {
 			g.cfg.logger.Log(LogLevelInfo, "heartbeat errored", "group", g.cfg.group, "err", err)
 		} else {
@@ -1022,7 +1199,7 @@ func (g *groupConsumer) heartbeat(fetchErrCh <-chan error, s *assignRevokeSessio
 			// to be done so that we avoid calling onLost
 			// concurrently.
 			if !errors.Is(err, kerr.RebalanceInProgress) && revoked == nil {
-				return "", err
+				return rebalanceWhy{}, err
 			}
 
 			// Now we call the user provided revoke callback, even
@@ -1052,6 +1229,79 @@ func (g *groupConsumer) heartbeat(fetchErrCh <-chan error, s *assignRevokeSessio
 	}
 }
 
+// RebalanceReason is why a group member is joining (or rejoining) its group.
+type RebalanceReason int8
+
+const (
+	// RebalanceReasonUnknown is an unknown reason; this is not used by
+	// the client itself.
+	RebalanceReasonUnknown RebalanceReason = iota
+
+	// RebalanceReasonInitialJoin is used when the member is joining the
+	// group for the first time.
+	RebalanceReasonInitialJoin
+
+	// RebalanceReasonGroup is used when the group coordinator signaled a
+	// rebalance (REBALANCE_IN_PROGRESS): another member joined or left the
+	// group, a member's session expired, or the group leader rejoined to
+	// rebalance.
+	RebalanceReasonGroup
+
+	// RebalanceReasonMetadataChange is used when this member noticed that
+	// topics it is interested in changed, or that partitions were added to
+	// topics that the group is consuming (only the leader checks other
+	// members' topics).
+	RebalanceReasonMetadataChange
+
+	// RebalanceReasonCooperative is used when a cooperative member
+	// rejoins after revoking partitions that it lost in a rebalance.
+	RebalanceReasonCooperative
+
+	// RebalanceReasonUser is used when the rejoin was requested with
+	// ForceRebalance, RequestRejoin, or PurgeTopicsFromConsuming.
+	RebalanceReasonUser
+
+	// RebalanceReasonError is used when the member is rejoining after the
+	// prior group session failed (e.g., UNKNOWN_MEMBER_ID, or a failed
+	// join or sync).
+	RebalanceReasonError
+)
+
+func (r RebalanceReason) String() string {
+	switch r {
+	case RebalanceReasonInitialJoin:
+		return "initial join"
+	case RebalanceReasonGroup:
+		return "group rebalance"
+	case RebalanceReasonMetadataChange:
+		return "metadata change"
+	case RebalanceReasonCooperative:
+		return "cooperative rejoin"
+	case RebalanceReasonUser:
+		return "user requested"
+	case RebalanceReasonError:
+		return "session error"
+	default:
+		return "unknown"
+	}
+}
+
+// RebalanceInfo contains information about why a group member is joining its
+// group; see OnRebalance.
+type RebalanceInfo struct {
+	// Reason is the category of why the member is joining.
+	Reason RebalanceReason
+	// Why is a more detailed description of why the member is joining.
+	// This is also sent to the broker as the join reason in Kafka 3.2+
+	// (KIP-800).
+	Why string
+}
+
+type rebalanceWhy struct {
+	reason RebalanceReason
+	why    string
+}
+
 // ForceRebalance quits a group member's heartbeat loop so that the member
 // rejoins with a JoinGroupRequest.
 //
@@ -1065,17 +1315,24 @@ func (g *groupConsumer) heartbeat(fetchErrCh <-chan error, s *assignRevokeSessio
 // join group metadata has not changed), then Kafka will not actually trigger a
 // rebalance and will instead reply to the member with its current assignment.
 func (cl *Client) ForceRebalance() {
+	cl.RequestRejoin("rejoin from ForceRebalance")
+}
+
+// RequestRejoin is ForceRebalance with a reason for rejoining, which is logged
+// and, for Kafka 3.2+ (KIP-800), sent to the broker in the JoinGroupRequest.
+// The same caveats documented on ForceRebalance apply.
+func (cl *Client) RequestRejoin(reason string) {
 	if g := cl.consumer.g; g != nil {
-		g.rejoin("rejoin from ForceRebalance")
+		g.rejoin(RebalanceReasonUser, reason)
 	}
 }
 
 // rejoin is called after a cooperative member revokes what it lost at the
 // beginning of a session, or if we are leader and detect new partitions to
 // consume.
-func (g *groupConsumer) rejoin(why string) {
+func (g *groupConsumer) rejoin(reason RebalanceReason, why string) {
 	select {
-	case g.rejoinCh <- why:
+	case g.rejoinCh <- rebalanceWhy{reason, why}:
 	default:
 	}
 }
@@ -1207,7 +1464,7 @@ start:
 		for _, assign := range plan {
 			if assign.MemberID == memberID {
 				if !bytes.Equal(assign.MemberAssignment, syncResp.MemberAssignment) {
-					g.rejoin("instance group leader restarted and was reassigned old plan, our topic interests changed and we must rejoin to force a rebalance")
+					g.rejoin(RebalanceReasonMetadataChange, "instance group leader restarted and was reassigned old plan, our topic interests changed and we must rejoin to force a rebalance")
 				}
 				break
 			}
@@ -1457,12 +1714,23 @@ func (g *groupConsumer) joinGroupProtocols() []kmsg.JoinGroupRequestProtocol {
 
 	g.mu.Unlock()
 
+	gen := g.memberGen.generation()
+
+	// If we have no assignment of our own and are restarting with an
+	// imported assignment, we present it as our current assignment so
+	// that sticky balancers can give it back to us.
+	if len(lastDup) == 0 && g.imported != nil {
+		for t, ps := range g.imported.Assigned {
+			lastDup[t] = append([]int32(nil), ps...)
+		}
+		gen = g.imported.Generation
+	}
+
 	sort.Strings(topics) // we guarantee to JoinGroupMetadata that the input strings are sorted
 	for _, partitions := range lastDup {
 		sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] }) // same for partitions
 	}
 
-	gen := g.memberGen.generation()
 	var protos []kmsg.JoinGroupRequestProtocol
 	for _, balancer := range g.cfg.balancers {
 		proto := kmsg.NewJoinGroupRequestProtocol()
@@ -1568,7 +1836,7 @@ start:
 	fetchDone := make(chan struct{})
 	go func() {
 		defer close(fetchDone)
-		resp, err = req.RequestWith(ctx, g.cl)
+		resp, err = g.requestOffsetFetch(ctx, req)
 	}()
 	select {
 	case <-fetchDone:
@@ -1647,7 +1915,9 @@ start:
 	}
 	if g.cfg.adjustOffsetsBeforeAssign != nil {
 		g.onFetchedMu.Lock()
-		offsets, err = g.cfg.adjustOffsetsBeforeAssign(ctx, offsets)
+		c := &g.cl.consumer
+		offsets, err = g.cfg.adjustOffsetsBeforeAssign(ctx, mapTopicKeys(c, offsets, c.logicalTopic))
+		offsets = mapTopicKeys(c, offsets, c.wireTopic)
 		g.onFetchedMu.Unlock()
 		if err != nil {
 			return err
@@ -1690,6 +1960,7 @@ start:
 			}
 		}
 	}
+	g.lockedNotifyCommitted()
 	return nil
 }
 
@@ -1743,7 +2014,7 @@ func (g *groupConsumer) findNewAssignments() {
 		// want to load the metadata", but the topic was not returned
 		// in the metadata (or it was returned with an error).
 		if useTopic && numPartitions > 0 {
-			if g.cfg.regex && parts.isInternal {
+			if g.cfg.regex && g.cfg.regexExcludeInternal && parts.isInternal {
 				continue
 			}
 			toChange[topic] = change{isNew: true, delta: numPartitions}
@@ -1775,12 +2046,12 @@ func (g *groupConsumer) findNewAssignments() {
 	}
 
 	if numNewTopics > 0 {
-		g.rejoin("rejoining because there are more topics to consume, our interests have changed")
+		g.rejoin(RebalanceReasonMetadataChange, "rejoining because there are more topics to consume, our interests have changed")
 	} else if g.leader.Load() {
 		if len(toChange) > 0 {
-			g.rejoin("rejoining because we are the leader and noticed some topics have new partitions")
+			g.rejoin(RebalanceReasonMetadataChange, "rejoining because we are the leader and noticed some topics have new partitions")
 		} else if externalRejoin {
-			g.rejoin("leader detected that partitions on topics another member is consuming have changed, rejoining to trigger rebalance")
+			g.rejoin(RebalanceReasonMetadataChange, "leader detected that partitions on topics another member is consuming have changed, rejoining to trigger rebalance")
 		}
 	}
 }
@@ -1894,6 +2165,38 @@ func (g *groupConsumer) updateUncommitted(fetches Fetches) {
 	}
 }
 
+// rewindUncommitted rewinds uncommitted offsets to the given offsets, for
+// partitions whose polled records were not all processed in
+// RunPartitionProcessors. This does nothing when autocommitting marks, since
+// unprocessed records are never marked.
+func (g *groupConsumer) rewindUncommitted(rewind map[string]map[int32]EpochOffset) {
+	if g.cfg.autocommitMarks {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for topic, partitions := range rewind {
+		topicOffsets := g.uncommitted[topic]
+		if topicOffsets == nil {
+			continue
+		}
+		for partition, to := range partitions {
+			current, ok := topicOffsets[partition]
+			if !ok {
+				continue
+			}
+			if to.Less(current.dirty) {
+				current.dirty = to
+			}
+			if to.Less(current.head) {
+				current.head = to
+			}
+			topicOffsets[partition] = current
+		}
+	}
+}
+
 // Called at the start of PollXyz only if autocommitting is enabled and we are
 // not committing greedily, this ensures that when we enter poll, everything
 // previously consumed is a candidate for autocommitting.
@@ -2056,6 +2359,7 @@ func (g *groupConsumer) updateCommitted(
 			}
 
 			topic[respPart.Partition] = uncommit
+			g.lockedNotifyCommitted()
 		}
 
 		if debug {
@@ -2095,6 +2399,44 @@ func (g *groupConsumer) defaultCommitCallback(_ *Client, _ *kmsg.OffsetCommitReq
 	}
 }
 
+// autoCommitted returns the offsets that were successfully committed and the
+// first error encountered, for OnAutoCommit.
+func autoCommitted(req *kmsg.OffsetCommitRequest, resp *kmsg.OffsetCommitResponse, err error) (map[string]map[int32]EpochOffset, error) {
+	if err != nil {
+		return nil, err
+	}
+	requested := make(map[string]map[int32]EpochOffset, len(req.Topics))
+	for _, t := range req.Topics {
+		ps := make(map[int32]EpochOffset, len(t.Partitions))
+		for _, p := range t.Partitions {
+			ps[p.Partition] = EpochOffset{p.LeaderEpoch, p.Offset}
+		}
+		requested[t.Topic] = ps
+	}
+	committed := make(map[string]map[int32]EpochOffset, len(resp.Topics))
+	for _, t := range resp.Topics {
+		for _, p := range t.Partitions {
+			if perr := kerr.ErrorForCode(p.ErrorCode); perr != nil {
+				if err == nil {
+					err = perr
+				}
+				continue
+			}
+			eo, ok := requested[t.Topic][p.Partition]
+			if !ok {
+				continue
+			}
+			ps := committed[t.Topic]
+			if ps == nil {
+				ps = make(map[int32]EpochOffset)
+				committed[t.Topic] = ps
+			}
+			ps[p.Partition] = eo
+		}
+	}
+	return committed, err
+}
+
 func (g *groupConsumer) loopCommit() {
 	ticker := time.NewTicker(g.cfg.autocommitInterval)
 	defer ticker.Stop()
@@ -2127,6 +2469,9 @@ func (g *groupConsumer) loopCommit() {
 				g.commit(g.ctx, uncommitted, func(cl *Client, req *kmsg.OffsetCommitRequest, resp *kmsg.OffsetCommitResponse, err error) {
 					g.noCommitDuringJoinAndSync.RUnlock()
 					g.cfg.commitCallback(cl, req, resp, err)
+					if g.cfg.onAutoCommit != nil {
+						g.cfg.onAutoCommit(autoCommitted(req, resp, err))
+					}
 				})
 			}
 		} else {
@@ -2169,6 +2514,7 @@ func (g *groupConsumer) getSetAssigns(setOffsets map[string]map[int32]EpochOffse
 				head:      epochOffset,
 				committed: epochOffset,
 			}
+			g.lockedNotifyCommitted()
 			if exists && current.dirty == epochOffset {
 				continue
 			} else if topicAssigns == nil {
@@ -2195,6 +2541,11 @@ func (g *groupConsumer) getSetAssigns(setOffsets map[string]map[int32]EpochOffse
 //
 // If there are no uncommitted offsets, this returns nil.
 func (cl *Client) UncommittedOffsets() map[string]map[int32]EpochOffset {
+	c := &cl.consumer
+	return mapTopicKeys(c, cl.uncommittedOffsets(), c.logicalTopic)
+}
+
+func (cl *Client) uncommittedOffsets() map[string]map[int32]EpochOffset {
 	if g := cl.consumer.g; g != nil {
 		return g.getUncommitted(true)
 	}
@@ -2210,7 +2561,8 @@ func (cl *Client) MarkedOffsets() map[string]map[int32]EpochOffset {
 	if g == nil || !cl.cfg.autocommitMarks {
 		return nil
 	}
-	return g.getUncommitted(false)
+	c := &cl.consumer
+	return mapTopicKeys(c, g.getUncommitted(false), c.logicalTopic)
 }
 
 // CommittedOffsets returns the latest committed offsets. Committed offsets are
@@ -2218,6 +2570,11 @@ func (cl *Client) MarkedOffsets() map[string]map[int32]EpochOffset {
 //
 // If there are no committed offsets, this returns nil.
 func (cl *Client) CommittedOffsets() map[string]map[int32]EpochOffset {
+	c := &cl.consumer
+	return mapTopicKeys(c, cl.committedOffsets(), c.logicalTopic)
+}
+
+func (cl *Client) committedOffsets() map[string]map[int32]EpochOffset {
 	g := cl.consumer.g
 	if g == nil {
 		return nil
@@ -2228,6 +2585,191 @@ func (cl *Client) CommittedOffsets() map[string]map[int32]EpochOffset {
 	return g.getUncommittedLocked(false, false)
 }
 
+// GroupLag returns the lag for every partition currently assigned to this
+// group member: the difference between each partition's end offset and the
+// next offset to consume (i.e., the offset after the last polled record). If
+// consuming with ReadCommitted, the end offset is the last stable offset,
+// otherwise it is the high watermark. This issues a ListOffsetsRequest to the
+// leaders of all assigned partitions.
+//
+// Assigned partitions that have not yet been polled from and that have no
+// committed offset are not included. This returns an error if the client is
+// not consuming as a group member or if listing offsets fails. For
+// calculating the lag of any group, including groups this client is not a
+// member of, see the kadm package.
+func (cl *Client) GroupLag(ctx context.Context) (map[string]map[int32]int64, error) {
+	return cl.groupLag(ctx, func(u uncommit) int64 { return u.dirty.Offset })
+}
+
+// CommittedLag returns the committed lag for every partition currently
+// assigned to this group member: the difference between each partition's end
+// offset and the last offset this member committed. This is how much would be
+// reprocessed if the member restarted right now, and unlike GroupLag, it
+// includes records that have been polled but not yet committed. The end offset
+// is the same as in GroupLag.
+//
+// Assigned partitions that have no committed offset are not included. This
+// returns an error if the client is not consuming as a group member or if
+// listing offsets fails.
+func (cl *Client) CommittedLag(ctx context.Context) (map[string]map[int32]int64, error) {
+	return cl.groupLag(ctx, func(u uncommit) int64 { return u.committed.Offset })
+}
+
+// groupLag returns the lag from the end offset of all assigned partitions to
+// the position returned from fn, skipping positions that are negative.
+func (cl *Client) groupLag(ctx context.Context, fn func(uncommit) int64) (map[string]map[int32]int64, error) {
+	g := cl.consumer.g
+	if g == nil {
+		return nil, errNotGroup
+	}
+	assigned := g.nowAssigned.read()
+	if len(assigned) == 0 {
+		return nil, nil
+	}
+
+	positions := make(map[string]map[int32]int64, len(assigned))
+	g.mu.Lock()
+	for topic, partitions := range assigned {
+		for _, partition := range partitions {
+			u, ok := g.uncommitted[topic][partition]
+			if !ok {
+				continue
+			}
+			pos := fn(u)
+			if pos < 0 {
+				continue
+			}
+			if positions[topic] == nil {
+				positions[topic] = make(map[int32]int64, len(partitions))
+			}
+			positions[topic][partition] = pos
+		}
+	}
+	g.mu.Unlock()
+	if len(positions) == 0 {
+		return nil, nil
+	}
+
+	req := kmsg.NewPtrListOffsetsRequest()
+	req.ReplicaID = -1
+	req.IsolationLevel = int8(cl.cfg.isolationLevel.load())
+	for topic, partitions := range positions {
+		rt := kmsg.NewListOffsetsRequestTopic()
+		rt.Topic = topic
+		for partition := range partitions {
+			rp := kmsg.NewListOffsetsRequestTopicPartition()
+			rp.Partition = partition
+			rp.Timestamp = -1 // end
+			rt.Partitions = append(rt.Partitions, rp)
+		}
+		req.Topics = append(req.Topics, rt)
+	}
+
+	lag := make(map[string]map[int32]int64, len(positions))
+	for _, shard := range cl.RequestSharded(ctx, req) {
+		if shard.Err != nil {
+			return nil, shard.Err
+		}
+		resp := shard.Resp.(*kmsg.ListOffsetsResponse)
+		for _, rt := range resp.Topics {
+			for _, rp := range rt.Partitions {
+				if err := kerr.ErrorForCode(rp.ErrorCode); err != nil {
+					return nil, fmt.Errorf("unable to list end offset for topic %s partition %d: %w", rt.Topic, rp.Partition, err)
+				}
+				pos, ok := positions[rt.Topic][rp.Partition]
+				if !ok {
+					continue
+				}
+				l := rp.Offset - pos
+				if l < 0 {
+					l = 0
+				}
+				if lag[rt.Topic] == nil {
+					lag[rt.Topic] = make(map[int32]int64, len(positions[rt.Topic]))
+				}
+				lag[rt.Topic][rp.Partition] = l
+			}
+		}
+	}
+	return mapTopicKeys(&cl.consumer, lag, cl.consumer.logicalTopic), nil
+}
+
+// WaitCommitted waits until this group member's committed offset for the
+// given partition is at or past offset, returning nil once it is. Committed
+// offsets are the offset of the next record to consume; to wait for a record
+// to be committed, use the record's offset plus one.
+//
+// This tracks the member's own commits (including commits from autocommitting,
+// transactions, and WithOffsetStore) as well as offsets fetched when the
+// partition is assigned; it does not poll Kafka. If the partition is not
+// assigned to this member, this waits until it is assigned and committed past
+// offset. This returns the context error if the context is canceled,
+// ErrClientClosed if the client is closed, or an error if the client is not
+// consuming as a group member.
+func (cl *Client) WaitCommitted(ctx context.Context, topic string, partition int32, offset int64) error {
+	g := cl.consumer.g
+	if g == nil {
+		return errNotGroup
+	}
+	topic = cl.consumer.wireTopic(topic)
+	for {
+		g.mu.Lock()
+		if u, ok := g.uncommitted[topic][partition]; ok && u.committed.Offset >= offset {
+			g.mu.Unlock()
+			return nil
+		}
+		if g.committedCh == nil {
+			g.committedCh = make(chan struct{})
+		}
+		committed := g.committedCh
+		g.mu.Unlock()
+
+		select {
+		case <-committed:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-cl.ctx.Done():
+			return ErrClientClosed
+		}
+	}
+}
+
+// CommitInFlight returns whether an offset commit is currently in flight for
+// this group member. This includes commits from autocommitting, CommitOffsets
+// and the functions that use it, and committing in a GroupTransactSession.
+//
+// Issuing a new commit cancels any commit that is in flight. If you commit
+// from multiple places, e.g. on a timer and at shutdown, this can be used to
+// skip a redundant commit rather than canceling one that is already in
+// progress. This returns false if the client is not consuming as a group
+// member.
+func (cl *Client) CommitInFlight() bool {
+	g := cl.consumer.g
+	if g == nil {
+		return false
+	}
+	g.mu.Lock()
+	done := g.commitDone
+	g.mu.Unlock()
+	if done == nil {
+		return false
+	}
+	select {
+	case <-done:
+		return false
+	default:
+		return true
+	}
+}
+
+// lockedNotifyCommitted wakes anything in WaitCommitted; g.mu must be held.
+func (g *groupConsumer) lockedNotifyCommitted() {
+	if g.committedCh != nil {
+		close(g.committedCh)
+		g.committedCh = nil
+	}
+}
+
 func (g *groupConsumer) getUncommitted(dirty bool) map[string]map[int32]EpochOffset {
 	g.mu.Lock()
 	defer g.mu.Unlock()
@@ -2399,10 +2941,11 @@ func (cl *Client) MarkCommitRecords(rs ...*Record) {
 	var curPartitions map[int32]uncommit
 	for _, r := range rs {
 		if curPartitions == nil || r.Topic != curTopic {
-			curPartitions = g.uncommitted[r.Topic]
+			topic := cl.consumer.wireTopic(r.Topic)
+			curPartitions = g.uncommitted[topic]
 			if curPartitions == nil {
 				curPartitions = make(map[int32]uncommit)
-				g.uncommitted[r.Topic] = curPartitions
+				g.uncommitted[topic] = curPartitions
 			}
 			curTopic = r.Topic
 		}
@@ -2430,6 +2973,7 @@ func (cl *Client) MarkCommitOffsets(unmarked map[string]map[int32]EpochOffset) {
 	if g == nil || !cl.cfg.autocommitMarks {
 		return
 	}
+	unmarked = mapTopicKeys(&cl.consumer, unmarked, cl.consumer.wireTopic)
 
 	// protect g.uncommitted map
 	g.mu.Lock()
@@ -2497,6 +3041,23 @@ func (cl *Client) CommitMarkedOffsets(ctx context.Context) error {
 	return cl.commitOffsets(ctx, marked)
 }
 
+// CommitPartition issues a synchronous offset commit for a single partition.
+// Retryable errors are retried up to the configured retry limit, and any
+// unretryable error is returned. The offset is the next offset to consume,
+// i.e., one past the last processed record.
+//
+// This is a shortcut for CommitOffsetsSync with a single partition, and is
+// useful if you process partitions independently (i.e., a goroutine per
+// partition) and want to commit each partition as it progresses without
+// building a full offset map. Synchronous commits are serialized: concurrent
+// calls wait for each other, so it is not recommended to call this for every
+// record in a high throughput scenario.
+func (cl *Client) CommitPartition(ctx context.Context, topic string, partition int32, offset EpochOffset) error {
+	return cl.commitOffsets(ctx, map[string]map[int32]EpochOffset{
+		topic: {partition: offset},
+	})
+}
+
 func (cl *Client) commitOffsets(ctx context.Context, offsets map[string]map[int32]EpochOffset) error {
 	var rerr error
 	cl.CommitOffsetsSync(ctx, offsets, func(_ *Client, _ *kmsg.OffsetCommitRequest, resp *kmsg.OffsetCommitResponse, err error) {
@@ -2517,6 +3078,107 @@ func (cl *Client) commitOffsets(ctx context.Context, offsets map[string]map[int3
 	return rerr
 }
 
+// DeleteGroupOffsets deletes the committed offsets for the given topics and
+// partitions in a group, returning the first error encountered. This issues
+// an OffsetDeleteRequest (Kafka 2.4+) to the group coordinator, and is useful
+// to clean up offsets for decommissioned topics that would otherwise confuse
+// lag monitoring.
+//
+// The group does not need to be the group this client is consuming, and the
+// client does not need to be consuming in a group at all. Kafka rejects the
+// deletion with GROUP_SUBSCRIBED_TO_TOPIC if the group is actively
+// subscribed to a topic. For more complete group administration, see the kadm
+// package.
+func (cl *Client) DeleteGroupOffsets(ctx context.Context, group string, topics map[string][]int32) error {
+	if len(topics) == 0 {
+		return nil
+	}
+	req := kmsg.NewPtrOffsetDeleteRequest()
+	req.Group = group
+	for topic, partitions := range topics {
+		rt := kmsg.NewOffsetDeleteRequestTopic()
+		rt.Topic = topic
+		for _, partition := range partitions {
+			rp := kmsg.NewOffsetDeleteRequestTopicPartition()
+			rp.Partition = partition
+			rt.Partitions = append(rt.Partitions, rp)
+		}
+		req.Topics = append(req.Topics, rt)
+	}
+	resp, err := req.RequestWith(ctx, cl)
+	if err != nil {
+		return err
+	}
+	if err := kerr.ErrorForCode(resp.ErrorCode); err != nil {
+		return err
+	}
+	for _, topic := range resp.Topics {
+		for _, partition := range topic.Partitions {
+			if err := kerr.ErrorForCode(partition.ErrorCode); err != nil {
+				return fmt.Errorf("unable to delete offset for topic %s partition %d: %w", topic.Topic, partition.Partition, err)
+			}
+		}
+	}
+	return nil
+}
+
+// OffsetAndMetadata is a committed offset along with the metadata string that
+// was stored with the commit.
+type OffsetAndMetadata struct {
+	EpochOffset
+	Metadata string
+}
+
+// FetchGroupOffsetsWithMetadata returns all committed offsets for a group
+// along with the metadata stored with each commit, returning the first error
+// encountered. This issues an OffsetFetchRequest to the group coordinator.
+// Partitions in the response that have no committed offset are skipped.
+//
+// The client writes its member ID as commit metadata by default, but other
+// tools may store richer metadata, such as a processing checkpoint that needs
+// to be read back on restart.
+//
+// The group does not need to be the group this client is consuming, and the
+// client does not need to be consuming in a group at all. For more complete
+// group administration, see the kadm package.
+func (cl *Client) FetchGroupOffsetsWithMetadata(ctx context.Context, group string) (map[string]map[int32]OffsetAndMetadata, error) {
+	req := kmsg.NewPtrOffsetFetchRequest()
+	req.Group = group
+	req.RequireStable = cl.cfg.requireStable
+	resp, err := req.RequestWith(ctx, cl)
+	if err != nil {
+		return nil, err
+	}
+	if err := kerr.ErrorForCode(resp.ErrorCode); err != nil {
+		return nil, err
+	}
+	offsets := make(map[string]map[int32]OffsetAndMetadata)
+	for _, topic := range resp.Topics {
+		for _, partition := range topic.Partitions {
+			if err := kerr.ErrorForCode(partition.ErrorCode); err != nil {
+				return nil, fmt.Errorf("unable to fetch offset for topic %s partition %d: %w", topic.Topic, partition.Partition, err)
+			}
+			if partition.Offset < 0 {
+				continue
+			}
+			ps := offsets[topic.Topic]
+			if ps == nil {
+				ps = make(map[int32]OffsetAndMetadata)
+				offsets[topic.Topic] = ps
+			}
+			var metadata string
+			if partition.Metadata != nil {
+				metadata = *partition.Metadata
+			}
+			ps[partition.Partition] = OffsetAndMetadata{
+				EpochOffset: EpochOffset{partition.LeaderEpoch, partition.Offset},
+				Metadata:    metadata,
+			}
+		}
+	}
+	return offsets, nil
+}
+
 // CommitOffsetsSync cancels any active CommitOffsets, begins a commit that
 // cannot be canceled, and waits for that commit to complete. This function
 // will not return until the commit is done and the onDone callback is
@@ -2549,7 +3211,7 @@ func (cl *Client) CommitOffsetsSync(
 		onDone(cl, kmsg.NewPtrOffsetCommitRequest(), kmsg.NewPtrOffsetCommitResponse(), nil)
 		return
 	}
-	g.commitOffsetsSync(ctx, uncommitted, onDone)
+	g.commitOffsetsSync(ctx, mapTopicKeys(&cl.consumer, uncommitted, cl.consumer.wireTopic), onDone)
 }
 
 // waitJoinSyncMu is a rather insane way to try to grab a lock, but also return
@@ -2712,7 +3374,7 @@ func (cl *Client) CommitOffsets(
 		g.blockAuto = false
 	}
 
-	g.commit(ctx, uncommitted, unblockAuto)
+	g.commit(ctx, mapTopicKeys(&cl.consumer, uncommitted, cl.consumer.wireTopic), unblockAuto)
 }
 
 // defaultRevoke commits the last fetched offsets and waits for the commit to
@@ -2726,7 +3388,13 @@ func (g *groupConsumer) defaultRevoke(context.Context, *Client, map[string][]int
 		// We use the client's context rather than the group context,
 		// because this could come from the group being left. The group
 		// context will already be canceled.
-		g.commitOffsetsSync(g.cl.ctx, g.getUncommitted(false), g.cfg.commitCallback)
+		ctx := g.cl.ctx
+		if g.cfg.revokeCommitTimeout > 0 {
+			var cancel func()
+			ctx, cancel = context.WithTimeout(ctx, g.cfg.revokeCommitTimeout)
+			defer cancel()
+		}
+		g.commitOffsetsSync(ctx, g.getUncommitted(false), g.cfg.commitCallback)
 	}
 }
 
@@ -2848,7 +3516,7 @@ func (g *groupConsumer) commit(
 			}
 		}
 
-		resp, err := req.RequestWith(commitCtx, g.cl)
+		resp, err := g.requestOffsetCommit(commitCtx, req)
 		if err != nil {
 			onDone(g.cl, req, nil, err)
 			return
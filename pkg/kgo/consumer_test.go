@@ -0,0 +1,900 @@
+package kgo
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+func TestSkipCorruptBatches(t *testing.T) {
+	batch := func(firstOffset int64, lastOffsetDelta int32) []byte {
+		rb := kmsg.RecordBatch{
+			FirstOffset:     firstOffset,
+			Magic:           2,
+			LastOffsetDelta: lastOffsetDelta,
+			NumRecords:      lastOffsetDelta + 1,
+			Records:         []byte{1, 2, 3},
+		}
+		b := rb.AppendTo(nil)
+		binary.BigEndian.PutUint32(b[8:], uint32(len(b)-12))
+		return b // CRC is left as zero, and thus does not match
+	}
+	in := append(batch(5, 2), batch(8, 1)...)
+
+	for _, skip := range []bool{false, true} {
+		type corrupt struct {
+			offset int64
+			err    error
+		}
+		var corrupts []corrupt
+		from := &cursor{topic: "foo"}
+		if skip {
+			from.onCorrupt = func(offset int64, err error) {
+				corrupts = append(corrupts, corrupt{offset, err})
+			}
+		}
+		o := cursorOffsetNext{cursorOffset: cursorOffset{offset: 5}, from: from}
+		rp := &kmsg.FetchResponseTopicPartition{RecordBatches: in}
+		fp := o.processRespPartition(nil, rp, 0, newDecompressor(), nil)
+
+		if !skip {
+			if fp.Err == nil {
+				t.Error("expected crc error without skipping")
+			}
+			if o.offset != 5 {
+				t.Errorf("got offset %d != exp 5 without skipping", o.offset)
+			}
+			continue
+		}
+		if fp.Err != nil {
+			t.Errorf("got unexpected error while skipping: %v", fp.Err)
+		}
+		// The last corrupt batch only advances one offset; the next
+		// fetch finds where the following batch begins.
+		if o.offset != 9 {
+			t.Errorf("got offset %d != exp 9 after skipping", o.offset)
+		}
+		if len(corrupts) != 2 || corrupts[0].offset != 5 || corrupts[1].offset != 8 {
+			t.Errorf("got corrupt batches %v != exp offsets 5 and 8", corrupts)
+		}
+	}
+
+	t.Run("corrupt_last_offset_delta", func(t *testing.T) {
+		// The corrupt batch claims to span far past the next batch;
+		// we must skip only to the next batch, not drop it.
+		in := append(batch(5, 1<<30), encodeRecordBatch(t, 8, NoCompression(), "a", "b")...)
+		var corrupts []int64
+		from := &cursor{topic: "foo", onCorrupt: func(offset int64, _ error) { corrupts = append(corrupts, offset) }}
+		o := cursorOffsetNext{cursorOffset: cursorOffset{offset: 5}, from: from}
+		rp := &kmsg.FetchResponseTopicPartition{RecordBatches: in}
+		fp := o.processRespPartition(nil, rp, 0, newDecompressor(), nil)
+
+		if fp.Err != nil {
+			t.Errorf("got unexpected error while skipping: %v", fp.Err)
+		}
+		var got []int64
+		for _, r := range fp.Records {
+			got = append(got, r.Offset)
+		}
+		if exp := []int64{8, 9}; !reflect.DeepEqual(got, exp) {
+			t.Errorf("got record offsets %v != exp %v", got, exp)
+		}
+		if o.offset != 10 {
+			t.Errorf("got offset %d != exp 10 after skipping", o.offset)
+		}
+		if exp := []int64{5}; !reflect.DeepEqual(corrupts, exp) {
+			t.Errorf("got corrupt batches %v != exp %v", corrupts, exp)
+		}
+	})
+
+	t.Run("undecompressable_message_v1", func(t *testing.T) {
+		m := kmsg.MessageV1{Offset: 7, Magic: 1, Attributes: 1, Value: []byte("not gzip")} // gzip
+		in := m.AppendTo(nil)
+		binary.BigEndian.PutUint32(in[8:], uint32(len(in)-12))
+		binary.BigEndian.PutUint32(in[12:], crc32.ChecksumIEEE(in[16:]))
+
+		for _, skip := range []bool{false, true} {
+			var corrupts []int64
+			from := &cursor{topic: "foo"}
+			if skip {
+				from.onCorrupt = func(offset int64, _ error) { corrupts = append(corrupts, offset) }
+			}
+			o := cursorOffsetNext{cursorOffset: cursorOffset{offset: 7}, from: from}
+			rp := &kmsg.FetchResponseTopicPartition{RecordBatches: in}
+			o.processRespPartition(nil, rp, 0, newDecompressor(), nil)
+
+			exp, expCorrupts := int64(7), []int64(nil)
+			if skip {
+				exp, expCorrupts = 8, []int64{7}
+			}
+			if o.offset != exp {
+				t.Errorf("skip? %v: got offset %d != exp %d", skip, o.offset, exp)
+			}
+			if !reflect.DeepEqual(corrupts, expCorrupts) {
+				t.Errorf("skip? %v: got corrupt messages %v != exp %v", skip, corrupts, expCorrupts)
+			}
+		}
+	})
+}
+
+func TestMaxPollInterval(t *testing.T) {
+	stalls := make(chan time.Duration, 10)
+	cl := newUnitClient(t,
+		ConsumeTopics("foo"),
+		MaxPollInterval(50*time.Millisecond),
+		OnPollStall(func(since time.Duration) { stalls <- since }),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cl.PollFetches(ctx)
+
+	select {
+	case since := <-stalls:
+		if since < 50*time.Millisecond {
+			t.Errorf("got stall since %v < exp 50ms", since)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stall was not detected")
+	}
+	select {
+	case <-stalls:
+		t.Error("stall fired twice without an intervening poll")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestMaxConsumeRecordBytes(t *testing.T) {
+	var skipped []int64
+	from := &cursor{
+		topic:          "foo",
+		maxRecordBytes: 3,
+		onTooLarge:     func(offset int64, _ int) { skipped = append(skipped, offset) },
+	}
+	o := cursorOffsetNext{from: from}
+	var fp FetchPartition
+	for i, v := range []string{"a", "abcd", "abc"} {
+		o.maybeKeepRecord(&fp, &Record{Offset: int64(i), Value: []byte(v)}, false)
+	}
+	if len(fp.Records) != 2 || fp.Records[0].Offset != 0 || fp.Records[1].Offset != 2 {
+		t.Errorf("got %d kept records, exp offsets 0 and 2", len(fp.Records))
+	}
+	if len(skipped) != 1 || skipped[0] != 1 {
+		t.Errorf("got skipped offsets %v != exp [1]", skipped)
+	}
+	if o.offset != 3 {
+		t.Errorf("got offset %d != exp 3", o.offset)
+	}
+}
+
+func TestConsumeLastN(t *testing.T) {
+	user := map[string]map[int32]Offset{"foo": {0: NewOffset().AtStart()}}
+	cl := newUnitClient(t,
+		ConsumePartitions(user),
+		ConsumeLastN("foo", 1, 100),
+		ConsumeLastN("bar", 0, 5),
+	)
+
+	got := cl.OptValue(ConsumePartitions).(map[string]map[int32]Offset)
+	exp := map[string]map[int32]Offset{
+		"foo": {
+			0: NewOffset().AtStart(),
+			1: NewOffset().AtEnd().Relative(-100),
+		},
+		"bar": {0: NewOffset().AtEnd().Relative(-5)},
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("got partitions %v != exp %v", got, exp)
+	}
+	if len(user["foo"]) != 1 {
+		t.Errorf("ConsumeLastN modified the ConsumePartitions input map")
+	}
+}
+
+func TestFetchesPartitions(t *testing.T) {
+	r0, r1, r2 := &Record{Offset: 0}, &Record{Offset: 1}, &Record{Offset: 2}
+	errFoo := errors.New("foo")
+	fs := Fetches{
+		{Topics: []FetchTopic{
+			{Topic: "a", Partitions: []FetchPartition{
+				{Partition: 0, HighWatermark: 2, Records: []*Record{r0}},
+				{Partition: 1, Err: errFoo},
+			}},
+		}},
+		{Topics: []FetchTopic{
+			{Topic: "a", Partitions: []FetchPartition{
+				{Partition: 0, HighWatermark: 3, Records: []*Record{r1, r2}},
+			}},
+		}},
+	}
+	exp := map[string]map[int32]FetchPartition{
+		"a": {
+			0: {Partition: 0, HighWatermark: 3, Records: []*Record{r0, r1, r2}},
+			1: {Partition: 1, Err: errFoo},
+		},
+	}
+	if got := fs.Partitions(); !reflect.DeepEqual(got, exp) {
+		t.Errorf("got %v != exp %v", got, exp)
+	}
+	if len(fs[0].Topics[0].Partitions[0].Records) != 1 {
+		t.Error("Partitions modified the original fetches")
+	}
+}
+
+func TestRegexDiscoveryInterval(t *testing.T) {
+	tt := &scriptedTransport{resp: scriptedProduce}
+	cl := newUnitClient(t,
+		WithTestTransport(tt),
+		ConsumeTopics("foo.*"),
+		ConsumeRegex(),
+		RegexDiscoveryInterval(time.Hour),
+	)
+
+	metaReqs := func() []*kmsg.MetadataRequest {
+		tt.mu.Lock()
+		defer tt.mu.Unlock()
+		var reqs []*kmsg.MetadataRequest
+		for _, req := range tt.reqs {
+			if req, ok := req.(*kmsg.MetadataRequest); ok {
+				reqs = append(reqs, req)
+			}
+		}
+		return reqs
+	}
+	waitMeta := func(n int) []*kmsg.MetadataRequest {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if reqs := metaReqs(); len(reqs) >= n {
+				return reqs
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("did not see %d metadata requests", n)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	waitMeta(1)
+	cl.ForceMetadataRefresh()
+	reqs := waitMeta(2)
+	if reqs[0].Topics != nil {
+		t.Errorf("first metadata request did not discover all topics")
+	}
+	if reqs[1].Topics == nil {
+		t.Errorf("second metadata request unexpectedly requested all topics before the discovery interval")
+	}
+}
+
+func TestConsumeExcludeTopics(t *testing.T) {
+	if _, err := NewClient(ConsumeTopics("foo"), ConsumeExcludeTopics("bar")); err == nil {
+		t.Error("expected error using ConsumeExcludeTopics without ConsumeRegex")
+	}
+
+	tt := &scriptedTransport{resp: scriptedProduce}
+	cl := newUnitClient(t,
+		WithTestTransport(tt),
+		ConsumeTopics(`events\..*`, `logs`),
+		ConsumeExcludeTopics(`events\.internal\..*`),
+		ConsumeRegex(),
+	)
+
+	keep, _ := cl.consumer.filterMetadataAllTopics([]string{
+		"events.a",
+		"events.internal.b",
+		"logs",
+		"other",
+	})
+	if exp := []string{"events.a", "logs"}; !reflect.DeepEqual(keep, exp) {
+		t.Errorf("got kept %v != exp %v", keep, exp)
+	}
+}
+
+func TestOnRegexTopicsMatched(t *testing.T) {
+	for _, excludeInternal := range []bool{true, false} {
+		t.Run(fmt.Sprintf("exclude_internal_%v", excludeInternal), func(t *testing.T) {
+			var (
+				mu     sync.Mutex
+				topics = []string{"foo", "__consumer_offsets"}
+			)
+			tt := &scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+				meta, ok := req.(*kmsg.MetadataRequest)
+				if !ok || meta.Topics != nil {
+					return scriptedProduce(req)
+				}
+				resp := meta.ResponseKind().(*kmsg.MetadataResponse)
+				b := kmsg.NewMetadataResponseBroker()
+				b.Host, b.Port = "localhost", 1
+				resp.Brokers = append(resp.Brokers, b)
+				mu.Lock()
+				defer mu.Unlock()
+				for _, topic := range topics {
+					st := kmsg.NewMetadataResponseTopic()
+					st.Topic = kmsg.StringPtr(topic)
+					st.IsInternal = strings.HasPrefix(topic, "__")
+					st.Partitions = append(st.Partitions, kmsg.NewMetadataResponseTopicPartition())
+					resp.Topics = append(resp.Topics, st)
+				}
+				return resp, nil
+			}}
+
+			type match struct{ added, removed []string }
+			matches := make(chan match, 10)
+			cl := newUnitClient(t,
+				WithTestTransport(tt),
+				ConsumeTopics(".*"),
+				ConsumeRegex(),
+				RegexExcludeInternal(excludeInternal),
+				ConsiderMissingTopicDeletedAfter(time.Millisecond),
+				MetadataMinAge(10*time.Millisecond),
+				OnRegexTopicsMatched(func(added, removed []string) {
+					matches <- match{added, removed}
+				}),
+			)
+			next := func() match {
+				t.Helper()
+				deadline := time.After(5 * time.Second)
+				for {
+					select {
+					case m := <-matches:
+						return m
+					case <-time.After(10 * time.Millisecond):
+						cl.ForceMetadataRefresh()
+					case <-deadline:
+						t.Fatal("timed out waiting for matched topics")
+					}
+				}
+			}
+
+			exp := match{added: []string{"foo"}}
+			if !excludeInternal {
+				exp.added = []string{"__consumer_offsets", "foo"}
+			}
+			if got := next(); !reflect.DeepEqual(got, exp) {
+				t.Errorf("got matched %v != exp %v", got, exp)
+			}
+
+			mu.Lock()
+			topics = topics[1:]
+			mu.Unlock()
+			if got := next(); len(got.added) != 0 || !reflect.DeepEqual(got.removed, []string{"foo"}) {
+				t.Errorf("got matched %v != exp only foo removed", got)
+			}
+		})
+	}
+}
+
+func TestConsumeRegexes(t *testing.T) {
+	events := regexp.MustCompile(`events\..*`)
+	cl := newUnitClient(t,
+		WithTestTransport(&scriptedTransport{resp: scriptedProduce}),
+		ConsumeRegexes(events, regexp.MustCompile(`^logs$`)),
+		ConsumeExcludeRegexes(regexp.MustCompile(`events\.internal\..*`)),
+	)
+	if !cl.cfg.regex {
+		t.Fatal("ConsumeRegexes did not enable regex consuming")
+	}
+	if cl.cfg.topics[events.String()] != events {
+		t.Error("ConsumeRegexes did not keep the compiled regular expression")
+	}
+
+	keep, _ := cl.consumer.filterMetadataAllTopics([]string{
+		"events.a",
+		"events.internal.b",
+		"logs",
+		"logs2",
+		"other",
+	})
+	if exp := []string{"events.a", "logs"}; !reflect.DeepEqual(keep, exp) {
+		t.Errorf("got kept %v != exp %v", keep, exp)
+	}
+
+	if _, err := NewClient(ConsumeTopics("foo"), ConsumeExcludeRegexes(events)); err == nil {
+		t.Error("expected error using ConsumeExcludeRegexes without regex consuming")
+	}
+}
+
+func TestMaxConcurrentFetchPartitions(t *testing.T) {
+	cl := newUnitClient(t, MaxConcurrentFetchPartitions(2))
+
+	s := &source{cl: cl}
+	for i := int32(0); i < 5; i++ {
+		c := &cursor{topic: "foo", partition: i, cursorsIdx: int(i)}
+		c.useState.Store(true)
+		s.cursors = append(s.cursors, c)
+	}
+
+	for _, exp := range [][]int32{{0, 1}, {2, 3}, {4, 0}} {
+		req := s.createReq()
+		var got []int32
+		for p, o := range req.usedOffsets["foo"] {
+			got = append(got, p)
+			o.from.useState.Store(true)
+		}
+		sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+		sort.Slice(exp, func(i, j int) bool { return exp[i] < exp[j] })
+		if !reflect.DeepEqual(got, exp) {
+			t.Errorf("got fetched partitions %v != exp %v", got, exp)
+		}
+	}
+
+	// The next request starts at the cursor after the last one fetched,
+	// and removing the cursor at the end wraps the start back to 0.
+	if s.cursorsStart != 1 {
+		t.Errorf("got start index %d != exp 1", s.cursorsStart)
+	}
+	s.cursorsStart = 4
+	s.removeCursor(s.cursors[4])
+	if s.cursorsStart != 0 {
+		t.Errorf("got start index %d after removing the last cursor != exp 0", s.cursorsStart)
+	}
+
+	// Unusable cursors do not count toward the limit; the start index
+	// moves past them.
+	s.cursors[0].useState.Store(false)
+	req := s.createReq()
+	if _, ok := req.usedOffsets["foo"][0]; ok || len(req.usedOffsets["foo"]) != 2 {
+		t.Errorf("got fetched partitions %v, exp 1 and 2", req.usedOffsets["foo"])
+	}
+	if s.cursorsStart != 3 {
+		t.Errorf("got start index %d != exp 3", s.cursorsStart)
+	}
+
+	if !cl.newSource(1).session.killed {
+		t.Error("expected fetch sessions to be disabled when limiting partitions per request")
+	}
+}
+
+func TestMaxBufferedFetchBytesDrainWakesAll(t *testing.T) {
+	cl := newUnitClient(t, MaxBufferedFetchBytes(10))
+	c := &cl.consumer
+	c.bufferedBytes.Store(20)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &consumerSession{
+		c:             c,
+		ctx:           ctx,
+		cancel:        cancel,
+		desireFetchCh: make(chan chan chan struct{}),
+		cancelFetchCh: make(chan chan chan struct{}, 4),
+	}
+
+	// Two sources want to fetch while the buffer is full.
+	wants := []chan chan struct{}{make(chan chan struct{}, 1), make(chan chan struct{}, 1)}
+	for _, want := range wants {
+		s.desireFetch() <- want
+	}
+	select {
+	case <-wants[0]:
+		t.Fatal("fetch allowed while over MaxBufferedFetchBytes")
+	case <-wants[1]:
+		t.Fatal("fetch allowed while over MaxBufferedFetchBytes")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// One drain must allow both to fetch.
+	c.bufferedBytes.Store(0)
+	c.signalBufferDrained()
+	var dones []chan struct{}
+	for i, want := range wants {
+		select {
+		case done := <-want:
+			dones = append(dones, done)
+		case <-time.After(time.Second):
+			t.Fatalf("source %d was not allowed to fetch after the buffer drained", i)
+		}
+	}
+
+	cancel()
+	for _, done := range dones {
+		done <- struct{}{}
+	}
+}
+
+func TestFairPollAcrossPartitions(t *testing.T) {
+	// bufferSource buffers a fetch of two partitions with three records
+	// each, as if a fetch response were just processed.
+	bufferSource := func(cl *Client) {
+		s := cl.newSource(1)
+		f := Fetch{Topics: []FetchTopic{{Topic: "foo"}}}
+		used := usedOffsets{"foo": make(map[int32]*cursorOffsetNext)}
+		for p := int32(0); p < 2; p++ {
+			var rs []*Record
+			for o := int64(0); o < 3; o++ {
+				rs = append(rs, &Record{Topic: "foo", Partition: p, Offset: o})
+			}
+			f.Topics[0].Partitions = append(f.Topics[0].Partitions, FetchPartition{Partition: p, Records: rs})
+			used["foo"][p] = &cursorOffsetNext{
+				cursorOffset: cursorOffset{offset: 3},
+				from:         &cursor{topic: "foo", partition: p, source: s},
+			}
+		}
+		s.buffered = bufferedFetch{fetch: f, doneFetch: make(chan struct{}, 1), usedOffsets: used}
+		s.sem = make(chan struct{}) // closed when the buffered fetch is taken
+		cl.consumer.addSourceReadyForDraining(s)
+	}
+
+	type polled struct {
+		partition int32
+		offset    int64
+	}
+	for _, test := range []struct {
+		fair bool
+		exp  [][]polled
+	}{
+		{
+			fair: false, // drains a partition before moving on
+			exp: [][]polled{
+				{{0, 0}, {0, 1}},
+				{{0, 2}, {1, 0}},
+				{{1, 1}, {1, 2}},
+			},
+		},
+		{
+			fair: true, // interleaves partitions
+			exp: [][]polled{
+				{{0, 0}, {1, 0}},
+				{{0, 1}, {1, 1}},
+				{{0, 2}, {1, 2}},
+			},
+		},
+	} {
+		t.Run(fmt.Sprintf("fair_%v", test.fair), func(t *testing.T) {
+			opts := []Opt{}
+			if test.fair {
+				opts = append(opts, FairPollAcrossPartitions(true))
+			}
+			cl := newUnitClient(t, opts...)
+			bufferSource(cl)
+
+			for i, exp := range test.exp {
+				var got []polled
+				cl.PollRecords(nil, 2).EachRecord(func(r *Record) {
+					got = append(got, polled{r.Partition, r.Offset})
+				})
+				if !reflect.DeepEqual(got, exp) {
+					t.Errorf("poll %d: got %v != exp %v", i, got, exp)
+				}
+			}
+			if fs := cl.PollRecords(nil, 2); fs.NumRecords() != 0 {
+				t.Errorf("got %d records after draining, exp 0", fs.NumRecords())
+			}
+		})
+	}
+}
+
+func TestFetchDecompressWorkers(t *testing.T) {
+	const partitions = 8
+	newReqResp := func() (*fetchRequest, *kmsg.FetchResponse) {
+		req := &fetchRequest{usedOffsets: usedOffsets{"foo": make(map[int32]*cursorOffsetNext)}}
+		resp := kmsg.NewPtrFetchResponse()
+		resp.Version = 12
+		rt := kmsg.NewFetchResponseTopic()
+		rt.Topic = "foo"
+		for p := int32(0); p < partitions; p++ {
+			req.usedOffsets["foo"][p] = &cursorOffsetNext{from: &cursor{topic: "foo", partition: p}}
+			rp := kmsg.NewFetchResponseTopicPartition()
+			rp.Partition = p
+			rp.HighWatermark = 6
+			for first := int64(0); first < 6; first += 3 {
+				var vs []string
+				for o := first; o < first+3; o++ {
+					vs = append(vs, fmt.Sprintf("%d-%d", p, o))
+				}
+				rp.RecordBatches = append(rp.RecordBatches, encodeRecordBatch(t, first, ZstdCompression(), vs...)...)
+			}
+			rt.Partitions = append(rt.Partitions, rp)
+		}
+		resp.Topics = append(resp.Topics, rt)
+		return req, resp
+	}
+
+	cl := newUnitClient(t)
+	req, resp := newReqResp()
+	if processed := cl.newSource(1).processRespPartitionsConcurrently(nil, req, resp); processed != nil {
+		t.Error("unexpectedly processed partitions concurrently with one worker")
+	}
+
+	cl = newUnitClient(t, FetchDecompressWorkers(4))
+	req, resp = newReqResp()
+	processed := cl.newSource(1).processRespPartitionsConcurrently(nil, req, resp)
+	if len(processed) != partitions {
+		t.Fatalf("got %d processed partitions != exp %d", len(processed), partitions)
+	}
+	for i := range resp.Topics[0].Partitions {
+		rp := &resp.Topics[0].Partitions[i]
+		fp, ok := processed[rp]
+		if !ok || fp.Err != nil || fp.Partition != rp.Partition {
+			t.Fatalf("partition %d: got %v, %v", rp.Partition, fp, ok)
+		}
+		var got []string
+		for _, r := range fp.Records {
+			got = append(got, string(r.Value))
+		}
+		var exp []string
+		for o := 0; o < 6; o++ {
+			exp = append(exp, fmt.Sprintf("%d-%d", rp.Partition, o))
+		}
+		if !reflect.DeepEqual(got, exp) {
+			t.Errorf("partition %d: got records %v != exp %v", rp.Partition, got, exp)
+		}
+		if next := req.usedOffsets["foo"][rp.Partition].offset; next != 6 {
+			t.Errorf("partition %d: got next offset %d != exp 6", rp.Partition, next)
+		}
+	}
+}
+
+func TestOnPartitionEOF(t *testing.T) {
+	var eofs []int64
+	fn := func(topic string, partition int32, offset int64) {
+		if topic != "foo" || partition != 1 {
+			t.Errorf("got EOF for %s[%d], exp foo[1]", topic, partition)
+		}
+		eofs = append(eofs, offset)
+	}
+	c := &newUnitClient(t, OnPartitionEOF(fn)).consumer
+	o := cursorOffsetNext{cursorOffset: cursorOffset{offset: 10}, from: &cursor{topic: "foo", partition: 1}}
+
+	o.maybeEOF(c, &FetchPartition{HighWatermark: 12}, 0) // behind
+	o.maybeEOF(c, &FetchPartition{HighWatermark: 10}, 0) // caught up
+	o.maybeEOF(c, &FetchPartition{HighWatermark: 10}, 0) // still caught up, not called again
+	o.maybeEOF(c, &FetchPartition{Records: []*Record{{}}}, 0)
+	o.maybeEOF(c, &FetchPartition{HighWatermark: 12, LastStableOffset: 10}, 1) // caught up to the LSO
+
+	if exp := []int64{10, 10}; !reflect.DeepEqual(eofs, exp) {
+		t.Errorf("got EOF offsets %v != exp %v", eofs, exp)
+	}
+}
+
+func TestSetIsolationLevel(t *testing.T) {
+	cl := newUnitClient(t, FetchIsolationLevel(ReadCommitted()))
+	s := cl.newSource(1)
+
+	check := func(exp int8) {
+		t.Helper()
+		if got := s.createReq().isolationLevel; got != exp {
+			t.Errorf("got fetch isolation level %d != exp %d", got, exp)
+		}
+		if got := cl.OptValue(FetchIsolationLevel); got != exp {
+			t.Errorf("got opt isolation level %v != exp %d", got, exp)
+		}
+	}
+	check(1)
+	cl.SetIsolationLevel(ReadUncommitted())
+	check(0)
+	cl.SetIsolationLevel(ReadCommitted())
+	check(1)
+
+	// The level can be read while it is being set; this is checked by
+	// the race detector.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		cl.SetIsolationLevel(ReadCommitted())
+	}()
+	cl.OptValue(FetchIsolationLevel)
+	wg.Wait()
+
+	// An aborted transactional batch is only dropped when processed with
+	// the level of the request that fetched it.
+	in := markTransactional(encodeRecordBatch(t, 0, NoCompression(), "a", "b"))
+	abort := kmsg.NewFetchResponseTopicPartitionAbortedTransaction()
+	rp := &kmsg.FetchResponseTopicPartition{
+		RecordBatches:       in,
+		AbortedTransactions: []kmsg.FetchResponseTopicPartitionAbortedTransaction{abort},
+	}
+	for _, level := range []int8{0, 1} {
+		o := cursorOffsetNext{from: &cursor{topic: "foo"}}
+		fp := o.processRespPartition(nil, rp, level, newDecompressor(), nil)
+		if fp.Err != nil {
+			t.Fatalf("level %d: unexpected err: %v", level, fp.Err)
+		}
+		exp := 2
+		if level == 1 {
+			exp = 0
+		}
+		if len(fp.Records) != exp {
+			t.Errorf("level %d: got %d records != exp %d", level, len(fp.Records), exp)
+		}
+		if o.offset != 2 {
+			t.Errorf("level %d: got next offset %d != exp 2", level, o.offset)
+		}
+	}
+}
+
+func TestIncludeAbortedRecords(t *testing.T) {
+	in := markTransactional(encodeRecordBatch(t, 0, NoCompression(), "a", "b"))
+	abort := kmsg.NewFetchResponseTopicPartitionAbortedTransaction()
+	rp := &kmsg.FetchResponseTopicPartition{
+		RecordBatches:       in,
+		AbortedTransactions: []kmsg.FetchResponseTopicPartitionAbortedTransaction{abort},
+	}
+
+	for _, test := range []struct {
+		level      int8
+		include    bool
+		expRecs    int
+		expAborted bool
+	}{
+		{1, false, 0, false},
+		{1, true, 2, true},
+		{0, true, 2, false}, // read uncommitted does not know what was aborted
+	} {
+		o := cursorOffsetNext{from: &cursor{topic: "foo", includeAborted: test.include}}
+		fp := o.processRespPartition(nil, rp, test.level, newDecompressor(), nil)
+		if fp.Err != nil {
+			t.Fatalf("level %d include %v: unexpected err: %v", test.level, test.include, fp.Err)
+		}
+		if len(fp.Records) != test.expRecs {
+			t.Errorf("level %d include %v: got %d records != exp %d", test.level, test.include, len(fp.Records), test.expRecs)
+		}
+		for _, r := range fp.Records {
+			if r.Aborted != test.expAborted {
+				t.Errorf("level %d include %v: got record aborted %v != exp %v", test.level, test.include, r.Aborted, test.expAborted)
+			}
+		}
+		if o.offset != 2 {
+			t.Errorf("level %d include %v: got next offset %d != exp 2", test.level, test.include, o.offset)
+		}
+	}
+
+	// The option is threaded to cursors through the partition.
+	cl := newUnitClient(t, IncludeAbortedRecords(true))
+	if tp := (metadataPartition{}).newPartition(cl, false); !tp.cursor.includeAborted {
+		t.Error("expected the partition cursor to include aborted records")
+	}
+}
+
+func TestResetFetchSessions(t *testing.T) {
+	for _, disable := range []bool{false, true} {
+		t.Run(fmt.Sprintf("disabled_%v", disable), func(t *testing.T) {
+			var opts []Opt
+			if disable {
+				opts = append(opts, DisableFetchSessions())
+			}
+			cl := newUnitClient(t, opts...)
+
+			var sources []*source
+			cl.sinksAndSourcesMu.Lock()
+			for node := int32(0); node < 2; node++ {
+				s := cl.newSource(node)
+				if !disable {
+					s.session.id, s.session.epoch = 5, 3
+					s.session.used = map[string]map[int32]fetchSessionOffsetEpoch{"foo": {0: {}}}
+				}
+				sources = append(sources, s)
+				cl.sinksAndSources[node] = sinkAndSource{source: s}
+			}
+			cl.sinksAndSourcesMu.Unlock()
+			defer func() { // our sources have no sinks to close
+				cl.sinksAndSourcesMu.Lock()
+				defer cl.sinksAndSourcesMu.Unlock()
+				clear(cl.sinksAndSources)
+			}()
+
+			cl.ResetFetchSessions()
+			for _, s := range sources {
+				if !disable && s.session.epoch != 3 {
+					t.Errorf("source %d: session reset before the next request was created", s.nodeID)
+				}
+				s.createReq()
+				switch {
+				case disable && !s.session.killed:
+					t.Errorf("source %d: resetting revived a disabled session", s.nodeID)
+				case !disable && (s.session.id != 5 || s.session.epoch != 0 || s.session.used != nil):
+					t.Errorf("source %d: got session id %d epoch %d used %v, exp id 5 epoch 0 and nothing used", s.nodeID, s.session.id, s.session.epoch, s.session.used)
+				}
+				if s.resetSession.Load() {
+					t.Errorf("source %d: reset was not consumed by creating a request", s.nodeID)
+				}
+			}
+		})
+	}
+}
+
+func TestFetchPlan(t *testing.T) {
+	cl := newUnitClient(t, TopicNameMapper(nil, func(s string) string { return "tenant." + s }))
+	foo, bar := cl.consumer.wireTopic("foo"), cl.consumer.wireTopic("bar")
+
+	cl.sinksAndSourcesMu.Lock()
+	for node, cursors := range map[int32][]*cursor{
+		0: {{topic: foo, partition: 0}, {topic: foo, partition: 2}, {topic: bar, partition: 0}},
+		1: {{topic: foo, partition: 1}},
+		2: nil, // a broker we fetch nothing from is not in the plan
+	} {
+		s := cl.newSource(node)
+		s.cursors = cursors
+		cl.sinksAndSources[node] = sinkAndSource{source: s}
+	}
+	cl.sinksAndSourcesMu.Unlock()
+	defer func() { // our sources have no sinks to close
+		cl.sinksAndSourcesMu.Lock()
+		defer cl.sinksAndSourcesMu.Unlock()
+		clear(cl.sinksAndSources)
+	}()
+
+	exp := map[int32]map[string][]int32{
+		0: {"foo": {0, 2}, "bar": {0}},
+		1: {"foo": {1}},
+	}
+	if got := cl.FetchPlan(); !reflect.DeepEqual(got, exp) {
+		t.Errorf("got fetch plan %v != exp %v", got, exp)
+	}
+}
+
+func TestOnOffsetOutOfRange(t *testing.T) {
+	errNoReset := errors.New("no reset")
+	for _, test := range []struct {
+		name   string
+		reset  Offset
+		err    error
+		expErr error
+	}{
+		{"reset", NewOffset().At(5), nil, nil},
+		{"error", Offset{}, errNoReset, errNoReset},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var calls []string
+			cl := newUnitClient(t,
+				TopicNameMapper(nil, func(s string) string { return "tenant." + s }),
+				OnOffsetOutOfRange(func(topic string, partition int32, requested int64) (Offset, error) {
+					calls = append(calls, fmt.Sprintf("%s[%d]@%d", topic, partition, requested))
+					return test.reset, test.err
+				}),
+			)
+			wire := cl.consumer.wireTopic("foo")
+
+			s := cl.newSource(1)
+			req := &fetchRequest{usedOffsets: usedOffsets{wire: {0: &cursorOffsetNext{
+				cursorOffset: cursorOffset{offset: 100},
+				from:         &cursor{topic: wire, topicPartitionData: topicPartitionData{leader: 1}},
+			}}}}
+			resp := kmsg.NewPtrFetchResponse()
+			resp.Version = 12
+			rt := kmsg.NewFetchResponseTopic()
+			rt.Topic = wire
+			rp := kmsg.NewFetchResponseTopicPartition()
+			rp.ErrorCode = kerr.OffsetOutOfRange.Code
+			rt.Partitions = append(rt.Partitions, rp)
+			resp.Topics = append(resp.Topics, rt)
+
+			f, reloads, _, _, _ := s.handleReqResp(nil, req, resp)
+			if exp := []string{"foo[0]@100"}; !reflect.DeepEqual(calls, exp) {
+				t.Errorf("got calls %v != exp %v", calls, exp)
+			}
+
+			load, reloaded := reloads.List[wire][0]
+			var gotErr error
+			if len(f.Topics) == 1 && len(f.Topics[0].Partitions) == 1 {
+				gotErr = f.Topics[0].Partitions[0].Err
+			}
+			if test.expErr != nil {
+				if reloaded {
+					t.Error("partition was reset even though OnOffsetOutOfRange errored")
+				}
+				if !errors.Is(gotErr, test.expErr) {
+					t.Errorf("got partition err %v != exp %v", gotErr, test.expErr)
+				}
+				return
+			}
+			if !reloaded || load.Offset != test.reset || load.replica != -1 {
+				t.Errorf("got reload %v (reloaded? %v), exp a list from the leader of %v", load, reloaded, test.reset)
+			}
+			if gotErr != nil {
+				t.Errorf("got unexpected partition err %v", gotErr)
+			}
+		})
+	}
+}
@@ -204,6 +204,48 @@ var (
 	//
 	// For any request, the request is failed with this error.
 	ErrClientClosed = errors.New("client closed")
+
+	// ErrRecordExported is passed to produce promises for records that
+	// were removed from the client with ExportBufferedRecords.
+	ErrRecordExported = errors.New("record was exported from the client before being produced")
+
+	// ErrRecordAborted is passed to the produce promise of a record that
+	// was removed from the client with AbortRecord.
+	ErrRecordAborted = errors.New("record was aborted before being produced")
+
+	// ErrNotConnected is passed to produce promises when using
+	// ProduceRequireConnection and the client is not connected to any
+	// broker.
+	ErrNotConnected = errors.New("client is not connected to any broker")
+
+	// ErrDuplicateProduce is passed to ProduceOnce promises if a record with
+	// the same idempotency key was produced within the deduplication window.
+	ErrDuplicateProduce = errors.New("record with the same idempotency key was recently produced")
+
+	// ErrConcurrentTransactionsTimeout is returned from transactional
+	// requests (AddPartitionsToTxn, AddOffsetsToTxn, EndTxn) if retrying
+	// CONCURRENT_TRANSACTIONS errors would exceed the request's
+	// RetryTimeout. This means the transaction coordinator was still
+	// finalizing a prior transaction, and it may be worth backing off
+	// longer before retrying the transaction. The returned error also
+	// wraps kerr.ConcurrentTransactions. If the request context is canceled
+	// or the client is closed while retrying, the context error or
+	// ErrClientClosed is returned instead.
+	ErrConcurrentTransactionsTimeout = errors.New("timed out retrying CONCURRENT_TRANSACTIONS while the coordinator finalizes a prior transaction")
+
+	// ErrProducerEpochMismatch is passed to ProduceIfEpoch promises if the
+	// client's current producer epoch is not the expected epoch.
+	ErrProducerEpochMismatch = errors.New("producer epoch does not match the expected epoch")
+
+	// ErrForcedHeartbeatTimeout is returned from GroupTransactSession.End
+	// if the heartbeat forced before ending the transaction does not
+	// complete within the rebalance timeout. The transaction is aborted.
+	ErrForcedHeartbeatTimeout = errors.New("forced heartbeat did not complete within the rebalance timeout; the transaction was aborted")
+
+	// ErrIdempotenceUnsupported is returned from NewClient when using the
+	// RequireIdempotentWriteSupport option if idempotency is enabled but
+	// the broker does not support InitProducerID (Kafka < 0.11).
+	ErrIdempotenceUnsupported = errors.New("idempotent write is enabled but the broker does not support InitProducerID; disable idempotent writes or upgrade to Kafka 0.11+")
 )
 
 // ErrFirstReadEOF is returned for responses that immediately error with
@@ -3,11 +3,20 @@ package kgo
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"reflect"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kmsg"
 )
 
 // TestGroupETL tests:
@@ -241,3 +250,889 @@ func (c *testConsumer) etl(etlsBeforeQuit int) {
 		}
 	}
 }
+
+func TestImportGroupMemberState(t *testing.T) {
+	cl := newUnitClient(t,
+		ConsumerGroup("g"),
+		ConsumeTopics("foo"),
+		ImportGroupMemberState(GroupMemberState{
+			Generation: 7,
+			Assigned:   map[string][]int32{"foo": {0, 2}},
+		}),
+	)
+
+	protos := cl.consumer.g.joinGroupProtocols()
+	if len(protos) == 0 {
+		t.Fatal("no join group protocols")
+	}
+	var meta kmsg.ConsumerMemberMetadata
+	if err := meta.ReadFrom(protos[0].Metadata); err != nil {
+		t.Fatal(err)
+	}
+	if meta.Generation != 7 {
+		t.Errorf("got generation %d != exp 7", meta.Generation)
+	}
+	if len(meta.OwnedPartitions) != 1 || meta.OwnedPartitions[0].Topic != "foo" || !reflect.DeepEqual(meta.OwnedPartitions[0].Partitions, []int32{0, 2}) {
+		t.Errorf("got owned partitions %v != exp foo[0 2]", meta.OwnedPartitions)
+	}
+
+	if state := cl.ExportGroupMemberState(); len(state.Assigned) != 0 {
+		t.Errorf("got exported assignment %v != exp empty before joining", state.Assigned)
+	}
+}
+
+func TestRebalanceReasonString(t *testing.T) {
+	for _, test := range []struct {
+		r   RebalanceReason
+		exp string
+	}{
+		{RebalanceReasonUnknown, "unknown"},
+		{RebalanceReasonInitialJoin, "initial join"},
+		{RebalanceReasonGroup, "group rebalance"},
+		{RebalanceReasonMetadataChange, "metadata change"},
+		{RebalanceReasonCooperative, "cooperative rejoin"},
+		{RebalanceReasonUser, "user requested"},
+		{RebalanceReasonError, "session error"},
+		{RebalanceReason(100), "unknown"},
+	} {
+		if got := test.r.String(); got != test.exp {
+			t.Errorf("%d: got %q != exp %q", test.r, got, test.exp)
+		}
+	}
+}
+
+func TestCommittedLagNotGroup(t *testing.T) {
+	cl := newUnitClient(t, WithTestTransport(&scriptedTransport{resp: scriptedProduce}))
+	if _, err := cl.CommittedLag(context.Background()); err != errNotGroup {
+		t.Errorf("got err %v != exp %v", err, errNotGroup)
+	}
+}
+
+func TestGroupLag(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		errCode int16
+		reqs    []*kmsg.ListOffsetsRequest
+	)
+	tt := &scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+		switch req := req.(type) {
+		case *kmsg.MetadataRequest:
+			resp := req.ResponseKind().(*kmsg.MetadataResponse)
+			b := kmsg.NewMetadataResponseBroker()
+			b.Host, b.Port = "localhost", 1
+			resp.Brokers = append(resp.Brokers, b)
+			for _, rt := range req.Topics {
+				st := kmsg.NewMetadataResponseTopic()
+				st.Topic = rt.Topic
+				for p := int32(0); p < 4; p++ {
+					sp := kmsg.NewMetadataResponseTopicPartition()
+					sp.Partition = p
+					st.Partitions = append(st.Partitions, sp)
+				}
+				resp.Topics = append(resp.Topics, st)
+			}
+			return resp, nil
+
+		case *kmsg.ListOffsetsRequest:
+			mu.Lock()
+			defer mu.Unlock()
+			reqs = append(reqs, req)
+			resp := req.ResponseKind().(*kmsg.ListOffsetsResponse)
+			for _, rt := range req.Topics {
+				st := kmsg.NewListOffsetsResponseTopic()
+				st.Topic = rt.Topic
+				for _, rp := range rt.Partitions {
+					sp := kmsg.NewListOffsetsResponseTopicPartition()
+					sp.Partition = rp.Partition
+					sp.Offset = 20 // high watermark
+					if req.IsolationLevel == 1 {
+						sp.Offset = 15 // last stable offset
+					}
+					if rp.Partition == 0 {
+						sp.ErrorCode = errCode
+					}
+					st.Partitions = append(st.Partitions, sp)
+				}
+				resp.Topics = append(resp.Topics, st)
+			}
+			return resp, nil
+		}
+		return nil, fmt.Errorf("unexpected request %T", req)
+	}}
+	cl := newUnitClient(t,
+		WithTestTransport(tt),
+		TopicNameMapper(nil, func(s string) string { return "tenant." + s }),
+	)
+	wire := cl.consumer.wireTopic("foo")
+
+	g := &groupConsumer{cl: cl, cfg: &cl.cfg}
+	g.nowAssigned.store(map[string][]int32{wire: {0, 1, 2, 3}})
+	g.uncommitted = uncommitted{wire: {
+		0: {dirty: EpochOffset{-1, 10}, committed: EpochOffset{-1, 5}},
+		1: {dirty: EpochOffset{-1, 25}, committed: EpochOffset{-1, 18}}, // polled past the end
+		2: {dirty: EpochOffset{-1, -1}, committed: EpochOffset{-1, -1}}, // not yet polled or committed
+		// 3 has no uncommitted offsets
+	}}
+	cl.consumer.g = g
+	defer func() { cl.consumer.g = nil }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, test := range []struct {
+		name  string
+		level IsolationLevel
+		lag   func(context.Context) (map[string]map[int32]int64, error)
+		exp   map[string]map[int32]int64
+	}{
+		{"group_lag_read_uncommitted", ReadUncommitted(), cl.GroupLag, map[string]map[int32]int64{"foo": {0: 10, 1: 0}}},
+		{"group_lag_read_committed", ReadCommitted(), cl.GroupLag, map[string]map[int32]int64{"foo": {0: 5, 1: 0}}},
+		{"committed_lag", ReadUncommitted(), cl.CommittedLag, map[string]map[int32]int64{"foo": {0: 15, 1: 2}}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			mu.Lock()
+			reqs = nil
+			mu.Unlock()
+			cl.SetIsolationLevel(test.level)
+
+			lag, err := test.lag(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(lag, test.exp) {
+				t.Errorf("got lag %v != exp %v", lag, test.exp)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(reqs) != 1 {
+				t.Fatalf("got %d list offsets requests != exp 1", len(reqs))
+			}
+			req := reqs[0]
+			if req.IsolationLevel != test.level.level || len(req.Topics) != 1 || req.Topics[0].Topic != wire {
+				t.Fatalf("got isolation level %d and topics %v, exp level %d and only %s", req.IsolationLevel, req.Topics, test.level.level, wire)
+			}
+			var listed []int32
+			for _, rp := range req.Topics[0].Partitions {
+				if rp.Timestamp != -1 {
+					t.Errorf("partition %d: got timestamp %d != exp -1", rp.Partition, rp.Timestamp)
+				}
+				listed = append(listed, rp.Partition)
+			}
+			sort.Slice(listed, func(i, j int) bool { return listed[i] < listed[j] })
+			if exp := []int32{0, 1}; !reflect.DeepEqual(listed, exp) {
+				t.Errorf("got listed partitions %v != exp %v", listed, exp)
+			}
+		})
+	}
+
+	errCode = kerr.TopicAuthorizationFailed.Code
+	lag, err := cl.GroupLag(ctx)
+	if !errors.Is(err, kerr.TopicAuthorizationFailed) || !strings.Contains(err.Error(), "partition 0") || lag != nil {
+		t.Errorf("got lag %v and err %v, exp TopicAuthorizationFailed for partition 0", lag, err)
+	}
+}
+
+type mapOffsetStore struct {
+	offsets map[string]map[int32]EpochOffset
+}
+
+func (s *mapOffsetStore) Fetch(_ context.Context, _ string, partitions map[string][]int32) (map[string]map[int32]EpochOffset, error) {
+	fetched := make(map[string]map[int32]EpochOffset)
+	for t, ps := range partitions {
+		for _, p := range ps {
+			if eo, ok := s.offsets[t][p]; ok {
+				if fetched[t] == nil {
+					fetched[t] = make(map[int32]EpochOffset)
+				}
+				fetched[t][p] = eo
+			}
+		}
+	}
+	return fetched, nil
+}
+
+func (s *mapOffsetStore) Commit(_ context.Context, group string, offsets map[string]map[int32]EpochOffset) error {
+	if group != "g" {
+		return errors.New("unexpected group")
+	}
+	for t, ps := range offsets {
+		if s.offsets[t] == nil {
+			s.offsets[t] = make(map[int32]EpochOffset)
+		}
+		for p, eo := range ps {
+			s.offsets[t][p] = eo
+		}
+	}
+	return nil
+}
+
+func TestOffsetStore(t *testing.T) {
+	if _, err := NewClient(WithOffsetStore(new(mapOffsetStore)), TransactionalID("txn")); err == nil {
+		t.Error("expected error using WithOffsetStore with TransactionalID")
+	}
+
+	store := &mapOffsetStore{offsets: make(map[string]map[int32]EpochOffset)}
+	cfg := defaultCfg()
+	cfg.offsetStore = store
+	g := &groupConsumer{cfg: &cfg}
+	ctx := context.Background()
+
+	commit := kmsg.NewPtrOffsetCommitRequest()
+	commit.Group = "g"
+	ct := kmsg.NewOffsetCommitRequestTopic()
+	ct.Topic = "foo"
+	cp := kmsg.NewOffsetCommitRequestTopicPartition()
+	cp.Partition, cp.Offset, cp.LeaderEpoch = 1, 10, 2
+	ct.Partitions = append(ct.Partitions, cp)
+	commit.Topics = append(commit.Topics, ct)
+
+	commitResp, err := g.requestOffsetCommit(ctx, commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commitResp.Topics) != 1 || len(commitResp.Topics[0].Partitions) != 1 || commitResp.Topics[0].Partitions[0].Partition != 1 {
+		t.Errorf("unexpected commit response %v", commitResp.Topics)
+	}
+	if exp := map[string]map[int32]EpochOffset{"foo": {1: {2, 10}}}; !reflect.DeepEqual(store.offsets, exp) {
+		t.Errorf("got stored %v != exp %v", store.offsets, exp)
+	}
+
+	fetch := kmsg.NewPtrOffsetFetchRequest()
+	fetch.Group = "g"
+	ft := kmsg.NewOffsetFetchRequestTopic()
+	ft.Topic, ft.Partitions = "foo", []int32{1, 2}
+	fetch.Topics = append(fetch.Topics, ft)
+
+	fetchResp, err := g.requestOffsetFetch(ctx, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[int32]EpochOffset)
+	for _, rt := range fetchResp.Topics {
+		for _, rp := range rt.Partitions {
+			got[rp.Partition] = EpochOffset{rp.LeaderEpoch, rp.Offset}
+		}
+	}
+	if exp := map[int32]EpochOffset{1: {2, 10}, 2: {-1, -1}}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("got fetched %v != exp %v", got, exp)
+	}
+}
+
+func TestWaitCommitted(t *testing.T) {
+	cl := newUnitClient(t, WithTestTransport(&scriptedTransport{resp: scriptedProduce}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := cl.WaitCommitted(ctx, "foo", 0, 10); err != errNotGroup {
+		t.Fatalf("got err %v != exp %v", err, errNotGroup)
+	}
+
+	g := &groupConsumer{cl: cl, cfg: &cl.cfg}
+	cl.consumer.g = g
+	defer func() { cl.consumer.g = nil }()
+
+	setCommitted := func(offset int64) {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		g.uncommitted = uncommitted{"foo": {0: {committed: EpochOffset{-1, offset}}}}
+		g.lockedNotifyCommitted()
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cl.WaitCommitted(ctx, "foo", 0, 10) }()
+
+	setCommitted(5)
+	select {
+	case err := <-done:
+		t.Fatalf("WaitCommitted returned early with err %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	setCommitted(10)
+	if err := <-done; err != nil {
+		t.Errorf("unexpected WaitCommitted err: %v", err)
+	}
+
+	short, shortCancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer shortCancel()
+	if err := cl.WaitCommitted(short, "foo", 0, 11); err != context.DeadlineExceeded {
+		t.Errorf("got err %v != exp %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestCommitInFlight(t *testing.T) {
+	cl := newUnitClient(t)
+
+	if cl.CommitInFlight() {
+		t.Error("commit in flight without a group")
+	}
+
+	g := &groupConsumer{cl: cl, cfg: &cl.cfg}
+	cl.consumer.g = g
+	defer func() { cl.consumer.g = nil }()
+
+	if cl.CommitInFlight() {
+		t.Error("commit in flight before any commit")
+	}
+	done := make(chan struct{})
+	g.mu.Lock()
+	g.commitDone = done
+	g.mu.Unlock()
+	if !cl.CommitInFlight() {
+		t.Error("commit not in flight while commit is running")
+	}
+	close(done)
+	if cl.CommitInFlight() {
+		t.Error("commit in flight after commit is done")
+	}
+}
+
+func TestRequestRejoin(t *testing.T) {
+	cl := newUnitClient(t)
+	cl.RequestRejoin("no group") // no-op without a group
+
+	g := &groupConsumer{cl: cl, cfg: &cl.cfg, rejoinCh: make(chan rebalanceWhy, 1)}
+	cl.consumer.g = g
+	defer func() { cl.consumer.g = nil }()
+
+	cl.RequestRejoin("config changed")
+	cl.ForceRebalance() // dropped: a rejoin is already pending
+	if got, exp := <-g.rejoinCh, (rebalanceWhy{RebalanceReasonUser, "config changed"}); got != exp {
+		t.Errorf("got rejoin %v != exp %v", got, exp)
+	}
+
+	cl.ForceRebalance()
+	if got, exp := <-g.rejoinCh, (rebalanceWhy{RebalanceReasonUser, "rejoin from ForceRebalance"}); got != exp {
+		t.Errorf("got rejoin %v != exp %v", got, exp)
+	}
+}
+
+func TestGroupState(t *testing.T) {
+	cl := newUnitClient(t)
+	if state := cl.GroupState(); state != (GroupState{CoordinatorID: -1}) {
+		t.Errorf("got state %+v without a group, exp the zero state", state)
+	}
+
+	g := &groupConsumer{cl: cl, cfg: &cl.cfg}
+	cl.consumer.g = g
+	defer func() { cl.consumer.g = nil }()
+
+	now := time.Unix(1700000000, 0)
+	g.lastHeartbeat.Store(now.UnixNano())
+	g.rebalancing.Store(true)
+	loaded := &coordinatorLoad{loadWait: make(chan struct{}), node: 3}
+	close(loaded.loadWait)
+	cl.coordinatorsMu.Lock()
+	cl.coordinators[coordinatorKey{g.cfg.group, coordinatorTypeGroup}] = loaded
+	cl.coordinatorsMu.Unlock()
+
+	state := cl.GroupState()
+	if !state.LastHeartbeat.Equal(now) || state.CoordinatorID != 3 || !state.RebalanceInProgress {
+		t.Errorf("got state %+v, exp heartbeat at %v, coordinator 3, and rebalancing", state, now)
+	}
+}
+
+// injectRecords makes the next poll return records for offsets [start, end)
+// of the given topic partition, returning the injected fetch.
+func injectRecords(cl *Client, topic string, partition int32, start, end int64) Fetch {
+	var rs []*Record
+	for o := start; o < end; o++ {
+		rs = append(rs, &Record{Topic: topic, Partition: partition, Offset: o})
+	}
+	f := Fetch{Topics: []FetchTopic{{
+		Topic:      topic,
+		Partitions: []FetchPartition{{Partition: partition, Records: rs}},
+	}}}
+	c := &cl.consumer
+	c.sourcesReadyMu.Lock()
+	c.fakeReadyForDraining = append(c.fakeReadyForDraining, f)
+	c.sourcesReadyMu.Unlock()
+	c.sourcesReadyCond.Broadcast()
+	return f
+}
+
+func TestRunPartitionProcessorsCommitsProcessed(t *testing.T) {
+	errProcess := errors.New("process failure")
+	for _, marks := range []bool{false, true} {
+		t.Run(fmt.Sprintf("marks_%v", marks), func(t *testing.T) {
+			cl := newUnitClient(t, PartitionProcessor(func(_ string, partition int32) RecordProcessor {
+				return RecordProcessorFunc(func(r *Record) error {
+					if partition == 0 && r.Offset == 2 {
+						return errProcess
+					}
+					return nil
+				})
+			}))
+			cl.cfg.autocommitMarks = marks // AutoCommitMarks requires a real group
+			g := &groupConsumer{cl: cl, cfg: &cl.cfg}
+			g.nowAssigned.store(map[string][]int32{"foo": {0, 1}})
+			cl.consumer.g = g
+			defer func() { cl.consumer.g = nil }()
+
+			// Injected fetches skip uncommitted tracking, so we
+			// track them as a real poll would.
+			g.updateUncommitted(Fetches{
+				injectRecords(cl, "foo", 0, 0, 5),
+				injectRecords(cl, "foo", 1, 0, 3),
+			})
+
+			if err := cl.RunPartitionProcessors(context.Background()); !errors.Is(err, errProcess) {
+				t.Fatalf("got err %v != exp %v", err, errProcess)
+			}
+
+			// Partition 0 was polled through offset 4 but only
+			// processed through offset 1; partition 1 was fully
+			// processed. What is committable (what the next poll or
+			// a revoke would commit) is only what was processed.
+			exp := map[string]map[int32]EpochOffset{"foo": {0: {0, 2}, 1: {0, 3}}}
+			g.undirtyUncommitted()
+			if got := g.getUncommitted(false); !reflect.DeepEqual(got, exp) {
+				t.Errorf("got committable %v != exp %v", got, exp)
+			}
+		})
+	}
+}
+
+func TestRunPartitionProcessorsStopsRevoked(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		created = make(map[int32]int)
+	)
+	processed := make(chan int32)
+	cl := newUnitClient(t, PartitionProcessor(func(_ string, partition int32) RecordProcessor {
+		mu.Lock()
+		created[partition]++
+		mu.Unlock()
+		return RecordProcessorFunc(func(r *Record) error {
+			processed <- r.Partition
+			return nil
+		})
+	}))
+	g := &groupConsumer{cl: cl, cfg: &cl.cfg}
+	g.nowAssigned.store(map[string][]int32{"foo": {0, 1}})
+	cl.consumer.g = g
+	defer func() { cl.consumer.g = nil }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- cl.RunPartitionProcessors(ctx) }()
+	wait := func(n int) {
+		for i := 0; i < n; i++ {
+			select {
+			case <-processed:
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for records to be processed")
+			}
+		}
+	}
+
+	injectRecords(cl, "foo", 0, 0, 1)
+	injectRecords(cl, "foo", 1, 0, 1)
+	wait(2)
+
+	// Revoke partition 0: its worker is stopped after the next poll, and
+	// reassigning it creates a new processor.
+	g.nowAssigned.store(map[string][]int32{"foo": {1}})
+	injectRecords(cl, "foo", 1, 1, 2)
+	wait(1)
+	g.nowAssigned.store(map[string][]int32{"foo": {0, 1}})
+	injectRecords(cl, "foo", 0, 1, 2)
+	wait(1)
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("got err %v != exp context.Canceled", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if created[0] != 2 || created[1] != 1 {
+		t.Errorf("got processors created %v, exp partition 0 twice and partition 1 once", created)
+	}
+}
+
+func TestInstanceIDFenced(t *testing.T) {
+	var joins atomic.Int32
+	tt := &scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+		if req, ok := req.(*kmsg.JoinGroupRequest); ok {
+			joins.Add(1)
+			resp := req.ResponseKind().(*kmsg.JoinGroupResponse)
+			resp.ErrorCode = kerr.FencedInstanceID.Code
+			return resp, nil
+		}
+		return scriptedCoordinator(req)
+	}}
+	lost := make(chan struct{}, 1)
+	cl := newUnitClient(t,
+		WithTestTransport(tt),
+		ConsumerGroup("group"),
+		ConsumeTopics("foo"),
+		InstanceID("instance"),
+		OnPartitionsLost(func(context.Context, *Client, map[string][]int32) { lost <- struct{}{} }),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	fetches := cl.PollFetches(ctx)
+	var sessionErr *ErrGroupSession
+	if err := fetches.Err(); !errors.As(err, &sessionErr) || !errors.Is(err, kerr.FencedInstanceID) {
+		t.Fatalf("got poll err %v, exp ErrGroupSession wrapping FencedInstanceID", err)
+	}
+	select {
+	case <-lost:
+	default:
+		t.Error("OnPartitionsLost was not called when fenced")
+	}
+
+	// The fenced member stops managing the group rather than rejoining,
+	// which would fence the new member in turn.
+	select {
+	case <-cl.consumer.g.manageDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("group management did not stop after being fenced")
+	}
+	if n := joins.Load(); n != 1 {
+		t.Errorf("got %d joins != exp 1", n)
+	}
+}
+
+func TestDeleteGroupOffsets(t *testing.T) {
+	var (
+		errCode int16
+		reqs    []*kmsg.OffsetDeleteRequest
+	)
+	cl := newUnitClient(t, WithTestTransport(&scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+		del, ok := req.(*kmsg.OffsetDeleteRequest)
+		if !ok {
+			return scriptedCoordinator(req)
+		}
+		reqs = append(reqs, del)
+		resp := del.ResponseKind().(*kmsg.OffsetDeleteResponse)
+		for _, rt := range del.Topics {
+			st := kmsg.NewOffsetDeleteResponseTopic()
+			st.Topic = rt.Topic
+			for _, rp := range rt.Partitions {
+				sp := kmsg.NewOffsetDeleteResponseTopicPartition()
+				sp.Partition = rp.Partition
+				if rp.Partition == 1 {
+					sp.ErrorCode = errCode
+				}
+				st.Partitions = append(st.Partitions, sp)
+			}
+			resp.Topics = append(resp.Topics, st)
+		}
+		return resp, nil
+	}}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := cl.DeleteGroupOffsets(ctx, "g", nil); err != nil || len(reqs) != 0 {
+		t.Errorf("got err %v and %d requests deleting nothing, exp none", err, len(reqs))
+	}
+
+	if err := cl.DeleteGroupOffsets(ctx, "g", map[string][]int32{"foo": {0, 1}}); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if len(reqs) != 1 {
+		t.Fatalf("got %d requests != exp 1", len(reqs))
+	}
+	req := reqs[0]
+	if req.Group != "g" || len(req.Topics) != 1 || req.Topics[0].Topic != "foo" || len(req.Topics[0].Partitions) != 2 {
+		t.Errorf("unexpected offset delete request: group %q, topics %v", req.Group, req.Topics)
+	}
+
+	errCode = kerr.GroupSubscribedToTopic.Code
+	err := cl.DeleteGroupOffsets(ctx, "g", map[string][]int32{"foo": {0, 1}})
+	if !errors.Is(err, kerr.GroupSubscribedToTopic) || !strings.Contains(err.Error(), "topic foo partition 1") {
+		t.Errorf("got err %v, exp GroupSubscribedToTopic for foo partition 1", err)
+	}
+}
+
+// commitBlockingTransport never answers OffsetCommit requests, returning only
+// once the request context is canceled.
+type commitBlockingTransport struct{}
+
+func (commitBlockingTransport) RoundTrip(ctx context.Context, _ BrokerMetadata, req kmsg.Request) (kmsg.Response, error) {
+	if _, ok := req.(*kmsg.OffsetCommitRequest); ok {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return scriptedCoordinator(req)
+}
+
+func TestRevokeCommitTimeout(t *testing.T) {
+	if _, err := NewClient(ConsumerGroup("group"), RevokeCommitTimeout(-time.Second)); err == nil || !strings.Contains(err.Error(), "revoke commit timeout") {
+		t.Errorf("got err %v, exp a negative revoke commit timeout to be invalid", err)
+	}
+
+	commitErr := make(chan error, 1)
+	cl := newUnitClient(t,
+		WithTestTransport(commitBlockingTransport{}),
+		RevokeCommitTimeout(50*time.Millisecond),
+	)
+	// ConsumerGroup would start a real group, and AutoCommitCallback
+	// requires one.
+	cl.cfg.group = "group"
+	cl.cfg.commitCallback = func(_ *Client, _ *kmsg.OffsetCommitRequest, _ *kmsg.OffsetCommitResponse, err error) {
+		commitErr <- err
+	}
+
+	g := &groupConsumer{cl: cl, cfg: &cl.cfg, ctx: context.Background()}
+	g.memberGen.store("member", 1)
+	g.updateUncommitted(Fetches{{Topics: []FetchTopic{{
+		Topic:      "foo",
+		Partitions: []FetchPartition{{Records: []*Record{{Topic: "foo"}}}},
+	}}}})
+	g.undirtyUncommitted() // as the next poll would
+	cl.consumer.g = g
+	defer func() { cl.consumer.g = nil }()
+
+	revoked := make(chan struct{})
+	go func() {
+		defer close(revoked)
+		g.defaultRevoke(context.Background(), cl, map[string][]int32{"foo": {0}})
+	}()
+	select {
+	case <-revoked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("revoking was not bounded by the revoke commit timeout")
+	}
+	if err := <-commitErr; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got commit err %v != exp %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestOnAutoCommit(t *testing.T) {
+	tt := &scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+		creq, ok := req.(*kmsg.OffsetCommitRequest)
+		if !ok {
+			return scriptedCoordinator(req)
+		}
+		resp := creq.ResponseKind().(*kmsg.OffsetCommitResponse)
+		for _, rt := range creq.Topics {
+			st := kmsg.NewOffsetCommitResponseTopic()
+			st.Topic = rt.Topic
+			for _, rp := range rt.Partitions {
+				sp := kmsg.NewOffsetCommitResponseTopicPartition()
+				sp.Partition = rp.Partition
+				if rp.Partition == 1 {
+					sp.ErrorCode = kerr.OffsetMetadataTooLarge.Code
+				}
+				st.Partitions = append(st.Partitions, sp)
+			}
+			resp.Topics = append(resp.Topics, st)
+		}
+		return resp, nil
+	}}
+	type result struct {
+		committed map[string]map[int32]EpochOffset
+		err       error
+	}
+	results := make(chan result, 1)
+	cl := newUnitClient(t,
+		WithTestTransport(tt),
+		OnAutoCommit(func(committed map[string]map[int32]EpochOffset, err error) {
+			select {
+			case results <- result{committed, err}:
+			default:
+			}
+		}),
+	)
+	// ConsumerGroup would start a real group; we also autocommit faster
+	// than AutoCommitInterval allows.
+	cl.cfg.group = "group"
+	cl.cfg.autocommitInterval = 10 * time.Millisecond
+	cl.cfg.commitCallback = func(*Client, *kmsg.OffsetCommitRequest, *kmsg.OffsetCommitResponse, error) {}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	g := &groupConsumer{cl: cl, cfg: &cl.cfg, ctx: ctx}
+	g.memberGen.store("member", 1)
+	g.updateUncommitted(Fetches{
+		injectRecords(cl, "foo", 0, 0, 3),
+		injectRecords(cl, "foo", 1, 0, 2),
+	})
+	g.undirtyUncommitted()
+	cl.consumer.g = g
+	defer func() { cl.consumer.g = nil }()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.loopCommit()
+	}()
+	defer func() { cancel(); <-done }()
+
+	select {
+	case r := <-results:
+		if exp := map[string]map[int32]EpochOffset{"foo": {0: {0, 3}}}; !reflect.DeepEqual(r.committed, exp) {
+			t.Errorf("got committed %v != exp %v", r.committed, exp)
+		}
+		if !errors.Is(r.err, kerr.OffsetMetadataTooLarge) {
+			t.Errorf("got err %v != exp %v", r.err, kerr.OffsetMetadataTooLarge)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnAutoCommit was not called")
+	}
+}
+
+func TestCommitPartition(t *testing.T) {
+	var (
+		errCode int16
+		reqs    []*kmsg.OffsetCommitRequest
+	)
+	tt := &scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+		creq, ok := req.(*kmsg.OffsetCommitRequest)
+		if !ok {
+			return scriptedCoordinator(req)
+		}
+		reqs = append(reqs, creq)
+		resp := creq.ResponseKind().(*kmsg.OffsetCommitResponse)
+		for _, rt := range creq.Topics {
+			st := kmsg.NewOffsetCommitResponseTopic()
+			st.Topic = rt.Topic
+			for _, rp := range rt.Partitions {
+				sp := kmsg.NewOffsetCommitResponseTopicPartition()
+				sp.Partition = rp.Partition
+				sp.ErrorCode = errCode
+				st.Partitions = append(st.Partitions, sp)
+			}
+			resp.Topics = append(resp.Topics, st)
+		}
+		return resp, nil
+	}}
+	cl := newUnitClient(t,
+		WithTestTransport(tt),
+		TopicNameMapper(nil, func(s string) string { return "tenant." + s }),
+	)
+	cl.cfg.group = "group" // ConsumerGroup would start a real group
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	g := &groupConsumer{cl: cl, cfg: &cl.cfg, ctx: ctx}
+	g.memberGen.store("member", 1)
+	cl.consumer.g = g
+	defer func() { cl.consumer.g = nil }()
+
+	if err := cl.CommitPartition(ctx, "foo", 2, EpochOffset{Epoch: 4, Offset: 10}); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if len(reqs) != 1 {
+		t.Fatalf("got %d commit requests != exp 1", len(reqs))
+	}
+	req := reqs[0]
+	if req.Group != "group" || len(req.Topics) != 1 || req.Topics[0].Topic != "tenant.foo" || len(req.Topics[0].Partitions) != 1 {
+		t.Fatalf("unexpected commit request: group %q, topics %v", req.Group, req.Topics)
+	}
+	if rp := req.Topics[0].Partitions[0]; rp.Partition != 2 || rp.Offset != 10 || rp.LeaderEpoch != 4 {
+		t.Errorf("got committed partition %d offset %d epoch %d != exp partition 2 offset 10 epoch 4", rp.Partition, rp.Offset, rp.LeaderEpoch)
+	}
+
+	errCode = kerr.OffsetMetadataTooLarge.Code
+	if err := cl.CommitPartition(ctx, "foo", 2, EpochOffset{Epoch: 4, Offset: 11}); !errors.Is(err, kerr.OffsetMetadataTooLarge) {
+		t.Errorf("got err %v != exp %v", err, kerr.OffsetMetadataTooLarge)
+	}
+}
+
+func TestFetchGroupOffsetsWithMetadata(t *testing.T) {
+	var groupErr, partErr int16
+	cl := newUnitClient(t, WithTestTransport(&scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+		freq, ok := req.(*kmsg.OffsetFetchRequest)
+		if !ok {
+			return scriptedCoordinator(req)
+		}
+		resp := freq.ResponseKind().(*kmsg.OffsetFetchResponse)
+		for _, rg := range freq.Groups {
+			sg := kmsg.NewOffsetFetchResponseGroup()
+			sg.Group = rg.Group
+			sg.ErrorCode = groupErr
+			for _, tp := range []struct {
+				topic     string
+				partition int32
+				epoch     int32
+				offset    int64
+				metadata  *string
+			}{
+				{"foo", 0, 2, 5, kmsg.StringPtr("checkpoint")},
+				{"foo", 1, -1, -1, nil}, // no committed offset
+				{"bar", 0, -1, 3, nil},
+			} {
+				st := kmsg.NewOffsetFetchResponseGroupTopic()
+				st.Topic = tp.topic
+				sp := kmsg.NewOffsetFetchResponseGroupTopicPartition()
+				sp.Partition, sp.LeaderEpoch, sp.Offset, sp.Metadata = tp.partition, tp.epoch, tp.offset, tp.metadata
+				if tp.partition == 1 {
+					sp.ErrorCode = partErr
+				}
+				st.Partitions = append(st.Partitions, sp)
+				sg.Topics = append(sg.Topics, st)
+			}
+			resp.Groups = append(resp.Groups, sg)
+		}
+		return resp, nil
+	}}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	offsets, err := cl.FetchGroupOffsetsWithMetadata(ctx, "g")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]map[int32]OffsetAndMetadata{
+		"foo": {0: {EpochOffset{2, 5}, "checkpoint"}},
+		"bar": {0: {EpochOffset{-1, 3}, ""}},
+	}
+	if !reflect.DeepEqual(offsets, exp) {
+		t.Errorf("got offsets %v != exp %v", offsets, exp)
+	}
+
+	partErr = kerr.UnstableOffsetCommit.Code
+	if _, err := cl.FetchGroupOffsetsWithMetadata(ctx, "g"); !errors.Is(err, kerr.UnstableOffsetCommit) || !strings.Contains(err.Error(), "topic foo partition 1") {
+		t.Errorf("got err %v, exp UnstableOffsetCommit for foo partition 1", err)
+	}
+
+	partErr, groupErr = 0, kerr.GroupAuthorizationFailed.Code
+	if _, err := cl.FetchGroupOffsetsWithMetadata(ctx, "g"); !errors.Is(err, kerr.GroupAuthorizationFailed) {
+		t.Errorf("got err %v != exp %v", err, kerr.GroupAuthorizationFailed)
+	}
+}
+
+func TestOnPartialRevoke(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		cooperative bool
+		leaving     bool
+		exp         map[string][]int32
+	}{
+		{"cooperative", true, false, map[string][]int32{"foo": {1}}},
+		{"cooperative_leaving", true, true, nil},
+		{"eager", false, false, nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				revoked bool
+				partial map[string][]int32
+			)
+			cl := newUnitClient(t, TopicNameMapper(nil, func(s string) string { return "tenant." + s }))
+			wire := cl.consumer.wireTopic("foo")
+			cl.cfg.onRevoked = func(context.Context, *Client, map[string][]int32) { revoked = true }
+			cl.cfg.onPartialRevoke = func(_ context.Context, _ *Client, lost map[string][]int32) { partial = lost }
+
+			g := &groupConsumer{c: &cl.consumer, cl: cl, cfg: &cl.cfg, ctx: context.Background()}
+			g.cooperative.Store(test.cooperative)
+			g.nowAssigned.store(map[string][]int32{wire: {0, 1}})
+			cl.consumer.g = g
+			defer func() { cl.consumer.g = nil }()
+
+			g.revoke(revokeLastSession, map[string][]int32{wire: {1}}, test.leaving)
+			if !revoked {
+				t.Error("OnPartitionsRevoked was not called")
+			}
+			if !reflect.DeepEqual(partial, test.exp) {
+				t.Errorf("got partial revoke %v != exp %v", partial, test.exp)
+			}
+		})
+	}
+}
@@ -1,13 +1,17 @@
 package kgo
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
 	"net"
 	"os"
 	"sort"
@@ -18,6 +22,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/twmb/franz-go/pkg/kbin"
 	"github.com/twmb/franz-go/pkg/kerr"
 	"github.com/twmb/franz-go/pkg/kmsg"
 	"github.com/twmb/franz-go/pkg/sasl"
@@ -547,3 +552,281 @@ out:
 		}
 	}
 }
+
+type scriptedTransport struct {
+	mu    sync.Mutex
+	reqs  []kmsg.Request
+	nodes []int32 // the broker each request in reqs was issued to
+	resp  func(kmsg.Request) (kmsg.Response, error)
+}
+
+func (s *scriptedTransport) RoundTrip(_ context.Context, broker BrokerMetadata, req kmsg.Request) (kmsg.Response, error) {
+	s.mu.Lock()
+	s.reqs = append(s.reqs, req)
+	s.nodes = append(s.nodes, broker.NodeID)
+	s.mu.Unlock()
+	return s.resp(req)
+}
+
+// scriptedProduce responds to requests as a single broker cluster that leads
+// every partition of every topic, with partitions replicated to brokers 0, 1,
+// and 2 and broker 2 out of sync.
+func scriptedProduce(req kmsg.Request) (kmsg.Response, error) {
+	switch req := req.(type) {
+	case *kmsg.MetadataRequest:
+		resp := req.ResponseKind().(*kmsg.MetadataResponse)
+		b := kmsg.NewMetadataResponseBroker()
+		b.Host, b.Port = "localhost", 1
+		resp.Brokers = append(resp.Brokers, b)
+		for _, rt := range req.Topics {
+			st := kmsg.NewMetadataResponseTopic()
+			st.Topic = rt.Topic
+			sp := kmsg.NewMetadataResponseTopicPartition()
+			sp.Replicas = []int32{0, 1, 2}
+			sp.ISR = []int32{0, 1}
+			st.Partitions = append(st.Partitions, sp)
+			resp.Topics = append(resp.Topics, st)
+		}
+		return resp, nil
+	case *kmsg.InitProducerIDRequest:
+		resp := req.ResponseKind().(*kmsg.InitProducerIDResponse)
+		resp.ProducerID = 7
+		return resp, nil
+	case *kmsg.ProduceRequest:
+		resp := req.ResponseKind().(*kmsg.ProduceResponse)
+		for _, rt := range req.Topics {
+			st := kmsg.NewProduceResponseTopic()
+			st.Topic = rt.Topic
+			for _, rp := range rt.Partitions {
+				sp := kmsg.NewProduceResponseTopicPartition()
+				sp.Partition = rp.Partition
+				sp.BaseOffset = 41
+				st.Partitions = append(st.Partitions, sp)
+			}
+			resp.Topics = append(resp.Topics, st)
+		}
+		return resp, nil
+	}
+	return nil, fmt.Errorf("unexpected request %T", req)
+}
+
+// scriptedCoordinator responds to FindCoordinator requests with our one broker
+// as the coordinator for every key, otherwise deferring to scriptedProduce.
+func scriptedCoordinator(req kmsg.Request) (kmsg.Response, error) {
+	if req, ok := req.(*kmsg.FindCoordinatorRequest); ok {
+		resp := req.ResponseKind().(*kmsg.FindCoordinatorResponse)
+		for _, key := range req.CoordinatorKeys {
+			c := kmsg.NewFindCoordinatorResponseCoordinator()
+			c.Key, c.Host, c.Port = key, "localhost", 1
+			resp.Coordinators = append(resp.Coordinators, c)
+		}
+		return resp, nil
+	}
+	return scriptedProduce(req)
+}
+
+// encodeRecordBatch returns a v2 record batch starting at firstOffset with a
+// record per value, compressed with the given codec, as a broker would return
+// it in a fetch response.
+func encodeRecordBatch(t *testing.T, firstOffset int64, codec CompressionCodec, values ...string) []byte {
+	t.Helper()
+	var raw []byte
+	for i, v := range values {
+		kr := kmsg.Record{OffsetDelta: int32(i), Value: []byte(v)}
+		kr.Length = int32(len(kr.AppendTo(nil)) - 1) // the length varint is one byte
+		raw = kr.AppendTo(raw)
+	}
+	rb := kmsg.RecordBatch{
+		FirstOffset:     firstOffset,
+		Magic:           2,
+		LastOffsetDelta: int32(len(values) - 1),
+		NumRecords:      int32(len(values)),
+		Records:         raw,
+	}
+	if codec.codec != codecNone {
+		c, err := newCompressor(codec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compressed, used := c.compress(new(bytes.Buffer), raw, 9)
+		if used != codec.codec {
+			t.Fatalf("unable to compress with codec %d", codec.codec)
+		}
+		rb.Records = compressed
+		rb.Attributes = int16(used)
+	}
+	in := rb.AppendTo(nil)
+	binary.BigEndian.PutUint32(in[8:], uint32(len(in)-12))
+	binary.BigEndian.PutUint32(in[17:], crc32.Checksum(in[21:], crc32c))
+	return in
+}
+
+// markTransactional marks a batch encoded by encodeRecordBatch as
+// transactional for producer ID 0, recomputing the batch CRC.
+func markTransactional(in []byte) []byte {
+	binary.BigEndian.PutUint16(in[21:], 0b0001_0000)
+	binary.BigEndian.PutUint32(in[17:], crc32.Checksum(in[21:], crc32c))
+	return in
+}
+
+// newUnitClient returns a client for unit tests that do not need a live
+// broker. The client is seeded with an unreachable broker and is closed when
+// the test finishes; to answer requests in memory, pass WithTestTransport.
+func newUnitClient(t *testing.T, opts ...Opt) *Client {
+	t.Helper()
+	cl, err := NewClient(append([]Opt{SeedBrokers("localhost:1")}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cl.Close)
+	return cl
+}
+
+// fakeBroker is a minimal single node broker listening on localhost, for unit
+// tests that need real connections (rather than a TestTransport). It only
+// supports ApiVersions, Metadata (up to v8), and Produce (up to v7), and
+// answers produce requests with scriptedProduce. Produce requests with acks of
+// 0 are answered as well, as some non-Kafka brokers do.
+type fakeBroker struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	cxns     int
+	headers  []fakeHeader
+	produces []fakeProduce
+}
+
+// fakeHeader is the header of a request a fakeBroker received.
+type fakeHeader struct {
+	key      int16
+	corrID   int32
+	clientID string
+}
+
+// fakeProduce is a produce request a fakeBroker received, and on which
+// connection.
+type fakeProduce struct {
+	cxn    int
+	acks   int16
+	topics []string
+}
+
+func newFakeBroker(t *testing.T) *fakeBroker {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	f := &fakeBroker{ln: ln}
+	go f.accept()
+	return f
+}
+
+func (f *fakeBroker) addr() string { return f.ln.Addr().String() }
+
+func (f *fakeBroker) received() []fakeHeader {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]fakeHeader(nil), f.headers...)
+}
+
+func (f *fakeBroker) produced() []fakeProduce {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]fakeProduce(nil), f.produces...)
+}
+
+func (f *fakeBroker) accept() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.cxns++
+		cxn := f.cxns
+		f.mu.Unlock()
+		go f.serve(cxn, conn)
+	}
+}
+
+func (f *fakeBroker) serve(cxn int, conn net.Conn) {
+	defer conn.Close()
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		buf := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return
+		}
+		b := kbin.Reader{Src: buf}
+		key, version, corrID := b.Int16(), b.Int16(), b.Int32()
+		h := fakeHeader{key: key, corrID: corrID}
+		if clientID := b.NullableString(); clientID != nil {
+			h.clientID = *clientID
+		}
+		f.mu.Lock()
+		f.headers = append(f.headers, h)
+		f.mu.Unlock()
+		req := kmsg.RequestForKey(key)
+		if req == nil {
+			return
+		}
+		req.SetVersion(version)
+		if req.IsFlexible() {
+			kmsg.SkipTags(&b)
+		}
+		if err := req.ReadFrom(b.Src); err != nil {
+			return
+		}
+
+		resp, ok := f.handle(cxn, req)
+		if !ok {
+			return
+		}
+		resp.SetVersion(version)
+		out := kbin.AppendInt32(make([]byte, 4), corrID)
+		if resp.IsFlexible() && key != 18 {
+			out = append(out, 0) // empty response header tags
+		}
+		out = resp.AppendTo(out)
+		binary.BigEndian.PutUint32(out, uint32(len(out)-4))
+		if _, err := conn.Write(out); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeBroker) handle(cxn int, req kmsg.Request) (kmsg.Response, bool) {
+	switch req := req.(type) {
+	case *kmsg.ApiVersionsRequest:
+		resp := req.ResponseKind().(*kmsg.ApiVersionsResponse)
+		for _, k := range [][3]int16{{0, 0, 7}, {3, 0, 8}, {18, 0, 3}} {
+			v := kmsg.NewApiVersionsResponseApiKey()
+			v.ApiKey, v.MinVersion, v.MaxVersion = k[0], k[1], k[2]
+			resp.ApiKeys = append(resp.ApiKeys, v)
+		}
+		return resp, true
+	case *kmsg.MetadataRequest:
+		resp, _ := scriptedProduce(req)
+		host, port, _ := net.SplitHostPort(f.addr())
+		p, _ := strconv.Atoi(port)
+		mb := &resp.(*kmsg.MetadataResponse).Brokers[0]
+		mb.Host, mb.Port = host, int32(p)
+		return resp, true
+	case *kmsg.ProduceRequest:
+		p := fakeProduce{cxn: cxn, acks: req.Acks}
+		for _, rt := range req.Topics {
+			p.topics = append(p.topics, rt.Topic)
+		}
+		f.mu.Lock()
+		f.produces = append(f.produces, p)
+		f.mu.Unlock()
+		resp, _ := scriptedProduce(req)
+		return resp, true
+	}
+	return nil, false
+}
@@ -102,6 +102,10 @@ type HookBrokerRead interface {
 // Note that if this is for a produce request with no acks, there will be no
 // read wait / time to read.
 type BrokerE2E struct {
+	// Version is the version of the request that was written and of the
+	// response that was read.
+	Version int16
+
 	// BytesWritten is the number of bytes written for this request.
 	//
 	// This may not be the whole request if there was an error while writing.
@@ -162,6 +166,12 @@ func (e *BrokerE2E) Err() error {
 // This differs from HookBrokerRead and HookBrokerWrite by tracking all E2E
 // info for a write and a read, which allows for easier e2e metrics. This hook
 // can replace both the read and write hook.
+//
+// This hook is called for every request the client issues (other than
+// Kerberos SASL), and includes the request key and version, the bytes written
+// and read, timing, and any error. Combined with HookBrokerWrite (which is
+// called as soon as a request is written), this can be used to create
+// request-level tracing spans.
 type HookBrokerE2E interface {
 	// OnBrokerE2E is passed the broker metadata, the key for the
 	// request/response that was written/read, and the e2e info for the
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math/rand"
 	"sort"
 	"strings"
 	"sync"
@@ -46,25 +47,9 @@ func (cl *Client) PartitionLeader(topic string, partition int32) (leader, leader
 		return -1, -1, errors.New("invalid negative partition")
 	}
 
-	var t *topicPartitions
-
-	m := cl.producer.topics.load()
-	if len(m) > 0 {
-		t = m[topic]
-	}
+	t := cl.loadedTopic(topic)
 	if t == nil {
-		if cl.consumer.g != nil {
-			if m = cl.consumer.g.tps.load(); len(m) > 0 {
-				t = m[topic]
-			}
-		} else if cl.consumer.d != nil {
-			if m = cl.consumer.d.tps.load(); len(m) > 0 {
-				t = m[topic]
-			}
-		}
-		if t == nil {
-			return -1, -1, nil
-		}
+		return -1, -1, nil
 	}
 
 	tv := t.load()
@@ -75,6 +60,143 @@ func (cl *Client) PartitionLeader(topic string, partition int32) (leader, leader
 	return p.leader, p.leaderEpoch, p.loadErr
 }
 
+// PartitionReplicaInfo contains the replica information for a partition, as
+// of the client's latest metadata load.
+type PartitionReplicaInfo struct {
+	// Leader is the broker leading the partition.
+	Leader int32
+	// LeaderEpoch is the leader epoch of the partition, or -1 if the
+	// client is not using leader epochs.
+	LeaderEpoch int32
+	// Replicas are all brokers that replicate the partition.
+	Replicas []int32
+	// ISR are the replicas that are in sync with the leader.
+	ISR []int32
+	// OfflineReplicas are replicas that are offline (Kafka 1.0+).
+	OfflineReplicas []int32
+	// Err is the load error for the partition, if any. If non-nil, the
+	// other fields are from the last successful load, if any.
+	Err error
+}
+
+// PartitionReplicas returns the leader, replicas, in-sync replicas, and
+// offline replicas for all partitions of a topic, from the client's existing
+// metadata. Partitions that have fewer in-sync replicas than replicas are
+// under-replicated. This returns nil, nil if the topic is not being produced
+// to or consumed and thus has not been loaded, and the topic load error if
+// the topic failed to load.
+//
+// Metadata is refreshed periodically (see MetadataMaxAge); the returned
+// information may be stale. To issue a fresh request, see the kadm package.
+func (cl *Client) PartitionReplicas(topic string) (map[int32]PartitionReplicaInfo, error) {
+	t := cl.loadedTopic(topic)
+	if t == nil {
+		return nil, nil
+	}
+
+	tv := t.load()
+	if len(tv.partitions) == 0 {
+		return nil, tv.loadErr
+	}
+	infos := make(map[int32]PartitionReplicaInfo, len(tv.partitions))
+	for i, p := range tv.partitions {
+		infos[int32(i)] = PartitionReplicaInfo{
+			Leader:          p.leader,
+			LeaderEpoch:     p.leaderEpoch,
+			Replicas:        append([]int32(nil), p.replicas...),
+			ISR:             append([]int32(nil), p.isr...),
+			OfflineReplicas: append([]int32(nil), p.offlineReplicas...),
+			Err:             p.loadErr,
+		}
+	}
+	return infos, nil
+}
+
+// loadedTopic returns the topic's partitions from the producer, or from the
+// consumer if the producer is not using the topic, or nil if neither are.
+func (cl *Client) loadedTopic(topic string) *topicPartitions {
+	if t := cl.producer.topics.load()[topic]; t != nil {
+		return t
+	}
+	if cl.consumer.g != nil {
+		return cl.consumer.g.tps.load()[topic]
+	} else if cl.consumer.d != nil {
+		return cl.consumer.d.tps.load()[topic]
+	}
+	return nil
+}
+
+// RefreshPartitionLeader immediately fetches metadata for the partition's topic
+// and merges it into the client, moving the partition to its new leader if the
+// leader changed. This returns the partition's load error, if any, or an error
+// if the metadata request failed.
+//
+// The client refreshes metadata on its own when a produce or fetch fails with
+// NOT_LEADER_FOR_PARTITION, but the refresh covers every tracked topic and is
+// subject to MetadataMinAge. This is a targeted, fast recovery for a single
+// hot partition. Kafka returns metadata per topic, so all partitions in the
+// topic are updated. If the topic is not being produced to or consumed, the
+// metadata is fetched but nothing is updated.
+func (cl *Client) RefreshPartitionLeader(ctx context.Context, topic string, partition int32) error {
+	if partition < 0 {
+		return errors.New("invalid negative partition")
+	}
+	errCh := make(chan error, 1)
+	go cl.blockingMetadataFn(func() {
+		errCh <- cl.refreshTopicMetadata(topic, partition)
+	})
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-cl.ctx.Done():
+		return ErrClientClosed
+	}
+}
+
+// refreshTopicMetadata is RefreshPartitionLeader's metadata update for a
+// single topic; this must be run in the metadata loop.
+func (cl *Client) refreshTopicMetadata(topic string, partition int32) error {
+	latest, err := cl.fetchTopicMetadata(false, []string{topic})
+	if err != nil {
+		return err
+	}
+	mt, exists := latest[topic]
+	if !exists {
+		return fmt.Errorf("metadata request did not return topic %s", topic)
+	}
+
+	css := &consumerSessionStopper{cl: cl}
+	defer css.maybeRestart()
+
+	var retryWhy multiUpdateWhy
+	if tps := cl.producer.topics.load()[topic]; tps != nil {
+		cl.mergeTopicPartitions(topic, tps, mt, true, css, &retryWhy)
+	}
+	var tpsConsumer *topicsPartitions
+	switch c := &cl.consumer; {
+	case c.g != nil:
+		tpsConsumer = c.g.tps
+	case c.d != nil:
+		tpsConsumer = c.d.tps
+	}
+	if tps := tpsConsumer.load()[topic]; tps != nil {
+		cl.mergeTopicPartitions(topic, tps, mt, false, css, &retryWhy)
+	}
+	if len(retryWhy) > 0 {
+		cl.triggerUpdateMetadata(false, retryWhy.reason("refresh partition leader had retryable errors"))
+	}
+
+	if mt.loadErr != nil {
+		return mt.loadErr
+	}
+	if int(partition) >= len(mt.partitions) {
+		return fmt.Errorf("topic %s has %d partitions, partition %d does not exist", topic, len(mt.partitions), partition)
+	}
+	return kerr.ErrorForCode(mt.partitions[partition].loadErr)
+}
+
 // waitmeta returns immediately if metadata was updated within the last second,
 // otherwise this waits for up to wait for a metadata update to complete.
 func (cl *Client) waitmeta(ctx context.Context, wait time.Duration, why string) {
@@ -165,8 +287,16 @@ func (cl *Client) updateMetadataLoop() {
 	var consecutiveErrors int
 	var lastAt time.Time
 
-	ticker := time.NewTicker(cl.cfg.metadataMaxAge)
+	ticker := time.NewTicker(cl.jitteredMetadataMaxAge())
 	defer ticker.Stop()
+
+	cl.regexDiscoverDue = true
+	var discoverC <-chan time.Time
+	if cl.cfg.regex && cl.cfg.regexDiscoveryInterval > 0 {
+		discover := time.NewTicker(cl.cfg.regexDiscoveryInterval)
+		defer discover.Stop()
+		discoverC = discover.C
+	}
 loop:
 	for {
 		var now bool
@@ -175,6 +305,11 @@ loop:
 			return
 		case <-ticker.C:
 			// We do not log on the standard update case.
+			if cl.cfg.metadataMaxJitter > 0 {
+				ticker.Reset(cl.jitteredMetadataMaxAge())
+			}
+		case <-discoverC:
+			cl.regexDiscoverDue = true
 		case why := <-cl.updateMetadataCh:
 			cl.cfg.logger.Log(LogLevelInfo, "metadata update triggered", "why", why)
 		case why := <-cl.updateMetadataNowCh:
@@ -189,8 +324,8 @@ loop:
 	start:
 		nowTries++
 		if !now {
-			if wait := cl.cfg.metadataMinAge - time.Since(lastAt); wait > 0 {
-				timer := time.NewTimer(wait)
+			if wait := cl.cfg.metadataMinAge - cl.cfg.clock.Since(lastAt); wait > 0 {
+				timer := cl.cfg.clock.NewTimer(wait)
 			prewait:
 				select {
 				case <-cl.ctx.Done():
@@ -199,7 +334,7 @@ loop:
 				case why := <-cl.updateMetadataNowCh:
 					timer.Stop()
 					cl.cfg.logger.Log(LogLevelInfo, "immediate metadata update triggered, bypassing normal wait", "why", why)
-				case <-timer.C:
+				case <-timer.C():
 				case fn := <-cl.blockingMetadataFnCh:
 					fn()
 					goto prewait
@@ -209,7 +344,7 @@ loop:
 
 		// Even with an "update now", we sleep just a bit to allow some
 		// potential pile on now triggers.
-		time.Sleep(time.Until(lastAt.Add(10 * time.Millisecond)))
+		cl.cfg.clock.Sleep(lastAt.Add(10 * time.Millisecond).Sub(cl.cfg.clock.Now()))
 
 		// Drain any refires that occurred during our waiting.
 	out:
@@ -225,6 +360,9 @@ loop:
 		}
 
 		retryWhy, err := cl.updateMetadata()
+		if fn := cl.cfg.onMetadataRetry; fn != nil && err == nil && retryWhy != nil {
+			fn(retryWhy.topicErrs())
+		}
 		if retryWhy != nil || err != nil {
 			// If err is non-nil, the metadata request failed
 			// itself and already retried 3x; we do not loop more.
@@ -245,13 +383,13 @@ loop:
 					"errors", retryWhy.reason(""),
 					"update_after", wait,
 				)
-				timer := time.NewTimer(wait)
+				timer := cl.cfg.clock.NewTimer(wait)
 			quickbackoff:
 				select {
 				case <-cl.ctx.Done():
 					timer.Stop()
 					return
-				case <-timer.C:
+				case <-timer.C():
 				case fn := <-cl.blockingMetadataFnCh:
 					fn()
 					goto quickbackoff
@@ -267,19 +405,19 @@ loop:
 		if err == nil {
 			cl.metawait.signal()
 			cl.consumer.doOnMetadataUpdate()
-			lastAt = time.Now()
+			lastAt = cl.cfg.clock.Now()
 			consecutiveErrors = 0
 			continue
 		}
 
 		consecutiveErrors++
-		after := time.NewTimer(cl.cfg.retryBackoff(consecutiveErrors))
+		after := cl.cfg.clock.NewTimer(cl.cfg.retryBackoff(consecutiveErrors))
 	backoff:
 		select {
 		case <-cl.ctx.Done():
 			after.Stop()
 			return
-		case <-after.C:
+		case <-after.C():
 		case fn := <-cl.blockingMetadataFnCh:
 			fn()
 			goto backoff
@@ -287,6 +425,18 @@ loop:
 	}
 }
 
+// jitteredMetadataMaxAge returns the metadata max age, randomly adjusted by up
+// to +/- the configured jitter fraction.
+func (cl *Client) jitteredMetadataMaxAge() time.Duration {
+	age := cl.cfg.metadataMaxAge
+	if cl.cfg.metadataMaxJitter == 0 {
+		return age
+	}
+	var random float64
+	cl.rng(func(r *rand.Rand) { random = r.Float64() })
+	return time.Duration(float64(age) * (1 + cl.cfg.metadataMaxJitter*(2*random-1)))
+}
+
 var errMissingTopic = errors.New("topic_missing")
 
 // Updates all producer and consumer partition data, returning whether a new
@@ -300,7 +450,7 @@ func (cl *Client) updateMetadata() (retryWhy multiUpdateWhy, err error) {
 		tpsProducerLoad = cl.producer.topics.load()
 		tpsConsumer     *topicsPartitions
 		groupExternal   *groupExternal
-		all             = cl.cfg.regex
+		all             = cl.cfg.regex && (cl.cfg.regexDiscoveryInterval <= 0 || cl.regexDiscoverDue)
 		reqTopics       []string
 	)
 	c := &cl.consumer
@@ -340,6 +490,9 @@ func (cl *Client) updateMetadata() (retryWhy multiUpdateWhy, err error) {
 		return nil, err
 	}
 	groupExternal.updateLatest(latest)
+	if all {
+		cl.regexDiscoverDue = false
+	}
 
 	// If we are consuming with regex and fetched all topics, the metadata
 	// may have returned topics the consumer is not yet tracking. We ensure
@@ -356,7 +509,7 @@ func (cl *Client) updateMetadata() (retryWhy multiUpdateWhy, err error) {
 		// we will never use (the client works with misc. topics in
 		// there, but it's better to avoid it -- and allows us to use
 		// `tps` in GetConsumeTopics).
-		allTopics = c.filterMetadataAllTopics(allTopics)
+		allTopics, newMatches := c.filterMetadataAllTopics(allTopics)
 
 		tpsConsumerLoad = tpsConsumer.ensureTopics(allTopics)
 		defer tpsConsumer.storeData(tpsConsumerLoad)
@@ -383,7 +536,23 @@ func (cl *Client) updateMetadata() (retryWhy multiUpdateWhy, err error) {
 			// metadata fn; this will wait for our current
 			// execution to finish then purge.
 			cl.cfg.logger.Log(LogLevelInfo, "regex consumer purging topics that were previously consumed because they are missing in a metadata response, we are assuming they are deleted", "topics", purgeTopics)
-			go cl.PurgeTopicsFromClient(purgeTopics...)
+			go cl.purgeTopics(purgeTopics, purgeTopics)
+		}
+
+		if fn := cl.cfg.onRegexMatched; fn != nil {
+			added := newMatches[:0]
+			for _, topic := range newMatches {
+				if cl.cfg.regexExcludeInternal && latest[topic].isInternal {
+					continue
+				}
+				added = append(added, topic)
+			}
+			if len(added) > 0 || len(purgeTopics) > 0 {
+				removed := append([]string(nil), purgeTopics...)
+				sort.Strings(added)
+				sort.Strings(removed)
+				fn(added, removed)
+			}
 		}
 	}
 
@@ -491,6 +660,10 @@ type metadataPartition struct {
 	leader      int32
 	leaderEpoch int32
 	sns         sinkAndSource
+
+	replicas        []int32
+	isr             []int32
+	offlineReplicas []int32
 }
 
 func (mp metadataPartition) newPartition(cl *Client, isProduce bool) *topicPartition {
@@ -501,6 +674,9 @@ func (mp metadataPartition) newPartition(cl *Client, isProduce bool) *topicParti
 	p := &topicPartition{
 		loadErr:            kerr.ErrorForCode(mp.loadErr),
 		topicPartitionData: td,
+		replicas:           mp.replicas,
+		isr:                mp.isr,
+		offlineReplicas:    mp.offlineReplicas,
 	}
 	if isProduce {
 		p.records = &recBuf{
@@ -508,6 +684,8 @@ func (mp metadataPartition) newPartition(cl *Client, isProduce bool) *topicParti
 			topic:               mp.topic,
 			partition:           mp.partition,
 			maxRecordBatchBytes: cl.maxRecordBatchBytesForTopic(mp.topic),
+			linger:              cl.cfg.lingerForTopic(mp.topic),
+			acks:                cl.cfg.acksForTopic(mp.topic),
 			recBufsIdx:          -1,
 			failing:             mp.loadErr != 0,
 			sink:                mp.sns.sink,
@@ -515,11 +693,17 @@ func (mp metadataPartition) newPartition(cl *Client, isProduce bool) *topicParti
 			lastAckedOffset:     -1,
 		}
 	} else {
+		logical := cl.consumer.logicalTopic(mp.topic)
 		p.cursor = &cursor{
 			topic:              mp.topic,
 			topicID:            mp.topicID,
 			partition:          mp.partition,
 			keepControl:        cl.cfg.keepControl,
+			includeAborted:     cl.cfg.includeAborted,
+			onCorrupt:          cl.corruptBatchFn(logical, mp.partition),
+			maxRecordBytes:     cl.cfg.maxConsumeRecordBytes,
+			onTooLarge:         cl.recordTooLargeFn(logical, mp.partition),
+			decode:             cl.recordDecodeFn(logical),
 			cursorsIdx:         -1,
 			source:             mp.sns.source,
 			topicPartitionData: td,
@@ -603,12 +787,15 @@ func (cl *Client) fetchTopicMetadata(all bool, reqTopics []string) (map[string]*
 				leaderEpoch = -1
 			}
 			mp := metadataPartition{
-				topic:       topic,
-				topicID:     topicMeta.TopicID,
-				partition:   partMeta.Partition,
-				loadErr:     partMeta.ErrorCode,
-				leader:      partMeta.Leader,
-				leaderEpoch: leaderEpoch,
+				topic:           topic,
+				topicID:         topicMeta.TopicID,
+				partition:       partMeta.Partition,
+				loadErr:         partMeta.ErrorCode,
+				leader:          partMeta.Leader,
+				leaderEpoch:     leaderEpoch,
+				replicas:        partMeta.Replicas,
+				isr:             partMeta.ISR,
+				offlineReplicas: partMeta.OfflineReplicas,
 			}
 			if mp.loadErr != 0 {
 				mp.leader = unknownSeedID(0) // ensure every records & cursor can use a sink or source
@@ -923,6 +1110,25 @@ func (m *multiUpdateWhy) add(t string, p int32, err error) {
 	}
 }
 
+// topicErrs returns each topic mapped to one of the errors it had.
+func (m multiUpdateWhy) topicErrs() map[string]error {
+	errs := make(map[string]error)
+	for ks, ts := range m {
+		var err error
+		if ks.k != nil {
+			err = ks.k
+		} else {
+			err = errors.New(ks.s)
+		}
+		for t := range ts {
+			if _, exists := errs[t]; !exists {
+				errs[t] = err
+			}
+		}
+	}
+	return errs
+}
+
 // err{topic[1 2 3] topic2[4 5 6]} err2{...}
 func (m multiUpdateWhy) reason(reason string) string {
 	if len(m) == 0 {
@@ -0,0 +1,104 @@
+package kgo
+
+import (
+	"context"
+	"sync"
+)
+
+// MultiClient is a thin multiplexer over multiple clients that produces every
+// record to all clients. This is useful for active-active replication or
+// disaster recovery setups that double write to multiple clusters.
+//
+// Each client is configured and owned independently; MultiClient only fans
+// out producing and aggregates the per-client results. Results are always in
+// the order of the clients passed to NewMultiClient.
+type MultiClient struct {
+	clients []*Client
+}
+
+// NewMultiClient returns a MultiClient that produces to all given clients.
+func NewMultiClient(clients ...*Client) *MultiClient {
+	return &MultiClient{clients: append([]*Client(nil), clients...)}
+}
+
+// Clients returns the clients this MultiClient produces to.
+func (m *MultiClient) Clients() []*Client {
+	return append([]*Client(nil), m.clients...)
+}
+
+// Produce produces a copy of the record to every client, calling promise once
+// all clients have finished producing their copy. The results passed to
+// promise contain each client's copy of the record and its error, in client
+// order. The input record itself is not modified.
+//
+// A copy is produced per client because producing sets fields on the record,
+// such as the partition and offset. The key, value, and header contents are
+// shared across copies and must not be modified until the promise is called.
+func (m *MultiClient) Produce(ctx context.Context, r *Record, promise func(ProduceResults)) {
+	var (
+		wg      sync.WaitGroup
+		results = make(ProduceResults, len(m.clients))
+	)
+	wg.Add(len(m.clients))
+	for i, cl := range m.clients {
+		i := i
+		cl.Produce(ctx, copyRecord(r), func(r *Record, err error) {
+			results[i] = ProduceResult{r, err}
+			wg.Done()
+		})
+	}
+	if promise != nil {
+		go func() {
+			wg.Wait()
+			promise(results)
+		}()
+	}
+}
+
+// ProduceSync produces a copy of the record to every client and waits for
+// all clients to finish, returning each client's result in client order.
+func (m *MultiClient) ProduceSync(ctx context.Context, r *Record) ProduceResults {
+	done := make(chan ProduceResults, 1)
+	m.Produce(ctx, r, func(rs ProduceResults) { done <- rs })
+	return <-done
+}
+
+// Flush flushes every client concurrently, returning the first error
+// encountered in client order.
+func (m *MultiClient) Flush(ctx context.Context) error {
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(m.clients))
+	)
+	wg.Add(len(m.clients))
+	for i, cl := range m.clients {
+		i, cl := i, cl
+		go func() {
+			defer wg.Done()
+			errs[i] = cl.Flush(ctx)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes every client.
+func (m *MultiClient) Close() {
+	for _, cl := range m.clients {
+		cl.Close()
+	}
+}
+
+// copyRecord returns a shallow copy of the record suitable for producing
+// independently. The headers slice is copied so that appending to one copy
+// does not affect another.
+func copyRecord(r *Record) *Record {
+	cp := *r
+	cp.Headers = append([]RecordHeader(nil), r.Headers...)
+	return &cp
+}
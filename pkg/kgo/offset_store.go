@@ -0,0 +1,104 @@
+package kgo
+
+import (
+	"context"
+
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// OffsetStore is an external store for a group's committed offsets, used in
+// place of Kafka's __consumer_offsets topic; see WithOffsetStore.
+//
+// Group membership and partition assignment are still managed by Kafka; only
+// committing and fetching offsets uses the store. This allows for exactly once
+// processing into an external system: the offsets for processed records can be
+// committed in the same external transaction as the processing results, and
+// are fetched from that system whenever partitions are assigned.
+type OffsetStore interface {
+	// Fetch returns the committed offsets for the requested partitions in
+	// the group. Partitions that have no committed offset can be omitted,
+	// in which case the client starts consuming them at the
+	// ConsumeResetOffset. The epoch in each offset can be -1 if unknown.
+	Fetch(ctx context.Context, group string, partitions map[string][]int32) (map[string]map[int32]EpochOffset, error)
+
+	// Commit commits offsets for the group. As with Kafka commits, each
+	// offset is the offset of the next record to consume, i.e. one after
+	// the last processed record. If this returns an error, the commit is
+	// considered failed for all offsets.
+	Commit(ctx context.Context, group string, offsets map[string]map[int32]EpochOffset) error
+}
+
+// requestOffsetFetch issues an OffsetFetchRequest, or if using an
+// OffsetStore, fetches from the store and maps the offsets into a response.
+func (g *groupConsumer) requestOffsetFetch(ctx context.Context, req *kmsg.OffsetFetchRequest) (*kmsg.OffsetFetchResponse, error) {
+	store := g.cfg.offsetStore
+	if store == nil {
+		return req.RequestWith(ctx, g.cl)
+	}
+
+	partitions := make(map[string][]int32, len(req.Topics))
+	for _, t := range req.Topics {
+		partitions[t.Topic] = append(partitions[t.Topic], t.Partitions...)
+	}
+	offsets, err := store.Fetch(ctx, req.Group, partitions)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := req.ResponseKind().(*kmsg.OffsetFetchResponse)
+	resp.Version = 7 // the last version with top level topics and leader epochs (KIP-320)
+	for topic, ps := range partitions {
+		rt := kmsg.NewOffsetFetchResponseTopic()
+		rt.Topic = topic
+		for _, partition := range ps {
+			rp := kmsg.NewOffsetFetchResponseTopicPartition()
+			rp.Partition = partition
+			rp.Offset = -1
+			rp.LeaderEpoch = -1
+			if eo, ok := offsets[topic][partition]; ok {
+				rp.Offset = eo.Offset
+				rp.LeaderEpoch = eo.Epoch
+			}
+			rt.Partitions = append(rt.Partitions, rp)
+		}
+		resp.Topics = append(resp.Topics, rt)
+	}
+	return resp, nil
+}
+
+// requestOffsetCommit issues an OffsetCommitRequest, or if using an
+// OffsetStore, commits to the store and maps the result into a response.
+func (g *groupConsumer) requestOffsetCommit(ctx context.Context, req *kmsg.OffsetCommitRequest) (*kmsg.OffsetCommitResponse, error) {
+	store := g.cfg.offsetStore
+	if store == nil {
+		return req.RequestWith(ctx, g.cl)
+	}
+
+	offsets := make(map[string]map[int32]EpochOffset, len(req.Topics))
+	for _, t := range req.Topics {
+		ps := offsets[t.Topic]
+		if ps == nil {
+			ps = make(map[int32]EpochOffset, len(t.Partitions))
+			offsets[t.Topic] = ps
+		}
+		for _, p := range t.Partitions {
+			ps[p.Partition] = EpochOffset{p.LeaderEpoch, p.Offset}
+		}
+	}
+	if err := store.Commit(ctx, req.Group, offsets); err != nil {
+		return nil, err
+	}
+
+	resp := req.ResponseKind().(*kmsg.OffsetCommitResponse)
+	for _, t := range req.Topics {
+		rt := kmsg.NewOffsetCommitResponseTopic()
+		rt.Topic = t.Topic
+		for _, p := range t.Partitions {
+			rp := kmsg.NewOffsetCommitResponseTopicPartition()
+			rp.Partition = p.Partition
+			rt.Partitions = append(rt.Partitions, rp)
+		}
+		resp.Topics = append(resp.Topics, rt)
+	}
+	return resp, nil
+}
@@ -0,0 +1,216 @@
+package kgo
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// RecordProcessor processes records for a single partition, as returned from
+// the function passed to the PartitionProcessor option. Process is always
+// called from one goroutine at a time per partition, in offset order.
+//
+// If Process returns an error, the processor for that partition skips the
+// remainder of the records in the current poll and RunPartitionProcessors
+// returns the error once all other partitions finish their current records.
+type RecordProcessor interface {
+	Process(*Record) error
+}
+
+// RecordProcessorFunc is a function that implements RecordProcessor.
+type RecordProcessorFunc func(*Record) error
+
+// Process calls fn(r).
+func (fn RecordProcessorFunc) Process(r *Record) error { return fn(r) }
+
+// partitionWork is one poll's worth of records for a partition.
+type partitionWork struct {
+	rs   []*Record
+	done func(error)
+}
+
+// partitionWorker processes records for one partition on its own goroutine.
+// processed is only read once the worker is done with its current work.
+type partitionWorker struct {
+	work      chan partitionWork
+	processed EpochOffset // where to resume: just past the last processed record
+}
+
+// RunPartitionProcessors polls the client and processes all polled records
+// with the processors created by the PartitionProcessor option, using one
+// goroutine per partition. This returns when the context is canceled, the
+// client is closed, or a processor returns an error.
+//
+// Each poll is fanned out to its partitions' goroutines, and the next poll
+// only happens once every partition has processed its records. This gives
+// per-partition parallelism with ordered delivery. The offset just past each
+// partition's last successfully processed record is tracked, and only those
+// offsets are committed: with AutoCommitMarks, processed offsets are marked;
+// otherwise, if a processor fails, its partition's uncommitted offset is
+// rewound to the last processed record so that autocommitting (or
+// CommitUncommittedOffsets) does not commit records that were polled but
+// never processed. Records that were skipped due to a failure are not
+// re-polled by a later RunPartitionProcessors on the same client; they are
+// consumed again once the partition is assigned from its committed offset.
+//
+// When group consuming, partitions that are no longer assigned (because they
+// were revoked or lost) have their goroutines stopped after the next poll; if
+// a partition is assigned again, a new processor is created for it. If
+// BlockRebalanceOnPoll is used, AllowRebalance is called after each poll is
+// processed.
+//
+// Fetch errors that are not the client closing or the context being canceled
+// are logged and otherwise ignored.
+func (cl *Client) RunPartitionProcessors(ctx context.Context) error {
+	newProcessor := cl.cfg.partitionProcessor
+	if newProcessor == nil {
+		return errors.New("RunPartitionProcessors requires the PartitionProcessor option")
+	}
+
+	workers := make(map[string]map[int32]*partitionWorker)
+	var wg sync.WaitGroup
+	stop := func(w *partitionWorker) {
+		close(w.work)
+	}
+	defer func() {
+		for _, ps := range workers {
+			for _, w := range ps {
+				stop(w)
+			}
+		}
+		wg.Wait()
+		cl.AllowRebalance()
+	}()
+
+	for {
+		fetches := cl.PollFetches(ctx)
+		if fetches.IsClientClosed() {
+			return ErrClientClosed
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		fetches.EachError(func(t string, p int32, err error) {
+			cl.cfg.logger.Log(LogLevelWarn, "partition processor fetch error", "topic", t, "partition", p, "err", err)
+		})
+
+		// Workers are idle between polls; we stop any for partitions
+		// we no longer own before handing out new work.
+		if g := cl.consumer.g; g != nil {
+			assigned := g.nowAssigned.read()
+			for t, ps := range workers {
+				for p, w := range ps {
+					if !containsPartition(assigned[cl.consumer.wireTopic(t)], p) {
+						cl.cfg.logger.Log(LogLevelDebug, "stopping partition processor for no longer assigned partition", "topic", t, "partition", p)
+						stop(w)
+						delete(ps, p)
+					}
+				}
+				if len(ps) == 0 {
+					delete(workers, t)
+				}
+			}
+		}
+
+		var (
+			pollWg   sync.WaitGroup
+			errMu    sync.Mutex
+			firstErr error
+			polled   = make(map[*partitionWorker]EpochOffset)
+		)
+		done := func(err error) {
+			if err != nil {
+				errMu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				errMu.Unlock()
+			}
+			pollWg.Done()
+		}
+
+		fetches.EachPartition(func(p FetchTopicPartition) {
+			if len(p.Records) == 0 {
+				return
+			}
+			ps := workers[p.Topic]
+			if ps == nil {
+				ps = make(map[int32]*partitionWorker)
+				workers[p.Topic] = ps
+			}
+			w := ps[p.Partition]
+			if w == nil {
+				w = &partitionWorker{work: make(chan partitionWork, 1)}
+				ps[p.Partition] = w
+				proc := newProcessor(p.Topic, p.Partition)
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					w.process(proc)
+				}()
+			}
+			final := p.Records[len(p.Records)-1]
+			polled[w] = EpochOffset{final.LeaderEpoch, final.Offset + 1}
+			pollWg.Add(1)
+			w.work <- partitionWork{p.Records, done}
+		})
+		pollWg.Wait()
+
+		var processed, rewind map[string]map[int32]EpochOffset
+		add := func(m *map[string]map[int32]EpochOffset, t string, p int32, o EpochOffset) {
+			if *m == nil {
+				*m = make(map[string]map[int32]EpochOffset)
+			}
+			if (*m)[t] == nil {
+				(*m)[t] = make(map[int32]EpochOffset)
+			}
+			(*m)[t][p] = o
+		}
+		for t, ps := range workers {
+			for p, w := range ps {
+				end, ok := polled[w]
+				if !ok {
+					continue
+				}
+				add(&processed, t, p, w.processed)
+				if w.processed != end {
+					add(&rewind, t, p, w.processed)
+				}
+			}
+		}
+		cl.MarkCommitOffsets(processed) // no-op unless AutoCommitMarks
+		if g := cl.consumer.g; g != nil && len(rewind) > 0 {
+			g.rewindUncommitted(mapTopicKeys(&cl.consumer, rewind, cl.consumer.wireTopic))
+		}
+
+		cl.AllowRebalance() // no-op if not BlockRebalanceOnPoll
+		if firstErr != nil {
+			return firstErr
+		}
+	}
+}
+
+func (w *partitionWorker) process(proc RecordProcessor) {
+	for work := range w.work {
+		first := work.rs[0]
+		w.processed = EpochOffset{first.LeaderEpoch, first.Offset}
+
+		var err error
+		for _, r := range work.rs {
+			if err = proc.Process(r); err != nil {
+				break
+			}
+			w.processed = EpochOffset{r.LeaderEpoch, r.Offset + 1}
+		}
+		work.done(err)
+	}
+}
+
+func containsPartition(ps []int32, p int32) bool {
+	for _, have := range ps {
+		if have == p {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,276 @@
+package kgo
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// coalesceMaxRecords is the maximum number of records we stage before the
+// producing goroutine flushes the stage itself. If the staged records do not
+// all fit in the buffer, producing blocks until fewer than this many records
+// are staged.
+const coalesceMaxRecords = 1024
+
+// produceCoalesce stages produced records for a short window and then buffers
+// them all at once, taking the producer's buffer lock once per window rather
+// than once per record; see ProduceCoalesceWindow.
+//
+// Staging pushes onto a lock free stack, so producing a record takes no lock
+// at all. Flushing swaps out the stack and buffers everything that fits in
+// the max buffered limits under one producer lock. Anything that does not fit
+// overflows to a queue that one goroutine buffers in order, blocking as
+// Produce normally would; nothing blocks while holding mu.
+type produceCoalesce struct {
+	cl *Client
+
+	head   atomic.Pointer[coalescedRec] // newest staged record first
+	staged atomic.Int64                 // staged and overflowing records; see BufferedProduceRecords
+
+	closed   atomic.Bool
+	closeErr error // set before closed is stored
+
+	mu       sync.Mutex      // serializes flushes, ensuring staged records are buffered in order
+	pending  []*coalescedRec // records taken off the stack by abort, buffered before the stack
+	overflow []*coalescedRec // records waiting for buffer space, buffered by drain
+	draining bool            // whether drain is running
+	space    chan struct{}   // closed and replaced whenever records are unstaged
+}
+
+type coalescedRec struct {
+	ctx      context.Context
+	promise  func(*Record, error)
+	r        *Record
+	userSize int64
+	block    bool
+
+	next *coalescedRec
+}
+
+// stage stages a validated record, returning false if the record was not
+// staged and must be buffered normally.
+func (c *produceCoalesce) stage(ctx context.Context, r *Record, promise func(*Record, error), userSize int64, block bool) bool {
+	if c.closed.Load() {
+		return false
+	}
+	n := c.staged.Add(1)
+	s := &coalescedRec{ctx: ctx, promise: promise, r: r, userSize: userSize, block: block}
+	for {
+		s.next = c.head.Load()
+		if c.head.CompareAndSwap(s.next, s) {
+			break
+		}
+	}
+
+	// If we raced with closing, our record may have been pushed after
+	// the close failed everything staged; we fail it ourselves.
+	if c.closed.Load() {
+		c.fail(c.closeErr, false)
+		return true
+	}
+
+	// The first record onto an empty stack starts the window. A timer
+	// that fires after its records were already flushed (because the
+	// stage filled up) just flushes whatever was staged since, early.
+	if s.next == nil {
+		time.AfterFunc(c.cl.cfg.produceCoalesceWindow, c.flush)
+	}
+	if n >= coalesceMaxRecords {
+		c.flush()
+		if block {
+			c.waitSpace(ctx)
+		}
+	}
+	return true
+}
+
+// waitSpace waits until fewer than coalesceMaxRecords are staged, applying
+// backpressure to producers while staged records overflow the buffer.
+func (c *produceCoalesce) waitSpace(ctx context.Context) {
+	for {
+		c.mu.Lock()
+		if c.staged.Load() < coalesceMaxRecords || c.closed.Load() {
+			c.mu.Unlock()
+			return
+		}
+		space := c.lockedSpace()
+		c.mu.Unlock()
+
+		select {
+		case <-space:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *produceCoalesce) lockedSpace() chan struct{} {
+	if c.space == nil {
+		c.space = make(chan struct{})
+	}
+	return c.space
+}
+
+// lockedTake takes everything pending and on the stack, in the order the
+// records were staged.
+func (c *produceCoalesce) lockedTake() []*coalescedRec {
+	var stack []*coalescedRec
+	for s := c.head.Swap(nil); s != nil; s = s.next {
+		stack = append(stack, s)
+	}
+	staged := c.pending
+	c.pending = nil
+	for i := len(stack) - 1; i >= 0; i-- {
+		stack[i].next = nil
+		staged = append(staged, stack[i])
+	}
+	return staged
+}
+
+// unstage removes n records from the staged count, waking producers waiting
+// for space as well as Flush.
+func (c *produceCoalesce) unstage(n int64) {
+	if n == 0 {
+		return
+	}
+	c.mu.Lock()
+	c.staged.Add(-n)
+	if c.space != nil {
+		close(c.space)
+		c.space = nil
+	}
+	c.mu.Unlock()
+
+	// Flush checks the staged count under the producer lock, so we
+	// broadcast under the lock to not race with Flush starting to wait.
+	if p := &c.cl.producer; p.flushing.Load() > 0 {
+		p.mu.Lock()
+		p.c.Broadcast()
+		p.mu.Unlock()
+	}
+}
+
+// flush buffers everything that is staged. Records that fit within the max
+// buffered limits are accounted for under one lock; anything remaining
+// overflows to be buffered in order by drain, which blocks (or fails) as
+// Produce normally does.
+func (c *produceCoalesce) flush() {
+	c.mu.Lock()
+	staged := c.lockedTake()
+	if len(staged) == 0 {
+		c.mu.Unlock()
+		return
+	}
+	if c.draining {
+		c.overflow = append(c.overflow, staged...)
+		c.mu.Unlock()
+		return
+	}
+
+	cl := c.cl
+	p := &cl.producer
+
+	seqs := make([]uint64, 0, len(staged))
+	p.mu.Lock()
+	for _, s := range staged {
+		nextBufRecs := p.bufferedRecords + 1
+		nextBufBytes := p.bufferedBytes + s.userSize
+		if p.blocked.Load() > 0 ||
+			nextBufRecs > cl.cfg.maxBufferedRecords ||
+			cl.cfg.maxBufferedBytes > 0 && nextBufBytes > cl.cfg.maxBufferedBytes {
+			break
+		}
+		p.bufferedRecords = nextBufRecs
+		p.bufferedBytes = nextBufBytes
+		p.produceSeq++
+		seqs = append(seqs, p.produceSeq)
+	}
+	waterChanged := p.lockedUpdateWater()
+	p.mu.Unlock()
+
+	if waterChanged {
+		p.notifyWater()
+	}
+
+	for i, seq := range seqs {
+		s := staged[i]
+		cl.partitionRecord(promisedRec{s.ctx, s.promise, s.r, seq})
+	}
+	if rest := staged[len(seqs):]; len(rest) > 0 {
+		c.overflow = rest
+		c.draining = true
+		go c.drain()
+	}
+	c.mu.Unlock()
+
+	c.unstage(int64(len(seqs)))
+}
+
+// drain buffers overflowing records one at a time, in order, blocking on the
+// max buffered limits without holding mu.
+func (c *produceCoalesce) drain() {
+	for {
+		c.mu.Lock()
+		if len(c.overflow) == 0 {
+			c.overflow = nil
+			c.draining = false
+			c.mu.Unlock()
+			return
+		}
+		s := c.overflow[0]
+		c.overflow[0] = nil
+		c.overflow = c.overflow[1:]
+		c.mu.Unlock()
+
+		// The record stays counted as staged until it is buffered
+		// or failed, so that Flush does not see it as gone early.
+		c.cl.bufferProduce(s.ctx, s.r, s.promise, s.userSize, s.block, 0, true)
+		c.unstage(1)
+	}
+}
+
+// fail fails everything that is staged or overflowing, and if closing, causes
+// all future records to skip staging.
+func (c *produceCoalesce) fail(err error, closing bool) {
+	if closing {
+		c.closeErr = err
+		c.closed.Store(true)
+	}
+	c.mu.Lock()
+	staged := append(c.overflow, c.lockedTake()...)
+	c.overflow = nil
+	c.mu.Unlock()
+
+	p := &c.cl.producer
+	for _, s := range staged {
+		p.promiseRecordBeforeBuf(promisedRec{ctx: s.ctx, promise: s.promise, Record: s.r}, err)
+	}
+	c.unstage(int64(len(staged)))
+}
+
+// abort removes r from the stage, if it is staged or overflowing.
+func (c *produceCoalesce) abort(r *Record) (promisedRec, bool) {
+	c.mu.Lock()
+	c.pending = c.lockedTake()
+	var found *coalescedRec
+	for _, recs := range []*[]*coalescedRec{&c.pending, &c.overflow} {
+		for i, s := range *recs {
+			if s.r == r {
+				found = s
+				*recs = append((*recs)[:i], (*recs)[i+1:]...)
+				break
+			}
+		}
+		if found != nil {
+			break
+		}
+	}
+	c.mu.Unlock()
+
+	if found == nil {
+		return promisedRec{}, false
+	}
+	c.unstage(1)
+	return promisedRec{ctx: found.ctx, promise: found.promise, Record: found.r}, true
+}
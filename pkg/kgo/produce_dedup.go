@@ -0,0 +1,97 @@
+package kgo
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// produceDedup is a bounded LRU of recently produced idempotency keys, used
+// in ProduceOnce.
+type produceDedup struct {
+	mu    sync.Mutex
+	keys  map[string]*list.Element
+	order list.List // front is most recent; values are dedupEntry
+}
+
+type dedupEntry struct {
+	key string
+	at  time.Time
+}
+
+// tryAdd adds the key if it is not present within the window, returning
+// whether the key was added.
+func (d *produceDedup) tryAdd(key string, now time.Time, window time.Duration, max int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.keys == nil {
+		d.keys = make(map[string]*list.Element)
+	}
+	if e, ok := d.keys[key]; ok {
+		if now.Sub(e.Value.(dedupEntry).at) < window {
+			return false
+		}
+		d.order.Remove(e)
+		delete(d.keys, key)
+	}
+	d.keys[key] = d.order.PushFront(dedupEntry{key, now})
+	for d.order.Len() > max {
+		oldest := d.order.Back()
+		d.order.Remove(oldest)
+		delete(d.keys, oldest.Value.(dedupEntry).key)
+	}
+	return true
+}
+
+// remove removes the key if it was added at the given time, allowing a
+// failed produce to be retried.
+func (d *produceDedup) remove(key string, at time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if e, ok := d.keys[key]; ok && e.Value.(dedupEntry).at.Equal(at) {
+		d.order.Remove(e)
+		delete(d.keys, key)
+	}
+}
+
+// ProduceOnce produces a record unless a record with the same idempotency key
+// was produced with ProduceOnce within the window configured with
+// ProduceDedupWindow. If the key was seen recently, the record is not
+// produced and the promise is called with ErrDuplicateProduce. If producing
+// fails, the key is forgotten so that the record can be produced again. See
+// the Produce documentation for an in depth description of how producing
+// works.
+//
+// This is application level deduplication on top of Kafka's idempotent
+// producing, which does not survive a client restart and does not cover the
+// same logical event being passed to Produce twice. This is useful for at
+// least once upstream sources that may replay events. Keys are only tracked
+// in memory and are not shared across clients.
+//
+// If ProduceDedupWindow is not used, this is equivalent to Produce.
+func (cl *Client) ProduceOnce(ctx context.Context, key string, r *Record, promise func(*Record, error)) {
+	window, max := cl.cfg.dedupWindow, cl.cfg.dedupMaxKeys
+	if window <= 0 {
+		cl.Produce(ctx, r, promise)
+		return
+	}
+	if promise == nil {
+		promise = noPromise
+	}
+
+	d := &cl.producer.dedup
+	now := cl.cfg.clock.Now()
+	if !d.tryAdd(key, now, window, max) {
+		promise(r, ErrDuplicateProduce)
+		return
+	}
+	cl.Produce(ctx, r, func(r *Record, err error) {
+		if err != nil {
+			d.remove(key, now)
+		}
+		promise(r, err)
+	})
+}
@@ -0,0 +1,257 @@
+package kgo
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/twmb/franz-go/pkg/kbin"
+)
+
+// produceSpill spills records to disk when the client has the maximum amount
+// of records or bytes buffered, and replays them in order once there is space.
+//
+// Only the key, value, and headers of a record are written to disk; the record
+// itself, its context, and its promise are kept in memory so that the promise
+// can be called with the same record that was produced. While any record is
+// spilled, all new records are spilled as well to preserve ordering.
+type produceSpill struct {
+	cl *Client
+
+	mu        sync.Mutex
+	file      *os.File // lazily created on first spill
+	writeAt   int64
+	queue     []spilledRec
+	replaying bool
+	closed    bool
+}
+
+type spilledRec struct {
+	ctx     context.Context
+	promise func(*Record, error)
+	r       *Record
+	seq     uint64 // assigned when spilled, for ProduceBarrier
+	at      int64
+	n       int
+}
+
+// maybeSpill spills the record if we are over the max buffered limits or if
+// records are already spilled, returning whether the record was handled
+// (spilled or failed). If spilling fails (the spill file is full or cannot be
+// written) and nothing is spilled, the record is not spilled and must be
+// buffered normally. If records are already spilled, buffering this record in
+// memory would reorder it ahead of them, so the record is failed instead:
+// with ErrMaxBuffered if the spill file is full, or with the spill error.
+func (s *produceSpill) maybeSpill(ctx context.Context, r *Record, promise func(*Record, error), userSize int64) bool {
+	spilled, err := s.spill(ctx, r, promise, userSize)
+	if err != nil {
+		s.cl.producer.promiseRecordBeforeBuf(promisedRec{ctx: ctx, promise: promise, Record: r}, err)
+		return true
+	}
+	return spilled
+}
+
+func (s *produceSpill) spill(ctx context.Context, r *Record, promise func(*Record, error), userSize int64) (bool, error) {
+	cl := s.cl
+	p := &cl.producer
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false, nil
+	}
+	queued := len(s.queue) > 0
+	if !queued {
+		p.mu.Lock()
+		over := p.bufferedRecords+1 > cl.cfg.maxBufferedRecords ||
+			cl.cfg.maxBufferedBytes > 0 && p.bufferedBytes+userSize > cl.cfg.maxBufferedBytes
+		p.mu.Unlock()
+		if !over {
+			return false, nil
+		}
+	}
+	fallback := func(err error) (bool, error) {
+		if queued {
+			return false, err
+		}
+		return false, nil
+	}
+
+	buf := encodeSpilledRecord(r)
+	if s.writeAt+int64(len(buf)) > cl.cfg.spillMaxBytes {
+		cl.cfg.logger.Log(LogLevelWarn, "produce disk spill is full",
+			"spill_bytes", s.writeAt,
+			"max_spill_bytes", cl.cfg.spillMaxBytes,
+			"failing_record", queued,
+		)
+		return fallback(ErrMaxBuffered)
+	}
+	if s.file == nil {
+		f, err := os.CreateTemp(cl.cfg.spillDir, "kgo-spill-*")
+		if err != nil {
+			cl.cfg.logger.Log(LogLevelError, "unable to create produce disk spill file, buffering record in memory", "err", err)
+			return false, nil // nothing can be queued without a file
+		}
+		s.file = f
+	}
+	if _, err := s.file.WriteAt(buf, s.writeAt); err != nil {
+		cl.cfg.logger.Log(LogLevelError, "unable to write to produce disk spill file", "err", err, "failing_record", queued)
+		return fallback(fmt.Errorf("unable to spill record: %w", err))
+	}
+
+	p.mu.Lock()
+	p.spilledRecords++
+	p.produceSeq++
+	seq := p.produceSeq
+	p.mu.Unlock()
+
+	s.queue = append(s.queue, spilledRec{
+		ctx:     ctx,
+		promise: promise,
+		r:       r,
+		seq:     seq,
+		at:      s.writeAt,
+		n:       len(buf),
+	})
+	s.writeAt += int64(len(buf))
+	r.Key, r.Value, r.Headers = nil, nil, nil
+
+	if !s.replaying {
+		s.replaying = true
+		go s.replay()
+	}
+	return true, nil
+}
+
+// replay produces spilled records in order, blocking for space in the buffer
+// for each. A record is only removed from the queue once it is buffered (or
+// failed), ensuring new records continue to be spilled until then.
+func (s *produceSpill) replay() {
+	cl := s.cl
+	p := &cl.producer
+	for {
+		s.mu.Lock()
+		if len(s.queue) == 0 {
+			s.replaying = false
+			s.resetFile()
+			s.mu.Unlock()
+			return
+		}
+		sr := s.queue[0]
+		s.mu.Unlock()
+
+		if err := s.restore(sr); err != nil {
+			p.promiseRecordBeforeBuf(promisedRec{sr.ctx, sr.promise, sr.r, sr.seq}, err)
+		} else {
+			cl.bufferProduce(sr.ctx, sr.r, sr.promise, sr.r.userSize(), true, sr.seq, false)
+		}
+
+		s.mu.Lock()
+		s.queue[0] = spilledRec{}
+		s.queue = s.queue[1:]
+		s.mu.Unlock()
+	}
+}
+
+// abort removes a spilled record that is not actively being replayed,
+// restoring its key, value, and headers, for AbortRecord.
+func (s *produceSpill) abort(r *Record) (promisedRec, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var start int
+	if s.replaying {
+		start = 1 // owned by the replay goroutine
+	}
+	for i := start; i < len(s.queue); i++ {
+		sr := s.queue[i]
+		if sr.r != r {
+			continue
+		}
+		s.restore(sr) //nolint:errcheck // best effort to return the record as it was produced
+		s.queue = append(s.queue[:i], s.queue[i+1:]...)
+		return promisedRec{sr.ctx, sr.promise, sr.r, sr.seq}, true
+	}
+	return promisedRec{}, false
+}
+
+// close fails all spilled records that are not actively being replayed and
+// removes the spill file if nothing is replaying.
+func (s *produceSpill) close() {
+	s.mu.Lock()
+	s.closed = true
+	var start int
+	if s.replaying {
+		start = 1 // owned by the replay goroutine
+	}
+	var failing []spilledRec
+	if len(s.queue) > start {
+		failing = append(failing, s.queue[start:]...)
+		s.queue = s.queue[:start]
+	}
+	for _, sr := range failing {
+		s.restore(sr) //nolint:errcheck // best effort to return the record as it was produced
+	}
+	if !s.replaying {
+		s.resetFile()
+	}
+	s.mu.Unlock()
+
+	p := &s.cl.producer
+	for _, sr := range failing {
+		p.promiseRecordBeforeBuf(promisedRec{sr.ctx, sr.promise, sr.r, sr.seq}, ErrClientClosed)
+	}
+}
+
+// resetFile truncates the spill file once everything has been replayed, or
+// removes it if we are closed. This must be called with s.mu held.
+func (s *produceSpill) resetFile() {
+	if s.file == nil {
+		return
+	}
+	s.writeAt = 0
+	if !s.closed {
+		if err := s.file.Truncate(0); err == nil {
+			return
+		}
+	}
+	s.file.Close()
+	os.Remove(s.file.Name())
+	s.file = nil
+}
+
+// restore reads a spilled record's key, value, and headers back from disk.
+func (s *produceSpill) restore(sr spilledRec) error {
+	buf := make([]byte, sr.n)
+	if _, err := s.file.ReadAt(buf, sr.at); err != nil {
+		return fmt.Errorf("unable to read spilled record: %w", err)
+	}
+	b := kbin.Reader{Src: buf}
+	sr.r.Key = b.NullableBytes()
+	sr.r.Value = b.NullableBytes()
+	if n := b.ArrayLen(); n > 0 {
+		sr.r.Headers = make([]RecordHeader, 0, n)
+		for i := int32(0); i < n; i++ {
+			sr.r.Headers = append(sr.r.Headers, RecordHeader{
+				Key:   b.String(),
+				Value: b.NullableBytes(),
+			})
+		}
+	}
+	if err := b.Complete(); err != nil {
+		return fmt.Errorf("unable to decode spilled record: %w", err)
+	}
+	return nil
+}
+
+func encodeSpilledRecord(r *Record) []byte {
+	buf := make([]byte, 0, r.userSize()+16+8*int64(len(r.Headers)))
+	buf = kbin.AppendNullableBytes(buf, r.Key)
+	buf = kbin.AppendNullableBytes(buf, r.Value)
+	buf = kbin.AppendArrayLen(buf, len(r.Headers))
+	for _, h := range r.Headers {
+		buf = kbin.AppendString(buf, h.Key)
+		buf = kbin.AppendNullableBytes(buf, h.Value)
+	}
+	return buf
+}
@@ -16,6 +16,10 @@ import (
 type producer struct {
 	inflight atomicI64 // high 16: # waiters, low 48: # inflight
 
+	// inflightSem, if non-nil, limits the number of produce requests in
+	// flight across all brokers; see MaxInFlightProduceRequests.
+	inflightSem chan struct{}
+
 	// mu and c are used for flush and drain notifications; mu is used for
 	// a few other tight locks.
 	mu sync.Mutex
@@ -24,6 +28,29 @@ type producer struct {
 	bufferedRecords int64
 	bufferedBytes   int64
 
+	// produceSeq is incremented for every buffered (or spilled) record and
+	// is used to snapshot what has been produced in ProduceBarrier.
+	// barriers are all active barriers waiting for records up to their
+	// sequence number.
+	produceSeq uint64
+	barriers   []*produceBarrier
+
+	dedup produceDedup // used in ProduceOnce
+
+	spill          *produceSpill // non-nil if using ProduceDiskSpill
+	spilledRecords int64         // guarded by mu
+	blockedSpilled int64         // guarded by mu; spilled records that are also counted in blocked
+
+	coalesce      *produceCoalesce // non-nil if using ProduceCoalesceWindow
+	blockedStaged int64            // guarded by mu; staged records that are also counted in blocked
+
+	// atHighWater is whether the buffer is at its high water mark, and
+	// is guarded by mu. notifiedHighWater is the state last passed to
+	// the user, guarded by waterMu; see notifyWater.
+	atHighWater       bool
+	waterMu           sync.Mutex
+	notifiedHighWater bool
+
 	cl *Client
 
 	topicsMu sync.Mutex // locked to prevent concurrent updates; reads are always atomic
@@ -89,7 +116,16 @@ type producer struct {
 func (cl *Client) BufferedProduceRecords() int64 {
 	cl.producer.mu.Lock()
 	defer cl.producer.mu.Unlock()
-	return cl.producer.bufferedRecords + int64(cl.producer.blocked.Load())
+	return cl.producer.bufferedRecords + int64(cl.producer.blocked.Load()) + cl.producer.spilledRecords - cl.producer.blockedSpilled + cl.producer.stagedRecords() - cl.producer.blockedStaged
+}
+
+// stagedRecords returns the number of records staged with
+// ProduceCoalesceWindow that are not yet buffered.
+func (p *producer) stagedRecords() int64 {
+	if p.coalesce == nil {
+		return 0
+	}
+	return p.coalesce.staged.Load()
 }
 
 // BufferedProduceBytes returns the number of bytes currently buffered for
@@ -118,6 +154,15 @@ func (p *producer) init(cl *Client) {
 		err:   errReloadProducerID,
 	})
 	p.c = sync.NewCond(&p.mu)
+	if cl.cfg.spillDir != "" {
+		p.spill = &produceSpill{cl: cl}
+	}
+	if cl.cfg.produceCoalesceWindow > 0 {
+		p.coalesce = &produceCoalesce{cl: cl}
+	}
+	if n := cl.cfg.maxInflightProduceRequests; n > 0 {
+		p.inflightSem = make(chan struct{}, n)
+	}
 
 	inithooks := func() {
 		if p.hooks == nil {
@@ -197,7 +242,7 @@ func (p *producer) purgeTopics(topics []string) {
 			go func() {
 				r.mu.Lock()
 				defer r.mu.Unlock()
-				r.failAllRecords(errPurged)
+				r.failAllRecords(errPurged, false)
 			}()
 		}
 	}
@@ -265,6 +310,108 @@ func (cl *Client) ProduceSync(ctx context.Context, rs ...*Record) ProduceResults
 	return results
 }
 
+// ProduceFuture is the result of a record produced with Client.ProduceFuture.
+type ProduceFuture struct {
+	done chan struct{}
+	r    *Record
+	err  error
+}
+
+// Done returns a channel that is closed once the record has been produced or
+// has failed, after which Wait returns immediately.
+func (f *ProduceFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait blocks until the record has been produced or has failed, returning
+// the record and any produce error. Wait can be called any number of times.
+func (f *ProduceFuture) Wait() (*Record, error) {
+	<-f.done
+	return f.r, f.err
+}
+
+// ProduceFuture produces a record and returns a future for its result,
+// rather than calling a promise. See the Produce documentation for an in
+// depth description of how producing works.
+//
+// This is useful to store futures and wait for them in a controlled order,
+// such as waiting for all futures at a batch boundary, without managing a
+// WaitGroup and shared error state around a promise.
+func (cl *Client) ProduceFuture(ctx context.Context, r *Record) *ProduceFuture {
+	f := &ProduceFuture{done: make(chan struct{})}
+	cl.Produce(ctx, r, func(r *Record, err error) {
+		f.r, f.err = r, err
+		close(f.done)
+	})
+	return f
+}
+
+// ProduceAndWaitConsumable synchronously produces a record and then waits
+// until the record is consumable: that is, until the end offset of the
+// record's partition is past the record's offset. The end offset is checked
+// with ListOffsets using the client's isolation level (FetchIsolationLevel),
+// meaning the high watermark is used for ReadUncommitted and the last stable
+// offset is used for ReadCommitted. This returns the record's epoch and
+// offset.
+//
+// This is meant for tests and verification flows that produce a record and
+// then want to consume it without racing offset propagation. If producing
+// transactionally with ReadCommitted, the record does not become consumable
+// until the transaction is committed, so this function will block until the
+// context is canceled.
+func (cl *Client) ProduceAndWaitConsumable(ctx context.Context, r *Record) (EpochOffset, error) {
+	if err := cl.ProduceSync(ctx, r).FirstErr(); err != nil {
+		return EpochOffset{}, err
+	}
+	produced := EpochOffset{Epoch: r.LeaderEpoch, Offset: r.Offset}
+	topic := r.Topic
+	if fn := cl.cfg.produceTopicMapper; fn != nil {
+		topic = fn(topic)
+	}
+
+	req := kmsg.NewPtrListOffsetsRequest()
+	req.ReplicaID = -1
+	req.IsolationLevel = int8(cl.cfg.isolationLevel.load())
+	rt := kmsg.NewListOffsetsRequestTopic()
+	rt.Topic = topic
+	rp := kmsg.NewListOffsetsRequestTopicPartition()
+	rp.Partition = r.Partition
+	rp.CurrentLeaderEpoch = -1
+	rp.Timestamp = -1 // latest
+	rt.Partitions = append(rt.Partitions, rp)
+	req.Topics = append(req.Topics, rt)
+
+	for tries := 1; ; tries++ {
+		resp, err := req.RequestWith(ctx, cl)
+		if err == nil {
+			for _, t := range resp.Topics {
+				for _, p := range t.Partitions {
+					if t.Topic != topic || p.Partition != r.Partition {
+						continue
+					}
+					if err = kerr.ErrorForCode(p.ErrorCode); err == nil && p.Offset > produced.Offset {
+						return produced, nil
+					}
+				}
+			}
+		}
+		if err != nil && !kerr.IsRetriable(err) && !isRetryableBrokerErr(err) {
+			return produced, err
+		}
+
+		after := time.NewTimer(cl.cfg.retryBackoff(tries))
+		select {
+		case <-after.C:
+		case <-ctx.Done():
+			after.Stop()
+			return produced, ctx.Err()
+		case <-cl.ctx.Done():
+			after.Stop()
+			return produced, ErrClientClosed
+		}
+	}
+}
+
 // FirstErrPromise is a helper type to capture only the first failing error
 // when producing a batch of records with this type's Promise function.
 //
@@ -379,6 +526,47 @@ func (cl *Client) Produce(
 	cl.produce(ctx, r, promise, true)
 }
 
+// ProduceIfEpoch is the same as Produce, but first loads the producer ID (see
+// ProducerID) and fails the record immediately with ErrProducerEpochMismatch
+// if the producer epoch is not the expected epoch. If the producer ID cannot
+// be loaded, the record is failed with that error.
+//
+// This is useful for advanced fencing, where an external system tracks which
+// producer epoch is valid and wants to assert the epoch still matches at
+// produce time, rather than discovering a fence only when a batch is rejected.
+// Note that this check is done only before buffering: if the epoch is bumped
+// after the record is buffered (i.e., if the producer ID is reset due to an
+// error), the record is produced with the new epoch.
+func (cl *Client) ProduceIfEpoch(
+	ctx context.Context,
+	expectedEpoch int16,
+	r *Record,
+	promise func(*Record, error),
+) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	_, epoch, err := cl.ProducerID(ctx)
+	if err == nil && epoch != expectedEpoch {
+		err = fmt.Errorf("%w: expected epoch %d, current epoch %d", ErrProducerEpochMismatch, expectedEpoch, epoch)
+	}
+	if err == nil {
+		cl.produce(ctx, r, promise, true)
+		return
+	}
+
+	if promise == nil {
+		promise = noPromise
+	}
+	p := &cl.producer
+	if p.hooks != nil && len(p.hooks.buffered) > 0 {
+		for _, h := range p.hooks.buffered {
+			h.OnProduceRecordBuffered(r)
+		}
+	}
+	p.promiseRecordBeforeBuf(promisedRec{ctx: ctx, promise: promise, Record: r}, err)
+}
+
 func (cl *Client) produce(
 	ctx context.Context,
 	r *Record,
@@ -397,6 +585,10 @@ func (cl *Client) produce(
 	if r.Topic == "" {
 		r.Topic = cl.cfg.defaultProduceTopic
 	}
+	if fn := cl.cfg.produceTopicMapper; fn != nil && r.Topic != "" {
+		r.logicalTopic = r.Topic
+		r.Topic = fn(r.Topic)
+	}
 
 	p := &cl.producer
 	if p.hooks != nil && len(p.hooks.buffered) > 0 {
@@ -407,19 +599,67 @@ func (cl *Client) produce(
 
 	// We can now fail the rec after the buffered hook.
 	if r.Topic == "" {
-		p.promiseRecordBeforeBuf(promisedRec{ctx, promise, r}, errNoTopic)
+		p.promiseRecordBeforeBuf(promisedRec{ctx: ctx, promise: promise, Record: r}, errNoTopic)
+		return
+	}
+	if cl.cfg.requireConnection && !cl.ensureConnected(ctx) {
+		p.promiseRecordBeforeBuf(promisedRec{ctx: ctx, promise: promise, Record: r}, ErrNotConnected)
 		return
 	}
+	if cl.cfg.recordValidator != nil {
+		if err := cl.cfg.recordValidator(r); err != nil {
+			p.promiseRecordBeforeBuf(promisedRec{ctx: ctx, promise: promise, Record: r}, err)
+			return
+		}
+	}
 	if cl.cfg.txnID != nil && !p.producingTxn.Load() {
-		p.promiseRecordBeforeBuf(promisedRec{ctx, promise, r}, errNotInTransaction)
+		p.promiseRecordBeforeBuf(promisedRec{ctx: ctx, promise: promise, Record: r}, errNotInTransaction)
 		return
 	}
+	if cl.cfg.recordCodec != nil {
+		topic := r.Topic
+		if r.logicalTopic != "" {
+			topic = r.logicalTopic
+		}
+		v, err := cl.cfg.recordCodec.Encode(topic, r.Value)
+		if err != nil {
+			p.promiseRecordBeforeBuf(promisedRec{ctx: ctx, promise: promise, Record: r}, err)
+			return
+		}
+		r.Value = v
+	}
 
 	userSize := r.userSize()
 	if cl.cfg.maxBufferedBytes > 0 && userSize > cl.cfg.maxBufferedBytes {
-		p.promiseRecordBeforeBuf(promisedRec{ctx, promise, r}, kerr.MessageTooLarge)
+		p.promiseRecordBeforeBuf(promisedRec{ctx: ctx, promise: promise, Record: r}, kerr.MessageTooLarge)
+		return
+	}
+
+	if p.spill != nil && p.spill.maybeSpill(ctx, r, promise, userSize) {
+		return
+	}
+	if p.coalesce != nil && p.coalesce.stage(ctx, r, promise, userSize, block) {
 		return
 	}
+	cl.bufferProduce(ctx, r, promise, userSize, block, 0, false)
+}
+
+// bufferProduce buffers a validated record, blocking or failing the record if
+// we are at the maximum buffered records or bytes. If the record was already
+// assigned a sequence number (it was spilled to disk), seq is that number;
+// otherwise seq is zero and the record is assigned one once it is buffered.
+// If the record is still counted as staged with ProduceCoalesceWindow until
+// this returns, staged is true.
+func (cl *Client) bufferProduce(
+	ctx context.Context,
+	r *Record,
+	promise func(*Record, error),
+	userSize int64,
+	block bool,
+	seq uint64,
+	staged bool,
+) {
+	p := &cl.producer
 
 	// We have to grab the produce lock to check if this record will exceed
 	// configured limits. We try to keep the logic tight since this is
@@ -440,7 +680,7 @@ func (cl *Client) produce(
 	if overMaxRecs || overMaxBytes {
 		if !block || cl.cfg.manualFlushing {
 			p.mu.Unlock()
-			p.promiseRecordBeforeBuf(promisedRec{ctx, promise, r}, ErrMaxBuffered)
+			p.promiseRecordBeforeBuf(promisedRec{ctx, promise, r, seq}, ErrMaxBuffered)
 			return
 		}
 
@@ -451,6 +691,12 @@ func (cl *Client) produce(
 		// notified.
 		p.blocked.Add(1)
 		p.blockedBytes += userSize
+		if seq != 0 {
+			p.blockedSpilled++ // already counted in spilledRecords
+		}
+		if staged {
+			p.blockedStaged++ // already counted in the coalesce stage
+		}
 		p.mu.Unlock()
 
 		cl.cfg.logger.Log(LogLevelDebug, "blocking Produce because we are either over max buffered records or max buffered bytes",
@@ -477,6 +723,12 @@ func (cl *Client) produce(
 			}
 			p.blocked.Add(-1)
 			p.blockedBytes -= userSize
+			if seq != 0 {
+				p.blockedSpilled--
+			}
+			if staged {
+				p.blockedStaged--
+			}
 		}()
 
 		drainBuffered := func(err error) {
@@ -498,7 +750,7 @@ func (cl *Client) produce(
 			}()
 			<-wait // we wait for the goroutine to exit, then unlock again (since the goroutine leaves the mutex locked)
 			p.mu.Unlock()
-			p.promiseRecordBeforeBuf(promisedRec{ctx, promise, r}, err)
+			p.promiseRecordBeforeBuf(promisedRec{ctx, promise, r, seq}, err)
 		}
 
 		select {
@@ -516,20 +768,38 @@ func (cl *Client) produce(
 	}
 	p.bufferedRecords = nextBufRecs
 	p.bufferedBytes = nextBufBytes
+	if seq == 0 {
+		p.produceSeq++
+		seq = p.produceSeq
+	} else {
+		p.spilledRecords-- // now accounted for in bufferedRecords
+	}
+	waterChanged := p.lockedUpdateWater()
 	p.mu.Unlock()
 
-	cl.partitionRecord(promisedRec{ctx, promise, r})
+	if waterChanged {
+		p.notifyWater()
+	}
+
+	cl.partitionRecord(promisedRec{ctx, promise, r, seq})
 }
 
 type batchPromise struct {
-	baseOffset int64
-	pid        int64
-	epoch      int16
-	attrs      RecordAttrs
-	beforeBuf  bool
-	partition  int32
-	recs       []promisedRec
-	err        error
+	baseOffset    int64
+	logAppendTime int64 // -1 unless the topic uses LogAppendTime
+	pid           int64
+	epoch         int16
+	attrs         RecordAttrs
+	beforeBuf     bool
+	partition     int32
+	recs          []promisedRec
+	err           error
+
+	// If non-zero, the batch is being failed because it hit the retry
+	// limit after this many tries, with lastErr being the last error that
+	// caused a retry. This is for OnProduceRetryExhausted.
+	exhaustedTries int64
+	lastErr        error
 }
 
 func (p *producer) promiseBatch(b batchPromise) {
@@ -558,6 +828,13 @@ start:
 		pr.ProducerID = b.pid
 		pr.ProducerEpoch = b.epoch
 		pr.Attrs = b.attrs
+		if b.logAppendTime >= 0 && b.err == nil {
+			pr.Timestamp = time.UnixMilli(b.logAppendTime)
+			pr.Attrs.attrs |= 0b0000_1000
+		}
+		if b.exhaustedTries > 0 && cl.cfg.onRetryExhausted != nil {
+			cl.cfg.onRetryExhausted(pr.Record, int(b.exhaustedTries), b.lastErr)
+		}
 		cl.finishRecordPromise(pr, b.err, b.beforeBuf)
 		b.recs[i] = promisedRec{}
 	}
@@ -587,11 +864,26 @@ func (cl *Client) finishRecordPromise(pr promisedRec, err error, beforeBuffering
 	// allowing users of Flush to know all buf recs are done by the
 	// time we notify flush below.
 	userSize := pr.userSize()
+	pr.restoreTopic()
 	pr.promise(pr.Record, err)
 
 	// If this record was never buffered, it's size was never accounted
-	// for on any p field: return early.
+	// for on any p field: return early. Spilled records are the exception:
+	// they are counted in spilledRecords and are assigned a sequence
+	// number (which a barrier may be waiting on) before being buffered.
 	if beforeBuffering {
+		if pr.seq != 0 {
+			p.mu.Lock()
+			p.spilledRecords--
+			if len(p.barriers) > 0 {
+				p.finishBarriers(pr.seq)
+			}
+			broadcast := p.flushing.Load() > 0
+			p.mu.Unlock()
+			if broadcast {
+				p.c.Broadcast()
+			}
+		}
 		return
 	}
 
@@ -600,11 +892,63 @@ func (cl *Client) finishRecordPromise(pr promisedRec, err error, beforeBuffering
 	p.bufferedBytes -= userSize
 	p.bufferedRecords--
 	broadcast := p.blocked.Load() > 0 || p.bufferedRecords == 0 && p.flushing.Load() > 0
+	if len(p.barriers) > 0 {
+		p.finishBarriers(pr.seq)
+	}
+	waterChanged := p.lockedUpdateWater()
 	p.mu.Unlock()
 
 	if broadcast {
 		p.c.Broadcast()
 	}
+	if waterChanged {
+		p.notifyWater()
+	}
+}
+
+// lockedUpdateWater updates whether the buffer is at its high water mark,
+// returning whether this changed. This must be called with p.mu held.
+func (p *producer) lockedUpdateWater() bool {
+	cfg := &p.cl.cfg
+	if cfg.onBufferHighWater == nil && cfg.onBufferLowWater == nil {
+		return false
+	}
+	mark := func(max int64, frac float64) int64 { return int64(math.Ceil(float64(max) * frac)) }
+	if !p.atHighWater {
+		p.atHighWater = p.bufferedRecords >= mark(cfg.maxBufferedRecords, cfg.bufferHighWater) ||
+			cfg.maxBufferedBytes > 0 && p.bufferedBytes >= mark(cfg.maxBufferedBytes, cfg.bufferHighWater)
+		return p.atHighWater
+	}
+	if p.bufferedRecords <= int64(float64(cfg.maxBufferedRecords)*cfg.bufferLowWater) &&
+		(cfg.maxBufferedBytes == 0 || p.bufferedBytes <= int64(float64(cfg.maxBufferedBytes)*cfg.bufferLowWater)) {
+		p.atHighWater = false
+		return true
+	}
+	return false
+}
+
+// notifyWater calls the user's high or low water function if the water state
+// differs from what the user last saw. Concurrent changes may coalesce, but
+// calls always alternate and the last call matches the current state.
+func (p *producer) notifyWater() {
+	p.waterMu.Lock()
+	defer p.waterMu.Unlock()
+
+	p.mu.Lock()
+	atHigh, records, bytes := p.atHighWater, p.bufferedRecords, p.bufferedBytes
+	p.mu.Unlock()
+
+	if atHigh == p.notifiedHighWater {
+		return
+	}
+	p.notifiedHighWater = atHigh
+	if atHigh {
+		if fn := p.cl.cfg.onBufferHighWater; fn != nil {
+			fn(records, bytes)
+		}
+	} else if fn := p.cl.cfg.onBufferLowWater; fn != nil {
+		fn(records, bytes)
+	}
 }
 
 // partitionRecord loads the partitions for a topic and produce to them. If
@@ -712,6 +1056,13 @@ type producerID struct {
 	err   error
 }
 
+// ProducerIDEpoch is a producer ID and epoch, as used in idempotent and
+// transactional producing.
+type ProducerIDEpoch struct {
+	ID    int64
+	Epoch int16
+}
+
 var errReloadProducerID = errors.New("producer id needs reloading")
 
 // initProducerID initializes the client's producer ID for idempotent
@@ -726,6 +1077,30 @@ func (cl *Client) producerID(ctxFn func() context.Context) (int64, int16, error)
 		defer p.idMu.Unlock()
 
 		if id = p.id.Load().(*producerID); errors.Is(id.err, errReloadProducerID) {
+			old := ProducerIDEpoch{id.id, id.epoch}
+			defer func() {
+				if old.ID < 0 || cl.cfg.disableIdempotency {
+					return // initial load, not a recovery
+				}
+				recovered := ProducerIDEpoch{id.id, id.epoch}
+				if id.err == nil {
+					cl.cfg.logger.Log(LogLevelInfo, "producer id recovered",
+						"old_id", old.ID,
+						"old_epoch", old.Epoch,
+						"new_id", recovered.ID,
+						"new_epoch", recovered.Epoch,
+					)
+				} else {
+					cl.cfg.logger.Log(LogLevelInfo, "producer id recovery failed",
+						"old_id", old.ID,
+						"old_epoch", old.Epoch,
+						"err", id.err,
+					)
+				}
+				if fn := cl.cfg.onProducerIDRecovered; fn != nil {
+					fn(old, recovered, id.err)
+				}
+			}()
 			if cl.cfg.disableIdempotency {
 				cl.cfg.logger.Log(LogLevelInfo, "skipping producer id initialization because the client was configured to disable idempotent writes")
 				id = &producerID{
@@ -1045,7 +1420,7 @@ func (cl *Client) waitUnknownTopic(
 }
 
 func (cl *Client) unlingerDueToMaxRecsBuffered() {
-	if cl.cfg.linger <= 0 {
+	if !cl.cfg.anyLinger() {
 		return
 	}
 	for _, parts := range cl.producer.topics.load() {
@@ -1074,11 +1449,16 @@ func (cl *Client) Flush(ctx context.Context) error {
 	cl.cfg.logger.Log(LogLevelInfo, "flushing")
 	defer cl.cfg.logger.Log(LogLevelDebug, "flushed")
 
+	// Anything staged with ProduceCoalesceWindow is buffered immediately.
+	if p.coalesce != nil {
+		p.coalesce.flush()
+	}
+
 	// At this point, if lingering is configured, nothing will _start_ a
 	// linger because the producer's flushing atomic int32 is nonzero. We
 	// must wake anything that could be lingering up, after which all sinks
 	// will loop draining.
-	if cl.cfg.linger > 0 || cl.cfg.manualFlushing {
+	if cl.cfg.anyLinger() || cl.cfg.manualFlushing {
 		for _, parts := range p.topics.load() {
 			for _, part := range parts.load().partitions {
 				part.records.unlingerAndManuallyDrain()
@@ -1093,7 +1473,7 @@ func (cl *Client) Flush(ctx context.Context) error {
 		defer p.mu.Unlock()
 		defer close(done)
 
-		for !quit && p.bufferedRecords+int64(p.blocked.Load()) > 0 {
+		for !quit && p.bufferedRecords+int64(p.blocked.Load())+p.spilledRecords+p.stagedRecords() > 0 {
 			p.c.Wait()
 		}
 	}()
@@ -1110,6 +1490,99 @@ func (cl *Client) Flush(ctx context.Context) error {
 	}
 }
 
+type produceBarrier struct {
+	seq  uint64
+	left int64
+	done chan struct{}
+}
+
+// finishBarriers decrements every barrier waiting on a record with the given
+// sequence number, closing and removing barriers that have nothing left.
+// This must be called with p.mu held.
+func (p *producer) finishBarriers(seq uint64) {
+	keep := p.barriers[:0]
+	for _, b := range p.barriers {
+		if seq <= b.seq {
+			b.left--
+			if b.left == 0 {
+				close(b.done)
+				continue
+			}
+		}
+		keep = append(keep, b)
+	}
+	for i := len(keep); i < len(p.barriers); i++ {
+		p.barriers[i] = nil
+	}
+	p.barriers = keep
+}
+
+// ProduceBarrier waits until every record that was buffered before this call
+// has been acknowledged (i.e., its promise has been called), without waiting
+// for records produced after this call. This provides an "everything produced
+// up to now is durable" checkpoint while other goroutines continue producing,
+// whereas Flush waits for all buffered records, including ones produced
+// concurrently with the Flush.
+//
+// Records that are spilled to disk (see ProduceDiskSpill) are waited on, but
+// records that are blocked in Produce due to MaxBufferedRecords or
+// MaxBufferedBytes are not yet buffered and are not waited on. Records staged
+// with ProduceCoalesceWindow are buffered immediately and waited on, unless
+// they do not fit within the max buffered limits, in which case they are
+// treated as blocked. As with Flush, lingering is disabled while any barrier
+// is waiting so that the records being waited on are sent promptly.
+//
+// If the context finishes (Done), this returns the context's error.
+func (cl *Client) ProduceBarrier(ctx context.Context) error {
+	p := &cl.producer
+
+	// Anything staged with ProduceCoalesceWindow is buffered immediately
+	// so that it is assigned a sequence number we can wait on.
+	if p.coalesce != nil {
+		p.coalesce.flush()
+	}
+
+	p.mu.Lock()
+	left := p.bufferedRecords + p.spilledRecords
+	if left == 0 {
+		p.mu.Unlock()
+		return nil
+	}
+	b := &produceBarrier{
+		seq:  p.produceSeq,
+		left: left,
+		done: make(chan struct{}),
+	}
+	p.barriers = append(p.barriers, b)
+	p.mu.Unlock()
+
+	p.flushing.Add(1)
+	defer p.flushing.Add(-1)
+
+	if cl.cfg.anyLinger() || cl.cfg.manualFlushing {
+		for _, parts := range p.topics.load() {
+			for _, part := range parts.load().partitions {
+				part.records.unlingerAndManuallyDrain()
+			}
+		}
+	}
+
+	select {
+	case <-b.done:
+		return nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		for i, other := range p.barriers {
+			if other == b {
+				p.barriers = append(p.barriers[:i], p.barriers[i+1:]...)
+				break
+			}
+		}
+		return ctx.Err()
+	}
+}
+
 func (p *producer) pause(ctx context.Context) error {
 	p.inflight.Add(1 << 48)
 
@@ -1156,6 +1629,33 @@ func (p *producer) maybeAddInflight() bool {
 	return true
 }
 
+// InFlightProduceRequests returns the number of produce requests currently in
+// flight across all brokers.
+func (cl *Client) InFlightProduceRequests() int {
+	return int(cl.producer.inflight.Load() & ((1 << 48) - 1))
+}
+
+// acquireGlobalInflight waits for a slot in the global in flight produce
+// request limit, if one is configured, returning false if the client is
+// closed.
+func (p *producer) acquireGlobalInflight() bool {
+	if p.inflightSem == nil {
+		return true
+	}
+	select {
+	case p.inflightSem <- struct{}{}:
+		return true
+	case <-p.cl.ctx.Done():
+		return false
+	}
+}
+
+func (p *producer) releaseGlobalInflight() {
+	if p.inflightSem != nil {
+		<-p.inflightSem
+	}
+}
+
 func (p *producer) decInflight() {
 	if p.inflight.Add(-1)>>48 > 0 {
 		p.mu.Lock()
@@ -1193,6 +1693,151 @@ func (cl *Client) bumpRepeatedLoadErr(err error) {
 	}
 }
 
+// ExportBufferedRecords removes and returns all buffered records that have not
+// yet been sent to Kafka, failing their promises with ErrRecordExported. This
+// can be used to hand off records to a new client before shutting down: any
+// records returned can be produced on the other client.
+//
+// Records that are part of a produce request that has been issued (or that
+// is being retried) are not exported, because the client cannot know whether
+// Kafka has already written them; call Flush after exporting to wait for
+// those. Records are returned in order per partition, and are copies of the
+// records originally produced (the originals are passed to their promises).
+// Producing continues to work as normal after this function returns.
+func (cl *Client) ExportBufferedRecords() []*Record {
+	p := &cl.producer
+
+	var exported []*Record
+	export := func(prs []promisedRec) {
+		for _, pr := range prs {
+			r := *pr.Record
+			r.restoreTopic()
+			exported = append(exported, &r)
+		}
+		p.promiseBatch(batchPromise{
+			recs: prs,
+			err:  ErrRecordExported,
+		})
+	}
+
+	for _, partitions := range p.topics.load() {
+		for _, partition := range partitions.load().partitions {
+			recBuf := partition.records
+			recBuf.mu.Lock()
+
+			// Batches before the drain index are in a request, and
+			// any batch that has been tried is part of the sequence
+			// number chain; we can only export batches after both.
+			i := recBuf.batchDrainIdx
+			for i < len(recBuf.batches) && recBuf.batches[i].tries > 0 {
+				i++
+			}
+			for _, batch := range recBuf.batches[i:] {
+				batch.mu.Lock()
+				records := batch.records
+				batch.records = nil
+				batch.mu.Unlock()
+
+				recBuf.buffered.Add(-int64(len(records)))
+				export(records)
+			}
+			if i < len(recBuf.batches) {
+				recBuf.batches = recBuf.batches[:i]
+				if i == recBuf.batchDrainIdx {
+					recBuf.lockedStopLinger()
+				}
+			}
+			recBuf.mu.Unlock()
+		}
+	}
+
+	p.topicsMu.Lock()
+	defer p.topicsMu.Unlock()
+	p.unknownTopicsMu.Lock()
+	defer p.unknownTopicsMu.Unlock()
+
+	toStore := p.topics.clone()
+	defer p.topics.storeData(toStore)
+
+	for topic, unknown := range p.unknownTopics {
+		delete(toStore, topic)
+		delete(p.unknownTopics, topic)
+		close(unknown.wait)
+		export(unknown.buffered)
+	}
+
+	return exported
+}
+
+// AbortRecord removes a record from the client if it is still buffered and
+// has not yet been sent, failing its promise with ErrRecordAborted and
+// returning true. If the record is not buffered (it was already sent, is being
+// sent, was already finished, or was never produced), this returns false and
+// the record is produced or finished as normal.
+//
+// The record must be the same pointer that was passed to Produce. A record can
+// be aborted while it is waiting for its topic's metadata to load, while it is
+// spilled to disk (see ProduceDiskSpill) or staged (see ProduceCoalesceWindow),
+// or while it is buffered in a batch that has never been written in a request.
+// This searches all partitions of the record's topic, and is meant to be used
+// sparingly, for example when the request that caused a record to be produced
+// is canceled.
+func (cl *Client) AbortRecord(r *Record) bool {
+	if r == nil {
+		return false
+	}
+	p := &cl.producer
+
+	if p.spill != nil {
+		if pr, ok := p.spill.abort(r); ok {
+			p.promiseRecordBeforeBuf(pr, ErrRecordAborted)
+			return true
+		}
+	}
+	if p.coalesce != nil {
+		if pr, ok := p.coalesce.abort(r); ok {
+			p.promiseRecordBeforeBuf(pr, ErrRecordAborted)
+			return true
+		}
+	}
+
+	p.unknownTopicsMu.Lock()
+	if unknown := p.unknownTopics[r.Topic]; unknown != nil {
+		for i, pr := range unknown.buffered {
+			if pr.Record != r {
+				continue
+			}
+			unknown.buffered = append(unknown.buffered[:i], unknown.buffered[i+1:]...)
+			if len(unknown.buffered) == 0 {
+				// Like ExportBufferedRecords, closing wait
+				// stops the goroutine waiting for metadata.
+				delete(p.unknownTopics, r.Topic)
+				close(unknown.wait)
+			}
+			p.unknownTopicsMu.Unlock()
+			p.promiseRecord(pr, ErrRecordAborted)
+			return true
+		}
+	}
+	p.unknownTopicsMu.Unlock()
+
+	parts := p.topics.load()[r.Topic]
+	if parts == nil {
+		return false
+	}
+	for _, partition := range parts.load().partitions {
+		recBuf := partition.records
+		recBuf.mu.Lock()
+		pr, ok := recBuf.lockedRemoveUnsent(r)
+		recBuf.mu.Unlock()
+		if ok {
+			p.promiseRecord(pr, ErrRecordAborted)
+			return true
+		}
+	}
+	return false
+}
+
 // Clears all buffered records in the client with the given error.
 //
 // - closing client
@@ -1204,11 +1849,15 @@ func (cl *Client) bumpRepeatedLoadErr(err error) {
 func (cl *Client) failBufferedRecords(err error) {
 	p := &cl.producer
 
+	if p.coalesce != nil {
+		p.coalesce.fail(err, false)
+	}
+
 	for _, partitions := range p.topics.load() {
 		for _, partition := range partitions.load().partitions {
 			recBuf := partition.records
 			recBuf.mu.Lock()
-			recBuf.failAllRecords(err)
+			recBuf.failAllRecords(err, false)
 			recBuf.mu.Unlock()
 		}
 	}
@@ -0,0 +1,1447 @@
+package kgo
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+func TestProduceIfEpochMismatch(t *testing.T) {
+	// With idempotency disabled, the producer ID is loaded without a
+	// request as ID -1, epoch -1.
+	cl := newUnitClient(t,
+		DisableIdempotentWrite(),
+		DefaultProduceTopic("foo"),
+	)
+
+	done := make(chan error, 1)
+	cl.ProduceIfEpoch(context.Background(), 0, &Record{Value: []byte("v")}, func(_ *Record, err error) {
+		done <- err
+	})
+	if perr := <-done; !errors.Is(perr, ErrProducerEpochMismatch) {
+		t.Errorf("got err %v != exp ErrProducerEpochMismatch", perr)
+	}
+	if n := cl.BufferedProduceRecords(); n != 0 {
+		t.Errorf("got %d buffered records != exp 0", n)
+	}
+}
+
+func TestProduceTopicOpts(t *testing.T) {
+	if _, err := NewClient(ProduceTopicOpts("foo", 2*time.Minute, 0)); err == nil {
+		t.Error("expected error for too large topic linger")
+	}
+	if _, err := NewClient(ProduceTopicOpts("foo", 0, 100)); err == nil {
+		t.Error("expected error for too small topic max batch bytes")
+	}
+
+	cl := newUnitClient(t,
+		ProducerLinger(time.Second),
+		ProduceTopicOpts("control", 0, 0),
+		ProduceTopicOpts("data", 5*time.Second, 1<<10),
+	)
+
+	for _, test := range []struct {
+		topic  string
+		linger time.Duration
+	}{
+		{"control", 0},
+		{"data", 5 * time.Second},
+		{"other", time.Second},
+	} {
+		if got := cl.cfg.lingerForTopic(test.topic); got != test.linger {
+			t.Errorf("topic %s: got linger %v != exp %v", test.topic, got, test.linger)
+		}
+	}
+
+	if got := cl.maxRecordBatchBytesForTopic("data"); got != 1<<10 {
+		t.Errorf("got data max batch bytes %d != exp %d", got, 1<<10)
+	}
+	if got, def := cl.maxRecordBatchBytesForTopic("control"), cl.maxRecordBatchBytesForTopic("other"); got != def {
+		t.Errorf("got control max batch bytes %d != exp default %d", got, def)
+	}
+}
+
+func TestFlushOnRecord(t *testing.T) {
+	cl := newUnitClient(t,
+		WithTestTransport(&scriptedTransport{resp: scriptedProduce}),
+		ProducerLinger(time.Minute),
+		FlushOnRecord(2),
+	)
+
+	// The bound applies to every topic: the first record for each topic
+	// lingers, and the second stops the linger.
+	for _, topic := range []string{"foo", "bar"} {
+		done := make(chan error, 2)
+		promise := func(_ *Record, err error) { done <- err }
+		cl.Produce(context.Background(), &Record{Topic: topic}, promise)
+		select {
+		case <-done:
+			t.Fatalf("topic %s: first record was produced before the linger", topic)
+		case <-time.After(50 * time.Millisecond):
+		}
+		cl.Produce(context.Background(), &Record{Topic: topic}, promise)
+		for i := 0; i < 2; i++ {
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatalf("topic %s: unexpected produce error: %v", topic, err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("topic %s: records still lingering after reaching the flush count", topic)
+			}
+		}
+	}
+}
+
+func TestExportBufferedRecordsUnknownTopic(t *testing.T) {
+	cl := newUnitClient(t,
+		DefaultProduceTopic("foo"),
+	)
+
+	errs := make(chan error, 2)
+	for _, v := range []string{"a", "b"} {
+		cl.Produce(context.Background(), &Record{Value: []byte(v)}, func(_ *Record, err error) {
+			errs <- err
+		})
+	}
+
+	exported := cl.ExportBufferedRecords()
+	if len(exported) != 2 {
+		t.Fatalf("got %d exported records != exp 2", len(exported))
+	}
+	for i, exp := range []string{"a", "b"} {
+		if got := string(exported[i].Value); got != exp {
+			t.Errorf("exported record %d: got value %q != exp %q", i, got, exp)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; !errors.Is(err, ErrRecordExported) {
+			t.Errorf("got promise err %v != exp ErrRecordExported", err)
+		}
+	}
+	if n := cl.BufferedProduceRecords(); n != 0 {
+		t.Errorf("got %d buffered records != exp 0", n)
+	}
+}
+
+func TestProduceFuture(t *testing.T) {
+	cl := newUnitClient(t,
+		DefaultProduceTopic("foo"),
+	)
+
+	f := cl.ProduceFuture(context.Background(), &Record{Value: []byte("a")})
+	select {
+	case <-f.Done():
+		t.Fatal("future unexpectedly done before the record was exported")
+	default:
+	}
+
+	cl.ExportBufferedRecords()
+
+	for i := 0; i < 2; i++ {
+		r, err := f.Wait()
+		if !errors.Is(err, ErrRecordExported) {
+			t.Errorf("got err %v != exp ErrRecordExported", err)
+		}
+		if string(r.Value) != "a" {
+			t.Errorf("got value %q != exp %q", r.Value, "a")
+		}
+	}
+}
+
+func TestRecordValidator(t *testing.T) {
+	errInvalid := errors.New("invalid")
+	cl := newUnitClient(t,
+		DefaultProduceTopic("foo"),
+		RecordValidator(func(r *Record) error {
+			if len(r.Value) == 0 {
+				return errInvalid
+			}
+			return nil
+		}),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	r, err := cl.ProduceSync(ctx, &Record{}).First()
+	if !errors.Is(err, errInvalid) {
+		t.Errorf("got err %v != exp errInvalid", err)
+	}
+	if r.Topic != "foo" {
+		t.Errorf("got topic %q != exp %q", r.Topic, "foo")
+	}
+	if buffered := cl.BufferedProduceRecords(); buffered != 0 {
+		t.Errorf("got %d buffered records != exp 0", buffered)
+	}
+}
+
+func TestMultiClientProduceSync(t *testing.T) {
+	var (
+		clients []*Client
+		seen    = make([]string, 2)
+	)
+	for i, topic := range []string{"foo", "bar"} {
+		i, topic := i, topic
+		cl, err := NewClient(
+			SeedBrokers("localhost:1"),
+			TopicNameMapper(func(string) string { return topic }, nil),
+			RecordValidator(func(r *Record) error {
+				seen[i] = r.Topic
+				return errors.New("rejected")
+			}),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, cl)
+	}
+	m := NewMultiClient(clients...)
+	defer m.Close()
+
+	r := &Record{Topic: "orig", Value: []byte("v")}
+	results := m.ProduceSync(context.Background(), r)
+	if len(results) != 2 {
+		t.Fatalf("got %d results != exp 2", len(results))
+	}
+	for i, exp := range []string{"foo", "bar"} {
+		if results[i].Err == nil {
+			t.Errorf("result %d: unexpectedly nil err", i)
+		}
+		if seen[i] != exp {
+			t.Errorf("result %d: got wire topic %q != exp %q", i, seen[i], exp)
+		}
+		if got := results[i].Record; got == r || got.Topic != "orig" {
+			t.Errorf("result %d: got record %p topic %q, exp a copy with topic orig", i, got, got.Topic)
+		}
+	}
+	if r.Topic != "orig" {
+		t.Errorf("input record was modified: topic %q", r.Topic)
+	}
+}
+
+func TestProduceRequireConnection(t *testing.T) {
+	cl := newUnitClient(t,
+		DefaultProduceTopic("foo"),
+		ProduceRequireConnection(true),
+	)
+
+	if _, err := cl.ProduceSync(context.Background(), &Record{}).First(); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("got err %v != exp ErrNotConnected", err)
+	}
+}
+
+func TestProduceRequireConnectionFreshClient(t *testing.T) {
+	tt := &scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+		if _, ok := req.(*kmsg.ApiVersionsRequest); ok {
+			return kmsg.NewPtrApiVersionsResponse(), nil
+		}
+		return scriptedProduce(req)
+	}}
+	cl := newUnitClient(t,
+		DefaultProduceTopic("foo"),
+		ProduceRequireConnection(true),
+		WithTestTransport(tt),
+	)
+
+	if cl.connected() {
+		t.Fatal("fresh client unexpectedly connected")
+	}
+	if _, err := cl.ProduceSync(context.Background(), &Record{}).First(); err != nil {
+		t.Errorf("got err %v != exp nil on a fresh client", err)
+	}
+}
+
+func TestProduceOnce(t *testing.T) {
+	cl := newUnitClient(t,
+		DefaultProduceTopic("foo"),
+		ProduceDedupWindow(time.Minute, 2),
+	)
+
+	errs := make(chan error, 10)
+	promise := func(_ *Record, err error) { errs <- err }
+
+	cl.ProduceOnce(context.Background(), "a", &Record{}, promise)
+	cl.ProduceOnce(context.Background(), "a", &Record{}, promise)
+	if err := <-errs; !errors.Is(err, ErrDuplicateProduce) {
+		t.Errorf("got err %v != exp ErrDuplicateProduce", err)
+	}
+
+	// Failing the first produce forgets the key, allowing a re-produce.
+	cl.ExportBufferedRecords()
+	if err := <-errs; !errors.Is(err, ErrRecordExported) {
+		t.Errorf("got err %v != exp ErrRecordExported", err)
+	}
+	cl.ProduceOnce(context.Background(), "a", &Record{}, promise)
+	select {
+	case err := <-errs:
+		t.Errorf("unexpected immediate result %v for a forgotten key", err)
+	default:
+	}
+
+	// Adding two more keys evicts "a" from the LRU.
+	cl.ProduceOnce(context.Background(), "b", &Record{}, promise)
+	cl.ProduceOnce(context.Background(), "c", &Record{}, promise)
+	cl.ProduceOnce(context.Background(), "a", &Record{}, promise)
+	select {
+	case err := <-errs:
+		t.Errorf("unexpected immediate result %v for an evicted key", err)
+	default:
+	}
+}
+
+func TestProduceBarrier(t *testing.T) {
+	cl := newUnitClient(t,
+		DefaultProduceTopic("foo"),
+	)
+
+	if err := cl.ProduceBarrier(context.Background()); err != nil {
+		t.Fatalf("unexpected err %v with nothing buffered", err)
+	}
+
+	cl.Produce(context.Background(), &Record{}, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := cl.ProduceBarrier(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got err %v != exp context.DeadlineExceeded", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cl.ProduceBarrier(context.Background()) }()
+	for {
+		cl.producer.mu.Lock()
+		n := len(cl.producer.barriers)
+		cl.producer.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cl.producer.mu.Lock()
+	if left := cl.producer.barriers[0].left; left != 1 {
+		t.Errorf("got barrier waiting on %d records != exp 1", left)
+	}
+	cl.producer.mu.Unlock()
+
+	cl.Produce(context.Background(), &Record{}, nil) // not waited on
+	cl.ExportBufferedRecords()
+	if err := <-done; err != nil {
+		t.Errorf("unexpected barrier err %v", err)
+	}
+}
+
+func TestProduceDiskSpill(t *testing.T) {
+	dir := t.TempDir()
+	cl, err := NewClient(
+		SeedBrokers("localhost:1"),
+		DefaultProduceTopic("foo"),
+		MaxBufferedRecords(1),
+		ProduceDiskSpill(dir, 1<<20),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		key string
+		err error
+	}
+	results := make(chan result, 3)
+	for _, key := range []string{"a", "b", "c"} {
+		cl.TryProduce(context.Background(), &Record{Key: []byte(key), Value: []byte("v")}, func(r *Record, err error) {
+			results <- result{string(r.Key), err}
+		})
+	}
+	if n := cl.BufferedProduceRecords(); n != 3 {
+		t.Errorf("got %d buffered records != exp 3", n)
+	}
+	if ents, _ := os.ReadDir(dir); len(ents) != 1 {
+		t.Errorf("got %d files in spill dir != exp 1", len(ents))
+	}
+
+	cl.Close()
+	keys := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		r := <-results
+		if r.err == nil {
+			t.Errorf("unexpected nil error for key %q", r.key)
+		}
+		keys[r.key] = true
+	}
+	if !keys["a"] || !keys["b"] || !keys["c"] {
+		t.Errorf("got keys %v, exp a, b, and c to be restored", keys)
+	}
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(time.Millisecond) {
+		if ents, _ := os.ReadDir(dir); len(ents) == 0 {
+			return
+		}
+	}
+	t.Error("spill file was not removed after close")
+}
+
+func TestProduceDiskSpillFull(t *testing.T) {
+	// The spill file fits one spilled record: a is buffered in memory, b is
+	// spilled, and c must be failed rather than buffered ahead of b.
+	cl := newUnitClient(t,
+		DefaultProduceTopic("foo"),
+		MaxBufferedRecords(1),
+		ProduceDiskSpill(t.TempDir(), 20),
+	)
+
+	errs := make(chan error, 3)
+	promise := func(_ *Record, err error) { errs <- err }
+	for _, key := range []string{"a", "b"} {
+		cl.TryProduce(context.Background(), &Record{Key: []byte(key), Value: []byte("v")}, promise)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	cl.Produce(ctx, &Record{Key: []byte("c"), Value: []byte("v")}, promise)
+	if err := <-errs; !errors.Is(err, ErrMaxBuffered) {
+		t.Errorf("got err %v != exp ErrMaxBuffered", err)
+	}
+	if n := cl.BufferedProduceRecords(); n != 2 {
+		t.Errorf("got %d buffered records != exp 2", n)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cl.ProduceBarrier(context.Background()) }()
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		cl.producer.mu.Lock()
+		n := len(cl.producer.barriers)
+		var left int64
+		if n > 0 {
+			left = cl.producer.barriers[0].left
+		}
+		cl.producer.mu.Unlock()
+		if n > 0 {
+			if left != 2 {
+				t.Errorf("got barrier waiting on %d records != exp 2", left)
+			}
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatal("barrier never registered")
+		}
+	}
+
+	cl.ExportBufferedRecords() // fails a; b replays into the buffer
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		cl.producer.mu.Lock()
+		replayed := cl.producer.spilledRecords == 0 && cl.producer.bufferedRecords == 1
+		cl.producer.mu.Unlock()
+		if replayed {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatal("spilled record was never replayed")
+		}
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("barrier finished early with err %v, before the spilled record", err)
+	default:
+	}
+	cl.ExportBufferedRecords() // fails b
+	if err := <-done; err != nil {
+		t.Errorf("unexpected barrier err %v", err)
+	}
+}
+
+func TestAbortRecord(t *testing.T) {
+	tt := &scriptedTransport{resp: scriptedProduce}
+	cl := newUnitClient(t,
+		WithTestTransport(tt),
+		DefaultProduceTopic("foo"),
+		ProducerLinger(time.Minute),
+	)
+
+	var (
+		mu   sync.Mutex
+		errs = make(map[string]error)
+	)
+	produce := func(v string) *Record {
+		r := StringRecord(v)
+		cl.Produce(context.Background(), r, func(r *Record, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			errs[string(r.Value)] = err
+		})
+		return r
+	}
+
+	r1, r2, r3 := produce("1"), produce("2"), produce("3")
+	if !cl.AbortRecord(r2) {
+		t.Fatal("unable to abort buffered record 2")
+	}
+	if cl.AbortRecord(r2) {
+		t.Error("unexpectedly aborted record 2 twice")
+	}
+	if err := cl.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if cl.AbortRecord(r1) {
+		t.Error("unexpectedly aborted produced record 1")
+	}
+
+	// The topic is now loaded; aborting from the middle of a lingering
+	// batch rebuilds the batch.
+	r4, r5, r6 := produce("4"), produce("5"), produce("6")
+	if !cl.AbortRecord(r5) {
+		t.Fatal("unable to abort buffered record 5")
+	}
+	if err := cl.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	exp := map[string]error{"1": nil, "2": ErrRecordAborted, "3": nil, "4": nil, "5": ErrRecordAborted, "6": nil}
+	if !reflect.DeepEqual(errs, exp) {
+		t.Errorf("got promise errors %v != exp %v", errs, exp)
+	}
+	if r1.Offset != 41 || r3.Offset != 42 || r4.Offset != 41 || r6.Offset != 42 {
+		t.Errorf("got offsets %d, %d, %d, %d != exp 41, 42, 41, 42", r1.Offset, r3.Offset, r4.Offset, r6.Offset)
+	}
+}
+
+func TestBufferWaterMarks(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls []string
+	)
+	cl := newUnitClient(t,
+		WithTestTransport(&scriptedTransport{resp: scriptedProduce}),
+		DefaultProduceTopic("foo"),
+		ManualFlushing(),
+		MaxBufferedRecords(10),
+		OnBufferHighWater(func(records, _ int64) {
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, "high "+strconv.Itoa(int(records)))
+		}),
+		OnBufferLowWater(func(records, _ int64) {
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, "low "+strconv.Itoa(int(records)))
+		}),
+	)
+
+	for i := 0; i < 8; i++ {
+		cl.Produce(context.Background(), StringRecord("v"), nil)
+	}
+	if err := cl.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if exp := []string{"high 8", "low 5"}; !reflect.DeepEqual(calls, exp) {
+		t.Errorf("got water mark calls %v != exp %v", calls, exp)
+	}
+
+	if _, err := NewClient(BufferWaterMarks(0.5, 0.5)); err == nil {
+		t.Error("expected error for equal water marks")
+	}
+}
+
+func TestVerifyProduceOrdering(t *testing.T) {
+	recBuf := &recBuf{
+		topic:     "foo",
+		batch0Seq: 5,
+		batches: []*recBatch{
+			{records: make([]promisedRec, 3)},
+			{records: make([]promisedRec, 2)},
+			{records: make([]promisedRec, 1)},
+		},
+		batchDrainIdx: 2,
+		seq:           10,
+	}
+	if err := recBuf.lockedVerifySeq(); err != nil { // 5 + 3 + 2, ok
+		t.Errorf("unexpected err: %v", err)
+	}
+	recBuf.seq = 8
+	if err := recBuf.lockedVerifySeq(); err == nil {
+		t.Error("expected err on out of order sequence")
+	}
+
+	tt := &scriptedTransport{resp: scriptedProduce}
+	cl := newUnitClient(t,
+		WithTestTransport(tt),
+		DefaultProduceTopic("foo"),
+		ProducerLinger(time.Minute),
+		VerifyProduceOrdering(true),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		cl.Produce(ctx, StringRecord("v"), nil)
+		if err := cl.Flush(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// We break the sequence of the now loaded partition: the next batch
+	// must not be sent, and its records must be failed.
+	var perr error
+	cl.Produce(ctx, StringRecord("v"), func(_ *Record, err error) { perr = err })
+	recBuf = cl.producer.topics.load()["foo"].load().partitions[0].records
+	recBuf.mu.Lock()
+	recBuf.seq += 2
+	recBuf.mu.Unlock()
+	if err := cl.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if perr == nil || !strings.Contains(perr.Error(), "produce ordering violated") {
+		t.Errorf("got produce err %v, exp an ordering violation", perr)
+	}
+
+	var produced int
+	tt.mu.Lock()
+	for _, req := range tt.reqs {
+		if _, ok := req.(*kmsg.ProduceRequest); ok {
+			produced++
+		}
+	}
+	tt.mu.Unlock()
+	if produced != 3 {
+		t.Errorf("got %d produce requests != exp 3", produced)
+	}
+}
+
+func TestProduceCoalesceWindow(t *testing.T) {
+	tt := &scriptedTransport{resp: scriptedProduce}
+	cl := newUnitClient(t,
+		WithTestTransport(tt),
+		DefaultProduceTopic("foo"),
+		ProduceCoalesceWindow(time.Second), // flushed when full or in Flush
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		order []string
+	)
+	for i := 0; i < 2000; i++ { // more than coalesceMaxRecords
+		wg.Add(1)
+		v := strconv.Itoa(i)
+		cl.Produce(ctx, StringRecord(v), func(r *Record, err error) {
+			defer wg.Done()
+			if err != nil {
+				t.Errorf("unexpected produce err: %v", err)
+			}
+			mu.Lock()
+			order = append(order, string(r.Value))
+			mu.Unlock()
+		})
+	}
+
+	aborted := StringRecord("aborted")
+	var abortErr error
+	wg.Add(1)
+	cl.Produce(ctx, aborted, func(_ *Record, err error) { abortErr = err; wg.Done() })
+	if !cl.AbortRecord(aborted) {
+		t.Error("unable to abort staged record")
+	}
+
+	if err := cl.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if !errors.Is(abortErr, ErrRecordAborted) {
+		t.Errorf("got abort err %v != exp %v", abortErr, ErrRecordAborted)
+	}
+	for i, v := range order {
+		if v != strconv.Itoa(i) {
+			t.Fatalf("record %d finished out of order: got %s", i, v)
+		}
+	}
+}
+
+func TestProduceCoalesceWindowCounted(t *testing.T) {
+	cl := newUnitClient(t,
+		WithTestTransport(&scriptedTransport{resp: scriptedProduce}),
+		DefaultProduceTopic("foo"),
+		ProduceCoalesceWindow(time.Second),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var done atomic.Int64
+	for i := 0; i < 3; i++ {
+		cl.Produce(ctx, StringRecord("v"), func(_ *Record, err error) {
+			if err != nil {
+				t.Errorf("unexpected produce err: %v", err)
+			}
+			done.Add(1)
+		})
+	}
+	if n := cl.BufferedProduceRecords(); n != 3 {
+		t.Errorf("got %d buffered records while staged != exp 3", n)
+	}
+
+	// The barrier buffers the stage rather than waiting for the window.
+	start := time.Now()
+	if err := cl.ProduceBarrier(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := done.Load(); n != 3 {
+		t.Errorf("got %d finished records after the barrier != exp 3", n)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("barrier waited %v for the coalesce window", elapsed)
+	}
+}
+
+func TestProduceCoalesceWindowOverflow(t *testing.T) {
+	release := make(chan struct{})
+	tt := &scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+		if _, ok := req.(*kmsg.ProduceRequest); ok {
+			<-release
+		}
+		return scriptedProduce(req)
+	}}
+	cl := newUnitClient(t,
+		WithTestTransport(tt),
+		DefaultProduceTopic("foo"),
+		MaxBufferedRecords(1),
+		ProduceCoalesceWindow(time.Second),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var (
+		mu    sync.Mutex
+		order []string
+	)
+	for i := 0; i < 3; i++ {
+		cl.Produce(ctx, StringRecord(strconv.Itoa(i)), func(r *Record, err error) {
+			if err != nil {
+				t.Errorf("unexpected produce err: %v", err)
+			}
+			mu.Lock()
+			order = append(order, string(r.Value))
+			mu.Unlock()
+		})
+	}
+
+	// Only one record fits in the buffer; flushing the stage must not
+	// block on the rest, which overflow and remain counted.
+	flushed := make(chan struct{})
+	go func() {
+		cl.producer.coalesce.flush()
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+	case <-time.After(time.Second):
+		t.Fatal("flushing the stage blocked on the max buffered records")
+	}
+	if n := cl.BufferedProduceRecords(); n != 3 {
+		t.Errorf("got %d buffered records with overflow != exp 3", n)
+	}
+
+	close(release)
+	if err := cl.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if exp := []string{"0", "1", "2"}; !reflect.DeepEqual(order, exp) {
+		t.Errorf("got finished order %v != exp %v", order, exp)
+	}
+}
+
+func TestOnDuplicateAcknowledged(t *testing.T) {
+	tt := &scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+		kresp, err := scriptedProduce(req)
+		if resp, ok := kresp.(*kmsg.ProduceResponse); ok {
+			for i := range resp.Topics {
+				for j := range resp.Topics[i].Partitions {
+					resp.Topics[i].Partitions[j].ErrorCode = kerr.DuplicateSequenceNumber.Code
+				}
+			}
+		}
+		return kresp, err
+	}}
+
+	var dups []string
+	cl := newUnitClient(t,
+		WithTestTransport(tt),
+		DefaultProduceTopic("foo"),
+		OnDuplicateAcknowledged(func(topic string, partition int32, baseOffset int64) {
+			dups = append(dups, fmt.Sprintf("%s[%d]@%d", topic, partition, baseOffset))
+		}),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := cl.ProduceSync(ctx, StringRecord("v")).FirstErr(); err != nil {
+		t.Fatalf("duplicate was not treated as success: %v", err)
+	}
+	if exp := []string{"foo[0]@41"}; !reflect.DeepEqual(dups, exp) {
+		t.Errorf("got dups %v != exp %v", dups, exp)
+	}
+}
+
+func TestRecordImmediate(t *testing.T) {
+	tt := &scriptedTransport{resp: scriptedProduce}
+	cl := newUnitClient(t,
+		WithTestTransport(tt),
+		DefaultProduceTopic("foo"),
+		ProducerLinger(time.Minute),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Without Immediate, a lingering record would block until the
+	// context is canceled.
+	r := StringRecord("v")
+	r.Immediate = true
+	if err := cl.ProduceSync(ctx, r).FirstErr(); err != nil {
+		t.Fatalf("immediate record did not bypass linger: %v", err)
+	}
+}
+
+func TestOnProduceBatchRetry(t *testing.T) {
+	var produces int
+	tt := &scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+		kresp, err := scriptedProduce(req)
+		if resp, ok := kresp.(*kmsg.ProduceResponse); ok {
+			if produces++; produces == 1 {
+				resp.Topics[0].Partitions[0].ErrorCode = kerr.RequestTimedOut.Code
+			}
+		}
+		return kresp, err
+	}}
+
+	type retry struct {
+		topic     string
+		partition int32
+		tries     int
+		err       error
+	}
+	var (
+		mu      sync.Mutex
+		retries []retry
+	)
+	cl := newUnitClient(t,
+		WithTestTransport(tt),
+		DefaultProduceTopic("foo"),
+		RetryBackoffFn(func(int) time.Duration { return time.Millisecond }),
+		MetadataMinAge(10*time.Millisecond),
+		OnProduceBatchRetry(func(topic string, partition int32, tries int, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			retries = append(retries, retry{topic, partition, tries, err})
+		}),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := cl.ProduceSync(ctx, StringRecord("v")).FirstErr(); err != nil {
+		t.Fatalf("unexpected produce err: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if exp := []retry{{"foo", 0, 1, kerr.RequestTimedOut}}; !reflect.DeepEqual(retries, exp) {
+		t.Errorf("got retries %v != exp %v", retries, exp)
+	}
+}
+
+func TestOnProduceBatchRetryNotCalledOnFail(t *testing.T) {
+	errConn := errors.New("connection reset")
+	tt := &scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+		if _, ok := req.(*kmsg.ProduceRequest); ok {
+			return nil, errConn
+		}
+		return scriptedProduce(req)
+	}}
+	var retries atomic.Int32
+	cl := newUnitClient(t,
+		WithTestTransport(tt),
+		DefaultProduceTopic("foo"),
+		DisableIdempotentWrite(),
+		RecordRetries(2),
+		RetryBackoffFn(func(int) time.Duration { return time.Millisecond }),
+		MetadataMinAge(10*time.Millisecond),
+		OnProduceBatchRetry(func(string, int32, int, error) { retries.Add(1) }),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := cl.ProduceSync(ctx, StringRecord("v")).FirstErr(); !errors.Is(err, ErrRecordRetries) {
+		t.Fatalf("got produce err %v != exp ErrRecordRetries", err)
+	}
+	// The first request failure is retried; the second hits the retry
+	// limit and fails the batch, which is not a retry.
+	if n := retries.Load(); n != 1 {
+		t.Errorf("got %d retries != exp 1", n)
+	}
+}
+
+func TestOnProduceRetryExhausted(t *testing.T) {
+	errConn := errors.New("connection reset")
+	for _, test := range []struct {
+		name     string
+		err      func() (kmsg.Response, error)
+		expErr   error
+		expCalls []int
+	}{
+		{
+			name:     "exhausted",
+			err:      func() (kmsg.Response, error) { return nil, errConn },
+			expErr:   ErrRecordRetries,
+			expCalls: []int{2},
+		},
+		{
+			// A non-retryable error fails the batch on its first
+			// try, which is at the retry limit but did not exhaust it.
+			name: "fatal",
+			err: func() (kmsg.Response, error) {
+				resp := kmsg.NewPtrProduceResponse()
+				rt := kmsg.NewProduceResponseTopic()
+				rt.Topic = "foo"
+				rp := kmsg.NewProduceResponseTopicPartition()
+				rp.ErrorCode = kerr.MessageTooLarge.Code
+				rt.Partitions = append(rt.Partitions, rp)
+				resp.Topics = append(resp.Topics, rt)
+				return resp, nil
+			},
+			expErr: kerr.MessageTooLarge,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tt := &scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+				if _, ok := req.(*kmsg.ProduceRequest); ok {
+					return test.err()
+				}
+				return scriptedProduce(req)
+			}}
+			var (
+				mu    sync.Mutex
+				calls []int
+			)
+			retries := 2
+			if test.expCalls == nil {
+				retries = 1
+			}
+			cl := newUnitClient(t,
+				WithTestTransport(tt),
+				DefaultProduceTopic("foo"),
+				DisableIdempotentWrite(),
+				RecordRetries(retries),
+				RetryBackoffFn(func(int) time.Duration { return time.Millisecond }),
+				MetadataMinAge(10*time.Millisecond),
+				OnProduceRetryExhausted(func(_ *Record, tries int, lastErr error) {
+					mu.Lock()
+					defer mu.Unlock()
+					calls = append(calls, tries)
+					if !errors.Is(lastErr, errConn) {
+						t.Errorf("got last err %v != exp %v", lastErr, errConn)
+					}
+				}),
+			)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := cl.ProduceSync(ctx, StringRecord("v")).FirstErr(); !errors.Is(err, test.expErr) {
+				t.Fatalf("got produce err %v != exp %v", err, test.expErr)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if !reflect.DeepEqual(calls, test.expCalls) {
+				t.Errorf("got exhausted calls with tries %v != exp %v", calls, test.expCalls)
+			}
+		})
+	}
+}
+
+func TestProduceLogAppendTime(t *testing.T) {
+	const appendMillis = 1700000000123
+	for _, logAppend := range []bool{false, true} {
+		t.Run(fmt.Sprintf("log_append_%v", logAppend), func(t *testing.T) {
+			tt := &scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+				kresp, err := scriptedProduce(req)
+				if resp, ok := kresp.(*kmsg.ProduceResponse); ok && logAppend {
+					resp.Topics[0].Partitions[0].LogAppendTime = appendMillis
+				}
+				return kresp, err
+			}}
+			cl := newUnitClient(t,
+				WithTestTransport(tt),
+				DefaultProduceTopic("foo"),
+			)
+
+			ts := time.Unix(1600000000, 123456789) // sub-millisecond precision
+			r := &Record{Value: []byte("v"), Timestamp: ts}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := cl.ProduceSync(ctx, r).FirstErr(); err != nil {
+				t.Fatal(err)
+			}
+
+			expTs, expType := ts.Truncate(time.Millisecond), int8(0)
+			if logAppend {
+				expTs, expType = time.UnixMilli(appendMillis), 1
+			}
+			if !r.Timestamp.Equal(expTs) {
+				t.Errorf("got timestamp %v != exp %v", r.Timestamp, expTs)
+			}
+			if got := r.Attrs.TimestampType(); got != expType {
+				t.Errorf("got timestamp type %d != exp %d", got, expType)
+			}
+		})
+	}
+}
+
+func TestMaxInFlightProduceRequests(t *testing.T) {
+	for _, limit := range []int{0, 1} {
+		t.Run(fmt.Sprintf("limit_%d", limit), func(t *testing.T) {
+			var (
+				mu       sync.Mutex
+				cur, max int
+				release  = make(chan struct{})
+			)
+			tt := &scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+				switch req := req.(type) {
+				case *kmsg.MetadataRequest:
+					// Partition 0 is led by broker 0, and
+					// partition 1 by broker 1.
+					resp := req.ResponseKind().(*kmsg.MetadataResponse)
+					for node := int32(0); node < 2; node++ {
+						b := kmsg.NewMetadataResponseBroker()
+						b.NodeID, b.Host, b.Port = node, "localhost", 1+node
+						resp.Brokers = append(resp.Brokers, b)
+					}
+					for _, rt := range req.Topics {
+						st := kmsg.NewMetadataResponseTopic()
+						st.Topic = rt.Topic
+						for p := int32(0); p < 2; p++ {
+							sp := kmsg.NewMetadataResponseTopicPartition()
+							sp.Partition, sp.Leader = p, p
+							st.Partitions = append(st.Partitions, sp)
+						}
+						resp.Topics = append(resp.Topics, st)
+					}
+					return resp, nil
+				case *kmsg.ProduceRequest:
+					mu.Lock()
+					if cur++; cur > max {
+						max = cur
+					}
+					mu.Unlock()
+					<-release
+					mu.Lock()
+					cur--
+					mu.Unlock()
+				}
+				return scriptedProduce(req)
+			}}
+			opts := []Opt{
+				WithTestTransport(tt),
+				DefaultProduceTopic("foo"),
+				RecordPartitioner(ManualPartitioner()),
+			}
+			if limit > 0 {
+				opts = append(opts, MaxInFlightProduceRequests(limit))
+			}
+			cl := newUnitClient(t, opts...)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			var wg sync.WaitGroup
+			for p := int32(0); p < 2; p++ {
+				wg.Add(1)
+				cl.Produce(ctx, &Record{Partition: p, Value: []byte("v")}, func(_ *Record, err error) {
+					defer wg.Done()
+					if err != nil {
+						t.Errorf("unexpected produce err: %v", err)
+					}
+				})
+			}
+
+			exp := 2
+			if limit > 0 {
+				exp = limit
+			}
+			deadline := time.Now().Add(5 * time.Second)
+			for cl.InFlightProduceRequests() < exp && time.Now().Before(deadline) {
+				time.Sleep(5 * time.Millisecond)
+			}
+			time.Sleep(50 * time.Millisecond) // give a request over the limit time to be issued
+			if got := cl.InFlightProduceRequests(); got != exp {
+				t.Errorf("got %d in flight produce requests != exp %d", got, exp)
+			}
+
+			close(release)
+			wg.Wait()
+			mu.Lock()
+			defer mu.Unlock()
+			if max != exp {
+				t.Errorf("got max %d concurrent produce requests != exp %d", max, exp)
+			}
+		})
+	}
+}
+
+func TestProduceTopicAcks(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		acks       Acks
+		topicAcks  Acks
+		expNoAcks  string // topic that should be produced with acks=0
+		expAckedTo string // topic that should be produced with acks=-1
+	}{
+		{"global_noack_topic_allisr", NoAck(), AllISRAcks(), "global", "override"},
+		{"global_allisr_topic_noack", AllISRAcks(), NoAck(), "override", "global"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFakeBroker(t)
+			cl, err := NewClient(
+				SeedBrokers(f.addr()),
+				DisableIdempotentWrite(),
+				RequiredAcks(test.acks),
+				ProduceTopicAcks("override", test.topicAcks),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cl.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			// Interleave produces so that both acks levels are
+			// inflight to the broker at once.
+			for i := 0; i < 3; i++ {
+				for _, topic := range []string{test.expNoAcks, test.expAckedTo} {
+					r, err := cl.ProduceSync(ctx, &Record{Topic: topic}).First()
+					if err != nil {
+						t.Fatalf("produce to %s: %v", topic, err)
+					}
+					if topic == test.expAckedTo && r.Offset != 41 {
+						t.Errorf("acked produce to %s: got offset %d != exp 41", topic, r.Offset)
+					}
+				}
+			}
+
+			cxnAcks := make(map[int]int16)
+			for _, p := range f.produced() {
+				for _, topic := range p.topics {
+					exp := int16(-1)
+					if topic == test.expNoAcks {
+						exp = 0
+					}
+					if p.acks != exp {
+						t.Errorf("produce to %s: got acks %d != exp %d", topic, p.acks, exp)
+					}
+				}
+				if acks, ok := cxnAcks[p.cxn]; ok && acks != p.acks {
+					t.Errorf("connection %d used for both acks %d and %d", p.cxn, acks, p.acks)
+				}
+				cxnAcks[p.cxn] = p.acks
+			}
+			if len(cxnAcks) != 2 {
+				t.Errorf("got %d produce connections != exp 2", len(cxnAcks))
+			}
+		})
+	}
+}
+
+func TestProduceAndWaitConsumable(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		codes  []int16 // per ListOffsets request, with the end offset advancing each request
+		expErr error
+	}{
+		{"waits", []int16{0, 0}, nil},
+		{"fatal", []int16{kerr.TopicAuthorizationFailed.Code}, kerr.TopicAuthorizationFailed},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var lists []*kmsg.ListOffsetsRequest
+			tt := &scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+				lreq, ok := req.(*kmsg.ListOffsetsRequest)
+				if !ok {
+					return scriptedProduce(req)
+				}
+				// The record is produced at offset 41; the end
+				// offset starts at 41 and advances each request.
+				lists = append(lists, lreq)
+				resp := lreq.ResponseKind().(*kmsg.ListOffsetsResponse)
+				for _, rt := range lreq.Topics {
+					st := kmsg.NewListOffsetsResponseTopic()
+					st.Topic = rt.Topic
+					for _, rp := range rt.Partitions {
+						sp := kmsg.NewListOffsetsResponseTopicPartition()
+						sp.Partition = rp.Partition
+						sp.ErrorCode = test.codes[len(lists)-1]
+						sp.Offset = 40 + int64(len(lists))
+						st.Partitions = append(st.Partitions, sp)
+					}
+					resp.Topics = append(resp.Topics, st)
+				}
+				return resp, nil
+			}}
+			cl := newUnitClient(t,
+				WithTestTransport(tt),
+				TopicNameMapper(func(s string) string { return "tenant." + s }, nil),
+				FetchIsolationLevel(ReadCommitted()),
+				RetryBackoffFn(func(int) time.Duration { return time.Millisecond }),
+			)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			eo, err := cl.ProduceAndWaitConsumable(ctx, &Record{Topic: "foo", Value: []byte("v")})
+			if !errors.Is(err, test.expErr) {
+				t.Fatalf("got err %v != exp %v", err, test.expErr)
+			}
+			if eo.Offset != 41 {
+				t.Errorf("got produced offset %d != exp 41", eo.Offset)
+			}
+
+			tt.mu.Lock()
+			defer tt.mu.Unlock()
+			if len(lists) != len(test.codes) {
+				t.Errorf("got %d list offsets requests != exp %d", len(lists), len(test.codes))
+			}
+			for _, req := range lists {
+				if req.IsolationLevel != 1 {
+					t.Errorf("got list isolation level %d != exp 1", req.IsolationLevel)
+				}
+				if topic := req.Topics[0].Topic; topic != "tenant.foo" {
+					t.Errorf("got listed topic %q != exp the wire topic tenant.foo", topic)
+				}
+			}
+		})
+	}
+}
+
+func TestProduceTombstones(t *testing.T) {
+	var batch []byte
+	tt := &scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+		if req, ok := req.(*kmsg.ProduceRequest); ok {
+			batch = req.Topics[0].Partitions[0].Records
+		}
+		return scriptedProduce(req)
+	}}
+	cl := newUnitClient(t,
+		WithTestTransport(tt),
+		DefaultProduceTopic("foo"),
+		ProducerBatchCompression(NoCompression()),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	values := [][]byte{nil, {}, []byte("v")}
+	var rs []*Record
+	for _, v := range values {
+		rs = append(rs, &Record{Value: v})
+	}
+	if err := cl.ProduceSync(ctx, rs...).FirstErr(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Consuming what we produced keeps nil and empty values distinct.
+	o := cursorOffsetNext{from: &cursor{topic: "foo"}}
+	fp := o.processRespPartition(nil, &kmsg.FetchResponseTopicPartition{RecordBatches: batch}, 0, newDecompressor(), nil)
+	if fp.Err != nil {
+		t.Fatal(fp.Err)
+	}
+	if len(fp.Records) != len(values) {
+		t.Fatalf("got %d consumed records != exp %d", len(fp.Records), len(values))
+	}
+	for i, r := range fp.Records {
+		exp := values[i]
+		if (r.Value == nil) != (exp == nil) || !bytes.Equal(r.Value, exp) {
+			t.Errorf("record %d: got value %#v != exp %#v", i, r.Value, exp)
+		}
+		if r.IsTombstone() != (exp == nil) {
+			t.Errorf("record %d: got tombstone %v != exp %v", i, r.IsTombstone(), exp == nil)
+		}
+	}
+}
+
+func TestCompressionMinBytes(t *testing.T) {
+	if _, err := NewClient(CompressionMinBytes(-1)); err == nil || !strings.Contains(err.Error(), "compression min bytes") {
+		t.Errorf("got err %v, exp a negative compression min bytes to be invalid", err)
+	}
+
+	var (
+		mu    sync.Mutex
+		attrs []int16
+	)
+	tt := &scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+		if req, ok := req.(*kmsg.ProduceRequest); ok {
+			batch := req.Topics[0].Partitions[0].Records
+			mu.Lock()
+			attrs = append(attrs, int16(binary.BigEndian.Uint16(batch[21:])))
+			mu.Unlock()
+		}
+		return scriptedProduce(req)
+	}}
+	cl := newUnitClient(t,
+		WithTestTransport(tt),
+		DefaultProduceTopic("foo"),
+		ProducerBatchCompression(ZstdCompression()),
+		CompressionMinBytes(100),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, v := range []string{"small", strings.Repeat("large", 100)} {
+		if err := cl.ProduceSync(ctx, StringRecord(v)).FirstErr(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var codecs []int16
+	for _, a := range attrs {
+		codecs = append(codecs, a&0b111)
+	}
+	if exp := []int16{0, int16(codecZstd)}; !reflect.DeepEqual(codecs, exp) {
+		t.Errorf("got batch codecs %v != exp %v", codecs, exp)
+	}
+}
+
+func TestRelaxPartitionOrdering(t *testing.T) {
+	if _, err := NewClient(RelaxPartitionOrdering(true)); err == nil {
+		t.Error("expected RelaxPartitionOrdering to be invalid with idempotency")
+	}
+
+	for _, relax := range []bool{false, true} {
+		t.Run(fmt.Sprintf("relax_%v", relax), func(t *testing.T) {
+			sent := make(chan struct{}, 2)
+			release := make(chan struct{})
+			tt := &scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+				if _, ok := req.(*kmsg.ProduceRequest); ok {
+					sent <- struct{}{}
+					<-release
+				}
+				return scriptedProduce(req)
+			}}
+			cl := newUnitClient(t,
+				WithTestTransport(tt),
+				DefaultProduceTopic("foo"),
+				DisableIdempotentWrite(),
+				MaxProduceRequestsInflightPerBroker(2),
+				RelaxPartitionOrdering(relax),
+				ProducerLinger(0),
+			)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			var wg sync.WaitGroup
+			produce := func() {
+				wg.Add(1)
+				cl.Produce(ctx, StringRecord("v"), func(_ *Record, err error) {
+					defer wg.Done()
+					if err != nil {
+						t.Errorf("unexpected produce err: %v", err)
+					}
+				})
+			}
+
+			// The partition has not yet seen a successful response
+			// when the second batch is ready. The test transport
+			// issues requests to a broker one at a time, so we check
+			// how many requests the client has in flight rather than
+			// how many the transport sees.
+			produce()
+			<-sent
+			produce()
+			exp := 1
+			if relax {
+				exp = 2
+			}
+			deadline := time.Now().Add(5 * time.Second)
+			for cl.InFlightProduceRequests() < exp && time.Now().Before(deadline) {
+				time.Sleep(5 * time.Millisecond)
+			}
+			time.Sleep(50 * time.Millisecond) // give a second request time to be issued
+			if got := cl.InFlightProduceRequests(); got != exp {
+				t.Errorf("got %d in flight produce requests != exp %d", got, exp)
+			}
+
+			close(release)
+			wg.Wait()
+		})
+	}
+}
+
+func TestOnProducerIDRecovered(t *testing.T) {
+	var initErr atomic.Int32
+	tt := &scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+		if req, ok := req.(*kmsg.InitProducerIDRequest); ok && req.ProducerID >= 0 {
+			resp := req.ResponseKind().(*kmsg.InitProducerIDResponse)
+			resp.ProducerID, resp.ErrorCode = 8, int16(initErr.Load())
+			return resp, nil
+		}
+		return scriptedProduce(req)
+	}}
+	type recovery struct {
+		old, new ProducerIDEpoch
+		err      error
+	}
+	var recoveries []recovery
+	cl := newUnitClient(t,
+		WithTestTransport(tt),
+		OnProducerIDRecovered(func(old, new ProducerIDEpoch, err error) {
+			recoveries = append(recoveries, recovery{old, new, err})
+		}),
+	)
+	ctxFn := func() context.Context { return context.Background() }
+	reload := func(id int64, epoch int16) {
+		cl.producer.id.Store(&producerID{id, epoch, errReloadProducerID})
+	}
+
+	if _, _, err := cl.producerID(ctxFn); err != nil {
+		t.Fatal(err)
+	}
+	if len(recoveries) != 0 {
+		t.Fatalf("got %v for the initial load, exp no recovery", recoveries)
+	}
+
+	reload(7, 0) // bumped locally
+	cl.producerID(ctxFn)
+	reload(7, math.MaxInt16-1) // the epoch is exhausted: a new ID is loaded
+	cl.producerID(ctxFn)
+	initErr.Store(int32(kerr.ClusterAuthorizationFailed.Code))
+	reload(8, math.MaxInt16-1)
+	_, _, err := cl.producerID(ctxFn)
+
+	if !errors.Is(err, kerr.ClusterAuthorizationFailed) {
+		t.Errorf("got failed recovery err %v != exp %v", err, kerr.ClusterAuthorizationFailed)
+	}
+	exp := []recovery{
+		{ProducerIDEpoch{7, 0}, ProducerIDEpoch{7, 1}, nil},
+		{ProducerIDEpoch{7, math.MaxInt16 - 1}, ProducerIDEpoch{8, 0}, nil},
+		{ProducerIDEpoch{8, math.MaxInt16 - 1}, ProducerIDEpoch{8, math.MaxInt16 - 1}, err},
+	}
+	if !reflect.DeepEqual(recoveries, exp) {
+		t.Errorf("got recoveries %v != exp %v", recoveries, exp)
+	}
+}
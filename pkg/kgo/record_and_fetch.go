@@ -74,6 +74,18 @@ type Record struct {
 	// with the same key to go to the same partition.
 	Key []byte
 	// Value is blob of data to write to Kafka.
+	//
+	// A nil value and an empty, non-nil value are distinct on the wire: a
+	// nil value is encoded with a length of -1 and is a tombstone, which
+	// deletes the key in compacted topics, while an empty value is encoded
+	// with a length of 0 and is not a tombstone. The client preserves this
+	// distinction when producing and consuming; be careful to not
+	// accidentally produce a nil value when you mean an empty one. See
+	// IsTombstone.
+	//
+	// If the client uses a RecordCodec, producing replaces Value with the
+	// encoded value. A record that is produced again is encoded again, so
+	// to re-produce a record, reset Value to the original value first.
 	Value []byte
 
 	// Headers are optional key/value pairs that are passed along with
@@ -92,6 +104,14 @@ type Record struct {
 	// timestamps are generated by clients rather than brokers.
 	//
 	// When producing, if this field is not yet set, it is set to time.Now.
+	// If the topic is configured with message.timestamp.type=LogAppendTime,
+	// the broker overrides the timestamp when appending the batch to its
+	// log; when the record is successfully produced, this field is updated
+	// to the broker assigned log append time before the promise is called,
+	// and Attrs.TimestampType returns 1. Otherwise, the timestamp is what
+	// the broker stored: Kafka stores timestamps at millisecond precision,
+	// so this field is truncated to the millisecond when the record is
+	// buffered.
 	Timestamp time.Time
 
 	// Topic is the topic that a record is written to.
@@ -142,6 +162,21 @@ type Record struct {
 	// not mirror the offset actually stored within Kafka.
 	Offset int64
 
+	// Aborted is whether this record was part of an aborted transaction.
+	// This is only ever true when consuming with the ReadCommitted
+	// isolation level and the IncludeAbortedRecords option; otherwise,
+	// aborted records are not returned at all.
+	Aborted bool
+
+	// Immediate, if true when producing, causes the batch this record is
+	// buffered into to stop lingering and be sent as soon as possible. Any
+	// records buffered after this one into a new batch for the partition
+	// linger as normal. This is useful for the occasional latency critical
+	// record in a mostly lingering producer, without disabling linger or
+	// flushing everything. This has no effect when not lingering or with
+	// ManualFlushing.
+	Immediate bool
+
 	// Context is an optional field that is used for enriching records.
 	//
 	// If this field is nil when producing, it is set to the Produce ctx
@@ -149,6 +184,26 @@ type Record struct {
 	// producer hooks. It can also be set in a consumer hook to propagate
 	// enrichment to consumer clients.
 	Context context.Context
+
+	// logicalTopic is the topic a record was produced to before Topic was
+	// mapped to its wire name with TopicNameMapper. Topic is restored
+	// before the record's promise is called.
+	logicalTopic string
+}
+
+// restoreTopic restores a produced record's topic to its logical name, if it
+// was mapped with TopicNameMapper.
+func (r *Record) restoreTopic() {
+	if r.logicalTopic != "" {
+		r.Topic, r.logicalTopic = r.logicalTopic, ""
+	}
+}
+
+// IsTombstone returns whether the record is a tombstone, i.e., whether the
+// record's value is nil. In compacted topics, a tombstone deletes all prior
+// records with the same key. An empty, non-nil value is not a tombstone.
+func (r *Record) IsTombstone() bool {
+	return r.Value == nil
 }
 
 func (r *Record) userSize() int64 {
@@ -569,6 +624,40 @@ func (fs Fetches) EachTopic(fn func(FetchTopic)) {
 	}
 }
 
+// Partitions groups all partitions in Fetches by topic and partition.
+//
+// This is a convenience function for consumers that process each partition
+// independently. If a partition is spread across fetches, its records are
+// merged in order into one FetchPartition, the watermarks and log start
+// offset are from the latest fetch, and the error is the first non-nil error.
+// Injected errors that are not for a specific partition (for example,
+// ErrClientClosed or a context error) are keyed under an empty topic and
+// partition -1.
+func (fs Fetches) Partitions() map[string]map[int32]FetchPartition {
+	ps := make(map[string]map[int32]FetchPartition)
+	fs.EachPartition(func(p FetchTopicPartition) {
+		tps := ps[p.Topic]
+		if tps == nil {
+			tps = make(map[int32]FetchPartition)
+			ps[p.Topic] = tps
+		}
+		existing, exists := tps[p.Partition]
+		if !exists {
+			tps[p.Partition] = p.FetchPartition
+			return
+		}
+		existing.Records = append(existing.Records[:len(existing.Records):len(existing.Records)], p.Records...)
+		existing.HighWatermark = p.HighWatermark
+		existing.LastStableOffset = p.LastStableOffset
+		existing.LogStartOffset = p.LogStartOffset
+		if existing.Err == nil {
+			existing.Err = p.Err
+		}
+		tps[p.Partition] = existing
+	})
+	return ps
+}
+
 // EachRecord calls fn for each record in Fetches.
 //
 // This is very similar to using a record iter, and is solely a convenience
@@ -0,0 +1,44 @@
+package kgo
+
+import "fmt"
+
+// RecordCodec transforms record values at the client boundary; see
+// WithRecordCodec. Whereas a CompressionCodec applies to entire batches and is
+// transparent to Kafka, a RecordCodec applies to individual record values, and
+// the encoded values are what is stored in Kafka. A common use is envelope
+// encryption: Encode encrypts a value, and Decode decrypts it.
+//
+// A codec must be safe for concurrent use: values are encoded on every
+// producing goroutine and decoded on every fetch response concurrently.
+type RecordCodec interface {
+	// Encode returns the value to produce for a record to the given topic.
+	// A nil value (a tombstone) is passed to Encode as well; codecs
+	// should generally return nil for nil to preserve tombstones.
+	Encode(topic string, value []byte) ([]byte, error)
+
+	// Decode returns the decoded value of a consumed record from the
+	// given topic. If Decode returns an error, the error is returned as
+	// the partition's FetchPartition.Err, records before the undecodable
+	// record are still returned, and consuming the partition does not
+	// progress past the record until you set a later offset. Codecs that
+	// would rather return undecodable records can return the value
+	// unmodified.
+	Decode(topic string, value []byte) ([]byte, error)
+}
+
+// recordDecodeFn returns the function a cursor uses to decode record values,
+// or nil if no RecordCodec is in use.
+func (cl *Client) recordDecodeFn(topic string) func(*Record) error {
+	codec := cl.cfg.recordCodec
+	if codec == nil {
+		return nil
+	}
+	return func(r *Record) error {
+		v, err := codec.Decode(topic, r.Value)
+		if err != nil {
+			return fmt.Errorf("unable to decode record at offset %d: %w", r.Offset, err)
+		}
+		r.Value = v
+		return nil
+	}
+}
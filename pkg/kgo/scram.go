@@ -0,0 +1,110 @@
+package kgo
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+
+	"golang.org/x/crypto/pbkdf2"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// ScramCredential is a SCRAM credential for a user, used in
+// AlterScramCredentials.
+type ScramCredential struct {
+	// User is the user the credential is for.
+	User string
+
+	// Mechanism is the SCRAM mechanism of the credential, either
+	// "SCRAM-SHA-256" or "SCRAM-SHA-512".
+	Mechanism string
+
+	// Iterations is the number of iterations used to salt the password.
+	// Kafka requires this to be between 4096 and 16384. If zero, 4096 is
+	// used. This is unused when deleting a credential.
+	Iterations int32
+
+	// Password is the password to salt. The client generates a random
+	// 24 byte salt and salts the password with pbkdf2; the password itself
+	// is never sent to Kafka. This is unused when deleting a credential.
+	Password string
+}
+
+func scramMechanism(name string) (int8, error) {
+	switch name {
+	case "SCRAM-SHA-256":
+		return 1, nil
+	case "SCRAM-SHA-512":
+		return 2, nil
+	default:
+		return 0, fmt.Errorf("unknown SCRAM mechanism %q", name)
+	}
+}
+
+// AlterScramCredentials creates or updates (upserts) and deletes SCRAM
+// credentials, returning the first error encountered. This issues an
+// AlterUserScramCredentialsRequest (Kafka 2.7+), which allows rotating SCRAM
+// secrets through the same client that authenticates with them.
+//
+// A user can only appear once across both upserts and deletes. Deletions only
+// use the User and Mechanism fields. For more complete SCRAM administration,
+// including describing credentials, see the kadm package.
+func (cl *Client) AlterScramCredentials(ctx context.Context, upserts, deletes []ScramCredential) error {
+	req := kmsg.NewPtrAlterUserSCRAMCredentialsRequest()
+	for _, d := range deletes {
+		mechanism, err := scramMechanism(d.Mechanism)
+		if err != nil {
+			return fmt.Errorf("user %s: %w", d.User, err)
+		}
+		rd := kmsg.NewAlterUserSCRAMCredentialsRequestDeletion()
+		rd.Name = d.User
+		rd.Mechanism = mechanism
+		req.Deletions = append(req.Deletions, rd)
+	}
+	for _, u := range upserts {
+		mechanism, err := scramMechanism(u.Mechanism)
+		if err != nil {
+			return fmt.Errorf("user %s: %w", u.User, err)
+		}
+		iterations := u.Iterations
+		if iterations == 0 {
+			iterations = 4096
+		}
+		salt := make([]byte, 24)
+		if _, err := rand.Read(salt); err != nil {
+			return fmt.Errorf("user %s: unable to generate salt: %w", u.User, err)
+		}
+		ru := kmsg.NewAlterUserSCRAMCredentialsRequestUpsertion()
+		ru.Name = u.User
+		ru.Mechanism = mechanism
+		ru.Iterations = iterations
+		ru.Salt = salt
+		if mechanism == 1 {
+			ru.SaltedPassword = pbkdf2.Key([]byte(u.Password), salt, int(iterations), sha256.Size, sha256.New)
+		} else {
+			ru.SaltedPassword = pbkdf2.Key([]byte(u.Password), salt, int(iterations), sha512.Size, sha512.New)
+		}
+		req.Upsertions = append(req.Upsertions, ru)
+	}
+	if len(req.Deletions) == 0 && len(req.Upsertions) == 0 {
+		return nil
+	}
+
+	resp, err := req.RequestWith(ctx, cl)
+	if err != nil {
+		return err
+	}
+	for _, r := range resp.Results {
+		if err := kerr.ErrorForCode(r.ErrorCode); err != nil {
+			if r.ErrorMessage != nil {
+				return fmt.Errorf("unable to alter SCRAM credentials for user %s: %w: %s", r.User, err, *r.ErrorMessage)
+			}
+			return fmt.Errorf("unable to alter SCRAM credentials for user %s: %w", r.User, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package kgo
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+func TestAlterScramCredentials(t *testing.T) {
+	var (
+		errCode int16
+		reqs    []*kmsg.AlterUserSCRAMCredentialsRequest
+	)
+	cl := newUnitClient(t, WithTestTransport(&scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+		areq, ok := req.(*kmsg.AlterUserSCRAMCredentialsRequest)
+		if !ok {
+			resp, err := scriptedProduce(req)
+			if mresp, ok := resp.(*kmsg.MetadataResponse); ok {
+				mresp.ControllerID = 0 // the request is issued to the controller
+			}
+			return resp, err
+		}
+		reqs = append(reqs, areq)
+		resp := areq.ResponseKind().(*kmsg.AlterUserSCRAMCredentialsResponse)
+		for _, u := range areq.Upsertions {
+			r := kmsg.NewAlterUserSCRAMCredentialsResponseResult()
+			r.User, r.ErrorCode = u.Name, errCode
+			if errCode != 0 {
+				r.ErrorMessage = kmsg.StringPtr("bad")
+			}
+			resp.Results = append(resp.Results, r)
+		}
+		for _, d := range areq.Deletions {
+			r := kmsg.NewAlterUserSCRAMCredentialsResponseResult()
+			r.User = d.Name
+			resp.Results = append(resp.Results, r)
+		}
+		return resp, nil
+	}}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := cl.AlterScramCredentials(ctx, nil, nil); err != nil || len(reqs) != 0 {
+		t.Errorf("got err %v and %d requests altering nothing, exp none", err, len(reqs))
+	}
+	if err := cl.AlterScramCredentials(ctx, []ScramCredential{{User: "u", Mechanism: "SCRAM-SHA-1"}}, nil); err == nil || len(reqs) != 0 {
+		t.Errorf("got err %v and %d requests for an unknown mechanism, exp an error and no requests", err, len(reqs))
+	}
+
+	upsert := ScramCredential{User: "alice", Mechanism: "SCRAM-SHA-256", Password: "secret"}
+	del := ScramCredential{User: "bob", Mechanism: "SCRAM-SHA-512"}
+	if err := cl.AlterScramCredentials(ctx, []ScramCredential{upsert}, []ScramCredential{del}); err != nil {
+		t.Fatal(err)
+	}
+	if len(reqs) != 1 {
+		t.Fatalf("got %d requests != exp 1", len(reqs))
+	}
+	req := reqs[0]
+	if len(req.Deletions) != 1 || req.Deletions[0].Name != "bob" || req.Deletions[0].Mechanism != 2 {
+		t.Errorf("unexpected deletions %v", req.Deletions)
+	}
+	if len(req.Upsertions) != 1 {
+		t.Fatalf("got %d upsertions != exp 1", len(req.Upsertions))
+	}
+	u := req.Upsertions[0]
+	if u.Name != "alice" || u.Mechanism != 1 || u.Iterations != 4096 || len(u.Salt) != 24 {
+		t.Errorf("got upsertion name %q mechanism %d iterations %d salt len %d, exp alice 1 4096 24", u.Name, u.Mechanism, u.Iterations, len(u.Salt))
+	}
+	if exp := pbkdf2.Key([]byte("secret"), u.Salt, 4096, sha256.Size, sha256.New); !bytes.Equal(u.SaltedPassword, exp) {
+		t.Error("salted password does not match the password salted with the request salt")
+	}
+
+	errCode = kerr.UnacceptableCredential.Code
+	err := cl.AlterScramCredentials(ctx, []ScramCredential{upsert}, nil)
+	if !errors.Is(err, kerr.UnacceptableCredential) || !strings.Contains(err.Error(), "user alice") || !strings.HasSuffix(err.Error(), ": bad") {
+		t.Errorf("got err %v, exp UnacceptableCredential for alice with the error message", err)
+	}
+}
@@ -96,7 +96,7 @@ func (s *sink) createReq(id int64, epoch int16) (*produceRequest, *kmsg.AddParti
 		epoch: epoch,
 	}
 
-	var moreToDrain bool
+	var moreToDrain, acksSet bool
 
 	s.recBufsMu.Lock()
 	defer s.recBufsMu.Unlock()
@@ -107,17 +107,35 @@ func (s *sink) createReq(id int64, epoch int16) (*produceRequest, *kmsg.AddParti
 		recBufsIdx = (recBufsIdx + 1) % len(s.recBufs)
 
 		recBuf.mu.Lock()
-		if recBuf.failing || len(recBuf.batches) == recBuf.batchDrainIdx || recBuf.inflightOnSink != nil && recBuf.inflightOnSink != s || recBuf.inflight != 0 && !recBuf.okOnSink {
+		if recBuf.failing || len(recBuf.batches) == recBuf.batchDrainIdx || recBuf.inflightOnSink != nil && recBuf.inflightOnSink != s || recBuf.inflight != 0 && !recBuf.okOnSink && !s.cl.cfg.relaxOrdering {
 			recBuf.mu.Unlock()
 			continue
 		}
 
+		// The first partition we add determines the acks for this
+		// request; partitions with different acks wait for another.
+		if acksSet && recBuf.acks != req.acks {
+			recBuf.mu.Unlock()
+			moreToDrain = true
+			continue
+		}
+
+		if s.cl.cfg.verifyProduceOrdering && req.idempotent() {
+			if err := recBuf.lockedVerifySeq(); err != nil {
+				s.cl.cfg.logger.Log(LogLevelError, "failing partition's buffered records", "err", err)
+				recBuf.failAllRecords(err, false)
+				recBuf.mu.Unlock()
+				continue
+			}
+		}
+
 		batch := recBuf.batches[recBuf.batchDrainIdx]
 		if added := req.tryAddBatch(s.produceVersion.Load(), recBuf, batch); !added {
 			recBuf.mu.Unlock()
 			moreToDrain = true
 			continue
 		}
+		req.acks, acksSet = recBuf.acks, true
 
 		recBuf.inflightOnSink = s
 		recBuf.inflight++
@@ -243,6 +261,11 @@ func (s *sink) drain() {
 			s.drainState.hardFinish()
 			return
 		}
+		if !s.cl.producer.acquireGlobalInflight() {
+			<-sem
+			s.drainState.hardFinish()
+			return
+		}
 
 		again = s.drainState.maybeFinish(s.produce(sem))
 	}
@@ -280,6 +303,7 @@ func (s *sink) produce(sem <-chan struct{}) bool {
 	var produced bool
 	defer func() {
 		if !produced {
+			s.cl.producer.releaseGlobalInflight()
 			<-sem
 		}
 	}()
@@ -364,7 +388,7 @@ func (s *sink) produce(sem <-chan struct{}) bool {
 						batch0.mu.Unlock()
 					}
 					if failAll {
-						recBuf.failAllRecords(err)
+						recBuf.failAllRecords(err, false)
 					}
 					recBuf.mu.Unlock()
 				}
@@ -465,6 +489,7 @@ func (s *sink) produce(sem <-chan struct{}) bool {
 		s.handleReqResp(br, req, resp, err)
 		s.cl.producer.decInflight()
 		batches.eachOwnerLocked((*recBatch).decInflight)
+		s.cl.producer.releaseGlobalInflight()
 		<-sem
 	})
 	return moreToDrain
@@ -536,7 +561,7 @@ func (s *sink) doTxnReq(
 	// similar to the warning we give in the txn.go file, but the
 	// difference there is the user knows explicitly at the function call
 	// that canceling the context will opt them into invalid state.
-	err = s.cl.doWithConcurrentTransactions(s.cl.ctx, "AddPartitionsToTxn", func() error {
+	err = s.cl.doWithConcurrentTransactions(s.cl.ctx, kmsg.AddPartitionsToTxn.Int16(), func() error {
 		stripped, err = s.issueTxnReq(req, txnReq)
 		return err
 	})
@@ -642,7 +667,7 @@ func (s *sink) handleReqClientErr(req *produceRequest, err error) {
 		if updateMeta {
 			s.cl.cfg.logger.Log(LogLevelInfo, "produce request failed, triggering metadata update", "broker", logID(s.nodeID), "err", err)
 		}
-		s.handleRetryBatches(req.batches, nil, req.backoffSeq, updateMeta, false, "failed produce request triggered metadata update")
+		s.handleRetryBatches(req.batches, nil, func(seqRecBatch) error { return err }, req.backoffSeq, updateMeta, false, "failed produce request triggered metadata update")
 
 	case errors.Is(err, ErrClientClosed):
 		s.cl.failBufferedRecords(ErrClientClosed)
@@ -665,7 +690,7 @@ func (s *sink) handleReqRespNoack(b *bytes.Buffer, debug bool, req *produceReque
 				if debug {
 					fmt.Fprintf(b, "%d{0=>%d}, ", partition, len(batch.records))
 				}
-				s.cl.finishBatch(batch.recBatch, req.producerID, req.producerEpoch, partition, 0, nil)
+				s.cl.finishBatch(batch.recBatch, req.producerID, req.producerEpoch, partition, 0, -1, nil)
 			} else if debug {
 				fmt.Fprintf(b, "%d{skipped}, ", partition)
 			}
@@ -706,7 +731,8 @@ func (s *sink) handleReqResp(br *broker, req *produceRequest, resp kmsg.Response
 	}
 
 	var kmove kip951move
-	var reqRetry seqRecBatches // handled at the end
+	var reqRetry seqRecBatches         // handled at the end
+	var retryCodes map[*recBatch]int16 // why each batch in reqRetry is retried, for OnProduceBatchRetry
 
 	kresp := resp.(*kmsg.ProduceResponse)
 	for i := range kresp.Topics {
@@ -747,6 +773,12 @@ func (s *sink) handleReqResp(br *broker, req *produceRequest, resp kmsg.Response
 			)
 			if retry {
 				reqRetry.addSeqBatch(topic, partition, batch)
+				if s.cl.cfg.onProduceBatchRetry != nil {
+					if retryCodes == nil {
+						retryCodes = make(map[*recBatch]int16)
+					}
+					retryCodes[batch.recBatch] = rp.ErrorCode
+				}
 			}
 			if !didProduce {
 				delete(tmetrics, partition)
@@ -767,10 +799,10 @@ func (s *sink) handleReqResp(br *broker, req *produceRequest, resp kmsg.Response
 
 	if len(req.batches) > 0 {
 		s.cl.cfg.logger.Log(LogLevelError, "broker did not reply to all topics / partitions in the produce request! reenqueuing missing partitions", "broker", logID(s.nodeID))
-		s.handleRetryBatches(req.batches, nil, 0, true, false, "broker did not reply to all topics in produce request")
+		s.handleRetryBatches(req.batches, nil, nil, 0, true, false, "broker did not reply to all topics in produce request")
 	}
 	if len(reqRetry) > 0 {
-		s.handleRetryBatches(reqRetry, &kmove, 0, true, true, "produce request had retry batches")
+		s.handleRetryBatches(reqRetry, &kmove, func(batch seqRecBatch) error { return kerr.ErrorForCode(retryCodes[batch.recBatch]) }, 0, true, true, "produce request had retry batches")
 	}
 }
 
@@ -845,6 +877,7 @@ func (s *sink) handleReqRespBatch(
 		if debug {
 			fmt.Fprintf(b, "retrying@%d,%d(%s)}, ", rp.BaseOffset, nrec, err)
 		}
+		batch.lastErr = err
 		return true, false
 
 	case err == kerr.OutOfOrderSequenceNumber,
@@ -927,7 +960,7 @@ func (s *sink) handleReqRespBatch(
 			)
 			s.cl.failProducerID(producerID, producerEpoch, err)
 
-			s.cl.finishBatch(batch.recBatch, producerID, producerEpoch, rp.Partition, rp.BaseOffset, err)
+			s.cl.finishBatch(batch.recBatch, producerID, producerEpoch, rp.Partition, rp.BaseOffset, rp.LogAppendTime, err)
 			if debug {
 				fmt.Fprintf(b, "fatal@%d,%d(%s)}, ", rp.BaseOffset, nrec, err)
 			}
@@ -972,6 +1005,9 @@ func (s *sink) handleReqRespBatch(
 			"topic", topic,
 			"partition", rp.Partition,
 		)
+		if fn := s.cl.cfg.onDuplicateAcked; fn != nil {
+			fn(topic, rp.Partition, rp.BaseOffset)
+		}
 		err = nil
 		fallthrough
 	default:
@@ -988,7 +1024,7 @@ func (s *sink) handleReqRespBatch(
 			batch.owner.okOnSink = true
 			batch.owner.lastAckedOffset = rp.BaseOffset + int64(len(batch.records))
 		}
-		s.cl.finishBatch(batch.recBatch, producerID, producerEpoch, rp.Partition, rp.BaseOffset, err)
+		s.cl.finishBatch(batch.recBatch, producerID, producerEpoch, rp.Partition, rp.BaseOffset, rp.LogAppendTime, err)
 		didProduce = err == nil
 		if debug {
 			if err != nil {
@@ -1002,18 +1038,19 @@ func (s *sink) handleReqRespBatch(
 }
 
 // finishBatch removes a batch from its owning record buffer and finishes all
-// records in the batch.
+// records in the batch. If the topic uses LogAppendTime, logAppendTime is the
+// broker assigned timestamp for every record in the batch, otherwise it is -1.
 //
 // This is safe even if the owning recBuf migrated sinks, since we are
 // finishing based off the status of an inflight req from the original sink.
-func (cl *Client) finishBatch(batch *recBatch, producerID int64, producerEpoch int16, partition int32, baseOffset int64, err error) {
+func (cl *Client) finishBatch(batch *recBatch, producerID int64, producerEpoch int16, partition int32, baseOffset, logAppendTime int64, err error) {
 	recBuf := batch.owner
 
 	if err != nil {
 		// We know that Kafka replied this batch is a failure. We can
 		// fail this batch and all batches in this partition.
 		// This will keep sequence numbers correct.
-		recBuf.failAllRecords(err)
+		recBuf.failAllRecords(err, false)
 		return
 	}
 
@@ -1032,9 +1069,10 @@ func (cl *Client) finishBatch(batch *recBatch, producerID int64, producerEpoch i
 	batch.mu.Unlock()
 
 	cl.producer.promiseBatch(batchPromise{
-		baseOffset: baseOffset,
-		pid:        producerID,
-		epoch:      producerEpoch,
+		baseOffset:    baseOffset,
+		logAppendTime: logAppendTime,
+		pid:           producerID,
+		epoch:         producerEpoch,
 		// A recBuf.attrs is updated when appending to be written. For
 		// v0 && v1 produce requests, we set bit 8 in the attrs
 		// corresponding to our own RecordAttr's bit 8 being no
@@ -1054,6 +1092,7 @@ func (cl *Client) finishBatch(batch *recBatch, producerID int64, producerEpoch i
 func (s *sink) handleRetryBatches(
 	retry seqRecBatches,
 	kmove *kip951move,
+	retryErr func(seqRecBatch) error, // why a batch is retried, for OnProduceBatchRetry; nil to not call it
 	backoffSeq uint32,
 	updateMeta bool, // if we should maybe update the metadata
 	canFail bool, // if records can fail if they are at limits
@@ -1066,6 +1105,35 @@ func (s *sink) handleRetryBatches(
 	if kmove != nil {
 		defer kmove.maybeBeginMove(s.cl)
 	}
+	// We only call OnProduceBatchRetry for batches that are actually
+	// retried, once no locks are held; tries is read under the owner lock.
+	// We also save why the batch is retried for OnProduceRetryExhausted.
+	type batchRetry struct {
+		topic     string
+		partition int32
+		tries     int
+		err       error
+	}
+	var retries []batchRetry
+	fn := s.cl.cfg.onProduceBatchRetry
+	onRetry := func(batch seqRecBatch) {
+		if retryErr == nil {
+			return
+		}
+		err := retryErr(batch)
+		batch.lastErr = err
+		if fn != nil {
+			retries = append(retries, batchRetry{batch.owner.topic, batch.owner.partition, int(batch.tries), err})
+		}
+	}
+	if fn != nil && retryErr != nil {
+		defer func() {
+			for _, r := range retries {
+				fn(r.topic, r.partition, r.tries, r.err)
+			}
+		}()
+	}
+
 	var numRetryBatches, numMoveBatches int
 	retry.eachOwnerLocked(func(batch seqRecBatch) {
 		numRetryBatches++
@@ -1093,17 +1161,19 @@ func (s *sink) handleRetryBatches(
 					"new_sink", batch.owner.sink.nodeID,
 				)
 			}
+			onRetry(batch)
 			batch.owner.resetBatchDrainIdx()
 			return
 		}
 
 		if canFail || s.cl.cfg.disableIdempotency {
 			if err := batch.maybeFailErr(&s.cl.cfg); err != nil {
-				batch.owner.failAllRecords(err)
+				batch.owner.failAllRecords(err, err == ErrRecordRetries)
 				return
 			}
 		}
 
+		onRetry(batch)
 		batch.owner.resetBatchDrainIdx()
 
 		// Now that the batch drain index is reset, if this retry is
@@ -1209,6 +1279,15 @@ type recBuf struct {
 	// maxRecordBatchBytes because of produce request overhead.
 	maxRecordBatchBytes int32
 
+	// linger is how long this partition lingers, which is the client
+	// linger unless overridden for this topic with ProduceTopicOpts.
+	linger time.Duration
+
+	// acks are the acks for this partition, which are the client acks
+	// unless overridden for this topic with ProduceTopicAcks. Only
+	// partitions with the same acks can be in the same produce request.
+	acks int16
+
 	// addedToTxn, for transactions only, signifies whether this partition
 	// has been added to the transaction yet or not.
 	addedToTxn atomicBool
@@ -1371,7 +1450,7 @@ func (recBuf *recBuf) bufferRecord(pr promisedRec, abortOnNewBatch bool) bool {
 		recBuf.batches = append(recBuf.batches, newBatch)
 	}
 
-	if recBuf.cl.cfg.linger == 0 {
+	if recBuf.linger == 0 {
 		if onDrainBatch {
 			recBuf.sink.maybeDrain()
 		}
@@ -1380,6 +1459,11 @@ func (recBuf *recBuf) bufferRecord(pr promisedRec, abortOnNewBatch bool) bool {
 		// stop lingering and begin draining. The drain loop will
 		// restart our linger once this buffer has one batch left.
 		if newBatch && !onDrainBatch ||
+			// Immediate records always stop lingering.
+			pr.Immediate ||
+			// With FlushOnRecord, we stop lingering once the
+			// lingering batch has enough records.
+			recBuf.lingerFull() ||
 			// If this is the first batch, try lingering; if
 			// we cannot, we are being flushed and must drain.
 			onDrainBatch && !recBuf.lockedMaybeStartLinger() {
@@ -1407,19 +1491,26 @@ func (recBuf *recBuf) bufferRecord(pr promisedRec, abortOnNewBatch bool) bool {
 // lingering, then we are flushing and also indicate there is more to drain.
 func (recBuf *recBuf) tryStopLingerForDraining() bool {
 	recBuf.lockedStopLinger()
-	canLinger := recBuf.cl.cfg.linger == 0
+	canLinger := recBuf.linger == 0
 	moreToDrain := !canLinger && len(recBuf.batches) > recBuf.batchDrainIdx ||
 		canLinger && (len(recBuf.batches) > recBuf.batchDrainIdx+1 ||
 			len(recBuf.batches) == recBuf.batchDrainIdx+1 && !recBuf.lockedMaybeStartLinger())
 	return moreToDrain
 }
 
+// Returns whether FlushOnRecord is enabled and the last batch has enough
+// records to stop lingering.
+func (recBuf *recBuf) lingerFull() bool {
+	n := recBuf.cl.cfg.flushOnRecords
+	return n > 0 && len(recBuf.batches) > 0 && len(recBuf.batches[len(recBuf.batches)-1].records) >= n
+}
+
 // Begins a linger timer unless the producer is being flushed.
 func (recBuf *recBuf) lockedMaybeStartLinger() bool {
 	if recBuf.cl.producer.flushing.Load() > 0 || recBuf.cl.producer.blocked.Load() > 0 {
 		return false
 	}
-	recBuf.lingering = time.AfterFunc(recBuf.cl.cfg.linger, recBuf.sink.maybeDrain)
+	recBuf.lingering = time.AfterFunc(recBuf.linger, recBuf.sink.maybeDrain)
 	return true
 }
 
@@ -1452,6 +1543,7 @@ func (recBuf *recBuf) bumpRepeatedLoadErr(err error) {
 	}
 	batch0 := recBuf.batches[0]
 	batch0.tries++
+	batch0.lastErr = err
 
 	// We need to lock the batch as well because there could be a buffered
 	// request about to be written. Writing requests only grabs the batch
@@ -1459,7 +1551,8 @@ func (recBuf *recBuf) bumpRepeatedLoadErr(err error) {
 	batch0.mu.Lock()
 	var (
 		canFail        = !recBuf.cl.idempotent() || batch0.canFailFromLoadErrs // we can only fail if we are not idempotent or if we have no outstanding requests
-		batch0Fail     = batch0.maybeFailErr(&recBuf.cl.cfg) != nil            // timeout, retries, or aborting
+		batch0Err      = batch0.maybeFailErr(&recBuf.cl.cfg)                   // timeout, retries, or aborting
+		batch0Fail     = batch0Err != nil
 		netErr         = isRetryableBrokerErr(err) || isDialNonTimeoutErr(err) // we can fail if this is *not* a network error
 		retryableKerr  = kerr.IsRetriable(err)                                 // we fail if this is not a retryable kerr,
 		isUnknownLimit = recBuf.checkUnknownFailLimit(err)                     // or if it is, but it is UnknownTopicOrPartition and we are at our limit
@@ -1483,7 +1576,7 @@ func (recBuf *recBuf) bumpRepeatedLoadErr(err error) {
 	)
 
 	if willFail {
-		recBuf.failAllRecords(err)
+		recBuf.failAllRecords(err, batch0Err == ErrRecordRetries)
 	}
 }
 
@@ -1506,8 +1599,23 @@ func (recBuf *recBuf) checkUnknownFailLimit(err error) bool {
 //   - from client closing
 //   - if not idempotent && hit retry / timeout limit
 //   - if batch fails fatally when producing
-func (recBuf *recBuf) failAllRecords(err error) {
+//
+// If exhausted, the first batch is failing because maybeFailErr returned
+// ErrRecordRetries, and every batch is failed for the same reason; see
+// OnProduceRetryExhausted.
+func (recBuf *recBuf) failAllRecords(err error, exhausted bool) {
 	recBuf.lockedStopLinger()
+
+	var exhaustedTries int64
+	var lastErr error
+	if exhausted && len(recBuf.batches) > 0 {
+		batch0 := recBuf.batches[0]
+		exhaustedTries = batch0.tries
+		if lastErr = batch0.lastErr; lastErr == nil {
+			lastErr = err
+		}
+	}
+
 	for _, batch := range recBuf.batches {
 		// We need to guard our clearing of records against a
 		// concurrent produceRequest's write, which can have this batch
@@ -1522,8 +1630,10 @@ func (recBuf *recBuf) failAllRecords(err error) {
 		batch.mu.Unlock()
 
 		recBuf.cl.producer.promiseBatch(batchPromise{
-			recs: records,
-			err:  err,
+			recs:           records,
+			err:            err,
+			exhaustedTries: exhaustedTries,
+			lastErr:        lastErr,
 		})
 	}
 	recBuf.resetBatchDrainIdx()
@@ -1531,6 +1641,54 @@ func (recBuf *recBuf) failAllRecords(err error) {
 	recBuf.batches = nil
 }
 
+// lockedRemoveUnsent removes a record from any batch that has never been
+// written in a request, for AbortRecord. The batch the record is removed from
+// is rebuilt, because removing a record changes the offset and timestamp
+// deltas of every record after it.
+func (recBuf *recBuf) lockedRemoveUnsent(r *Record) (promisedRec, bool) {
+	// Batches before the drain index are in a request, and any batch that
+	// has been tried is part of the sequence number chain; see
+	// ExportBufferedRecords.
+	i := recBuf.batchDrainIdx
+	for i < len(recBuf.batches) && recBuf.batches[i].tries > 0 {
+		i++
+	}
+	for ; i < len(recBuf.batches); i++ {
+		batch := recBuf.batches[i]
+		for j, pr := range batch.records {
+			if pr.Record != r {
+				continue
+			}
+
+			rebuilt := recBuf.newRecordBatch()
+			for k, keep := range batch.records {
+				if k == j {
+					continue
+				}
+				nums := rebuilt.calculateRecordNumbers(keep.Record)
+				rebuilt.appendRecord(keep, nums)
+				keep.setLengthAndTimestampDelta(nums.lengthField, nums.tsDelta)
+			}
+
+			batch.mu.Lock()
+			batch.records = nil
+			batch.mu.Unlock()
+
+			if len(rebuilt.records) == 0 {
+				recBuf.batches = append(recBuf.batches[:i], recBuf.batches[i+1:]...)
+				if i == recBuf.batchDrainIdx && i == len(recBuf.batches) {
+					recBuf.lockedStopLinger()
+				}
+			} else {
+				recBuf.batches[i] = rebuilt
+			}
+			recBuf.buffered.Add(-1)
+			return pr, true
+		}
+	}
+	return promisedRec{}, false
+}
+
 // clearFailing clears a buffer's failing state if it is failing.
 //
 // This is called when a buffer is added to a sink (to clear a failing state
@@ -1546,6 +1704,22 @@ func (recBuf *recBuf) clearFailing() {
 	}
 }
 
+// lockedVerifySeq returns an error if the sequence number of the batch about
+// to be drained is not the sequence that follows every batch before it, which
+// would mean we are about to send batches out of order; see
+// VerifyProduceOrdering.
+func (recBuf *recBuf) lockedVerifySeq() error {
+	exp := recBuf.batch0Seq
+	for _, batch := range recBuf.batches[:recBuf.batchDrainIdx] {
+		exp = incrementSequence(exp, int32(len(batch.records)))
+	}
+	if recBuf.seq != exp {
+		return fmt.Errorf("produce ordering violated for %s[%d]: batch %d is being sent with sequence %d, expected %d (batch 0 sequence %d)",
+			recBuf.topic, recBuf.partition, recBuf.batchDrainIdx, recBuf.seq, exp, recBuf.batch0Seq)
+	}
+	return nil
+}
+
 func (recBuf *recBuf) resetBatchDrainIdx() {
 	recBuf.seq = recBuf.batch0Seq
 	recBuf.batchDrainIdx = 0
@@ -1557,6 +1731,7 @@ type promisedRec struct {
 	ctx     context.Context
 	promise func(*Record, error)
 	*Record
+	seq uint64 // buffered sequence number, for ProduceBarrier
 }
 
 func (pr promisedRec) cancelingCtx() context.Context {
@@ -1573,7 +1748,8 @@ func (pr promisedRec) cancelingCtx() context.Context {
 type recBatch struct {
 	owner *recBuf // who owns us
 
-	tries int64 // if this was sent before and is thus now immutable
+	tries   int64 // if this was sent before and is thus now immutable
+	lastErr error // the last error that caused this batch to be retried
 
 	// We can only fail a batch if we have never issued it, or we have
 	// issued it and have received a response. If we do not receive a
@@ -1815,7 +1991,7 @@ func (p *produceRequest) tryAddBatch(produceVersion int32, recBuf *recBuf, batch
 	if recBuf.batches[0] == batch {
 		if !p.idempotent() || batch.canFailFromLoadErrs {
 			if err := batch.maybeFailErr(&batch.owner.cl.cfg); err != nil {
-				recBuf.failAllRecords(err)
+				recBuf.failAllRecords(err, err == ErrRecordRetries)
 				return false
 			}
 		}
@@ -1967,8 +2143,13 @@ func (cl *Client) maxRecordBatchBytesForTopic(topic string) int32 {
 
 	wireLengthLimit := cl.cfg.maxBrokerWriteBytes
 
+	cfgLimit := cl.cfg.maxRecordBatchBytes
+	if opts, ok := cl.cfg.topicProduceOpts[topic]; ok && opts.maxBatchBytes > 0 {
+		cfgLimit = opts.maxBatchBytes
+	}
+
 	recordBatchLimit := wireLengthLimit - minOnePartitionBatchLength
-	if cfgLimit := cl.cfg.maxRecordBatchBytes; cfgLimit < recordBatchLimit {
+	if cfgLimit < recordBatchLimit {
 		recordBatchLimit = cfgLimit
 	}
 	return recordBatchLimit
@@ -2275,7 +2456,7 @@ func (b seqRecBatch) appendTo(
 	m.UncompressedBytes = len(toCompress)
 	m.CompressedBytes = m.UncompressedBytes
 
-	if compressor != nil {
+	if compressor != nil && len(toCompress) >= compressor.minBytes {
 		w := byteBuffers.Get().(*bytes.Buffer)
 		defer byteBuffers.Put(w)
 		w.Reset()
@@ -2357,7 +2538,7 @@ func (b seqRecBatch) appendToAsMessageSet(dst []byte, version uint8, compressor
 	m.UncompressedBytes = len(toCompress)
 	m.CompressedBytes = m.UncompressedBytes
 
-	if compressor != nil {
+	if compressor != nil && len(toCompress) >= compressor.minBytes {
 		w := byteBuffers.Get().(*bytes.Buffer)
 		defer byteBuffers.Put(w)
 		w.Reset()
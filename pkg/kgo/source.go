@@ -38,7 +38,8 @@ type source struct {
 	sem        chan struct{} // closed when fetchable, recreated when a buffered fetch exists
 	buffered   bufferedFetch // contains a fetch the source has buffered for polling
 
-	session fetchSession // supports fetch sessions as per KIP-227
+	session      fetchSession // supports fetch sessions as per KIP-227
+	resetSession atomicBool   // set by ResetFetchSessions, checked when creating the next request
 
 	cursorsMu    sync.Mutex
 	cursors      []*cursor // contains all partitions being consumed on this source
@@ -51,7 +52,10 @@ func (cl *Client) newSource(nodeID int32) *source {
 		nodeID: nodeID,
 		sem:    make(chan struct{}),
 	}
-	if cl.cfg.disableFetchSessions {
+	// Limiting the partitions per request rotates which partitions are
+	// in each request, and a fetch session would forget and re-add
+	// partitions every request; we do not use sessions in that case.
+	if cl.cfg.disableFetchSessions || cl.cfg.maxConcurrentFetchParts > 0 {
 		s.session.kill()
 	}
 	close(s.sem)
@@ -100,7 +104,20 @@ type cursor struct {
 
 	unknownIDFails atomicI32
 
-	keepControl bool // whether to keep control records
+	keepControl    bool // whether to keep control records
+	includeAborted bool // whether to keep aborted records (read committed only)
+
+	onCorrupt func(int64, error) // non-nil if skipping corrupt batches
+
+	maxRecordBytes int              // 0 if unbounded
+	onTooLarge     func(int64, int) // non-nil if maxRecordBytes > 0
+
+	decode func(*Record) error // non-nil if using a RecordCodec
+
+	// atEOF is whether OnPartitionEOF has been called since this cursor
+	// last received records. This is only accessed while handling fetch
+	// responses, which are serialized per cursor.
+	atEOF bool
 
 	cursorsIdx int // updated under source mutex
 
@@ -401,6 +418,9 @@ func (s *source) hook(f *Fetch, buffered, polled bool) {
 	} else {
 		s.cl.consumer.bufferedRecords.Add(-int64(nrecs))
 		s.cl.consumer.bufferedBytes.Add(-nbytes)
+		if c := &s.cl.consumer; nbytes > 0 && c.cl.cfg.maxBufferedFetchBytes > 0 && !c.overBufferedFetchBytes() {
+			c.signalBufferDrained()
+		}
 	}
 }
 
@@ -486,15 +506,20 @@ func (s *source) discardBuffered() {
 //
 // This only allows a new fetch once every buffered record has been taken.
 //
+// If perPartition is positive, at most perPartition records are taken from
+// each partition, and each partition is visited at most once. Partially taken
+// partitions (and topics) are rotated to the back so that the next take
+// starts with partitions that were not taken from.
+//
 // This returns the number of records taken and whether the source has been
 // completely drained.
-func (s *source) takeNBuffered(paused pausedTopics, n int) (Fetch, int, bool) {
+func (s *source) takeNBuffered(paused pausedTopics, n, perPartition int) (Fetch, int, bool) {
 	var r Fetch
 	var taken int
 
 	b := &s.buffered
 	bf := &b.fetch
-	for len(bf.Topics) > 0 && n > 0 {
+	for topicsLeft := len(bf.Topics); topicsLeft > 0 && len(bf.Topics) > 0 && n > 0; topicsLeft-- {
 		t := &bf.Topics[0]
 
 		// If the topic is outright paused, we allowUsable all
@@ -520,7 +545,7 @@ func (s *source) takeNBuffered(paused pausedTopics, n int) (Fetch, int, bool) {
 
 		tCursors := b.usedOffsets[t.Topic]
 
-		for len(t.Partitions) > 0 && n > 0 {
+		for partsLeft := len(t.Partitions); partsLeft > 0 && len(t.Partitions) > 0 && n > 0; partsLeft-- {
 			p := &t.Partitions[0]
 
 			if paused.has(t.Topic, p.Partition) {
@@ -539,6 +564,9 @@ func (s *source) takeNBuffered(paused pausedTopics, n int) (Fetch, int, bool) {
 			rp := &rt.Partitions[len(rt.Partitions)-1]
 
 			take := n
+			if perPartition > 0 && take > perPartition {
+				take = perPartition
+			}
 			if take > len(p.Records) {
 				take = len(p.Records)
 			}
@@ -570,10 +598,17 @@ func (s *source) takeNBuffered(paused pausedTopics, n int) (Fetch, int, bool) {
 				lastConsumedTime:  lastReturnedRecord.Timestamp,
 				hwm:               p.HighWatermark,
 			})
+			if perPartition > 0 {
+				rotate := *p
+				t.Partitions = append(t.Partitions[1:], rotate)
+			}
 		}
 
 		if len(t.Partitions) == 0 {
 			bf.Topics = bf.Topics[1:]
+		} else if perPartition > 0 {
+			rotate := *t
+			bf.Topics = append(bf.Topics[1:], rotate)
 		}
 	}
 
@@ -600,13 +635,16 @@ func (s *source) takeBufferedFn(polled bool, offsetFn func(usedOffsets)) Fetch {
 
 // createReq actually creates a fetch request.
 func (s *source) createReq() *fetchRequest {
+	if s.resetSession.Swap(false) {
+		s.session.reset()
+	}
 	req := &fetchRequest{
 		maxWait:        s.cl.cfg.maxWait,
 		minBytes:       s.cl.cfg.minBytes,
 		maxBytes:       s.cl.cfg.maxBytes.load(),
 		maxPartBytes:   s.cl.cfg.maxPartBytes.load(),
 		rack:           s.cl.cfg.rack,
-		isolationLevel: s.cl.cfg.isolationLevel,
+		isolationLevel: int8(s.cl.cfg.isolationLevel.load()),
 		preferLagFn:    s.cl.cfg.preferLagFn,
 
 		// We copy a view of the session for the request, which allows
@@ -619,20 +657,36 @@ func (s *source) createReq() *fetchRequest {
 	s.cursorsMu.Lock()
 	defer s.cursorsMu.Unlock()
 
+	var (
+		maxParts = s.cl.cfg.maxConcurrentFetchParts
+		added    int
+	)
 	cursorIdx := s.cursorsStart
 	for i := 0; i < len(s.cursors); i++ {
+		if maxParts > 0 && added == maxParts {
+			break
+		}
 		c := s.cursors[cursorIdx]
 		cursorIdx = (cursorIdx + 1) % len(s.cursors)
 		if !c.usable() || paused.has(c.topic, c.partition) {
 			continue
 		}
 		req.addCursor(c)
+		added++
 	}
 
 	// We could have lost our only record buffer just before we grabbed the
 	// source lock above.
+	//
+	// If we are limiting the partitions per request, the next request
+	// starts where this one stopped so that we round-robin through all
+	// partitions.
 	if len(s.cursors) > 0 {
-		s.cursorsStart = (s.cursorsStart + 1) % len(s.cursors)
+		if maxParts > 0 {
+			s.cursorsStart = cursorIdx
+		} else {
+			s.cursorsStart = (s.cursorsStart + 1) % len(s.cursors)
+		}
 	}
 
 	return req
@@ -727,7 +781,7 @@ func (s *source) killSessionOnClose(ctx context.Context) {
 		maxBytes:       1,
 		maxPartBytes:   1,
 		rack:           s.cl.cfg.rack,
-		isolationLevel: s.cl.cfg.isolationLevel,
+		isolationLevel: int8(s.cl.cfg.isolationLevel.load()),
 		session:        s.session,
 	}
 	ch := make(chan struct{})
@@ -1019,6 +1073,10 @@ func (s *source) handleReqResp(br *broker, req *fetchRequest, resp *kmsg.FetchRe
 		debugWhyStripped.add(t, p, err)
 	}
 
+	// If we have more than one decompress worker, we process all
+	// partitions concurrently up front and then use those results below.
+	processed := s.processRespPartitionsConcurrently(br, req, resp)
+
 	for _, rt := range resp.Topics {
 		topic := rt.Topic
 		// v13 only uses topic IDs, so we have to map the response
@@ -1069,7 +1127,10 @@ func (s *source) handleReqResp(br *broker, req *fetchRequest, resp *kmsg.FetchRe
 				continue
 			}
 
-			fp := partOffset.processRespPartition(br, rp, s.cl.decompressor, s.cl.cfg.hooks)
+			fp, ok := processed[rp]
+			if !ok {
+				fp = partOffset.processRespPartition(br, rp, req.isolationLevel, s.cl.decompressor, s.cl.cfg.hooks)
+			}
 			if fp.Err != nil {
 				if moving := kmove.maybeAddFetchPartition(resp, rp, partOffset.from); moving {
 					strip(topic, partition, fp.Err)
@@ -1101,6 +1162,9 @@ func (s *source) handleReqResp(br *broker, req *fetchRequest, resp *kmsg.FetchRe
 			case nil:
 				partOffset.from.unknownIDFails.Store(0)
 				keep = true
+				if s.cl.cfg.onPartitionEOF != nil {
+					partOffset.maybeEOF(&s.cl.consumer, &fp, req.isolationLevel)
+				}
 
 			case kerr.UnknownTopicID:
 				// We need to keep UnknownTopicID even though it is
@@ -1151,7 +1215,25 @@ func (s *source) handleReqResp(br *broker, req *fetchRequest, resp *kmsg.FetchRe
 				// no reset offset was configured. If so, we ignore
 				// trying to reset and instead keep our failed partition.
 				addList := func(replica int32, log bool) {
-					if s.cl.cfg.resetOffset.noReset {
+					if fn := s.cl.cfg.onOffsetOutOfRange; fn != nil {
+						reset, err := fn(s.cl.consumer.logicalTopic(topic), partition, partOffset.offset)
+						if err != nil {
+							fp.Err = err
+							keep = true
+							return
+						}
+						reloadOffsets.addLoad(topic, partition, loadTypeList, offsetLoad{
+							replica: replica,
+							Offset:  reset,
+						})
+						s.cl.cfg.logger.Log(LogLevelInfo, "received OFFSET_OUT_OF_RANGE, resetting to the offset returned from OnOffsetOutOfRange",
+							"broker", logID(s.nodeID),
+							"topic", topic,
+							"partition", partition,
+							"prior_offset", partOffset.offset,
+							"reset_offset", reset,
+						)
+					} else if s.cl.cfg.resetOffset.noReset {
 						keep = true
 					} else if !partOffset.from.lastConsumedTime.IsZero() {
 						reloadOffsets.addLoad(topic, partition, loadTypeList, offsetLoad{
@@ -1244,9 +1326,76 @@ func (s *source) handleReqResp(br *broker, req *fetchRequest, resp *kmsg.FetchRe
 	return f, reloadOffsets, preferreds, req.numOffsets == numErrsStripped, updateWhy
 }
 
+// processRespPartitionsConcurrently processes every partition in a response
+// that handleReqResp would process, using up to the configured number of
+// decompress workers. Each partition is processed entirely by one worker,
+// keeping records in order within a partition. This returns nil if there are
+// not multiple workers or not multiple partitions to process.
+func (s *source) processRespPartitionsConcurrently(br *broker, req *fetchRequest, resp *kmsg.FetchResponse) map[*kmsg.FetchResponseTopicPartition]FetchPartition {
+	workers := s.cl.cfg.fetchDecompressWorkers
+	if workers <= 1 {
+		return nil
+	}
+
+	type work struct {
+		o  *cursorOffsetNext
+		rp *kmsg.FetchResponseTopicPartition
+	}
+	var works []work
+	for i := range resp.Topics {
+		rt := &resp.Topics[i]
+		topic := rt.Topic
+		if resp.Version >= 13 {
+			topic = req.id2topic[rt.TopicID]
+		}
+		topicOffsets := req.usedOffsets[topic]
+		for j := range rt.Partitions {
+			rp := &rt.Partitions[j]
+			o, ok := topicOffsets[rp.Partition]
+			if !ok || resp.Version >= 11 && rp.PreferredReadReplica >= 0 {
+				continue // handleReqResp does not process these
+			}
+			works = append(works, work{o, rp})
+		}
+	}
+	if len(works) <= 1 {
+		return nil
+	}
+	if workers > len(works) {
+		workers = len(works)
+	}
+
+	var (
+		fps  = make([]FetchPartition, len(works))
+		next atomicI64
+		wg   sync.WaitGroup
+	)
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				idx := int(next.Add(1) - 1)
+				if idx >= len(works) {
+					return
+				}
+				w := works[idx]
+				fps[idx] = w.o.processRespPartition(br, w.rp, req.isolationLevel, s.cl.decompressor, s.cl.cfg.hooks)
+			}
+		}()
+	}
+	wg.Wait()
+
+	processed := make(map[*kmsg.FetchResponseTopicPartition]FetchPartition, len(works))
+	for i, w := range works {
+		processed[w.rp] = fps[i]
+	}
+	return processed
+}
+
 // processRespPartition processes all records in all potentially compressed
 // batches (or message sets).
-func (o *cursorOffsetNext) processRespPartition(br *broker, rp *kmsg.FetchResponseTopicPartition, decompressor *decompressor, hooks hooks) FetchPartition {
+func (o *cursorOffsetNext) processRespPartition(br *broker, rp *kmsg.FetchResponseTopicPartition, isolationLevel int8, decompressor *decompressor, hooks hooks) FetchPartition {
 	fp := FetchPartition{
 		Partition:        rp.Partition,
 		Err:              kerr.ErrorForCode(rp.ErrorCode),
@@ -1259,7 +1408,7 @@ func (o *cursorOffsetNext) processRespPartition(br *broker, rp *kmsg.FetchRespon
 	}
 
 	var aborter aborter
-	if br.cl.cfg.isolationLevel == 1 {
+	if isolationLevel == 1 {
 		aborter = buildAborter(rp)
 	}
 
@@ -1285,6 +1434,7 @@ func (o *cursorOffsetNext) processRespPartition(br *broker, rp *kmsg.FetchRespon
 		crcField    *int32
 		crcTable    *crc32.Table
 		crcAt       int
+		badCRC      bool
 
 		check = func() bool {
 			// If we call into check, we know we have a valid
@@ -1308,6 +1458,7 @@ func (o *cursorOffsetNext) processRespPartition(br *broker, rp *kmsg.FetchRespon
 			}
 			if crcCalc := int32(crc32.Checksum(in[crcAt:length], crcTable)); crcCalc != *crcField {
 				fp.Err = fmt.Errorf("encoded crc %x does not match calculated crc %x", *crcField, crcCalc)
+				badCRC = true
 				return false
 			}
 			return true
@@ -1357,7 +1508,29 @@ func (o *cursorOffsetNext) processRespPartition(br *broker, rp *kmsg.FetchRespon
 		}
 
 		if !check() {
-			break
+			if !badCRC || o.from.onCorrupt == nil {
+				break
+			}
+			// The batch is complete but corrupt; we skip past it.
+			// Anything within the batch, including the last offset
+			// delta, cannot be trusted, so we skip to the start of
+			// the next complete batch. If this is the last batch,
+			// we advance one and let the next fetch find where the
+			// next batch begins.
+			in = in[length:]
+			next := offset + 1
+			if len(in) > 17 && uint64(len(in)) >= uint64(binary.BigEndian.Uint32(in[8:]))+12 {
+				if first := int64(binary.BigEndian.Uint64(in)); first > next {
+					next = first
+				}
+			}
+			o.from.onCorrupt(offset, fp.Err)
+			if next > o.offset {
+				o.offset = next
+			}
+			fp.Err = nil
+			badCRC = false
+			continue
 		}
 
 		in = in[length:]
@@ -1394,6 +1567,63 @@ func (o *cursorOffsetNext) processRespPartition(br *broker, rp *kmsg.FetchRespon
 	return fp
 }
 
+// maybeEOF calls the OnPartitionEOF function if a successful fetch returned
+// no records and our offset is at the end of the partition: the high
+// watermark, or the last stable offset if reading committed. The function is
+// only called once per catching up.
+func (o *cursorOffsetNext) maybeEOF(c *consumer, fp *FetchPartition, isolationLevel int8) {
+	if len(fp.Records) > 0 {
+		o.from.atEOF = false
+		return
+	}
+	end := fp.HighWatermark
+	if isolationLevel == 1 {
+		end = fp.LastStableOffset
+	}
+	if o.offset < end || o.from.atEOF {
+		return
+	}
+	o.from.atEOF = true
+	c.cl.cfg.onPartitionEOF(c.logicalTopic(o.from.topic), o.from.partition, o.offset)
+}
+
+// corruptBatchFn returns the function a cursor uses when skipping a corrupt
+// batch, or nil if SkipCorruptBatches is not enabled.
+func (cl *Client) corruptBatchFn(topic string, partition int32) func(int64, error) {
+	if !cl.cfg.skipCorruptBatches {
+		return nil
+	}
+	return func(offset int64, err error) {
+		cl.cfg.logger.Log(LogLevelWarn, "skipping corrupt batch",
+			"topic", topic,
+			"partition", partition,
+			"offset", offset,
+			"err", err,
+		)
+		if fn := cl.cfg.onCorruptBatch; fn != nil {
+			fn(topic, partition, offset, err)
+		}
+	}
+}
+
+func (cl *Client) recordTooLargeFn(topic string, partition int32) func(int64, int) {
+	if cl.cfg.maxConsumeRecordBytes <= 0 {
+		return nil
+	}
+	return func(offset int64, size int) {
+		cl.cfg.logger.Log(LogLevelWarn, "skipping record with a value larger than the max consume record bytes",
+			"topic", topic,
+			"partition", partition,
+			"offset", offset,
+			"value_bytes", size,
+			"max_bytes", cl.cfg.maxConsumeRecordBytes,
+		)
+		if fn := cl.cfg.onRecordTooLarge; fn != nil {
+			fn(topic, partition, offset, size)
+		}
+	}
+}
+
 type aborter map[int64][]int64
 
 func buildAborter(rp *kmsg.FetchResponseTopicPartition) aborter {
@@ -1476,7 +1706,16 @@ func (o *cursorOffsetNext) processRecordBatch(
 	if compression := byte(batch.Attributes & 0x0007); compression != 0 {
 		var err error
 		if rawRecords, err = decompressor.decompress(rawRecords, compression); err != nil {
-			return 0, 0 // truncated batch
+			// If skipping corrupt batches, a complete batch that
+			// fails to decompress is skipped. Otherwise, we treat
+			// this as a truncated batch.
+			if o.from.onCorrupt != nil {
+				o.from.onCorrupt(batch.FirstOffset, fmt.Errorf("unable to decompress batch: %w", err))
+				if next := lastOffset + 1; next > o.offset {
+					o.offset = next
+				}
+			}
+			return 0, 0
 		}
 	}
 
@@ -1497,7 +1736,7 @@ func (o *cursorOffsetNext) processRecordBatch(
 	// either advance offsets or will set to nextAskOffset.
 	nextAskOffset := lastOffset + 1
 	defer func() {
-		if numRecords == len(krecords) && o.offset < nextAskOffset {
+		if numRecords == len(krecords) && fp.Err == nil && o.offset < nextAskOffset {
 			o.offset = nextAskOffset
 		}
 	}()
@@ -1510,7 +1749,9 @@ func (o *cursorOffsetNext) processRecordBatch(
 			batch,
 			&krecords[i],
 		)
-		o.maybeKeepRecord(fp, record, abortBatch)
+		if !o.maybeKeepRecord(fp, record, abortBatch) {
+			return i, uncompressedBytes
+		}
 
 		if abortBatch && record.Attrs.IsControl() {
 			// A control record has a key and a value where the key
@@ -1525,6 +1766,19 @@ func (o *cursorOffsetNext) processRecordBatch(
 	return len(krecords), uncompressedBytes
 }
 
+// maybeSkipCorruptMessage skips past a complete v0 or v1 outer message that
+// fails to decompress, if skipping corrupt batches. The offset of an outer
+// message is the offset of its last inner message.
+func (o *cursorOffsetNext) maybeSkipCorruptMessage(offset int64, err error) {
+	if o.from.onCorrupt == nil {
+		return
+	}
+	o.from.onCorrupt(offset, fmt.Errorf("unable to decompress message: %w", err))
+	if next := offset + 1; next > o.offset {
+		o.offset = next
+	}
+}
+
 // Processes an outer v1 message. There could be no inner message, which makes
 // this easy, but if not, we decompress and process each inner message as
 // either v0 or v1. We only expect the inner message to be v1, but technically
@@ -1542,6 +1796,7 @@ func (o *cursorOffsetNext) processV1OuterMessage(
 
 	rawInner, err := decompressor.decompress(message.Value, compression)
 	if err != nil {
+		o.maybeSkipCorruptMessage(message.Offset, err)
 		return 0, 0 // truncated batch
 	}
 
@@ -1634,8 +1889,7 @@ func (o *cursorOffsetNext) processV1Message(
 		return false
 	}
 	record := v1MessageToRecord(o.from.topic, fp.Partition, message)
-	o.maybeKeepRecord(fp, record, false)
-	return true
+	return o.maybeKeepRecord(fp, record, false)
 }
 
 // Processes an outer v0 message. We expect inner messages to be entirely v0 as
@@ -1653,6 +1907,7 @@ func (o *cursorOffsetNext) processV0OuterMessage(
 
 	rawInner, err := decompressor.decompress(message.Value, compression)
 	if err != nil {
+		o.maybeSkipCorruptMessage(message.Offset, err)
 		return 0, 0 // truncated batch
 	}
 
@@ -1710,24 +1965,41 @@ func (o *cursorOffsetNext) processV0Message(
 		return false
 	}
 	record := v0MessageToRecord(o.from.topic, fp.Partition, message)
-	o.maybeKeepRecord(fp, record, false)
-	return true
+	return o.maybeKeepRecord(fp, record, false)
 }
 
 // maybeKeepRecord keeps a record if it is within our range of offsets to keep.
 //
 // If the record is being aborted or the record is a control record and the
 // client does not want to keep control records, this does not keep the record.
-func (o *cursorOffsetNext) maybeKeepRecord(fp *FetchPartition, record *Record, abort bool) {
+// This returns false if the record could not be decoded, in which case
+// fp.Err is set and processing must stop at this record.
+func (o *cursorOffsetNext) maybeKeepRecord(fp *FetchPartition, record *Record, abort bool) bool {
 	if record.Offset < o.offset {
 		// We asked for offset 5, but that was in the middle of a
 		// batch; we got offsets 0 thru 4 that we need to skip.
-		return
+		return true
 	}
 
-	// We only keep control records if specifically requested.
-	if record.Attrs.IsControl() {
+	// We only keep control records if specifically requested. Aborted
+	// records can also be kept, but are marked as such. Records with a
+	// value larger than MaxConsumeRecordBytes are always skipped.
+	if o.from.maxRecordBytes > 0 && len(record.Value) > o.from.maxRecordBytes && !record.Attrs.IsControl() {
+		if !abort {
+			o.from.onTooLarge(record.Offset, len(record.Value))
+		}
+		abort = true
+	} else if record.Attrs.IsControl() {
 		abort = !o.from.keepControl
+	} else if abort && o.from.includeAborted {
+		record.Aborted = true
+		abort = false
+	}
+	if !abort && !record.Attrs.IsControl() && o.from.decode != nil {
+		if err := o.from.decode(record); err != nil {
+			fp.Err = err
+			return false
+		}
 	}
 	if !abort {
 		fp.Records = append(fp.Records, record)
@@ -1738,6 +2010,7 @@ func (o *cursorOffsetNext) maybeKeepRecord(fp *FetchPartition, record *Record, a
 	o.offset = record.Offset + 1
 	o.lastConsumedEpoch = record.LeaderEpoch
 	o.lastConsumedTime = record.Timestamp
+	return true
 }
 
 ///////////////////////////////
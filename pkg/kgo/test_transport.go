@@ -0,0 +1,98 @@
+package kgo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// TestTransport is an in-memory transport that replaces all broker
+// connections, for unit testing code that uses a client without running a
+// broker (or kfake). See the WithTestTransport option.
+//
+// RoundTrip is called for every request the client would issue to a broker,
+// including internal requests (metadata, producer ID initialization, produce,
+// fetch, and so on). The request is always a kmsg type: internal produce and
+// fetch requests are encoded and decoded into a *kmsg.ProduceRequest or
+// *kmsg.FetchRequest before being passed along, so that they can be
+// inspected. The request version is set to the highest version the client
+// supports (or the max version pinned with MaxVersions).
+//
+// RoundTrip must return either a non-nil response of the request's response
+// type (the response version is set to the request version for you), or an
+// error. RoundTrip may be called concurrently.
+type TestTransport interface {
+	RoundTrip(ctx context.Context, broker BrokerMetadata, req kmsg.Request) (kmsg.Response, error)
+}
+
+// handleTestReq issues a request through the user's TestTransport rather
+// than over a connection.
+func (b *broker) handleTestReq(pr promisedReq, tt TestTransport) {
+	req := pr.req
+
+	version := req.MaxVersion()
+	if b.cl.cfg.maxVersions != nil {
+		userMax, ok := b.cl.cfg.maxVersions.LookupMaxKeyVersion(req.Key())
+		if !ok {
+			pr.promise(nil, errUnknownRequestKey)
+			return
+		}
+		if userMax < version {
+			version = userMax
+		}
+	}
+	req.SetVersion(version)
+
+	select {
+	case <-pr.ctx.Done():
+		pr.promise(nil, pr.ctx.Err())
+		return
+	default:
+	}
+
+	// Our internal produce and fetch requests can only be encoded, so we
+	// round trip them through their kmsg equivalents.
+	rtReq := req
+	var noResp bool
+	switch r := req.(type) {
+	case *produceRequest:
+		noResp = r.acks == 0
+		rtReq = kmsg.NewPtrProduceRequest()
+	case *fetchRequest:
+		rtReq = kmsg.NewPtrFetchRequest()
+	case *kmsg.ProduceRequest:
+		r.Acks = b.cl.cfg.acks.val
+		noResp = r.Acks == 0
+	}
+	if rtReq != req {
+		rtReq.SetVersion(req.GetVersion())
+		if err := rtReq.ReadFrom(req.AppendTo(nil)); err != nil {
+			pr.promise(nil, fmt.Errorf("unable to decode internal request key %d for the test transport: %w", req.Key(), err))
+			return
+		}
+	}
+
+	resp, err := tt.RoundTrip(pr.ctx, b.meta, rtReq)
+	b.reachable.Store(err == nil)
+	if noResp && err == nil {
+		r := kmsg.NewPtrProduceResponse()
+		r.Version = req.GetVersion()
+		pr.promise(r, nil)
+		return
+	}
+	if err == nil {
+		switch {
+		case resp == nil:
+			err = fmt.Errorf("test transport returned no response and no error for request key %d", req.Key())
+		case resp.Key() != req.Key():
+			err = fmt.Errorf("test transport returned response key %d for request key %d", resp.Key(), req.Key())
+		default:
+			resp.SetVersion(req.GetVersion())
+		}
+	}
+	if err != nil {
+		resp = nil
+	}
+	pr.promise(resp, err)
+}
@@ -0,0 +1,120 @@
+package kgo
+
+import "regexp"
+
+// This file contains the helpers for TopicNameMapper. Topic names passed to
+// and returned from the client's API are logical names; the client maps them
+// to wire names on the way in and back on the way out, and uses wire names
+// internally.
+
+// wireProduceTopics returns the wire names for logical produce topics.
+func (cl *Client) wireProduceTopics(topics []string) []string {
+	fn := cl.cfg.produceTopicMapper
+	if fn == nil {
+		return topics
+	}
+	mapped := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		mapped = append(mapped, fn(topic))
+	}
+	return mapped
+}
+
+// mapsTopics returns whether consumed topic names are mapped.
+func (c *consumer) mapsTopics() bool {
+	return c.cl.cfg.consumeTopicMapper != nil && !c.cl.cfg.regex
+}
+
+// wireTopic returns the wire name for a logical topic, remembering the
+// logical name so that records consumed from the topic are returned with it.
+func (c *consumer) wireTopic(topic string) string {
+	if !c.mapsTopics() {
+		return topic
+	}
+	wire := c.cl.cfg.consumeTopicMapper(topic)
+	c.logicalNames.Store(wire, topic)
+	return wire
+}
+
+// logicalTopic returns the logical name for a wire topic, or the wire name if
+// it was never mapped.
+func (c *consumer) logicalTopic(wire string) string {
+	if !c.mapsTopics() {
+		return wire
+	}
+	if logical, ok := c.logicalNames.Load(wire); ok {
+		return logical.(string)
+	}
+	return wire
+}
+
+func (c *consumer) wireTopics(topics []string) []string {
+	if !c.mapsTopics() {
+		return topics
+	}
+	mapped := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		mapped = append(mapped, c.wireTopic(topic))
+	}
+	return mapped
+}
+
+func (c *consumer) logicalTopics(topics []string) []string {
+	if !c.mapsTopics() {
+		return topics
+	}
+	for i, topic := range topics {
+		topics[i] = c.logicalTopic(topic)
+	}
+	return topics
+}
+
+// mapTopicKeys returns m with every topic key passed through fn, or m itself
+// if the client does not map topic names.
+func mapTopicKeys[V any](c *consumer, m map[string]V, fn func(string) string) map[string]V {
+	if !c.mapsTopics() || m == nil {
+		return m
+	}
+	mapped := make(map[string]V, len(m))
+	for topic, v := range m {
+		mapped[fn(topic)] = v
+	}
+	return mapped
+}
+
+// mapConfiguredTopics maps the topics and partitions configured with
+// ConsumeTopics and ConsumePartitions to their wire names.
+func (c *consumer) mapConfiguredTopics() {
+	if !c.mapsTopics() {
+		return
+	}
+	cfg := &c.cl.cfg
+	if cfg.topics != nil {
+		topics := make(map[string]*regexp.Regexp, len(cfg.topics))
+		for topic, re := range cfg.topics {
+			topics[c.wireTopic(topic)] = re
+		}
+		cfg.topics = topics
+	}
+	cfg.partitions = mapTopicKeys(c, cfg.partitions, c.wireTopic)
+}
+
+// logicalFetches rewrites the topics of polled fetches, and the records
+// within them, to their logical names.
+func (c *consumer) logicalFetches(fetches Fetches) Fetches {
+	if !c.mapsTopics() {
+		return fetches
+	}
+	for i := range fetches {
+		for j := range fetches[i].Topics {
+			t := &fetches[i].Topics[j]
+			t.Topic = c.logicalTopic(t.Topic)
+			for _, p := range t.Partitions {
+				for _, r := range p.Records {
+					r.Topic = t.Topic
+				}
+			}
+		}
+	}
+	return fetches
+}
@@ -510,6 +510,13 @@ type topicPartition struct {
 	// Only one of records or cursor is non-nil.
 	records *recBuf
 	cursor  *cursor
+
+	// The replica sets from the metadata load; these are only used for
+	// PartitionReplicas and are not part of topicPartitionData because
+	// they do not affect where we produce or consume.
+	replicas        []int32
+	isr             []int32
+	offlineReplicas []int32
 }
 
 func (tp *topicPartition) partition() int32 {
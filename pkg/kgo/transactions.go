@@ -0,0 +1,238 @@
+package kgo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// ListedTransaction is a transaction returned from ListTransactions.
+type ListedTransaction struct {
+	TransactionalID string // TransactionalID is the transactional ID of the producer.
+	ProducerID      int64  // ProducerID is the producer ID of the producer.
+	State           string // State is the current transaction state, e.g. "Ongoing".
+}
+
+// ListTransactions lists transactions across all brokers (KIP-664, Kafka
+// 3.0+). If states is non-empty, only transactions in those states (e.g.
+// "Ongoing", "PrepareCommit") are listed. If producerIDs is non-empty, only
+// transactions for those producer IDs are listed.
+//
+// This issues a ListTransactionsRequest to every broker and merges the
+// results; if any broker fails, the first error is returned alongside the
+// transactions that could be listed. Only transactions that the client is
+// authorized to describe are returned.
+func (cl *Client) ListTransactions(ctx context.Context, states []string, producerIDs []int64) ([]ListedTransaction, error) {
+	req := kmsg.NewPtrListTransactionsRequest()
+	req.StateFilters = states
+	req.ProducerIDFilters = producerIDs
+
+	resp, err := req.RequestWith(ctx, cl)
+	if resp == nil {
+		return nil, err
+	}
+	listed := make([]ListedTransaction, 0, len(resp.TransactionStates))
+	for _, s := range resp.TransactionStates {
+		listed = append(listed, ListedTransaction{
+			TransactionalID: s.TransactionalID,
+			ProducerID:      s.ProducerID,
+			State:           s.TransactionState,
+		})
+	}
+	if err == nil {
+		err = kerr.ErrorForCode(resp.ErrorCode)
+	}
+	return listed, err
+}
+
+// DescribedTransaction is a transaction returned from DescribeTransaction.
+type DescribedTransaction struct {
+	// TransactionalID is the transactional ID that was described.
+	TransactionalID string
+
+	// State is the current transaction state, e.g. "Ongoing" or
+	// "CompleteCommit".
+	State string
+
+	// Timeout is the transaction timeout the producer initialized with.
+	Timeout time.Duration
+
+	// StartTime is when the current transaction started, or the zero time
+	// if there is no ongoing transaction.
+	StartTime time.Time
+
+	// ProducerID and ProducerEpoch are the current producer ID and epoch
+	// for the transactional ID.
+	ProducerID    int64
+	ProducerEpoch int16
+
+	// Partitions are the partitions that are currently a part of the
+	// transaction.
+	Partitions map[string][]int32
+}
+
+// DescribeTransaction describes a transactional ID (KIP-664, Kafka 3.0+),
+// issuing a DescribeTransactionsRequest to the transaction coordinator for
+// the ID. This can be used alongside ListTransactions to find hung
+// transactions, i.e. "Ongoing" transactions with an old StartTime, which
+// prevent read committed consumers from progressing past the last stable
+// offset.
+func (cl *Client) DescribeTransaction(ctx context.Context, txnID string) (DescribedTransaction, error) {
+	req := kmsg.NewPtrDescribeTransactionsRequest()
+	req.TransactionalIDs = []string{txnID}
+
+	resp, err := req.RequestWith(ctx, cl)
+	if err != nil {
+		return DescribedTransaction{}, err
+	}
+	for _, s := range resp.TransactionStates {
+		if s.TransactionalID != txnID {
+			continue
+		}
+		if err := kerr.ErrorForCode(s.ErrorCode); err != nil {
+			return DescribedTransaction{}, err
+		}
+		d := DescribedTransaction{
+			TransactionalID: s.TransactionalID,
+			State:           s.State,
+			Timeout:         time.Duration(s.TimeoutMillis) * time.Millisecond,
+			ProducerID:      s.ProducerID,
+			ProducerEpoch:   s.ProducerEpoch,
+			Partitions:      make(map[string][]int32, len(s.Topics)),
+		}
+		if s.StartTimestamp >= 0 {
+			d.StartTime = time.UnixMilli(s.StartTimestamp)
+		}
+		for _, t := range s.Topics {
+			d.Partitions[t.Topic] = append(d.Partitions[t.Topic], t.Partitions...)
+		}
+		return d, nil
+	}
+	return DescribedTransaction{}, fmt.Errorf("transactional ID %q missing in DescribeTransactions response", txnID)
+}
+
+// AbortTransaction forcefully aborts a hung transaction on a single partition
+// by writing an abort marker for the given producer ID and epoch (KIP-664),
+// issuing a WriteTxnMarkersRequest to the partition leader.
+//
+// This is an operator escape hatch and should be used with extreme care. It is
+// meant for when a zombie producer (or a coordinator bug) leaves a transaction
+// open on a partition, pinning the last stable offset and stalling read
+// committed consumers indefinitely. Aborting a transaction that is not hung
+// breaks the transaction's atomicity guarantees. The producer ID, producer
+// epoch, and coordinator epoch can be found with DescribeProducers on the
+// partition; the coordinator epoch is used by the broker to fence stale
+// markers. WriteTxnMarkers is normally a broker-to-broker
+// request and requires CLUSTER_ACTION on the cluster.
+func (cl *Client) AbortTransaction(ctx context.Context, topic string, partition int32, producerID int64, producerEpoch int16, coordinatorEpoch int32) error {
+	req := kmsg.NewPtrWriteTxnMarkersRequest()
+	rm := kmsg.NewWriteTxnMarkersRequestMarker()
+	rm.ProducerID = producerID
+	rm.ProducerEpoch = producerEpoch
+	rm.Committed = false
+	rm.CoordinatorEpoch = coordinatorEpoch
+	rt := kmsg.NewWriteTxnMarkersRequestMarkerTopic()
+	rt.Topic = topic
+	rt.Partitions = []int32{partition}
+	rm.Topics = append(rm.Topics, rt)
+	req.Markers = append(req.Markers, rm)
+
+	resp, err := req.RequestWith(ctx, cl)
+	if err != nil {
+		return err
+	}
+	for _, m := range resp.Markers {
+		if m.ProducerID != producerID {
+			continue
+		}
+		for _, t := range m.Topics {
+			if t.Topic != topic {
+				continue
+			}
+			for _, p := range t.Partitions {
+				if p.Partition == partition {
+					return kerr.ErrorForCode(p.ErrorCode)
+				}
+			}
+		}
+	}
+	return fmt.Errorf("%s[%d] missing in WriteTxnMarkers response", topic, partition)
+}
+
+// DescribedProducer is an active producer on a partition, returned from
+// DescribeProducers.
+type DescribedProducer struct {
+	// ProducerID and ProducerEpoch are the producer's ID and epoch.
+	ProducerID    int64
+	ProducerEpoch int16
+
+	// LastSequence is the last sequence number the broker has written
+	// for the producer, or -1 if unknown.
+	LastSequence int32
+
+	// LastTimestamp is the timestamp of the last record the broker has
+	// written for the producer, or the zero time if unknown.
+	LastTimestamp time.Time
+
+	// CoordinatorEpoch is the epoch of the transaction coordinator that
+	// last wrote a transaction marker for the producer.
+	CoordinatorEpoch int32
+
+	// CurrentTxnStartOffset is the offset of the first record in the
+	// producer's ongoing transaction, or -1 if there is no ongoing
+	// transaction.
+	CurrentTxnStartOffset int64
+}
+
+// DescribeProducers describes the active producers on a partition (KIP-664,
+// Kafka 3.0+), issuing a DescribeProducersRequest to the partition leader.
+//
+// This is the broker's view of the idempotent and transactional producers
+// writing to the partition, and is useful for debugging sequence number
+// (OutOfOrderSequenceNumber) or fencing issues. Producers with a non-negative
+// CurrentTxnStartOffset have an open transaction on the partition; if such a
+// transaction is hung, see AbortTransaction.
+func (cl *Client) DescribeProducers(ctx context.Context, topic string, partition int32) ([]DescribedProducer, error) {
+	req := kmsg.NewPtrDescribeProducersRequest()
+	rt := kmsg.NewDescribeProducersRequestTopic()
+	rt.Topic = topic
+	rt.Partitions = []int32{partition}
+	req.Topics = append(req.Topics, rt)
+
+	resp, err := req.RequestWith(ctx, cl)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range resp.Topics {
+		if t.Topic != topic {
+			continue
+		}
+		for _, p := range t.Partitions {
+			if p.Partition != partition {
+				continue
+			}
+			if err := kerr.ErrorForCode(p.ErrorCode); err != nil {
+				return nil, err
+			}
+			described := make([]DescribedProducer, 0, len(p.ActiveProducers))
+			for _, ap := range p.ActiveProducers {
+				d := DescribedProducer{
+					ProducerID:            ap.ProducerID,
+					ProducerEpoch:         int16(ap.ProducerEpoch),
+					LastSequence:          ap.LastSequence,
+					CoordinatorEpoch:      ap.CoordinatorEpoch,
+					CurrentTxnStartOffset: ap.CurrentTxnStartOffset,
+				}
+				if ap.LastTimestamp >= 0 {
+					d.LastTimestamp = time.UnixMilli(ap.LastTimestamp)
+				}
+				described = append(described, d)
+			}
+			return described, nil
+		}
+	}
+	return nil, fmt.Errorf("%s[%d] missing in DescribeProducers response", topic, partition)
+}
@@ -228,6 +228,11 @@ func (s *GroupTransactSession) failed() bool {
 // successful. If any of these conditions are false, this aborts. This flushes
 // or aborts depending on `commit`.
 //
+// Before ending a transaction with a commit, this forces a heartbeat to ensure
+// the member is still in the group. If the heartbeat does not complete within
+// the group's rebalance timeout, this aborts and returns
+// ErrForcedHeartbeatTimeout.
+//
 // This returns whether the transaction committed or any error that occurred.
 // No returned error is retryable. Either the transactional ID has entered a
 // failed state, or the client retried so much that the retry limit was hit,
@@ -235,6 +240,33 @@ func (s *GroupTransactSession) failed() bool {
 // it will likely leave the client in an invalid state. Canceling should only
 // be done if you want to shut down.
 func (s *GroupTransactSession) End(ctx context.Context, commit TransactionEndTry) (committed bool, err error) {
+	return s.end(ctx, commit, nil)
+}
+
+// TransactEndResult is the result of ending a transaction with EndDetailed.
+type TransactEndResult struct {
+	// Committed is whether the transaction committed.
+	Committed bool
+
+	// ResetOffsets, if the transaction did not commit, are the offsets
+	// that consuming was reset to: the group's currently committed
+	// offsets. Every record at or after these offsets will be consumed
+	// again. This is nil if the transaction committed.
+	ResetOffsets map[string]map[int32]EpochOffset
+}
+
+// EndDetailed is exactly the same as End, but additionally returns the
+// offsets that consuming was rolled back to if the transaction aborted. This
+// can be used to log or verify exactly which records will be reprocessed
+// after an abort.
+func (s *GroupTransactSession) EndDetailed(ctx context.Context, commit TransactionEndTry) (TransactEndResult, error) {
+	var r TransactEndResult
+	var err error
+	r.Committed, err = s.end(ctx, commit, &r.ResetOffsets)
+	return r, err
+}
+
+func (s *GroupTransactSession) end(ctx context.Context, commit TransactionEndTry, resetTo *map[string]map[int32]EpochOffset) (committed bool, err error) {
 	defer func() {
 		s.failMu.Lock()
 		s.revoked = false
@@ -260,8 +292,8 @@ func (s *GroupTransactSession) End(ctx context.Context, commit TransactionEndTry
 	s.failMu.Lock()
 	failed := s.failed()
 
-	precommit := s.cl.CommittedOffsets()
-	postcommit := s.cl.UncommittedOffsets()
+	precommit := s.cl.committedOffsets()
+	postcommit := s.cl.uncommittedOffsets()
 	s.failMu.Unlock()
 
 	var hasAbortableCommitErr bool
@@ -302,34 +334,96 @@ func (s *GroupTransactSession) End(ctx context.Context, commit TransactionEndTry
 
 		var commitErrs []string
 
-		committed := make(chan struct{})
-		g = s.cl.commitTransactionOffsets(ctx, postcommit,
-			func(_ *kmsg.TxnOffsetCommitRequest, resp *kmsg.TxnOffsetCommitResponse, err error) {
-				defer close(committed)
-				if err != nil {
-					if isAbortableCommitErr(err) {
-						hasAbortableCommitErr = true
+		// If the only abortable errors are RebalanceInProgress, we
+		// can retry the commit up to txnCommitRebalanceRetries times:
+		// a brief rebalance may finish before our rebalance timeout,
+		// and the commit would then succeed with the same generation.
+		// If the generation changed, the rebalance completed and our
+		// offsets may be owned by another member: we must abort.
+		var (
+			commitGen int32
+			haveGen   bool
+		)
+		for tries := 0; ; tries++ {
+			var onlyRebalancing bool
+			hasAbortableCommitErr = false
+			onAbortable := func(err error) {
+				if !hasAbortableCommitErr {
+					onlyRebalancing = true
+				}
+				hasAbortableCommitErr = true
+				onlyRebalancing = onlyRebalancing && errors.Is(err, kerr.RebalanceInProgress)
+			}
+
+			committed := make(chan struct{})
+			g = s.cl.commitTransactionOffsets(ctx, postcommit,
+				func(req *kmsg.TxnOffsetCommitRequest, resp *kmsg.TxnOffsetCommitResponse, err error) {
+					defer close(committed)
+					if req != nil && !haveGen {
+						commitGen, haveGen = req.Generation, true
+					}
+					if err != nil {
+						if isAbortableCommitErr(err) {
+							onAbortable(err)
+							return
+						}
+						commitErrs = append(commitErrs, err.Error())
 						return
 					}
-					commitErrs = append(commitErrs, err.Error())
-					return
-				}
-				kip447 = resp.Version >= 3
-
-				for _, t := range resp.Topics {
-					for _, p := range t.Partitions {
-						if err := kerr.ErrorForCode(p.ErrorCode); err != nil {
-							if isAbortableCommitErr(err) {
-								hasAbortableCommitErr = true
-							} else {
-								commitErrs = append(commitErrs, fmt.Sprintf("topic %s partition %d: %v", t.Topic, p.Partition, err))
+					kip447 = resp.Version >= 3
+
+					for _, t := range resp.Topics {
+						for _, p := range t.Partitions {
+							if err := kerr.ErrorForCode(p.ErrorCode); err != nil {
+								if isAbortableCommitErr(err) {
+									onAbortable(err)
+								} else {
+									commitErrs = append(commitErrs, fmt.Sprintf("topic %s partition %d: %v", t.Topic, p.Partition, err))
+								}
 							}
 						}
 					}
-				}
-			},
-		)
-		<-committed
+				},
+			)
+			<-committed
+
+			if !onlyRebalancing || len(commitErrs) > 0 || tries >= s.cl.cfg.txnCommitRebalanceRetries {
+				break
+			}
+
+			backoff := s.cl.cfg.retryBackoff(tries + 1)
+			s.cl.cfg.logger.Log(LogLevelInfo, "transactional offset commit hit RebalanceInProgress, retrying",
+				"group", s.cl.cfg.group,
+				"tries", tries+1,
+				"backoff", backoff,
+			)
+			var quit bool
+			timer := s.cl.cfg.clock.NewTimer(backoff)
+			select {
+			case <-timer.C():
+			case <-s.revokedCh:
+				quit = true
+			case <-s.lostCh:
+				quit = true
+			case <-ctx.Done():
+				quit = true
+			}
+			timer.Stop()
+			if quit {
+				break
+			}
+			if g == nil || !haveGen {
+				break
+			}
+			if gen := g.memberGen.generation(); gen != commitGen {
+				s.cl.cfg.logger.Log(LogLevelInfo, "group generation changed while retrying the transactional offset commit, not retrying",
+					"group", s.cl.cfg.group,
+					"commit_generation", commitGen,
+					"generation", gen,
+				)
+				break
+			}
+		}
 
 		if len(commitErrs) > 0 {
 			commitErr = fmt.Errorf("unable to commit transaction offsets: %s", strings.Join(commitErrs, ", "))
@@ -344,10 +438,15 @@ func (s *GroupTransactSession) End(ctx context.Context, commit TransactionEndTry
 	// We should not be booted from the group if we receive an ok
 	// heartbeat, meaning that, as mentioned, we should be able to end the
 	// transaction safely.
+	//
+	// If the heartbeat does not complete within the rebalance timeout
+	// (i.e., the coordinator is unresponsive or the heartbeat loop is
+	// wedged), we stop waiting, abort, and return ErrForcedHeartbeatTimeout.
 	var okHeartbeat bool
 	if g != nil && commitErr == nil {
 		waitHeartbeat := make(chan struct{})
 		var heartbeatErr error
+		timeout := s.cl.cfg.clock.NewTimer(s.cl.cfg.rebalanceTimeout)
 		select {
 		case g.heartbeatForceCh <- func(err error) {
 			defer close(waitHeartbeat)
@@ -358,9 +457,21 @@ func (s *GroupTransactSession) End(ctx context.Context, commit TransactionEndTry
 				okHeartbeat = heartbeatErr == nil
 			case <-s.revokedCh:
 			case <-s.lostCh:
+			case <-timeout.C():
+				commitErr = ErrForcedHeartbeatTimeout
+			case <-ctx.Done():
+				commitErr = ctx.Err()
 			}
 		case <-s.revokedCh:
 		case <-s.lostCh:
+		case <-timeout.C():
+			commitErr = ErrForcedHeartbeatTimeout
+		case <-ctx.Done():
+			commitErr = ctx.Err()
+		}
+		timeout.Stop()
+		if commitErr != nil {
+			s.cl.cfg.logger.Log(LogLevelWarn, "unable to force a heartbeat before ending the transaction; aborting", "err", commitErr)
 		}
 	}
 
@@ -384,7 +495,7 @@ func (s *GroupTransactSession) End(ctx context.Context, commit TransactionEndTry
 			if committed {
 				s.cl.cfg.logger.Log(LogLevelDebug, "sleeping 200ms before allowing a rebalance to continue to give the brokers a chance to write txn markers and avoid duplicates")
 				go func() {
-					time.Sleep(200 * time.Millisecond)
+					s.cl.cfg.clock.Sleep(200 * time.Millisecond)
 					s.failMu.Unlock()
 				}()
 			} else {
@@ -428,9 +539,9 @@ retry:
 
 		case errors.Is(endTxnErr, kerr.UnknownServerError):
 			s.cl.cfg.logger.Log(LogLevelInfo, "end transaction with commit unknown server error; retrying")
-			after := time.NewTimer(s.cl.cfg.retryBackoff(tries))
+			after := s.cl.cfg.clock.NewTimer(s.cl.cfg.retryBackoff(tries))
 			select {
-			case <-after.C: // context canceled; we will see when we retry
+			case <-after.C(): // context canceled; we will see when we retry
 			case <-s.cl.ctx.Done():
 				after.Stop()
 			}
@@ -439,7 +550,7 @@ retry:
 	}
 
 	if !willTryCommit || endTxnErr != nil {
-		currentCommit := s.cl.CommittedOffsets()
+		currentCommit := s.cl.committedOffsets()
 		s.cl.cfg.logger.Log(LogLevelInfo, "transact session resetting to current committed state (potentially after a rejoin)",
 			"tried_commit", willTryCommit,
 			"commit_err", endTxnErr,
@@ -447,6 +558,9 @@ retry:
 			"state_currently_committed", currentCommit,
 		)
 		s.cl.setOffsets(currentCommit, false)
+		if resetTo != nil {
+			*resetTo = mapTopicKeys(&s.cl.consumer, currentCommit, s.cl.consumer.logicalTopic)
+		}
 	} else if willTryCommit && endTxnErr == nil {
 		s.cl.cfg.logger.Log(LogLevelInfo, "transact session successful, setting to newly committed state",
 			"tried_commit", willTryCommit,
@@ -655,14 +769,19 @@ func (cl *Client) EndAndBeginTransaction(
 		"epoch", epoch,
 		"commit", commit,
 	)
+	endCtx := ctx
+	if cl.cfg.txnEndUncancelable {
+		endCtx = context.WithoutCancel(ctx)
+	}
+
 	cl.producer.readded = false
-	err = cl.doWithConcurrentTransactions(ctx, "EndTxn", func() error {
+	err = cl.doWithConcurrentTransactions(endCtx, kmsg.EndTxn.Int16(), func() error {
 		req := kmsg.NewPtrEndTxnRequest()
 		req.TransactionalID = *cl.cfg.txnID
 		req.ProducerID = id
 		req.ProducerEpoch = epoch
 		req.Commit = bool(commit)
-		resp, err := req.RequestWith(ctx, cl)
+		resp, err := req.RequestWith(endCtx, cl)
 		if err != nil {
 			return err
 		}
@@ -721,7 +840,7 @@ func (cl *Client) EndAndBeginTransaction(
 	// there could be a stranded txn within Kafka's ProducerStateManager,
 	// but ideally the user will reconnect with the same txnal id.
 	cl.producer.readded = true
-	return cl.doWithConcurrentTransactions(ctx, "AddPartitionsToTxn", func() error {
+	return cl.doWithConcurrentTransactions(ctx, kmsg.AddPartitionsToTxn.Int16(), func() error {
 		req := kmsg.NewPtrAddPartitionsToTxnRequest()
 		req.TransactionalID = *cl.cfg.txnID
 		req.ProducerID = id
@@ -849,7 +968,8 @@ func (cl *Client) UnsafeAbortBufferedRecords() {
 // Note that canceling the context will likely leave the client in an
 // undesirable state, because canceling the context may cancel the in-flight
 // EndTransaction request, making it impossible to know whether the commit or
-// abort was successful. It is recommended to not cancel the context.
+// abort was successful. It is recommended to not cancel the context, or to use
+// the TxnEndUncancelable option.
 func (cl *Client) EndTransaction(ctx context.Context, commit TransactionEndTry) error {
 	cl.producer.txnMu.Lock()
 	defer cl.producer.txnMu.Unlock()
@@ -925,15 +1045,19 @@ func (cl *Client) EndTransaction(ctx context.Context, commit TransactionEndTry)
 		"epoch", epoch,
 		"commit", commit,
 	)
+	endCtx := ctx
+	if cl.cfg.txnEndUncancelable {
+		endCtx = context.WithoutCancel(ctx)
+	}
 
 	cl.producer.readded = false
-	err = cl.doWithConcurrentTransactions(ctx, "EndTxn", func() error {
+	err = cl.doWithConcurrentTransactions(endCtx, kmsg.EndTxn.Int16(), func() error {
 		req := kmsg.NewPtrEndTxnRequest()
 		req.TransactionalID = *cl.cfg.txnID
 		req.ProducerID = id
 		req.ProducerEpoch = epoch
 		req.Commit = bool(commit)
-		resp, err := req.RequestWith(ctx, cl)
+		resp, err := req.RequestWith(endCtx, cl)
 		if err != nil {
 			return err
 		}
@@ -982,6 +1106,12 @@ func (cl *Client) maybeRecoverProducerID(ctx context.Context) (necessary, did bo
 		return true, false, err // fatal, unrecoverable
 	}
 
+	cl.cfg.logger.Log(LogLevelInfo, "producer id is recoverable, reloading on next use",
+		"id", id,
+		"epoch", epoch,
+		"err", err,
+	)
+
 	// Storing errReloadProducerID will reset sequence numbers as appropriate
 	// when the producer ID is reloaded successfully.
 	cl.producer.id.Store(&producerID{
@@ -994,9 +1124,14 @@ func (cl *Client) maybeRecoverProducerID(ctx context.Context) (necessary, did bo
 
 // If a transaction is begun too quickly after finishing an old transaction,
 // Kafka may still be finalizing its commit / abort and will return a
-// concurrent transactions error. We handle that by retrying for a bit.
-func (cl *Client) doWithConcurrentTransactions(ctx context.Context, name string, fn func() error) error {
-	start := time.Now()
+// concurrent transactions error. We handle that by retrying for a bit, up to
+// the retry timeout for the request key.
+//
+// If configured with TxnCoordinatorWait, we also retry for a bit if the
+// transaction coordinator is unavailable.
+func (cl *Client) doWithConcurrentTransactions(ctx context.Context, key int16, fn func() error) error {
+	name := kmsg.NameForKey(key)
+	start := cl.cfg.clock.Now()
 	tries := 0
 	backoff := cl.cfg.txnBackoff
 
@@ -1005,7 +1140,7 @@ start:
 	if errors.Is(err, kerr.ConcurrentTransactions) {
 		// The longer we are stalled, the more we enforce a minimum
 		// backoff.
-		since := time.Since(start)
+		since := cl.cfg.clock.Since(start)
 		switch {
 		case since > time.Second:
 			if backoff < 200*time.Millisecond {
@@ -1021,26 +1156,73 @@ start:
 			}
 		}
 
+		if timeout := cl.cfg.retryTimeout(key); timeout > 0 && cl.cfg.clock.Since(start)+backoff > timeout {
+			cl.cfg.logger.Log(LogLevelError, fmt.Sprintf("abandoning %s retry due to CONCURRENT_TRANSACTIONS retries exceeding the retry timeout", name),
+				"retry_timeout", timeout,
+				"tries", tries,
+			)
+			return fmt.Errorf("%w: %w", ErrConcurrentTransactionsTimeout, err)
+		}
+
 		tries++
 		cl.cfg.logger.Log(LogLevelDebug, fmt.Sprintf("%s failed with CONCURRENT_TRANSACTIONS, which may be because we ended a txn and began producing in a new txn too quickly; backing off and retrying", name),
 			"backoff", backoff,
-			"since_request_tries_start", time.Since(start),
+			"since_request_tries_start", cl.cfg.clock.Since(start),
 			"tries", tries,
 		)
+		after := cl.cfg.clock.NewTimer(backoff)
 		select {
-		case <-time.After(backoff):
+		case <-after.C():
 		case <-ctx.Done():
+			after.Stop()
 			cl.cfg.logger.Log(LogLevelError, fmt.Sprintf("abandoning %s retry due to request ctx quitting", name))
-			return err
+			return ctx.Err()
 		case <-cl.ctx.Done():
+			after.Stop()
 			cl.cfg.logger.Log(LogLevelError, fmt.Sprintf("abandoning %s retry due to client ctx quitting", name))
-			return err
+			return ErrClientClosed
+		}
+		goto start
+	}
+	if wait := cl.cfg.txnCoordUnavailable.wait; wait > 0 && isTxnCoordinatorUnavailableErr(err) && cl.cfg.clock.Since(start) < wait {
+		tries++
+		coordBackoff := cl.cfg.retryBackoff(tries)
+		if left := wait - cl.cfg.clock.Since(start); coordBackoff > left {
+			coordBackoff = left
+		}
+		cl.cfg.logger.Log(LogLevelInfo, fmt.Sprintf("%s failed because the transaction coordinator is unavailable; backing off and retrying", name),
+			"err", err,
+			"backoff", coordBackoff,
+			"since_request_tries_start", cl.cfg.clock.Since(start),
+			"tries", tries,
+		)
+		after := cl.cfg.clock.NewTimer(coordBackoff)
+		select {
+		case <-after.C():
+		case <-ctx.Done():
+			after.Stop()
+			cl.cfg.logger.Log(LogLevelError, fmt.Sprintf("abandoning %s retry due to request ctx quitting", name))
+			return ctx.Err()
+		case <-cl.ctx.Done():
+			after.Stop()
+			cl.cfg.logger.Log(LogLevelError, fmt.Sprintf("abandoning %s retry due to client ctx quitting", name))
+			return ErrClientClosed
 		}
 		goto start
 	}
 	return err
 }
 
+// isTxnCoordinatorUnavailableErr returns whether err indicates the transaction
+// coordinator is unavailable, either from Kafka or from failing to reach it.
+func isTxnCoordinatorUnavailableErr(err error) bool {
+	return errors.Is(err, kerr.CoordinatorNotAvailable) ||
+		errors.Is(err, kerr.CoordinatorLoadInProgress) ||
+		errors.Is(err, kerr.NotCoordinator) ||
+		isAnyDialErr(err) ||
+		isRetryableBrokerErr(err)
+}
+
 ////////////////////////////////////////////////////////////////////////////////////////////
 // TRANSACTIONAL COMMITTING                                                               //
 // MOSTLY DUPLICATED CODE DUE TO NO GENERICS AND BECAUSE THE TYPES ARE SLIGHTLY DIFFERENT //
@@ -1137,24 +1319,48 @@ func (cl *Client) addOffsetsToTxn(ctx context.Context, group string) error {
 		return err
 	}
 
-	err = cl.doWithConcurrentTransactions(ctx, "AddOffsetsToTxn", func() error { // committing offsets without producing causes a transaction to begin within Kafka
-		cl.cfg.logger.Log(LogLevelInfo, "issuing AddOffsetsToTxn",
+	// Failing here fails the transaction's offset commit, which forces
+	// the whole transaction to abort. Request retries already cover
+	// coordinator movement (and CONCURRENT_TRANSACTIONS is retried
+	// below), but if the coordinator is churning (say, during a rolling
+	// restart) we can still see retryable errors once request retries are
+	// exhausted. We retry those a few more times before giving up.
+	const maxRetriableTries = 5
+	var ke *kerr.Error
+	for tries := 1; ; tries++ {
+		err = cl.doWithConcurrentTransactions(ctx, kmsg.AddOffsetsToTxn.Int16(), func() error { // committing offsets without producing causes a transaction to begin within Kafka
+			cl.cfg.logger.Log(LogLevelInfo, "issuing AddOffsetsToTxn",
+				"txn", *cl.cfg.txnID,
+				"producerID", id,
+				"producerEpoch", epoch,
+				"group", group,
+			)
+			req := kmsg.NewPtrAddOffsetsToTxnRequest()
+			req.TransactionalID = *cl.cfg.txnID
+			req.ProducerID = id
+			req.ProducerEpoch = epoch
+			req.Group = group
+			resp, err := req.RequestWith(ctx, cl)
+			if err != nil {
+				return err
+			}
+			return kerr.ErrorForCode(resp.ErrorCode)
+		})
+		if tries >= maxRetriableTries || !errors.As(err, &ke) || !ke.Retriable {
+			break
+		}
+		backoff := cl.cfg.retryBackoff(tries)
+		cl.cfg.logger.Log(LogLevelInfo, "AddOffsetsToTxn failed with a retryable error, backing off and retrying",
 			"txn", *cl.cfg.txnID,
-			"producerID", id,
-			"producerEpoch", epoch,
 			"group", group,
+			"backoff", backoff,
+			"tries", tries,
+			"err", err,
 		)
-		req := kmsg.NewPtrAddOffsetsToTxnRequest()
-		req.TransactionalID = *cl.cfg.txnID
-		req.ProducerID = id
-		req.ProducerEpoch = epoch
-		req.Group = group
-		resp, err := req.RequestWith(ctx, cl)
-		if err != nil {
-			return err
+		if !cl.waitTries(ctx, backoff) {
+			break
 		}
-		return kerr.ErrorForCode(resp.ErrorCode)
-	})
+	}
 
 	// If the returned error is still a Kafka error, this is fatal and we
 	// need to fail our producer ID we created just above.
@@ -1164,7 +1370,6 @@ func (cl *Client) addOffsetsToTxn(ctx context.Context, group string) error {
 	// error. Some brokers send this when things fail internally, we can
 	// just abort our commit and see if things are still bad in
 	// EndTransaction.
-	var ke *kerr.Error
 	if errors.As(err, &ke) && !ke.Retriable && ke.Code != kerr.UnknownServerError.Code {
 		cl.failProducerID(id, epoch, err)
 	}
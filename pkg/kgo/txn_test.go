@@ -6,9 +6,14 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"reflect"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kmsg"
 )
 
 // This test is identical to TestGroupETL but based around transactions.
@@ -261,3 +266,495 @@ func (c *testConsumer) transact(txnsBeforeQuit int) {
 		c.mu.Unlock()
 	}
 }
+
+func TestAddOffsetsToTxnRetries(t *testing.T) {
+	var addOffsetsTries int
+	tt := &scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+		switch req := req.(type) {
+		case *kmsg.AddOffsetsToTxnRequest:
+			resp := req.ResponseKind().(*kmsg.AddOffsetsToTxnResponse)
+			if addOffsetsTries++; addOffsetsTries < 3 {
+				resp.ErrorCode = kerr.NotEnoughReplicas.Code
+			}
+			return resp, nil
+		}
+		return scriptedCoordinator(req)
+	}}
+
+	cl := newUnitClient(t,
+		WithTestTransport(tt),
+		TransactionalID("txn"),
+		RetryBackoffFn(func(int) time.Duration { return time.Millisecond }),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := cl.addOffsetsToTxn(ctx, "group"); err != nil {
+		t.Fatalf("unexpected error after retrying: %v", err)
+	}
+	if addOffsetsTries != 3 {
+		t.Errorf("got %d AddOffsetsToTxn tries != exp 3", addOffsetsTries)
+	}
+}
+
+func TestListDescribeTransactions(t *testing.T) {
+	tt := &scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+		switch req := req.(type) {
+		case *kmsg.ListTransactionsRequest:
+			resp := req.ResponseKind().(*kmsg.ListTransactionsResponse)
+			if len(req.StateFilters) != 1 || req.StateFilters[0] != "Ongoing" {
+				return nil, errors.New("missing state filter")
+			}
+			s := kmsg.NewListTransactionsResponseTransactionState()
+			s.TransactionalID, s.ProducerID, s.TransactionState = "txn", 3, "Ongoing"
+			resp.TransactionStates = append(resp.TransactionStates, s)
+			return resp, nil
+		case *kmsg.DescribeTransactionsRequest:
+			resp := req.ResponseKind().(*kmsg.DescribeTransactionsResponse)
+			for _, id := range req.TransactionalIDs {
+				s := kmsg.NewDescribeTransactionsResponseTransactionState()
+				s.TransactionalID = id
+				if id != "txn" {
+					s.ErrorCode = kerr.TransactionalIDNotFound.Code
+				} else {
+					s.State, s.TimeoutMillis, s.StartTimestamp = "Ongoing", 60000, 1000
+					s.ProducerID, s.ProducerEpoch = 3, 1
+					st := kmsg.NewDescribeTransactionsResponseTransactionStateTopic()
+					st.Topic, st.Partitions = "foo", []int32{0, 2}
+					s.Topics = append(s.Topics, st)
+				}
+				resp.TransactionStates = append(resp.TransactionStates, s)
+			}
+			return resp, nil
+		}
+		return scriptedCoordinator(req)
+	}}
+
+	cl := newUnitClient(t, WithTestTransport(tt))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	listed, err := cl.ListTransactions(ctx, []string{"Ongoing"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected list err: %v", err)
+	}
+	if exp := []ListedTransaction{{"txn", 3, "Ongoing"}}; !reflect.DeepEqual(listed, exp) {
+		t.Errorf("got listed %v != exp %v", listed, exp)
+	}
+
+	described, err := cl.DescribeTransaction(ctx, "txn")
+	if err != nil {
+		t.Fatalf("unexpected describe err: %v", err)
+	}
+	exp := DescribedTransaction{
+		TransactionalID: "txn",
+		State:           "Ongoing",
+		Timeout:         time.Minute,
+		StartTime:       time.UnixMilli(1000),
+		ProducerID:      3,
+		ProducerEpoch:   1,
+		Partitions:      map[string][]int32{"foo": {0, 2}},
+	}
+	if !reflect.DeepEqual(described, exp) {
+		t.Errorf("got described %v != exp %v", described, exp)
+	}
+
+	if _, err := cl.DescribeTransaction(ctx, "unknown"); !errors.Is(err, kerr.TransactionalIDNotFound) {
+		t.Errorf("got describe err %v != exp %v", err, kerr.TransactionalIDNotFound)
+	}
+}
+
+func TestAbortTransaction(t *testing.T) {
+	tt := &scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+		if req, ok := req.(*kmsg.WriteTxnMarkersRequest); ok {
+			resp := req.ResponseKind().(*kmsg.WriteTxnMarkersResponse)
+			for _, rm := range req.Markers {
+				if rm.Committed || rm.ProducerEpoch != 2 || rm.CoordinatorEpoch != 9 {
+					return nil, errors.New("bad marker")
+				}
+				m := kmsg.NewWriteTxnMarkersResponseMarker()
+				m.ProducerID = rm.ProducerID
+				for _, rt := range rm.Topics {
+					st := kmsg.NewWriteTxnMarkersResponseMarkerTopic()
+					st.Topic = rt.Topic
+					for _, p := range rt.Partitions {
+						sp := kmsg.NewWriteTxnMarkersResponseMarkerTopicPartition()
+						sp.Partition = p
+						if rm.ProducerID != 3 {
+							sp.ErrorCode = kerr.InvalidProducerEpoch.Code
+						}
+						st.Partitions = append(st.Partitions, sp)
+					}
+					m.Topics = append(m.Topics, st)
+				}
+				resp.Markers = append(resp.Markers, m)
+			}
+			return resp, nil
+		}
+		return scriptedProduce(req)
+	}}
+
+	cl := newUnitClient(t, WithTestTransport(tt))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := cl.AbortTransaction(ctx, "foo", 0, 3, 2, 9); err != nil {
+		t.Errorf("unexpected abort err: %v", err)
+	}
+	if err := cl.AbortTransaction(ctx, "foo", 0, 4, 2, 9); !errors.Is(err, kerr.InvalidProducerEpoch) {
+		t.Errorf("got abort err %v != exp %v", err, kerr.InvalidProducerEpoch)
+	}
+}
+
+func TestDescribeProducers(t *testing.T) {
+	tt := &scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+		if req, ok := req.(*kmsg.DescribeProducersRequest); ok {
+			resp := req.ResponseKind().(*kmsg.DescribeProducersResponse)
+			for _, rt := range req.Topics {
+				st := kmsg.NewDescribeProducersResponseTopic()
+				st.Topic = rt.Topic
+				for _, p := range rt.Partitions {
+					sp := kmsg.NewDescribeProducersResponseTopicPartition()
+					sp.Partition = p
+					ap := kmsg.NewDescribeProducersResponseTopicPartitionActiveProducer()
+					ap.ProducerID = 3
+					ap.ProducerEpoch = 2
+					ap.LastSequence = 10
+					ap.CoordinatorEpoch = 9
+					sp.ActiveProducers = append(sp.ActiveProducers, ap)
+					st.Partitions = append(st.Partitions, sp)
+				}
+				resp.Topics = append(resp.Topics, st)
+			}
+			return resp, nil
+		}
+		return scriptedProduce(req)
+	}}
+
+	cl := newUnitClient(t, WithTestTransport(tt))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	described, err := cl.DescribeProducers(ctx, "foo", 0)
+	if err != nil {
+		t.Fatalf("unexpected describe err: %v", err)
+	}
+	exp := []DescribedProducer{{
+		ProducerID:            3,
+		ProducerEpoch:         2,
+		LastSequence:          10,
+		CoordinatorEpoch:      9,
+		CurrentTxnStartOffset: -1,
+	}}
+	if !reflect.DeepEqual(described, exp) {
+		t.Errorf("got described producers %+v != exp %+v", described, exp)
+	}
+}
+
+func TestTxnCoordinatorUnavailableWait(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		behavior TxnCoordinatorBehavior
+		failures int
+		expTries int
+		expErr   bool
+	}{
+		{"fail fast", TxnCoordinatorFailFast(), 1, 1, true},
+		{"ride through", TxnCoordinatorWait(time.Second), 2, 3, false},
+		{"wait exhausted", TxnCoordinatorWait(250 * time.Millisecond), 100, 4, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			fc := newFakeClock()
+			cl := newUnitClient(t,
+				RetryBackoffFn(func(int) time.Duration { return 100 * time.Millisecond }),
+				TxnCoordinatorUnavailableBehavior(test.behavior),
+				withClock(fc),
+			)
+
+			var tries int
+			err := cl.doWithConcurrentTransactions(context.Background(), kmsg.EndTxn.Int16(), func() error {
+				if tries++; tries <= test.failures {
+					return kerr.CoordinatorNotAvailable
+				}
+				return nil
+			})
+			if gotErr := err != nil; gotErr != test.expErr {
+				t.Errorf("got err %v, exp err? %v", err, test.expErr)
+			}
+			if err != nil && !errors.Is(err, kerr.CoordinatorNotAvailable) {
+				t.Errorf("got err %v, expected kerr.CoordinatorNotAvailable", err)
+			}
+			if tries != test.expTries {
+				t.Errorf("got %d tries != exp %d", tries, test.expTries)
+			}
+		})
+	}
+
+	// Quitting while waiting returns why we quit, not the coordinator
+	// error, so that callers do not treat it as a coordinator failure.
+	cl := newUnitClient(t,
+		TxnCoordinatorUnavailableBehavior(TxnCoordinatorWait(time.Minute)),
+		withClock(newFakeClock()),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	err := cl.doWithConcurrentTransactions(ctx, kmsg.EndTxn.Int16(), func() error {
+		cancel()
+		return kerr.CoordinatorNotAvailable
+	})
+	if err != context.Canceled {
+		t.Errorf("got err %v != exp context.Canceled", err)
+	}
+	err = cl.doWithConcurrentTransactions(context.Background(), kmsg.EndTxn.Int16(), func() error {
+		cl.Close()
+		return kerr.CoordinatorNotAvailable
+	})
+	if err != ErrClientClosed {
+		t.Errorf("got err %v != exp ErrClientClosed", err)
+	}
+
+	if _, err := NewClient(TxnCoordinatorUnavailableBehavior(TxnCoordinatorWait(-time.Second))); err == nil {
+		t.Error("expected error for a negative txn coordinator wait")
+	}
+}
+
+func TestTxnEndUncancelable(t *testing.T) {
+	for _, uncancelable := range []bool{false, true} {
+		t.Run(strconv.FormatBool(uncancelable), func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var ends int
+			cl := newUnitClient(t,
+				TransactionalID("txn"),
+				TxnEndUncancelable(uncancelable),
+				WithTestTransport(&scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+					end, ok := req.(*kmsg.EndTxnRequest)
+					if !ok {
+						return scriptedCoordinator(req)
+					}
+					resp := end.ResponseKind().(*kmsg.EndTxnResponse)
+					if ends++; ends == 1 {
+						// Cancel while EndTxn is being retried.
+						cancel()
+						resp.ErrorCode = kerr.ConcurrentTransactions.Code
+					}
+					return resp, nil
+				}}),
+			)
+
+			if err := cl.BeginTransaction(); err != nil {
+				t.Fatal(err)
+			}
+			cl.producer.readded = true // pretend a partition was added to the transaction
+
+			err := cl.EndTransaction(ctx, TryCommit)
+			switch {
+			case uncancelable && err != nil:
+				t.Errorf("unexpected EndTransaction err: %v", err)
+			case !uncancelable && !errors.Is(err, context.Canceled):
+				t.Errorf("got EndTransaction err %v != exp %v", err, context.Canceled)
+			}
+		})
+	}
+}
+
+func TestTxnCommitRebalanceRetriesGeneration(t *testing.T) {
+	for _, rejoin := range []bool{false, true} {
+		t.Run(fmt.Sprintf("rejoin_%v", rejoin), func(t *testing.T) {
+			var (
+				g       *groupConsumer
+				commits int
+			)
+			cl := newUnitClient(t,
+				TransactionalID("txn"),
+				TxnCommitRebalanceRetries(3),
+				withClock(newFakeClock()),
+				WithTestTransport(&scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+					switch req := req.(type) {
+					case *kmsg.AddOffsetsToTxnRequest:
+						return req.ResponseKind(), nil
+					case *kmsg.EndTxnRequest:
+						return req.ResponseKind(), nil
+					case *kmsg.TxnOffsetCommitRequest:
+						resp := req.ResponseKind().(*kmsg.TxnOffsetCommitResponse)
+						commits++
+						for _, rt := range req.Topics {
+							st := kmsg.NewTxnOffsetCommitResponseTopic()
+							st.Topic = rt.Topic
+							for _, rp := range rt.Partitions {
+								sp := kmsg.NewTxnOffsetCommitResponseTopicPartition()
+								sp.Partition = rp.Partition
+								if commits == 1 {
+									sp.ErrorCode = kerr.RebalanceInProgress.Code
+								}
+								st.Partitions = append(st.Partitions, sp)
+							}
+							resp.Topics = append(resp.Topics, st)
+						}
+						if commits == 1 && rejoin {
+							g.memberGen.store("member", req.Generation+1)
+						}
+						return resp, nil
+					}
+					return scriptedCoordinator(req)
+				}}),
+			)
+			g = &groupConsumer{cl: cl, cfg: &cl.cfg, ctx: context.Background()}
+			g.memberGen.store("member", 1)
+			g.updateUncommitted(Fetches{{Topics: []FetchTopic{{
+				Topic:      "foo",
+				Partitions: []FetchPartition{{Records: []*Record{{Topic: "foo"}}}},
+			}}}})
+			cl.consumer.g = g
+			defer func() { cl.consumer.g = nil }()
+
+			s := &GroupTransactSession{cl: cl, revokedCh: make(chan struct{}), lostCh: make(chan struct{})}
+			if err := cl.BeginTransaction(); err != nil {
+				t.Fatal(err)
+			}
+			s.End(context.Background(), TryCommit) //nolint:errcheck // the forced heartbeat fails without a real group; we only check commits
+
+			// A retry after the group rejoined would commit with a
+			// stale generation.
+			exp := 2
+			if rejoin {
+				exp = 1
+			}
+			if commits != exp {
+				t.Errorf("got %d txn offset commits != exp %d", commits, exp)
+			}
+		})
+	}
+}
+
+func TestGroupTransactSessionEndHeartbeatTimeout(t *testing.T) {
+	for _, heartbeats := range []bool{false, true} {
+		t.Run(fmt.Sprintf("heartbeats_%v", heartbeats), func(t *testing.T) {
+			var (
+				mu   sync.Mutex
+				ends []bool
+			)
+			opts := []Opt{
+				TransactionalID("txn"),
+				RebalanceTimeout(time.Minute),
+				WithTestTransport(&scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+					switch req := req.(type) {
+					case *kmsg.AddOffsetsToTxnRequest:
+						return req.ResponseKind(), nil
+					case *kmsg.EndTxnRequest:
+						mu.Lock()
+						ends = append(ends, req.Commit)
+						mu.Unlock()
+						return req.ResponseKind(), nil
+					case *kmsg.TxnOffsetCommitRequest:
+						return req.ResponseKind(), nil
+					}
+					return scriptedCoordinator(req)
+				}}),
+			}
+			// Fake timers fire immediately, which would race with an
+			// answered heartbeat; we only fake the clock when the
+			// heartbeat is never answered.
+			fc := newFakeClock()
+			if !heartbeats {
+				opts = append(opts, withClock(fc))
+			}
+			cl := newUnitClient(t, opts...)
+
+			g := &groupConsumer{cl: cl, cfg: &cl.cfg, ctx: context.Background(), heartbeatForceCh: make(chan func(error))}
+			g.memberGen.store("member", 1)
+			g.updateUncommitted(Fetches{{Topics: []FetchTopic{{
+				Topic:      "foo",
+				Partitions: []FetchPartition{{Records: []*Record{{Topic: "foo"}}}},
+			}}}})
+			cl.consumer.g = g
+			defer func() { cl.consumer.g = nil }()
+			if heartbeats {
+				go func() { (<-g.heartbeatForceCh)(nil) }()
+			}
+
+			s := &GroupTransactSession{cl: cl, revokedCh: make(chan struct{}), lostCh: make(chan struct{})}
+			if err := cl.BeginTransaction(); err != nil {
+				t.Fatal(err)
+			}
+			committed, err := s.End(context.Background(), TryCommit)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if heartbeats {
+				if !committed || err != nil {
+					t.Errorf("got committed %v, err %v, exp a commit", committed, err)
+				}
+				if !reflect.DeepEqual(ends, []bool{true}) {
+					t.Errorf("got EndTxn commits %v != exp [true]", ends)
+				}
+				return
+			}
+			if committed || !errors.Is(err, ErrForcedHeartbeatTimeout) {
+				t.Errorf("got committed %v, err %v, exp an abort with ErrForcedHeartbeatTimeout", committed, err)
+			}
+			if !reflect.DeepEqual(ends, []bool{false}) {
+				t.Errorf("got EndTxn commits %v != exp [false]", ends)
+			}
+			var waitedRebalance bool
+			for _, d := range fc.Waited() {
+				waitedRebalance = waitedRebalance || d == time.Minute
+			}
+			if !waitedRebalance {
+				t.Errorf("forced heartbeat wait was not bounded by the rebalance timeout; waited %v", fc.Waited())
+			}
+		})
+	}
+}
+
+func TestGroupTransactSessionEndDetailed(t *testing.T) {
+	for _, commit := range []TransactionEndTry{TryAbort, TryCommit} {
+		t.Run(fmt.Sprintf("commit_%v", commit), func(t *testing.T) {
+			cl := newUnitClient(t,
+				TransactionalID("txn"),
+				TopicNameMapper(nil, func(s string) string { return "tenant." + s }),
+				WithTestTransport(&scriptedTransport{resp: func(req kmsg.Request) (kmsg.Response, error) {
+					switch req := req.(type) {
+					case *kmsg.AddOffsetsToTxnRequest, *kmsg.EndTxnRequest, *kmsg.TxnOffsetCommitRequest:
+						return req.ResponseKind(), nil
+					}
+					return scriptedCoordinator(req)
+				}}),
+			)
+			wire := cl.consumer.wireTopic("foo")
+
+			g := &groupConsumer{cl: cl, cfg: &cl.cfg, ctx: context.Background(), heartbeatForceCh: make(chan func(error))}
+			g.memberGen.store("member", 1)
+			g.uncommitted = uncommitted{wire: {0: {
+				head:      EpochOffset{-1, 5},
+				dirty:     EpochOffset{-1, 5},
+				committed: EpochOffset{-1, 3},
+			}}}
+			cl.consumer.g = g
+			defer func() { cl.consumer.g = nil }()
+			go func() { (<-g.heartbeatForceCh)(nil) }()
+
+			s := &GroupTransactSession{cl: cl, revokedCh: make(chan struct{}), lostCh: make(chan struct{})}
+			if err := cl.BeginTransaction(); err != nil {
+				t.Fatal(err)
+			}
+			r, err := s.EndDetailed(context.Background(), commit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Committed != bool(commit) {
+				t.Errorf("got committed %v != exp %v", r.Committed, commit)
+			}
+			var exp map[string]map[int32]EpochOffset
+			if !commit {
+				exp = map[string]map[int32]EpochOffset{"foo": {0: {-1, 3}}}
+			}
+			if !reflect.DeepEqual(r.ResetOffsets, exp) {
+				t.Errorf("got reset offsets %v != exp %v", r.ResetOffsets, exp)
+			}
+		})
+	}
+}